@@ -0,0 +1,107 @@
+use anyhow::{Context, Result};
+use std::{
+    env,
+    io::{self, Write},
+    path::Path,
+    process::{Command, Stdio},
+    thread,
+};
+
+/// Variable pointing to the age identity file used to decrypt environment
+/// files encrypted with age.
+pub const IDENTITY_VAR: &str = "DOTENV_AGE_IDENTITY";
+
+/// How an age file starts, when armored and when not.
+const ARMOR_HEADER: &[u8] = b"-----BEGIN AGE ENCRYPTED FILE-----";
+const BINARY_HEADER: &[u8] = b"age-encryption.org/v1\n";
+
+/// Check if the contents of a file are encrypted with age.
+pub fn is_encrypted(content: &[u8]) -> bool {
+    let start = content
+        .iter()
+        .position(|b| !b.is_ascii_whitespace())
+        .unwrap_or(content.len());
+    content[start..].starts_with(ARMOR_HEADER) || content.starts_with(BINARY_HEADER)
+}
+
+/// Decrypt the contents of an environment file with the identity file in
+/// `DOTENV_AGE_IDENTITY`.
+pub fn decrypt(file: &Path, content: &[u8]) -> Result<String> {
+    let identity = env::var_os(IDENTITY_VAR)
+        .filter(|v| !v.is_empty())
+        .with_context(|| {
+            format!(
+                "{} is encrypted with age, set {} to the path of an identity file to decrypt it",
+                file.display(),
+                IDENTITY_VAR
+            )
+        })?;
+
+    let mut cmd = Command::new("age");
+    cmd.arg("--decrypt").arg("--identity").arg(identity);
+    let plain =
+        run(cmd, content).with_context(|| format!("Could not decrypt {}", file.display()))?;
+    String::from_utf8(plain).with_context(|| format!("{} doesn't decrypt to text", file.display()))
+}
+
+/// Encrypt the contents of an environment file for the given recipients,
+/// as an armored age file, which can be committed and diffed like text.
+pub fn encrypt(content: &[u8], recipients: &[String]) -> Result<Vec<u8>> {
+    let mut cmd = Command::new("age");
+    cmd.arg("--encrypt").arg("--armor");
+    for recipient in recipients {
+        cmd.arg("--recipient").arg(recipient);
+    }
+    run(cmd, content)
+}
+
+/// Run age with some input, returning its output, or what it printed as
+/// the error if it fails.
+fn run(mut cmd: Command, input: &[u8]) -> Result<Vec<u8>> {
+    let spawned = cmd
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn();
+    let mut child = match spawned {
+        Ok(child) => child,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("age is not installed, see https://age-encryption.org")
+        }
+        Err(err) => return Err(err).context("Failed to run age"),
+    };
+
+    // Written from another thread, so age can't block on a full output
+    // while dotenv is still writing its input
+    let mut stdin = child.stdin.take().context("Failed to open age's input")?;
+    let input = input.to_vec();
+    let writer = thread::spawn(move || stdin.write_all(&input));
+
+    let output = child.wait_with_output().context("Failed to wait for age")?;
+    let _ = writer.join();
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("age failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    Ok(output.stdout)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_encrypted() {
+        assert!(is_encrypted(
+            b"-----BEGIN AGE ENCRYPTED FILE-----\nYWdl\n-----END AGE ENCRYPTED FILE-----\n"
+        ));
+        assert!(is_encrypted(b"\n  -----BEGIN AGE ENCRYPTED FILE-----\n"));
+        assert!(is_encrypted(b"age-encryption.org/v1\n-> X25519 abc\n"));
+        assert!(!is_encrypted(b"FOO=bar\n"));
+        assert!(!is_encrypted(b"# -----BEGIN AGE ENCRYPTED FILE-----\n"));
+        assert!(!is_encrypted(b""));
+    }
+}
@@ -0,0 +1,122 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret in Azure Key Vault, followed
+/// by the name of the vault and the name of the secret, and optionally its
+/// version, like `akv:my-vault/db-password`.
+pub const PREFIX: &str = "akv:";
+
+/// Replace, in place, the values referencing secrets in Key Vault with the
+/// secrets themselves, read with the Azure CLI and the account it's logged
+/// in with. Each secret is read once, however many keys reference it, and
+/// the secrets are read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut references = Vec::new();
+    for (key, value) in vars.iter() {
+        if let Some(reference) = value.strip_prefix(PREFIX) {
+            parse_reference(reference)
+                .with_context(|| format!("Invalid Key Vault reference in {}", key))?;
+            references.push((key.clone(), reference.to_string()));
+        }
+    }
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let ids = references.iter().map(|(_, reference)| reference.as_str());
+    let secrets = secrets::fetch_all(ids, read);
+
+    let mut resolved = Vec::new();
+    for (key, reference) in &references {
+        match &secrets[reference.as_str()] {
+            Ok(secret) => resolved.push((key.clone(), secret.clone())),
+            Err(err) => anyhow::bail!(
+                "Could not read {} from Azure Key Vault for {}: {:#}",
+                reference,
+                key,
+                err
+            ),
+        }
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Split a reference like `my-vault/db-password` into the name of the
+/// vault, the name of the secret and its version, if any.
+fn parse_reference(reference: &str) -> Result<(&str, &str, Option<&str>)> {
+    let parts: Vec<&str> = reference.split('/').collect();
+    match parts[..] {
+        [vault, secret] if !vault.is_empty() && !secret.is_empty() => Ok((vault, secret, None)),
+        [vault, secret, version]
+            if !vault.is_empty() && !secret.is_empty() && !version.is_empty() =>
+        {
+            Ok((vault, secret, Some(version)))
+        }
+        _ => anyhow::bail!(
+            "Expected a vault and a secret like {}my-vault/db-password, got {}{}",
+            PREFIX,
+            PREFIX,
+            reference
+        ),
+    }
+}
+
+/// Read a secret with the Azure CLI.
+fn read(reference: &str) -> Result<String> {
+    let (vault, secret, version) = parse_reference(reference)?;
+
+    let mut command = Command::new("az");
+    command
+        .args(["keyvault", "secret", "show"])
+        .args(["--vault-name", vault, "--name", secret]);
+    if let Some(version) = version {
+        command.args(["--version", version]);
+    }
+    let output = command.args(["--output", "json"]).output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => anyhow::bail!(
+            "The Azure CLI is not installed, see https://learn.microsoft.com/cli/azure/install-azure-cli"
+        ),
+        Err(err) => return Err(err).context("Failed to run az"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("az failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+
+    let response: serde_json::Value =
+        serde_json::from_slice(&output.stdout).context("az printed invalid JSON")?;
+    match response["value"].as_str() {
+        Some(value) => Ok(value.to_string()),
+        None => anyhow::bail!("az printed no value for the secret"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_reference() -> Result<()> {
+        assert_eq!(
+            parse_reference("my-vault/db-password")?,
+            ("my-vault", "db-password", None)
+        );
+        assert_eq!(
+            parse_reference("my-vault/db-password/0123abcd")?,
+            ("my-vault", "db-password", Some("0123abcd"))
+        );
+        assert!(parse_reference("db-password").is_err());
+        assert!(parse_reference("my-vault/").is_err());
+        assert!(parse_reference("/db-password").is_err());
+        assert!(parse_reference("my-vault/db-password/").is_err());
+        Ok(())
+    }
+}
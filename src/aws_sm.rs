@@ -0,0 +1,149 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret in AWS Secrets Manager,
+/// followed by its name or ARN and, optionally, a key to take from the
+/// secret when it's a JSON object, like `aws-sm:prod/db:password`.
+pub const PREFIX: &str = "aws-sm:";
+
+/// Replace, in place, the values referencing secrets in AWS Secrets
+/// Manager with the secrets themselves, read with the AWS CLI and the AWS
+/// credentials dotenv runs with. Each secret is read once, however many
+/// keys are taken from it, and the secrets are read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut references = Vec::new();
+    for (key, value) in vars.iter() {
+        if let Some(reference) = value.strip_prefix(PREFIX) {
+            let (id, field) = parse_reference(reference);
+            if id.is_empty() {
+                anyhow::bail!(
+                    "Missing the name of the secret after {:?} in {}",
+                    PREFIX,
+                    key
+                );
+            }
+            references.push((key.clone(), id.to_string(), field.map(str::to_string)));
+        }
+    }
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let ids = references.iter().map(|(_, id, _)| id.as_str());
+    let secrets = secrets::fetch_all(ids, read);
+
+    let mut resolved = Vec::new();
+    for (key, id, field) in &references {
+        let secret = match &secrets[id.as_str()] {
+            Ok(secret) => secret,
+            Err(err) => anyhow::bail!(
+                "Could not read {} from AWS Secrets Manager for {}: {:#}",
+                id,
+                key,
+                err
+            ),
+        };
+        let value = match field {
+            Some(field) => field_of(secret, field).with_context(|| {
+                format!(
+                    "The secret {} isn't a JSON object with the key {:?}, needed by {}",
+                    id, field, key
+                )
+            })?,
+            None => secret.clone(),
+        };
+        resolved.push((key.clone(), value));
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Split a reference into the secret's name or ARN and the key to take
+/// from it, if any, which comes after a colon. Names can't have colons,
+/// while ARNs always have six before the name.
+fn parse_reference(reference: &str) -> (&str, Option<&str>) {
+    let name_start = if reference.starts_with("arn:") {
+        match reference.match_indices(':').nth(5) {
+            Some((index, _)) => index + 1,
+            None => return (reference, None),
+        }
+    } else {
+        0
+    };
+    match reference[name_start..].split_once(':') {
+        Some((name, field)) => (&reference[..name_start + name.len()], Some(field)),
+        None => (reference, None),
+    }
+}
+
+/// Read the text of a secret with the AWS CLI.
+fn read(id: &str) -> Result<String> {
+    let output = Command::new("aws")
+        .args(["secretsmanager", "get-secret-value", "--secret-id", id])
+        .args(["--output", "json"])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("The AWS CLI is not installed, see https://aws.amazon.com/cli/")
+        }
+        Err(err) => return Err(err).context("Failed to run aws"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("aws failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+
+    let response: serde_json::Value =
+        serde_json::from_slice(&output.stdout).context("aws printed invalid JSON")?;
+    match response["SecretString"].as_str() {
+        Some(secret) => Ok(secret.to_string()),
+        None => anyhow::bail!("The secret is binary, only text secrets are supported"),
+    }
+}
+
+/// Take a key from a secret holding a JSON object, like the ones Secrets
+/// Manager creates for databases. Values that aren't strings are given as
+/// JSON.
+fn field_of(secret: &str, field: &str) -> Option<String> {
+    let object: serde_json::Value = serde_json::from_str(secret).ok()?;
+    match object.as_object()?.get(field)? {
+        serde_json::Value::String(value) => Some(value.clone()),
+        value => Some(value.to_string()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_reference() {
+        assert_eq!(parse_reference("prod/db"), ("prod/db", None));
+        assert_eq!(
+            parse_reference("prod/db:password"),
+            ("prod/db", Some("password"))
+        );
+        let arn = "arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-AbCdEf";
+        assert_eq!(parse_reference(arn), (arn, None));
+        assert_eq!(
+            parse_reference(&format!("{}:password", arn)),
+            (arn, Some("password"))
+        );
+    }
+
+    #[test]
+    fn test_field_of() {
+        let secret = r#"{"username":"app","password":"hunter2","port":5432}"#;
+        assert_eq!(field_of(secret, "password"), Some("hunter2".to_string()));
+        assert_eq!(field_of(secret, "port"), Some("5432".to_string()));
+        assert_eq!(field_of(secret, "host"), None);
+        assert_eq!(field_of("hunter2", "password"), None);
+        assert_eq!(field_of("[1, 2]", "password"), None);
+    }
+}
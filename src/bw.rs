@@ -0,0 +1,216 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret in Bitwarden, followed either
+/// by an item in the password manager and the field to take from it, like
+/// `bw:Database/password`, or by the id of a secret in Secrets Manager.
+pub const PREFIX: &str = "bw:";
+
+/// What a reference to Bitwarden points to.
+#[derive(Debug, PartialEq)]
+enum Reference<'a> {
+    /// A field of an item in the password manager, given by its name or id.
+    Field { item: &'a str, field: &'a str },
+    /// A secret in Secrets Manager, given by its id.
+    Secret(&'a str),
+}
+
+/// Replace, in place, the values referencing secrets in Bitwarden with the
+/// secrets themselves, read with `bw`, and so the session in `BW_SESSION`,
+/// or with `bws` and the access token in `BWS_ACCESS_TOKEN` for Secrets
+/// Manager. Each item or secret is read once, however many keys use it, and
+/// they're read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut references = Vec::new();
+    for (key, value) in vars.iter() {
+        if let Some(reference) = value.strip_prefix(PREFIX) {
+            parse_reference(reference)
+                .with_context(|| format!("Invalid Bitwarden reference in {}", key))?;
+            references.push((key.clone(), reference.to_string()));
+        }
+    }
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let mut items = Vec::new();
+    let mut ids = Vec::new();
+    for (_, reference) in &references {
+        match parse_reference(reference)? {
+            Reference::Field { item, .. } => items.push(item),
+            Reference::Secret(id) => ids.push(id),
+        }
+    }
+    let items = secrets::fetch_all(items, read_item);
+    let secrets = secrets::fetch_all(ids, read_secret);
+
+    let mut resolved = Vec::new();
+    for (key, reference) in &references {
+        let value = match parse_reference(reference)? {
+            Reference::Field { item, field } => match &items[item] {
+                Ok(found) => field_of(found, field).with_context(|| {
+                    format!(
+                        "The item {:?} in Bitwarden has no field {:?}, needed by {}",
+                        item, field, key
+                    )
+                })?,
+                Err(err) => anyhow::bail!(
+                    "Could not read the item {:?} from Bitwarden for {}: {:#}",
+                    item,
+                    key,
+                    err
+                ),
+            },
+            Reference::Secret(id) => match &secrets[id] {
+                Ok(secret) => secret.clone(),
+                Err(err) => anyhow::bail!(
+                    "Could not read {} from Bitwarden Secrets Manager for {}: {:#}",
+                    id,
+                    key,
+                    err
+                ),
+            },
+        };
+        resolved.push((key.clone(), value));
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Tell what a reference points to: a field of an item when it comes after
+/// the last slash, or else a secret in Secrets Manager, whose ids never
+/// have slashes.
+fn parse_reference(reference: &str) -> Result<Reference<'_>> {
+    match reference.rsplit_once('/') {
+        Some((item, field)) if !item.is_empty() && !field.is_empty() => {
+            Ok(Reference::Field { item, field })
+        }
+        None if !reference.is_empty() => Ok(Reference::Secret(reference)),
+        _ => anyhow::bail!(
+            "Expected an item and a field like {}Database/password, or the id of a secret, got {}{}",
+            PREFIX,
+            PREFIX,
+            reference
+        ),
+    }
+}
+
+/// Read an item from the password manager with `bw`, failing rather than
+/// asking for the master password when the vault is locked.
+fn read_item(item: &str) -> Result<serde_json::Value> {
+    let output = run("bw", &["get", "item", item, "--nointeraction"])?;
+    serde_json::from_slice(&output).context("bw printed invalid JSON")
+}
+
+/// Read a secret from Secrets Manager with `bws`.
+fn read_secret(id: &str) -> Result<String> {
+    let output = run("bws", &["secret", "get", id, "--output", "json"])?;
+    let secret: serde_json::Value =
+        serde_json::from_slice(&output).context("bws printed invalid JSON")?;
+    match secret["value"].as_str() {
+        Some(value) => Ok(value.to_string()),
+        None => anyhow::bail!("bws printed no value for the secret"),
+    }
+}
+
+/// Take a field from an item: its username, password, notes or first URI,
+/// or else one of its custom fields by name.
+fn field_of(item: &serde_json::Value, field: &str) -> Option<String> {
+    let value = match field {
+        "username" | "password" => &item["login"][field],
+        "uri" => &item["login"]["uris"][0]["uri"],
+        "notes" => &item["notes"],
+        _ => {
+            let fields = item["fields"].as_array()?;
+            &fields.iter().find(|custom| custom["name"] == field)?["value"]
+        }
+    };
+    value.as_str().map(str::to_string)
+}
+
+/// Run one of the Bitwarden CLIs and give what it printed.
+fn run(program: &str, args: &[&str]) -> Result<Vec<u8>> {
+    let output = match Command::new(program).args(args).output() {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            let docs = match program {
+                "bws" => "https://bitwarden.com/help/secrets-manager-cli/",
+                _ => "https://bitwarden.com/help/cli/",
+            };
+            anyhow::bail!("{} is not installed, see {}", program, docs)
+        }
+        Err(err) => return Err(err).with_context(|| format!("Failed to run {}", program)),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("{} failed with {}", program, output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    Ok(output.stdout)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_parse_reference() -> Result<()> {
+        assert_eq!(
+            parse_reference("Database/password")?,
+            Reference::Field {
+                item: "Database",
+                field: "password"
+            }
+        );
+        assert_eq!(
+            parse_reference("Team/Database/api key")?,
+            Reference::Field {
+                item: "Team/Database",
+                field: "api key"
+            }
+        );
+        assert_eq!(
+            parse_reference("be8e0ad8-d545-4017-a55a-b02f014d4158")?,
+            Reference::Secret("be8e0ad8-d545-4017-a55a-b02f014d4158")
+        );
+        assert!(parse_reference("").is_err());
+        assert!(parse_reference("Database/").is_err());
+        assert!(parse_reference("/password").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_field_of() {
+        let item = json!({
+            "name": "Database",
+            "notes": "Rotated monthly",
+            "login": {
+                "username": "app",
+                "password": "hunter2",
+                "uris": [{ "uri": "postgres://db.internal" }]
+            },
+            "fields": [{ "name": "api key", "value": "abc123", "type": 1 }]
+        });
+        assert_eq!(field_of(&item, "username"), Some("app".to_string()));
+        assert_eq!(field_of(&item, "password"), Some("hunter2".to_string()));
+        assert_eq!(
+            field_of(&item, "uri"),
+            Some("postgres://db.internal".to_string())
+        );
+        assert_eq!(
+            field_of(&item, "notes"),
+            Some("Rotated monthly".to_string())
+        );
+        assert_eq!(field_of(&item, "api key"), Some("abc123".to_string()));
+        assert_eq!(field_of(&item, "totp"), None);
+
+        let note = json!({ "name": "Note", "notes": null });
+        assert_eq!(field_of(&note, "notes"), None);
+        assert_eq!(field_of(&note, "password"), None);
+    }
+}
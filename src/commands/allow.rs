@@ -0,0 +1,57 @@
+use anyhow::{Context, Result};
+use std::{
+    env,
+    path::{Path, PathBuf},
+};
+
+use crate::{profiles, project, trust};
+
+/// Allow a file to be loaded, along with the commands it runs, as it is now. Without a
+/// file, the project file and the environment files that would be loaded
+/// from the current directory are allowed.
+pub fn allow(file: Option<&Path>) -> Result<()> {
+    for file in targets(file)? {
+        trust::allow(&file)?;
+        note!("Allowed {}", file.display());
+    }
+    Ok(())
+}
+
+/// Stop allowing a file, so it has to be allowed again before it's loaded.
+pub fn deny(file: Option<&Path>) -> Result<()> {
+    for file in targets(file)? {
+        if trust::deny(&file)? {
+            note!("Denied {}", file.display());
+        } else {
+            note!("{} wasn't allowed", file.display());
+        }
+    }
+    Ok(())
+}
+
+/// Find the files to allow or deny: the one given, or the ones dotenv would
+/// load from the current directory.
+fn targets(file: Option<&Path>) -> Result<Vec<PathBuf>> {
+    if let Some(file) = file {
+        if !file.is_file() {
+            anyhow::bail!("{} is not a file", file.display());
+        }
+        return Ok(vec![file.to_path_buf()]);
+    }
+
+    let cwd = env::current_dir().context("Could not get current directory")?;
+    let mut files = Vec::new();
+    let mut env_files = Vec::new();
+    if let Some(project) = project::Project::find(&cwd)? {
+        env_files = project.files();
+        files.push(project.path);
+    }
+    if env_files.is_empty() {
+        env_files.extend(profiles::resolve(None)?);
+    }
+    files.extend(env_files);
+    if files.is_empty() {
+        anyhow::bail!("No environment file found");
+    }
+    Ok(files)
+}
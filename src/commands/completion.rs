@@ -0,0 +1,257 @@
+use anyhow::Result;
+use clap::{Command, ValueEnum};
+
+/// Shells a completion script can be generated for.
+#[derive(ValueEnum, Clone, Copy, Debug, PartialEq)]
+pub enum Shell {
+    Bash,
+    Zsh,
+    Fish,
+}
+
+/// Print the completion script for the given shell. Subcommands and flags
+/// are read from the CLI definition, while environment names are looked
+/// up at completion time with `dotenv list --names`.
+pub fn run(shell: Shell, cmd: Command) -> Result<()> {
+    let subcommands = subcommands(&cmd);
+    let script = match shell {
+        Shell::Bash => bash(&cmd, &subcommands),
+        Shell::Zsh => zsh(&cmd, &subcommands),
+        Shell::Fish => fish(&cmd, &subcommands),
+    };
+
+    print!("{}", script);
+    Ok(())
+}
+
+/// A subcommand name, its description and its long flags.
+struct Entry {
+    name: String,
+    about: String,
+    flags: Vec<(String, String)>,
+}
+
+/// Collect the visible subcommands along with their long flags.
+fn subcommands(cmd: &Command) -> Vec<Entry> {
+    cmd.get_subcommands()
+        .filter(|sub| !sub.is_hide_set())
+        .map(|sub| Entry {
+            name: sub.get_name().to_string(),
+            about: about(sub),
+            flags: flags(sub),
+        })
+        .collect()
+}
+
+/// Get the long flags of a command along with their help text.
+fn flags(cmd: &Command) -> Vec<(String, String)> {
+    cmd.get_arguments()
+        .filter(|arg| !arg.is_hide_set())
+        .filter_map(|arg| {
+            let long = arg.get_long()?;
+            let help = arg.get_help().map(|h| h.to_string()).unwrap_or_default();
+            Some((format!("--{}", long), help))
+        })
+        .collect()
+}
+
+/// Get the one-line description of a command.
+fn about(cmd: &Command) -> String {
+    cmd.get_about().map(|a| a.to_string()).unwrap_or_default()
+}
+
+/// Escape a string to be placed inside single quotes in a shell script.
+fn quote(s: &str) -> String {
+    s.replace('\'', r"'\''")
+}
+
+fn bash(cmd: &Command, subcommands: &[Entry]) -> String {
+    let names: Vec<&str> = subcommands.iter().map(|s| s.name.as_str()).collect();
+    let root_flags: Vec<String> = flags(cmd).into_iter().map(|(f, _)| f).collect();
+
+    let mut cases = String::new();
+    for sub in subcommands {
+        let opts: Vec<&str> = sub.flags.iter().map(|(f, _)| f.as_str()).collect();
+        cases.push_str(&format!(
+            "        {}) opts=\"{}\" ;;\n",
+            sub.name,
+            opts.join(" ")
+        ));
+    }
+
+    format!(
+        r#"# bash completion for dotenv
+_dotenv() {{
+    local cur prev opts
+    cur="${{COMP_WORDS[COMP_CWORD]}}"
+    prev="${{COMP_WORDS[COMP_CWORD-1]}}"
+
+    if [[ "$prev" == "-e" || "$prev" == "--environment" ]]; then
+        COMPREPLY=( $(compgen -W "$(dotenv list --names 2>/dev/null)" -- "$cur") )
+        return
+    fi
+
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=( $(compgen -W "{names} {root}" -- "$cur") $(compgen -c -- "$cur") )
+        return
+    fi
+
+    case "${{COMP_WORDS[1]}}" in
+{cases}        *) opts="{root}" ;;
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=( $(compgen -W "$opts" -- "$cur") )
+    fi
+}}
+
+complete -o default -F _dotenv dotenv
+"#,
+        names = names.join(" "),
+        root = root_flags.join(" "),
+        cases = cases,
+    )
+}
+
+fn zsh(cmd: &Command, subcommands: &[Entry]) -> String {
+    let described: Vec<String> = subcommands
+        .iter()
+        .map(|s| format!("    '{}:{}'", s.name, quote(&s.about)))
+        .collect();
+    let root_flags: Vec<String> = flags(cmd).into_iter().map(|(f, _)| f).collect();
+
+    let mut cases = String::new();
+    for sub in subcommands {
+        let opts: Vec<&str> = sub.flags.iter().map(|(f, _)| f.as_str()).collect();
+        cases.push_str(&format!(
+            "        {}) opts=({}) ;;\n",
+            sub.name,
+            opts.join(" ")
+        ));
+    }
+
+    format!(
+        r#"#compdef dotenv
+# zsh completion for dotenv
+_dotenv() {{
+    local -a subcommands environments opts
+
+    if [[ "${{words[CURRENT-1]}}" == "-e" || "${{words[CURRENT-1]}}" == "--environment" ]]; then
+        environments=(${{(f)"$(dotenv list --names 2>/dev/null)"}})
+        compadd -a environments
+        return
+    fi
+
+    if (( CURRENT == 2 )); then
+        subcommands=(
+{described}
+        )
+        _describe 'command' subcommands
+        compadd -- {root}
+        _command_names -e
+        return
+    fi
+
+    case "${{words[2]}}" in
+{cases}        *) opts=({root}) ;;
+    esac
+
+    if [[ "$PREFIX" == -* ]]; then
+        compadd -a opts
+    else
+        _files
+    fi
+}}
+
+compdef _dotenv dotenv
+"#,
+        described = described.join("\n"),
+        root = root_flags.join(" "),
+        cases = cases,
+    )
+}
+
+fn fish(cmd: &Command, subcommands: &[Entry]) -> String {
+    let names: Vec<&str> = subcommands.iter().map(|s| s.name.as_str()).collect();
+
+    let mut out = String::from("# fish completion for dotenv\n");
+    out.push_str(
+        "complete -c dotenv -s e -l environment -x -a '(dotenv list --names 2>/dev/null)' -d 'Named environment'\n",
+    );
+
+    for (flag, help) in flags(cmd) {
+        if flag == "--environment" {
+            continue;
+        }
+        out.push_str(&format!(
+            "complete -c dotenv -n '__fish_use_subcommand' -l {} -d '{}'\n",
+            flag.trim_start_matches("--"),
+            quote(&help)
+        ));
+    }
+
+    for sub in subcommands {
+        out.push_str(&format!(
+            "complete -c dotenv -n '__fish_use_subcommand' -a {} -d '{}'\n",
+            sub.name,
+            quote(&sub.about)
+        ));
+        for (flag, help) in &sub.flags {
+            if flag == "--environment" {
+                continue;
+            }
+            out.push_str(&format!(
+                "complete -c dotenv -n '__fish_seen_subcommand_from {}' -l {} -d '{}'\n",
+                sub.name,
+                flag.trim_start_matches("--"),
+                quote(help)
+            ));
+        }
+    }
+
+    out.push_str(&format!(
+        "complete -c dotenv -n 'not __fish_seen_subcommand_from {}' -a '(__fish_complete_subcommand)'\n",
+        names.join(" ")
+    ));
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::Arg;
+
+    fn command() -> Command {
+        Command::new("dotenv")
+            .arg(Arg::new("environment").short('e').long("environment"))
+            .subcommand(
+                Command::new("list")
+                    .about("List the environment's files")
+                    .arg(Arg::new("json").long("json").help("Print JSON")),
+            )
+            .subcommand(Command::new("secret").hide(true))
+    }
+
+    #[test]
+    fn test_subcommands_skip_hidden() {
+        let entries = subcommands(&command());
+        assert_eq!(entries.len(), 1);
+        assert_eq!(entries[0].name, "list");
+        assert_eq!(
+            entries[0].flags,
+            vec![("--json".to_string(), "Print JSON".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_scripts_include_subcommands() {
+        let cmd = command();
+        let entries = subcommands(&cmd);
+
+        assert!(bash(&cmd, &entries).contains("        list) opts=\"--json\" ;;\n"));
+        assert!(zsh(&cmd, &entries).contains("'list:List the environment'\\''s files'"));
+        assert!(fish(&cmd, &entries).contains(
+            "complete -c dotenv -n '__fish_seen_subcommand_from list' -l json -d 'Print JSON'\n"
+        ));
+    }
+}
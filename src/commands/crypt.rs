@@ -0,0 +1,55 @@
+use anyhow::{Context, Result};
+use std::{fs, path::Path};
+
+use crate::{age, env_document, env_parser, profiles};
+
+/// Encrypt the resolved environment file in place with age, for the given
+/// recipients. It's decrypted whenever it's loaded, given an identity in
+/// `DOTENV_AGE_IDENTITY`.
+pub fn encrypt(environment: Option<&str>, recipients: &[String]) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let content = read(&file)?;
+    if age::is_encrypted(&content) {
+        anyhow::bail!("{} is already encrypted", file.display());
+    }
+
+    // Catch mistakes while they can still be seen
+    let plain = String::from_utf8_lossy(&content);
+    let errors = env_parser::validate_env_str(&plain);
+    if !errors.is_empty() {
+        note!("{} is not valid:", file.display());
+        for error in &errors {
+            note!("  {}", error);
+        }
+        anyhow::bail!("Fix the file before encrypting it");
+    }
+
+    let encrypted = age::encrypt(&content, recipients)
+        .with_context(|| format!("Could not encrypt {}", file.display()))?;
+    env_document::replace_file(&file, &encrypted)?;
+    note!("Encrypted {}", file.display());
+    Ok(())
+}
+
+/// Decrypt the resolved environment file in place, so it can be changed,
+/// with the identity in `DOTENV_AGE_IDENTITY`.
+pub fn decrypt(environment: Option<&str>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let content = read(&file)?;
+    if !age::is_encrypted(&content) {
+        anyhow::bail!("{} is not encrypted", file.display());
+    }
+
+    let plain = age::decrypt(&file, &content)?;
+    env_document::replace_file(&file, plain.as_bytes())?;
+    note!(
+        "Decrypted {}, run `dotenv encrypt` again once you're done with it",
+        file.display()
+    );
+    Ok(())
+}
+
+/// Read the raw contents of an environment file.
+fn read(file: &Path) -> Result<Vec<u8>> {
+    fs::read(file).with_context(|| format!("Failed to read .env file at {}", file.display()))
+}
@@ -0,0 +1,92 @@
+use anyhow::{Context, Result};
+use std::collections::{BTreeSet, HashMap};
+
+use crate::{extends, mask, profiles};
+
+/// A difference in a single variable between two environments.
+#[derive(Debug, PartialEq)]
+enum Change<'a> {
+    Added(&'a str, &'a str),
+    Removed(&'a str, &'a str),
+    Changed(&'a str, &'a str, &'a str),
+}
+
+/// Print the variables added, removed and changed between two
+/// environments, each given as a file path or a named environment. With
+/// `exit_code`, exit with status 1 when they differ, like `git diff`.
+pub fn run(left: &str, right: &str, reveal: bool, exit_code: bool) -> Result<()> {
+    let left_vars = load(left)?;
+    let right_vars = load(right)?;
+
+    let changes = compare(&left_vars, &right_vars);
+    for change in &changes {
+        match *change {
+            Change::Added(key, value) => {
+                println!("+ {}={}", key, mask::display_value(key, value, reveal))
+            }
+            Change::Removed(key, value) => {
+                println!("- {}={}", key, mask::display_value(key, value, reveal))
+            }
+            Change::Changed(key, old, new) => println!(
+                "~ {}: {} -> {}",
+                key,
+                mask::display_value(key, old, reveal),
+                mask::display_value(key, new, reveal)
+            ),
+        }
+    }
+
+    if exit_code && !changes.is_empty() {
+        std::process::exit(1);
+    }
+
+    Ok(())
+}
+
+/// Load the variables of an environment given as a path or a name.
+fn load(target: &str) -> Result<HashMap<String, String>> {
+    let file = profiles::resolve_path_or_name(target)?
+        .with_context(|| format!("No environment file found for: {}", target))?;
+
+    extends::load(&file)
+}
+
+/// Compare two sets of variables, returning the changes sorted by key.
+fn compare<'a>(
+    left: &'a HashMap<String, String>,
+    right: &'a HashMap<String, String>,
+) -> Vec<Change<'a>> {
+    let keys: BTreeSet<&String> = left.keys().chain(right.keys()).collect();
+
+    keys.into_iter()
+        .filter_map(|key| match (left.get(key), right.get(key)) {
+            (None, Some(new)) => Some(Change::Added(key, new)),
+            (Some(old), None) => Some(Change::Removed(key, old)),
+            (Some(old), Some(new)) if old != new => Some(Change::Changed(key, old, new)),
+            _ => None,
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::env_parser;
+
+    #[test]
+    fn test_compare() -> Result<()> {
+        let left = env_parser::parse_env_str("SAME=1\nGONE=x\nHOST=staging\n")?;
+        let right = env_parser::parse_env_str("SAME=1\nHOST=prod\nNEW=y\n")?;
+
+        assert_eq!(
+            compare(&left, &right),
+            vec![
+                Change::Removed("GONE", "x"),
+                Change::Changed("HOST", "staging", "prod"),
+                Change::Added("NEW", "y"),
+            ]
+        );
+        assert!(compare(&left, &left).is_empty());
+        Ok(())
+    }
+}
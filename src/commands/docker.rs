@@ -0,0 +1,102 @@
+use anyhow::{Context, Result};
+use std::process::Command;
+
+use crate::{exit, extends};
+
+/// The docker commands starting a container, which take `--env`, as the
+/// words they're written with.
+const SUBCOMMANDS: &[&[&str]] = &[
+    &["run"],
+    &["create"],
+    &["exec"],
+    &["container", "run"],
+    &["container", "create"],
+    &["container", "exec"],
+];
+
+/// Run a docker command starting a container, like `run image`, with the
+/// variables of an environment file set in the container. Each variable is
+/// passed as `--env NAME`, which has docker read its value from its own
+/// environment, so values with quotes or spanning several lines get to the
+/// container as they are, without showing up in the process list.
+pub fn run(environment: Option<&str>, args: &[String]) -> Result<()> {
+    let vars = extends::load_environment(environment)?;
+    let mut cmd = Command::new("docker");
+    cmd.args(docker_args(&vars.keys().collect::<Vec<_>>(), args)?)
+        .envs(&vars);
+
+    if cfg!(unix) {
+        let err = crate::replace_process(cmd)?;
+        return Err(exit::SpawnError(err)).context("Failed to run docker");
+    }
+    let status = cmd
+        .status()
+        .map_err(exit::SpawnError)
+        .context("Failed to run docker")?;
+    std::process::exit(exit::Ended::from(status).exit_code())
+}
+
+/// Add an `--env` flag for each variable right after the docker subcommand,
+/// before any of its own arguments, sorting them so the command is always
+/// the same.
+fn docker_args(keys: &[&String], args: &[String]) -> Result<Vec<String>> {
+    let given: Vec<&str> = args.iter().map(String::as_str).collect();
+    let subcommand = SUBCOMMANDS
+        .iter()
+        .filter(|words| given.starts_with(words))
+        .map(|words| words.len())
+        .max();
+    let Some(subcommand) = subcommand else {
+        anyhow::bail!(
+            "Expected a docker command starting a container, like run, create or exec, got {:?}",
+            args.join(" ")
+        );
+    };
+
+    let mut keys = keys.to_vec();
+    keys.sort();
+
+    let mut docker_args = args[..subcommand].to_vec();
+    for key in keys {
+        docker_args.push("--env".to_string());
+        docker_args.push(key.clone());
+    }
+    docker_args.extend_from_slice(&args[subcommand..]);
+    Ok(docker_args)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn words(words: &[&str]) -> Vec<String> {
+        words.iter().map(|w| w.to_string()).collect()
+    }
+
+    #[test]
+    fn test_docker_args() -> Result<()> {
+        let keys = words(&["TOKEN", "DB_URL"]);
+        let keys: Vec<&String> = keys.iter().collect();
+        assert_eq!(
+            docker_args(&keys, &words(&["run", "--rm", "alpine", "env"]))?,
+            words(&["run", "--env", "DB_URL", "--env", "TOKEN", "--rm", "alpine", "env"])
+        );
+        assert_eq!(
+            docker_args(&keys, &words(&["container", "exec", "web", "sh"]))?,
+            words(&[
+                "container",
+                "exec",
+                "--env",
+                "DB_URL",
+                "--env",
+                "TOKEN",
+                "web",
+                "sh"
+            ])
+        );
+        assert!(docker_args(&keys, &words(&["ps"])).is_err());
+        assert!(docker_args(&keys, &words(&["container"])).is_err());
+        assert!(docker_args(&keys, &[]).is_err());
+        Ok(())
+    }
+}
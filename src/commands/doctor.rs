@@ -0,0 +1,302 @@
+use anyhow::Result;
+use std::{env, fs, path::Path};
+
+use crate::{
+    config, env_parser,
+    profiles::{self, FOLDER_PATH_VAR},
+    project::Project,
+    settings::{self, Scope},
+};
+
+/// How serious a finding is.
+#[derive(Debug, PartialEq, Clone, Copy)]
+enum Level {
+    Ok,
+    Warn,
+    Error,
+}
+
+/// The result of a single health check.
+#[derive(Debug, PartialEq)]
+struct Finding {
+    level: Level,
+    message: String,
+}
+
+impl Finding {
+    fn new(level: Level, message: impl Into<String>) -> Self {
+        Self {
+            level,
+            message: message.into(),
+        }
+    }
+}
+
+/// Print the effective configuration and run a set of health checks on the
+/// dotenv folder and its files, returning an error if any check fails.
+pub fn run() -> Result<()> {
+    let folders = profiles::folders()?;
+    let resolution = profiles::explain(None)?;
+
+    let origin = if env::var_os(FOLDER_PATH_VAR).is_some_and(|v| !v.is_empty()) {
+        FOLDER_PATH_VAR
+    } else if config::Config::load().is_ok_and(|c| !c.folders.is_empty()) {
+        config::CONFIG_FILE
+    } else {
+        "default"
+    };
+    let folders_list: Vec<String> = folders.iter().map(|f| f.display().to_string()).collect();
+
+    println!("Configuration:");
+    println!("  dotenv folder: {} ({})", folders_list.join(", "), origin);
+    match &resolution.name {
+        Some((name, origin)) => println!("  environment:   {} (from {})", name, origin),
+        None => println!(
+            "  environment:   none, using the closest .env file from the current directory"
+        ),
+    }
+    match &resolution.file {
+        Some(file) => println!("  file:          {}", file.display()),
+        None if resolution.is_stdin() => println!("  file:          standard input"),
+        None if resolution.kubernetes().is_some() => {
+            println!("  file:          none, from Kubernetes")
+        }
+        None => println!("  file:          none found"),
+    }
+    let global = config::path().filter(|path| path.exists());
+    let global_result = config::Config::load();
+    match (&global, &global_result) {
+        (Some(path), Ok(_)) => println!("  config file:   {}", path.display()),
+        (Some(_), Err(_)) => println!("  config file:   invalid"),
+        (None, _) => println!("  config file:   none"),
+    }
+    let project = Project::find(&env::current_dir()?);
+    match &project {
+        Ok(Some(project)) => println!("  project file:  {}", project.path.display()),
+        Ok(None) => println!("  project file:  none"),
+        Err(_) => println!("  project file:  invalid"),
+    }
+
+    let mut findings = check_process_env(env::vars().map(|(k, _)| k));
+    for folder in &folders {
+        findings.extend(check_folder(folder));
+    }
+    if let Err(err) = global_result {
+        findings.push(Finding::new(Level::Error, format!("{:#}", err)));
+    }
+    if let Err(err) = project {
+        findings.push(Finding::new(Level::Error, format!("{:#}", err)));
+    }
+
+    println!();
+    println!("Checks:");
+    for finding in &findings {
+        let label = match finding.level {
+            Level::Ok => "ok   ",
+            Level::Warn => "warn ",
+            Level::Error => "error",
+        };
+        println!("  {} {}", label, finding.message);
+    }
+
+    let errors = findings.iter().filter(|f| f.level == Level::Error).count();
+    if errors > 0 {
+        anyhow::bail!("Found {} problem(s)", errors);
+    }
+
+    Ok(())
+}
+
+/// Look for `DOTENV_*` variables in the process environment that dotenv
+/// doesn't understand or only reads from environment files.
+fn check_process_env(keys: impl Iterator<Item = String>) -> Vec<Finding> {
+    let mut findings = Vec::new();
+
+    let mut keys: Vec<String> = keys.filter(|k| k.starts_with("DOTENV")).collect();
+    keys.sort();
+
+    for key in keys {
+        match settings::find(&key).map(|s| s.scope) {
+            Some(Scope::Process | Scope::Child) => {}
+            Some(Scope::File) => findings.push(Finding::new(
+                Level::Warn,
+                format!(
+                    "{} is set in your shell but is only read from environment files",
+                    key
+                ),
+            )),
+            None => findings.push(Finding::new(
+                Level::Warn,
+                format!("{} is set in your shell but is not a dotenv setting", key),
+            )),
+        }
+    }
+
+    findings
+}
+
+/// Check that the dotenv folder exists and that every environment file in
+/// it is private and well-formed.
+fn check_folder(folder: &Path) -> Vec<Finding> {
+    let mut findings = Vec::new();
+
+    if !folder.is_dir() {
+        findings.push(Finding::new(
+            Level::Warn,
+            format!(
+                "dotenv folder {} does not exist, create it with `dotenv init`",
+                folder.display()
+            ),
+        ));
+        return findings;
+    }
+    findings.push(Finding::new(
+        Level::Ok,
+        format!("dotenv folder {} exists", folder.display()),
+    ));
+    findings.extend(check_permissions(folder));
+
+    let profiles = match profiles::list_in(folder) {
+        Ok(profiles) => profiles,
+        Err(err) => {
+            findings.push(Finding::new(Level::Error, format!("{:#}", err)));
+            return findings;
+        }
+    };
+
+    for profile in &profiles {
+        findings.extend(check_permissions(&profile.path));
+
+        // Encrypted files can only be checked once decrypted
+        if profile.encrypted {
+            continue;
+        }
+        let content = match fs::read_to_string(&profile.path) {
+            Ok(content) => content,
+            Err(err) => {
+                findings.push(Finding::new(
+                    Level::Error,
+                    format!("{} can't be read: {}", profile.path.display(), err),
+                ));
+                continue;
+            }
+        };
+
+        for error in env_parser::validate_env_str(&content) {
+            findings.push(Finding::new(
+                Level::Error,
+                format!("{}: {}", profile.path.display(), error),
+            ));
+        }
+
+        if let Ok(vars) = env_parser::parse_env_str(&content) {
+            let mut unknown: Vec<&String> = vars
+                .keys()
+                .filter(|k| {
+                    k.starts_with("DOTENV")
+                        && settings::find(k).is_none_or(|s| s.scope != Scope::File)
+                })
+                .collect();
+            unknown.sort();
+
+            for key in unknown {
+                findings.push(Finding::new(
+                    Level::Warn,
+                    format!(
+                        "{} sets {} which dotenv does not read from environment files",
+                        profile.path.display(),
+                        key
+                    ),
+                ));
+            }
+        }
+    }
+
+    findings.push(Finding::new(
+        Level::Ok,
+        format!("{} environment file(s) checked", profiles.len()),
+    ));
+    findings
+}
+
+/// Warn when a file or folder can be accessed by other users.
+#[cfg(unix)]
+fn check_permissions(path: &Path) -> Vec<Finding> {
+    use std::os::unix::fs::PermissionsExt;
+
+    match fs::metadata(path) {
+        Ok(metadata) if metadata.permissions().mode() & 0o077 != 0 => vec![Finding::new(
+            Level::Warn,
+            format!(
+                "{} is accessible by other users (mode {:o})",
+                path.display(),
+                metadata.permissions().mode() & 0o777
+            ),
+        )],
+        _ => Vec::new(),
+    }
+}
+
+/// Permissions are managed differently on non-Unix platforms, so there's
+/// nothing to check.
+#[cfg(not(unix))]
+fn check_permissions(_path: &Path) -> Vec<Finding> {
+    Vec::new()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_check_process_env() {
+        let keys = ["PATH", "DOTENV", "DOTENV_STRICT", "DOTENV_FOLDER"].map(String::from);
+        let findings = check_process_env(keys.into_iter());
+
+        assert_eq!(
+            findings,
+            vec![
+                Finding::new(
+                    Level::Warn,
+                    "DOTENV_FOLDER is set in your shell but is not a dotenv setting"
+                ),
+                Finding::new(
+                    Level::Warn,
+                    "DOTENV_STRICT is set in your shell but is only read from environment files"
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_check_folder_missing() -> Result<()> {
+        let dir = tempdir()?;
+        let findings = check_folder(&dir.path().join("missing"));
+        assert_eq!(findings.len(), 1);
+        assert_eq!(findings[0].level, Level::Warn);
+        Ok(())
+    }
+
+    #[test]
+    fn test_check_folder_flags_problems() -> Result<()> {
+        let dir = tempdir()?;
+        fs::write(dir.path().join("good.env"), "FOO=bar\n")?;
+        fs::write(dir.path().join("bad.env"), "FOO\nDOTENV_STRCT=true\n")?;
+
+        let findings = check_folder(dir.path());
+        let errors: Vec<&Finding> = findings
+            .iter()
+            .filter(|f| f.level == Level::Error)
+            .collect();
+        assert_eq!(errors.len(), 1);
+        assert!(errors[0]
+            .message
+            .ends_with("line 1: missing '=' in \"FOO\""));
+
+        assert!(findings
+            .iter()
+            .any(|f| f.level == Level::Warn && f.message.contains("DOTENV_STRCT")));
+        Ok(())
+    }
+}
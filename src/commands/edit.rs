@@ -0,0 +1,153 @@
+use anyhow::{Context, Result};
+use std::{
+    env, fs,
+    io::{self, BufRead, IsTerminal, Write},
+    path::Path,
+    process::Command,
+};
+
+use crate::{age, env_parser, gpg, profiles, sops, store};
+
+/// Open the resolved environment file in the user's editor. Changes are
+/// made to a temporary copy and only written back once they validate, so
+/// a malformed edit never replaces the original file.
+pub fn run(environment: Option<&str>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    if gpg::is_encrypted(&file) {
+        anyhow::bail!(
+            "{} is encrypted with GPG, decrypt it with gpg to change it",
+            file.display()
+        );
+    }
+    let stored = store::entry_for(&file)?;
+    let original = match &stored {
+        Some(content) => content.clone(),
+        None => fs::read_to_string(&file)
+            .with_context(|| format!("Failed to read .env file at {}", file.display()))?,
+    };
+    // Editing a decrypted copy would leave the secrets on disk in plain text
+    if age::is_encrypted(original.as_bytes()) {
+        anyhow::bail!(
+            "{} is encrypted with age, run `dotenv decrypt` to change it",
+            file.display()
+        );
+    }
+    if sops::is_encrypted(&original) {
+        anyhow::bail!(
+            "{} is encrypted with SOPS, run `sops edit` to change it",
+            file.display()
+        );
+    }
+
+    let dir = file.parent().unwrap_or_else(|| Path::new("."));
+    let mut scratch = tempfile::Builder::new()
+        .prefix(".dotenv-edit-")
+        .suffix(".env")
+        .tempfile_in(dir)
+        .with_context(|| format!("Could not create temporary file in {}", dir.display()))?;
+    scratch.write_all(original.as_bytes())?;
+    scratch.flush()?;
+
+    let edited = loop {
+        open_editor(scratch.path())?;
+
+        let edited = fs::read_to_string(scratch.path())
+            .context("Failed to read the edited environment file")?;
+        let errors = env_parser::validate_env_str(&edited);
+        if errors.is_empty() {
+            break edited;
+        }
+
+        note!("The edited environment file is not valid:");
+        for error in &errors {
+            note!("  {}", error);
+        }
+
+        if !io::stdin().is_terminal() {
+            anyhow::bail!("Changes were not saved to {}", file.display());
+        }
+
+        eprint!("Press Enter to edit the file again, or type \"q\" to discard your changes: ");
+        io::stderr().flush()?;
+        let mut answer = String::new();
+        io::stdin().lock().read_line(&mut answer)?;
+        if answer.trim().eq_ignore_ascii_case("q") {
+            anyhow::bail!("Changes were not saved to {}", file.display());
+        }
+    };
+
+    if edited == original {
+        note!("No changes made to {}", file.display());
+        return Ok(());
+    }
+
+    if stored.is_some() {
+        store::write_entry(&file, edited.as_bytes())?;
+        note!("Saved changes to {}", file.display());
+        return Ok(());
+    }
+
+    // Keep the original permissions since env files usually hold credentials
+    let permissions = fs::metadata(&file)?.permissions();
+    fs::set_permissions(scratch.path(), permissions)?;
+    scratch
+        .persist(&file)
+        .with_context(|| format!("Could not save changes to {}", file.display()))?;
+
+    note!("Saved changes to {}", file.display());
+    Ok(())
+}
+
+/// Open the given file with `$VISUAL` or `$EDITOR`, falling back to a
+/// platform default, and wait for the editor to exit.
+fn open_editor(path: &Path) -> Result<()> {
+    let editor = editor_command();
+    let mut parts = editor.split_whitespace();
+    let program = parts.next().context("No editor configured")?;
+
+    let status = Command::new(program)
+        .args(parts)
+        .arg(path)
+        .status()
+        .with_context(|| format!("Failed to launch editor: {}", editor))?;
+
+    if !status.success() {
+        anyhow::bail!("Editor exited with an error: {}", editor);
+    }
+
+    Ok(())
+}
+
+/// Get the editor to use, preferring `$VISUAL` over `$EDITOR`.
+fn editor_command() -> String {
+    ["VISUAL", "EDITOR"]
+        .iter()
+        .filter_map(|var| env::var(var).ok())
+        .find(|val| !val.trim().is_empty())
+        .unwrap_or_else(|| {
+            if cfg!(windows) {
+                "notepad".to_string()
+            } else {
+                "vi".to_string()
+            }
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_editor_command_prefers_visual() {
+        let _env = crate::lock_env();
+        env::set_var("VISUAL", "code --wait");
+        env::set_var("EDITOR", "nano");
+        assert_eq!(editor_command(), "code --wait");
+
+        env::set_var("VISUAL", "");
+        assert_eq!(editor_command(), "nano");
+
+        env::remove_var("VISUAL");
+        env::remove_var("EDITOR");
+    }
+}
@@ -0,0 +1,122 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::BTreeSet,
+    fs,
+    path::{Path, PathBuf},
+};
+
+use crate::{env_document::EnvDocument, env_parser, profiles};
+
+/// Name of the example file generated next to an environment file.
+pub const EXAMPLE_FILE: &str = ".env.example";
+
+/// Value written in place of every scrubbed value.
+const PLACEHOLDER: &str = "changeme";
+
+/// Write a copy of the resolved environment file with its values scrubbed,
+/// safe to commit, to `output` or to a `.env.example` file next to it.
+pub fn generate(environment: Option<&str>, output: Option<&Path>, force: bool) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let example = example_path(&file, output);
+
+    if example.exists() && !force {
+        anyhow::bail!(
+            "Example file already exists: {} (use --force to overwrite it)",
+            example.display()
+        );
+    }
+
+    let doc = EnvDocument::load(&file)?.scrubbed(PLACEHOLDER);
+    fs::write(&example, doc.to_string())
+        .with_context(|| format!("Could not write example file: {}", example.display()))?;
+
+    note!("Created example file: {}", example.display());
+    Ok(())
+}
+
+/// Report the keys defined in the resolved environment file but not in its
+/// example file, and the other way around, returning an error if there are
+/// any.
+pub fn check(environment: Option<&str>, example: Option<&Path>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let example = example_path(&file, example);
+
+    let env_keys = keys(&file)?;
+    let example_keys = keys(&example)?;
+
+    let missing: Vec<&String> = env_keys.difference(&example_keys).collect();
+    let extra: Vec<&String> = example_keys.difference(&env_keys).collect();
+    if missing.is_empty() && extra.is_empty() {
+        note!("{} matches {}", example.display(), file.display());
+        return Ok(());
+    }
+
+    for key in &missing {
+        note!("{}: missing from {}", key, example.display());
+    }
+    for key in &extra {
+        note!("{}: missing from {}", key, file.display());
+    }
+
+    anyhow::bail!(
+        "{} and {} define different keys",
+        file.display(),
+        example.display()
+    )
+}
+
+/// Get the example file for an environment file: the given path, if any,
+/// or a `.env.example` file in the same folder.
+fn example_path(file: &Path, explicit: Option<&Path>) -> PathBuf {
+    match explicit {
+        Some(path) => path.to_path_buf(),
+        None => file
+            .parent()
+            .unwrap_or_else(|| Path::new("."))
+            .join(EXAMPLE_FILE),
+    }
+}
+
+/// Read the keys defined in a file. Keys with empty values, which example
+/// files often have, count too.
+fn keys(file: &Path) -> Result<BTreeSet<String>> {
+    let content = fs::read_to_string(file)
+        .with_context(|| format!("Failed to read .env file at {}", file.display()))?;
+    Ok(keys_in(&content))
+}
+
+/// Get the keys defined in a `.env` format string.
+fn keys_in(content: &str) -> BTreeSet<String> {
+    content
+        .lines()
+        .filter_map(env_parser::strip_comments)
+        .filter_map(|line| line.split_once('='))
+        .map(|(key, _)| key.trim())
+        .filter(|key| env_parser::is_valid_key(key))
+        .map(String::from)
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_keys_in_counts_empty_values() {
+        let keys = keys_in("# Comment\nDB_HOST=localhost\nAPI_KEY=\n\nnot a pair\nDB_HOST=again\n");
+        assert_eq!(
+            keys.into_iter().collect::<Vec<_>>(),
+            vec!["API_KEY", "DB_HOST"]
+        );
+    }
+
+    #[test]
+    fn test_example_path() {
+        let file = Path::new("/app/.env");
+        assert_eq!(example_path(file, None), PathBuf::from("/app/.env.example"));
+        assert_eq!(
+            example_path(file, Some(Path::new("/tmp/example"))),
+            PathBuf::from("/tmp/example")
+        );
+    }
+}
@@ -0,0 +1,36 @@
+use anyhow::{Context, Result};
+
+use crate::{extends, permissions, profiles};
+
+/// Take away the access other users have to the environment file that
+/// would be loaded, the environments it extends and their machine-local
+/// overlays, so they're only accessible by the current user.
+pub fn run(environment: Option<&str>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let files = extends::files(&file)?;
+
+    let mut fixed = false;
+    for file in &files {
+        if let Some(mode) = permissions::restrict(file)? {
+            note!(
+                "Restricted {} from mode {:o} to {:o}",
+                file.display(),
+                mode,
+                mode & 0o700
+            );
+            fixed = true;
+        }
+        // Only the owner or root can give the file back
+        for problem in permissions::problems(file) {
+            note!(
+                "{} is {}, which only they or root can change",
+                file.display(),
+                problem
+            );
+        }
+    }
+    if !fixed {
+        note!("Nothing to fix, the environment files are only accessible by you");
+    }
+    Ok(())
+}
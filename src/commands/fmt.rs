@@ -0,0 +1,57 @@
+use anyhow::{Context, Result};
+use std::{fs, path::PathBuf};
+
+use crate::{age, env_document::EnvDocument, env_parser, gpg, profiles, sops};
+
+/// Rewrite environment files in their canonical form. With `check`, files
+/// are left untouched and an error is returned if any of them would change.
+pub fn run(environment: Option<&str>, files: &[PathBuf], sort: bool, check: bool) -> Result<()> {
+    let files = if files.is_empty() {
+        vec![profiles::resolve(environment)?.context("No environment file found")?]
+    } else {
+        files.to_vec()
+    };
+
+    let mut failed = 0;
+    for file in &files {
+        if gpg::is_encrypted(file) {
+            note!("{} is encrypted, skipping it", file.display());
+            continue;
+        }
+        let content = fs::read_to_string(file)
+            .with_context(|| format!("Failed to read .env file at {}", file.display()))?;
+        if age::is_encrypted(content.as_bytes()) || sops::is_encrypted(&content) {
+            note!("{} is encrypted, skipping it", file.display());
+            continue;
+        }
+
+        let errors = env_parser::validate_env_str(&content);
+        if !errors.is_empty() {
+            note!("{} is not valid:", file.display());
+            for error in &errors {
+                note!("  {}", error);
+            }
+            failed += 1;
+            continue;
+        }
+
+        let formatted = EnvDocument::parse(&content).formatted(sort);
+        if formatted.to_string() == content {
+            continue;
+        }
+
+        if check {
+            note!("{} is not formatted", file.display());
+            failed += 1;
+        } else {
+            formatted.save(file)?;
+            note!("Formatted {}", file.display());
+        }
+    }
+
+    if failed > 0 {
+        anyhow::bail!("{} of {} file(s) need attention", failed, files.len());
+    }
+
+    Ok(())
+}
@@ -0,0 +1,237 @@
+use anyhow::{Context, Result};
+use std::{
+    env,
+    process::{Child, Command, Stdio},
+    thread,
+    time::{Duration, Instant},
+};
+
+use crate::{exit, output, profiles, signals, table};
+
+/// How often the running environments are checked for having finished.
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// How running the command in one environment ended.
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Outcome {
+    /// Not run, because another one failed with `--fail-fast` or dotenv
+    /// was asked to stop.
+    Skipped,
+    /// Ended, after running for a while.
+    Ended(exit::Ended, Duration),
+}
+
+/// Run a command once in every named environment matching the patterns,
+/// `jobs` of them at a time, then print how each run went. Once one fails,
+/// the ones not started yet are skipped with `fail_fast`. Exits with the
+/// status of the first environment that failed, in order.
+pub fn run(patterns: &[String], jobs: usize, fail_fast: bool, command: &[String]) -> Result<()> {
+    let names: Vec<String> = profiles::list()?.into_iter().map(|p| p.name).collect();
+    let names = select(&names, patterns)?;
+    let exe = env::current_exe().context("Could not find the dotenv executable")?;
+
+    // Running at once, the commands can't share the terminal, and their
+    // output is told apart by prefixing it with the environment's name
+    let parallel = jobs > 1 && names.len() > 1;
+
+    signals::install();
+    let mut outcomes = vec![Outcome::Skipped; names.len()];
+    let mut running: Vec<(usize, Child, Instant)> = Vec::new();
+    let mut next = 0;
+    let mut failed = false;
+    let mut stopped = false;
+    loop {
+        let stopping = signals::stopping() || (fail_fast && failed);
+        while !stopping && running.len() < jobs && next < names.len() {
+            let name = &names[next];
+            let mut cmd = Command::new(&exe);
+            cmd.arg("run").arg("--environment").arg(name);
+            if output::is_quiet() {
+                cmd.arg("--quiet");
+            }
+            if parallel {
+                cmd.arg(format!("--prefix-output={}", name));
+                cmd.stdin(Stdio::null());
+            } else {
+                note!("dotenv: running in {}", name);
+            }
+            cmd.arg("--").args(command);
+
+            let child = cmd
+                .spawn()
+                .map_err(exit::SpawnError)
+                .with_context(|| format!("Failed to run the command in {}", name))?;
+            running.push((next, child, Instant::now()));
+            next += 1;
+        }
+        if running.is_empty() {
+            break;
+        }
+
+        let mut index = 0;
+        while index < running.len() {
+            let (position, child, started) = &mut running[index];
+            let status = child.try_wait().with_context(|| {
+                format!("Failed to wait for the command in {}", names[*position])
+            })?;
+            match status {
+                Some(status) => {
+                    failed |= !status.success();
+                    outcomes[*position] = Outcome::Ended(status.into(), started.elapsed());
+                    running.remove(index);
+                }
+                None => index += 1,
+            }
+        }
+
+        // The dotenv running in each environment relays the signal to its
+        // command, killing it once the grace period is over
+        if signals::stopping() && !stopped {
+            for (_, child, _) in running.iter_mut() {
+                signals::terminate(child);
+            }
+            stopped = true;
+        }
+        thread::sleep(POLL_INTERVAL);
+    }
+
+    note!("\n{}", summary(&names, &outcomes).trim_end());
+    std::process::exit(exit_code(&outcomes))
+}
+
+/// Keep the environments matching any of the patterns, in order. Every
+/// pattern has to match at least one of them.
+fn select(names: &[String], patterns: &[String]) -> Result<Vec<String>> {
+    // Patterns can be written like the files, with their extension
+    let patterns: Vec<&str> = patterns
+        .iter()
+        .map(|p| p.strip_suffix(".env").unwrap_or(p))
+        .collect();
+
+    for pattern in &patterns {
+        if !names.iter().any(|name| matches(pattern, name)) {
+            anyhow::bail!("No environments match {:?}", pattern);
+        }
+    }
+    Ok(names
+        .iter()
+        .filter(|name| patterns.iter().any(|pattern| matches(pattern, name)))
+        .cloned()
+        .collect())
+}
+
+/// Check if an environment name matches a pattern, where `*` matches
+/// anything but a slash, `**` matches anything, including nested folders,
+/// and `?` matches a single character other than a slash.
+fn matches(pattern: &str, name: &str) -> bool {
+    fn go(pattern: &[char], name: &[char]) -> bool {
+        match pattern {
+            [] => name.is_empty(),
+            ['*', '*', '/', rest @ ..] => {
+                go(rest, name)
+                    || (0..name.len()).any(|i| name[i] == '/' && go(rest, &name[i + 1..]))
+            }
+            ['*', '*', rest @ ..] => (0..=name.len()).any(|i| go(rest, &name[i..])),
+            ['*', rest @ ..] => (0..=name.len())
+                .take_while(|&i| i == 0 || name[i - 1] != '/')
+                .any(|i| go(rest, &name[i..])),
+            ['?', rest @ ..] => name.first().is_some_and(|&c| c != '/') && go(rest, &name[1..]),
+            [c, rest @ ..] => name.first() == Some(c) && go(rest, &name[1..]),
+        }
+    }
+
+    let pattern: Vec<char> = pattern.chars().collect();
+    let name: Vec<char> = name.chars().collect();
+    go(&pattern, &name)
+}
+
+/// Render how the command went in every environment as a table.
+fn summary(names: &[String], outcomes: &[Outcome]) -> String {
+    let rows: Vec<Vec<String>> = names
+        .iter()
+        .zip(outcomes)
+        .map(|(name, outcome)| {
+            let (status, took) = match outcome {
+                Outcome::Skipped => ("skipped".to_string(), "-".to_string()),
+                Outcome::Ended(ended, took) => {
+                    let status = match ended {
+                        exit::Ended::Exited(0) => "ok".to_string(),
+                        exit::Ended::Exited(code) => format!("exited with status {}", code),
+                        exit::Ended::Killed(signal) => {
+                            format!("killed by {}", exit::signal_name(*signal))
+                        }
+                    };
+                    (status, format!("{:.1}s", took.as_secs_f64()))
+                }
+            };
+            vec![name.clone(), status, took]
+        })
+        .collect();
+    table::render(&["ENVIRONMENT", "STATUS", "TIME"], &rows)
+}
+
+/// Get the status to exit with: the one of the first environment that
+/// failed, 128 plus the signal's number if it was killed by one, or 1 if it
+/// wasn't run at all.
+fn exit_code(outcomes: &[Outcome]) -> i32 {
+    outcomes
+        .iter()
+        .find_map(|outcome| match outcome {
+            Outcome::Ended(exit::Ended::Exited(0), _) => None,
+            Outcome::Ended(ended, _) => Some(ended.exit_code()),
+            Outcome::Skipped => Some(1),
+        })
+        .unwrap_or(0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn names(names: &[&str]) -> Vec<String> {
+        names.iter().map(|n| n.to_string()).collect()
+    }
+
+    #[test]
+    fn test_matches() {
+        assert!(matches("clusters/*", "clusters/prod"));
+        assert!(!matches("clusters/*", "clusters/eu/prod"));
+        assert!(matches("clusters/**", "clusters/eu/prod"));
+        assert!(matches("clusters/**/prod", "clusters/prod"));
+        assert!(matches("clusters/**/prod", "clusters/eu/west/prod"));
+        assert!(matches("*-prod", "api-prod"));
+        assert!(!matches("*-prod", "team/api-prod"));
+        assert!(matches("db?", "db1"));
+        assert!(!matches("db?", "db"));
+        assert!(matches("staging", "staging"));
+        assert!(!matches("staging", "staging-2"));
+    }
+
+    #[test]
+    fn test_select() -> Result<()> {
+        let all = names(&["clusters/eu", "clusters/us", "dev", "prod"]);
+        assert_eq!(
+            select(&all, &names(&["clusters/*.env"]))?,
+            names(&["clusters/eu", "clusters/us"])
+        );
+        assert_eq!(
+            select(&all, &names(&["prod", "clusters/eu", "prod"]))?,
+            names(&["clusters/eu", "prod"])
+        );
+        assert!(select(&all, &names(&["dev", "qa-*"])).is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_exit_code() {
+        let took = Duration::from_secs(1);
+        let exited = |code| Outcome::Ended(exit::Ended::Exited(code), took);
+        assert_eq!(exit_code(&[exited(0)]), 0);
+        assert_eq!(exit_code(&[exited(0), exited(3), exited(2)]), 3);
+        assert_eq!(
+            exit_code(&[Outcome::Ended(exit::Ended::Killed(9), took)]),
+            137
+        );
+        assert_eq!(exit_code(&[exited(0), Outcome::Skipped]), 1);
+    }
+}
@@ -0,0 +1,147 @@
+use anyhow::{Context, Result};
+use std::{
+    env,
+    fs::{self, OpenOptions},
+    io::Write,
+    path::Path,
+};
+
+use crate::profiles;
+
+/// Header written at the top of every environment file created by `init`.
+const TEMPLATE: &str = "\
+# Environment file created by dotenv.
+#
+# Add one KEY=VALUE pair per line. Lines starting with \"#\" are comments
+# and values can be wrapped in single or double quotes.
+#
+# Uncomment the line below to only pass these variables (plus a minimal
+# whitelist like PATH and HOME) to the command being run.
+# DOTENV_STRICT=true
+";
+
+/// Create the dotenv folder if it's missing and write a new environment
+/// file: the named one inside the folder, or `.env` in the current
+/// directory when no name is given. The file can be pre-populated with the
+/// contents of an existing file, like a `.env.example`.
+pub fn run(name: Option<&str>, from: Option<&Path>, force: bool) -> Result<()> {
+    let folder = profiles::folder()?;
+    if !folder.exists() {
+        profiles::create_private_dir(&folder)?;
+        note!("Created dotenv folder: {}", folder.display());
+    }
+
+    let file = match name {
+        Some(name) => profiles::path_for(name)?,
+        None => env::current_dir()
+            .context("Could not get current directory")?
+            .join(".env"),
+    };
+
+    if file.exists() && !force {
+        anyhow::bail!(
+            "Environment file already exists: {} (use --force to overwrite it)",
+            file.display()
+        );
+    }
+
+    if name.is_some() {
+        profiles::create_parents(&file)?;
+    }
+
+    let content = render(from)?;
+    write_private(&file, &content)?;
+
+    note!("Created environment file: {}", file.display());
+    Ok(())
+}
+
+/// Build the contents of the new file: the template followed by the
+/// contents of `from`, if given.
+fn render(from: Option<&Path>) -> Result<String> {
+    let mut content = TEMPLATE.to_string();
+
+    if let Some(from) = from {
+        let existing = fs::read_to_string(from)
+            .with_context(|| format!("Failed to read .env file at {}", from.display()))?;
+        content.push_str(&format!("\n# Copied from {}\n", from.display()));
+        content.push_str(&existing);
+        if !content.ends_with('\n') {
+            content.push('\n');
+        }
+    }
+
+    Ok(content)
+}
+
+/// Write a file only readable and writable by the current user.
+pub fn write_private(file: &Path, content: &str) -> Result<()> {
+    let mut options = OpenOptions::new();
+    options.write(true).create(true).truncate(true);
+
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::OpenOptionsExt;
+        options.mode(0o600);
+    }
+
+    let mut handle = options
+        .open(file)
+        .with_context(|| format!("Could not create environment file: {}", file.display()))?;
+    handle.write_all(content.as_bytes())?;
+
+    // The mode above only applies to new files, so tighten existing ones too
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::set_permissions(file, fs::Permissions::from_mode(0o600))?;
+    }
+
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::env_parser;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_render_template_is_valid() -> Result<()> {
+        let content = render(None)?;
+        assert!(env_parser::validate_env_str(&content).is_empty());
+        assert!(env_parser::parse_env_str(&content)?.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_render_from_existing_file() -> Result<()> {
+        let dir = tempdir()?;
+        let example = dir.path().join(".env.example");
+        fs::write(&example, "DB_HOST=localhost\nDB_PORT=5432")?;
+
+        let content = render(Some(&example))?;
+        assert!(content.starts_with(TEMPLATE));
+        assert!(content.ends_with("DB_PORT=5432\n"));
+
+        let vars = env_parser::parse_env_str(&content)?;
+        assert_eq!(vars.get("DB_HOST"), Some(&"localhost".to_string()));
+        Ok(())
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_write_private_sets_permissions() -> Result<()> {
+        use std::os::unix::fs::PermissionsExt;
+
+        let dir = tempdir()?;
+        let file = dir.path().join("example.env");
+        fs::write(&file, "")?;
+        fs::set_permissions(&file, fs::Permissions::from_mode(0o644))?;
+
+        write_private(&file, TEMPLATE)?;
+        let mode = fs::metadata(&file)?.permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+        Ok(())
+    }
+}
@@ -0,0 +1,119 @@
+use anyhow::Result;
+use serde_json::json;
+
+use crate::{profiles, table};
+
+/// Print the environment files stored in the dotenv folder, either as
+/// an aligned table, as JSON, as a plain list of names or as a tree of
+/// their folders.
+pub fn run(as_json: bool, names_only: bool, as_tree: bool) -> Result<()> {
+    let profiles = profiles::list()?;
+
+    if names_only {
+        for p in &profiles {
+            println!("{}", p.name);
+        }
+        return Ok(());
+    }
+
+    if as_json {
+        let items: Vec<_> = profiles
+            .iter()
+            .map(|p| {
+                json!({
+                    "name": p.name,
+                    "path": p.path.display().to_string(),
+                    "variables": p.variables,
+                    "has_command": p.has_command,
+                    "encrypted": p.encrypted,
+                })
+            })
+            .collect();
+        println!("{}", serde_json::to_string_pretty(&items)?);
+        return Ok(());
+    }
+
+    if profiles.is_empty() {
+        let folders: Vec<String> = profiles::folders()?
+            .iter()
+            .map(|f| f.display().to_string())
+            .collect();
+        note!("No environment files found in: {}", folders.join(", "));
+        return Ok(());
+    }
+
+    if as_tree {
+        let names: Vec<&str> = profiles.iter().map(|p| p.name.as_str()).collect();
+        print!("{}", tree(&names));
+        return Ok(());
+    }
+
+    let rows: Vec<Vec<String>> = profiles
+        .iter()
+        .map(|p| {
+            let (variables, command) = if p.encrypted {
+                ("encrypted".to_string(), "-")
+            } else {
+                (
+                    p.variables.to_string(),
+                    if p.has_command { "yes" } else { "no" },
+                )
+            };
+            vec![
+                p.name.clone(),
+                variables,
+                command.to_string(),
+                p.path.display().to_string(),
+            ]
+        })
+        .collect();
+
+    print!(
+        "{}",
+        table::render(&["NAME", "VARIABLES", "COMMAND", "PATH"], &rows)
+    );
+    Ok(())
+}
+
+/// Render sorted environment names as an indented tree, printing each
+/// folder once above the environments it contains.
+fn tree(names: &[&str]) -> String {
+    let mut out = String::new();
+    let mut open: Vec<&str> = Vec::new();
+
+    for name in names {
+        let parts: Vec<&str> = name.split('/').collect();
+        let (leaf, folders) = parts.split_last().expect("split always yields a part");
+
+        let shared = open.iter().zip(folders).take_while(|(a, b)| a == b).count();
+        open.truncate(shared);
+
+        for folder in &folders[shared..] {
+            out.push_str(&format!("{}{}/\n", "  ".repeat(open.len()), folder));
+            open.push(folder);
+        }
+        out.push_str(&format!("{}{}\n", "  ".repeat(open.len()), leaf));
+    }
+
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tree() {
+        let names = [
+            "clients/acme/aws",
+            "clients/acme/gcp",
+            "clients/zeta",
+            "prod",
+            "work/kubectl",
+        ];
+        assert_eq!(
+            tree(&names),
+            "clients/\n  acme/\n    aws\n    gcp\n  zeta\nprod\nwork/\n  kubectl\n"
+        );
+    }
+}
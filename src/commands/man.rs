@@ -0,0 +1,199 @@
+use anyhow::Result;
+use clap::{Arg, Command};
+
+use crate::{
+    exit,
+    settings::{Scope, SETTINGS},
+};
+
+/// How environment files are found, in the order they're checked.
+static RESOLUTION: &[&str] = &[
+    "If --environment is given, the file <name>.env is loaded from the dotenv folder.",
+    "Otherwise, if the DOTENV environment variable is set, it is used as the environment name.",
+    "Otherwise, if config.toml in the dotenv folder maps the current directory (or a parent) to an environment, that environment is used.",
+    "Otherwise, the .env file in the current directory is loaded, or the closest one in its parent directories, up to the root of the git repository or the home directory.",
+];
+
+/// The rules followed when parsing environment files.
+static FORMAT: &[&str] = &[
+    "Each line holds a KEY=VALUE pair. Keys and values are trimmed.",
+    "Values may be wrapped in matching single or double quotes, which are removed.",
+    "Lines starting with # are comments, and a # anywhere else starts a trailing comment.",
+    "Empty lines, shebangs and lines without a key or a value are ignored.",
+];
+
+/// Print a roff man page generated from the CLI definition, so it stays in
+/// sync with the flags and subcommands the binary actually supports.
+pub fn run(cmd: Command) -> Result<()> {
+    print!("{}", render(&cmd));
+    Ok(())
+}
+
+/// Render the man page for the given command.
+fn render(cmd: &Command) -> String {
+    let name = cmd.get_name();
+    let mut out = String::new();
+
+    out.push_str(&format!(
+        ".TH {} 1 \"\" \"{} {}\" \"User Commands\"\n",
+        name.to_uppercase(),
+        name,
+        cmd.get_version().unwrap_or_default()
+    ));
+
+    out.push_str(".SH NAME\n");
+    out.push_str(&format!(
+        "{} \\- {}\n",
+        name,
+        escape(&cmd.get_about().map(|a| a.to_string()).unwrap_or_default())
+    ));
+
+    out.push_str(".SH SYNOPSIS\n");
+    out.push_str(&format!(
+        ".B {}\n[\\fIOPTIONS\\fR] [\\-\\-] \\fICOMMAND\\fR [\\fIARGS\\fR...]\n.br\n.B {}\n\\fISUBCOMMAND\\fR [\\fIOPTIONS\\fR]\n",
+        name, name
+    ));
+
+    out.push_str(".SH OPTIONS\n");
+    for arg in cmd.get_arguments() {
+        out.push_str(&option(arg));
+    }
+
+    out.push_str(".SH SUBCOMMANDS\n");
+    for sub in cmd.get_subcommands().filter(|s| !s.is_hide_set()) {
+        out.push_str(&format!(
+            ".SS {}\n{}\n",
+            sub.get_name(),
+            escape(&sub.get_about().map(|a| a.to_string()).unwrap_or_default())
+        ));
+        for arg in sub.get_arguments() {
+            out.push_str(&option(arg));
+        }
+    }
+
+    out.push_str(".SH ENVIRONMENT\n");
+    for setting in SETTINGS.iter().filter(|s| s.scope == Scope::Process) {
+        out.push_str(&format!(
+            ".TP\n.B {}\n{}\n",
+            setting.name,
+            escape(setting.description)
+        ));
+    }
+
+    out.push_str(".SH FILE SETTINGS\nThese variables are read from inside environment files.\n");
+    for setting in SETTINGS.iter().filter(|s| s.scope == Scope::File) {
+        out.push_str(&format!(
+            ".TP\n.B {}\n{}\n",
+            setting.name,
+            escape(setting.description)
+        ));
+    }
+
+    out.push_str(".SH EXPORTED VARIABLES\nThese variables are set in the environment of the command being run.\n");
+    for setting in SETTINGS.iter().filter(|s| s.scope == Scope::Child) {
+        out.push_str(&format!(
+            ".TP\n.B {}\n{}\n",
+            setting.name,
+            escape(setting.description)
+        ));
+    }
+
+    out.push_str(".SH FILE RESOLUTION\n");
+    out.push_str(&list(RESOLUTION));
+
+    out.push_str(".SH FILE FORMAT\n");
+    out.push_str(&list(FORMAT));
+
+    out.push_str(".SH EXIT STATUS\nWhen the command runs, dotenv exits with the command's exit status, or 128 plus the signal's number if it was killed by one. Otherwise:\n");
+    for (code, description) in exit::RESERVED {
+        out.push_str(&format!(".TP\n.B {}\n{}\n", code, escape(description)));
+    }
+
+    out
+}
+
+/// Render a single flag or positional argument as a tagged paragraph.
+fn option(arg: &Arg) -> String {
+    if arg.is_hide_set() {
+        return String::new();
+    }
+
+    let id = arg.get_id().as_str().to_uppercase();
+    let takes_value = arg.get_action().takes_values();
+
+    let mut names = Vec::new();
+    if let Some(short) = arg.get_short() {
+        names.push(format!("\\-{}", short));
+    }
+    if let Some(long) = arg.get_long() {
+        names.push(format!("\\-\\-{}", long));
+    }
+
+    let header = if names.is_empty() {
+        format!("\\fI{}\\fR", id)
+    } else if takes_value {
+        format!("\\fB{}\\fR \\fI{}\\fR", names.join("\\fR, \\fB"), id)
+    } else {
+        format!("\\fB{}\\fR", names.join("\\fR, \\fB"))
+    };
+
+    format!(
+        ".TP\n{}\n{}\n",
+        header,
+        escape(&arg.get_help().map(|h| h.to_string()).unwrap_or_default())
+    )
+}
+
+/// Render a list of sentences as bullet points.
+fn list(items: &[&str]) -> String {
+    items
+        .iter()
+        .map(|item| format!(".IP \\(bu 2\n{}\n", escape(item)))
+        .collect()
+}
+
+/// Escape text so roff doesn't interpret it as markup.
+fn escape(text: &str) -> String {
+    let escaped = text.replace('\\', "\\e").replace('-', "\\-");
+    if escaped.starts_with(['.', '\'']) {
+        format!("\\&{}", escaped)
+    } else {
+        escaped
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::ArgAction;
+
+    #[test]
+    fn test_escape() {
+        assert_eq!(escape("use --strict"), "use \\-\\-strict");
+        assert_eq!(escape(".env files"), "\\&.env files");
+        assert_eq!(escape("a\\b"), "a\\eb");
+    }
+
+    #[test]
+    fn test_render_includes_sections() {
+        let cmd = Command::new("dotenv")
+            .about("Inject variables")
+            .arg(
+                Arg::new("strict")
+                    .long("strict")
+                    .action(ArgAction::SetTrue)
+                    .help("Strict mode"),
+            )
+            .arg(Arg::new("environment").short('e').long("environment"))
+            .subcommand(Command::new("list").about("List environments"));
+
+        let page = render(&cmd);
+        assert!(page.starts_with(".TH DOTENV 1"));
+        assert!(page.contains(".TP\n\\fB\\-\\-strict\\fR\nStrict mode\n"));
+        assert!(page.contains("\\fB\\-e\\fR, \\fB\\-\\-environment\\fR \\fIENVIRONMENT\\fR\n"));
+        assert!(page.contains(".SS list\nList environments\n"));
+        assert!(page.contains(".B DOTENV_FOLDER_PATH\n"));
+        assert!(page.contains(".SH FILE FORMAT\n"));
+        assert!(page.contains(".TP\n.B 127\nthe command could not be found\n"));
+    }
+}
@@ -0,0 +1,84 @@
+use anyhow::{Context, Result};
+use std::fs;
+
+use crate::{profiles, prompt};
+
+/// Copy a named environment to a new name.
+pub fn copy(from: &str, to: &str, force: bool) -> Result<()> {
+    let source = existing(from)?;
+    let target = profiles::path_for(to)?;
+
+    if target.exists() && !force && !prompt::confirm(&format!("Overwrite {}?", target.display()))? {
+        return Ok(());
+    }
+
+    profiles::create_parents(&target)?;
+    fs::copy(&source, &target).with_context(|| {
+        format!(
+            "Could not copy {} to {}",
+            source.display(),
+            target.display()
+        )
+    })?;
+
+    note!("Copied {} to {}", source.display(), target.display());
+    Ok(())
+}
+
+/// Rename a named environment.
+pub fn rename(from: &str, to: &str, force: bool) -> Result<()> {
+    let source = existing(from)?;
+    let target = profiles::path_for(to)?;
+
+    if target.exists() && !force && !prompt::confirm(&format!("Overwrite {}?", target.display()))? {
+        return Ok(());
+    }
+
+    profiles::create_parents(&target)?;
+    fs::rename(&source, &target).with_context(|| {
+        format!(
+            "Could not move {} to {}",
+            source.display(),
+            target.display()
+        )
+    })?;
+    profiles::remove_empty_parents(&source);
+
+    note!("Moved {} to {}", source.display(), target.display());
+    Ok(())
+}
+
+/// Delete one or more named environments.
+pub fn remove(names: &[String], force: bool) -> Result<()> {
+    // Resolve every name first so a typo doesn't leave the job half done
+    let files = names
+        .iter()
+        .map(|name| existing(name))
+        .collect::<Result<Vec<_>>>()?;
+
+    for file in files {
+        if !force && !prompt::confirm(&format!("Remove {}?", file.display()))? {
+            continue;
+        }
+
+        fs::remove_file(&file).with_context(|| format!("Could not remove {}", file.display()))?;
+        profiles::remove_empty_parents(&file);
+        note!("Removed {}", file.display());
+    }
+
+    Ok(())
+}
+
+/// Get the path of a named environment that must already exist in one of
+/// the dotenv folders.
+fn existing(name: &str) -> Result<std::path::PathBuf> {
+    let file = profiles::path_for(name)?;
+    match profiles::locate(name)? {
+        Some(found) if found.is_file() => Ok(found),
+        Some(found) => anyhow::bail!(
+            "{} is kept in the store, run `dotenv store unseal` to work on it as a file",
+            found.display()
+        ),
+        _ => anyhow::bail!("Environment does not exist: {}", file.display()),
+    }
+}
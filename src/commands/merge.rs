@@ -0,0 +1,169 @@
+use anyhow::{Context, Result};
+use clap::ValueEnum;
+use std::path::{Path, PathBuf};
+
+use crate::{env_document::EnvDocument, mask, profiles};
+
+/// Which file wins when the same key is defined in more than one file.
+#[derive(ValueEnum, Clone, Copy, Debug, PartialEq)]
+pub enum Precedence {
+    /// Files listed later override earlier ones
+    Last,
+    /// Files listed earlier override later ones
+    First,
+}
+
+/// A key defined with different values in two of the merged files.
+#[derive(Debug, PartialEq)]
+struct Conflict {
+    key: String,
+    kept: (String, String),
+    dropped: (String, String),
+}
+
+/// Merge several environment files, each given as a path or a named
+/// environment, into a single one written to `output` or standard output.
+/// Conflicting keys are reported and, with `fail_on_conflict`, abort the
+/// merge.
+pub fn run(
+    sources: &[String],
+    precedence: Precedence,
+    output: Option<&Path>,
+    fail_on_conflict: bool,
+    reveal: bool,
+) -> Result<()> {
+    let mut files = Vec::new();
+    for source in sources {
+        let file = profiles::resolve_path_or_name(source)?
+            .with_context(|| format!("No environment file found for: {}", source))?;
+        let doc = EnvDocument::load(&file)?;
+        files.push((file, doc));
+    }
+
+    let (merged, conflicts) = merge(&files, precedence)?;
+
+    for conflict in &conflicts {
+        let (kept_file, kept_value) = &conflict.kept;
+        let (dropped_file, dropped_value) = &conflict.dropped;
+        note!(
+            "Conflict for {}: using {} from {} over {} from {}",
+            conflict.key,
+            mask::display_value(&conflict.key, kept_value, reveal),
+            kept_file,
+            mask::display_value(&conflict.key, dropped_value, reveal),
+            dropped_file,
+        );
+    }
+
+    if fail_on_conflict && !conflicts.is_empty() {
+        anyhow::bail!("Found {} conflicting variable(s)", conflicts.len());
+    }
+
+    match output {
+        Some(path) => merged.save(path),
+        None => {
+            print!("{}", merged);
+            Ok(())
+        }
+    }
+}
+
+/// Merge the entries of the given files, keeping keys in the order they
+/// first appear.
+fn merge(
+    files: &[(PathBuf, EnvDocument)],
+    precedence: Precedence,
+) -> Result<(EnvDocument, Vec<Conflict>)> {
+    // Each key keeps its value and the file it came from
+    let mut merged: Vec<(String, String, String)> = Vec::new();
+    let mut conflicts = Vec::new();
+
+    for (file, doc) in files {
+        let file = file.display().to_string();
+        for (key, value) in doc.entries() {
+            let existing = match merged.iter_mut().find(|(k, _, _)| *k == key) {
+                Some(existing) => existing,
+                None => {
+                    merged.push((key, value, file.clone()));
+                    continue;
+                }
+            };
+
+            // Values redefined within the same file are not conflicts, the
+            // last one wins just like when the file is loaded
+            if existing.2 == file {
+                existing.1 = value;
+                continue;
+            }
+
+            if existing.1 == value {
+                continue;
+            }
+
+            let incoming = (file.clone(), value);
+            let current = (existing.2.clone(), existing.1.clone());
+            let (kept, dropped) = match precedence {
+                Precedence::Last => (incoming, current),
+                Precedence::First => (current, incoming),
+            };
+
+            existing.1 = kept.1.clone();
+            existing.2 = kept.0.clone();
+            conflicts.push(Conflict {
+                key: key.clone(),
+                kept,
+                dropped,
+            });
+        }
+    }
+
+    let mut doc = EnvDocument::parse("");
+    for (key, value, _) in &merged {
+        doc.set(key, value)
+            .with_context(|| format!("Could not merge {}", key))?;
+    }
+
+    Ok((doc, conflicts))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn files() -> Vec<(PathBuf, EnvDocument)> {
+        vec![
+            (
+                PathBuf::from("base.env"),
+                EnvDocument::parse("HOST=base\nPORT=80\nPORT=8080\n"),
+            ),
+            (
+                PathBuf::from("prod.env"),
+                EnvDocument::parse("HOST=prod\nPORT=8080\nDEBUG=false\n"),
+            ),
+        ]
+    }
+
+    #[test]
+    fn test_merge_last_wins() -> Result<()> {
+        let (doc, conflicts) = merge(&files(), Precedence::Last)?;
+        assert_eq!(doc.to_string(), "HOST=prod\nPORT=8080\nDEBUG=false\n");
+        assert_eq!(
+            conflicts,
+            vec![Conflict {
+                key: "HOST".to_string(),
+                kept: ("prod.env".to_string(), "prod".to_string()),
+                dropped: ("base.env".to_string(), "base".to_string()),
+            }]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_merge_first_wins() -> Result<()> {
+        let (doc, conflicts) = merge(&files(), Precedence::First)?;
+        assert_eq!(doc.to_string(), "HOST=base\nPORT=8080\nDEBUG=false\n");
+        assert_eq!(conflicts.len(), 1);
+        assert_eq!(conflicts[0].kept.1, "base");
+        Ok(())
+    }
+}
@@ -0,0 +1,27 @@
+pub mod allow;
+pub mod completion;
+pub mod crypt;
+pub mod diff;
+pub mod docker;
+pub mod doctor;
+pub mod edit;
+pub mod example;
+pub mod fix_perms;
+pub mod fmt;
+pub mod foreach;
+pub mod init;
+pub mod list;
+pub mod man;
+pub mod manage;
+pub mod merge;
+pub mod pin;
+pub mod prune;
+pub mod secret;
+pub mod self_update;
+pub mod show;
+pub mod ssh;
+pub mod start;
+pub mod store;
+pub mod validate;
+pub mod vars;
+pub mod which;
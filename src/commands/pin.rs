@@ -0,0 +1,16 @@
+use anyhow::{Context, Result};
+
+use crate::{extends, pin, profiles};
+
+/// Pin the environment file that would be loaded, the environments it
+/// extends and their machine-local overlays, so running with `--verify`
+/// refuses to load them once any of them changes.
+pub fn run(environment: Option<&str>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let files = extends::files(&file)?;
+    pin::pin(&files)?;
+    for file in &files {
+        note!("Pinned {}", file.display());
+    }
+    Ok(())
+}
@@ -0,0 +1,114 @@
+use anyhow::{Context, Result};
+use std::{fs, path::Path, time::UNIX_EPOCH};
+
+use crate::{
+    profiles::{self, Profile},
+    prompt, table, usage,
+};
+
+/// Folder, inside the dotenv folder, where archived environments are moved.
+const ARCHIVE_FOLDER: &str = ".archive";
+
+/// What to do with stale environments.
+#[derive(Debug, PartialEq, Clone, Copy)]
+pub enum Action {
+    List,
+    Archive,
+    Delete,
+}
+
+/// Find the environments that haven't been used in the given number of days
+/// and list, archive or delete them. An environment counts as used when a
+/// command runs with it or when its file is modified.
+pub fn run(days: u64, action: Action, force: bool) -> Result<()> {
+    let folder = profiles::folder()?;
+    let profiles = profiles::list_in(&folder)?;
+    let log = usage::load(&folder);
+    let now = usage::now();
+
+    let stale: Vec<(&Profile, u64)> = profiles
+        .iter()
+        .map(|p| {
+            let used = log.get(&p.name).copied().unwrap_or_default();
+            (p, used.max(modified(&p.path)))
+        })
+        .filter(|(_, last_used)| is_stale(*last_used, now, days))
+        .collect();
+
+    if stale.is_empty() {
+        note!("No environments unused in the last {} day(s)", days);
+        return Ok(());
+    }
+
+    if action == Action::List {
+        let rows: Vec<Vec<String>> = stale
+            .iter()
+            .map(|(p, last_used)| {
+                vec![
+                    p.name.clone(),
+                    format!("{} days ago", now.saturating_sub(*last_used) / 86400),
+                    p.path.display().to_string(),
+                ]
+            })
+            .collect();
+        print!("{}", table::render(&["NAME", "LAST USED", "PATH"], &rows));
+        return Ok(());
+    }
+
+    let archive = folder.join(ARCHIVE_FOLDER);
+    for (profile, _) in stale {
+        let verb = if action == Action::Archive {
+            "Archive"
+        } else {
+            "Delete"
+        };
+        if !force && !prompt::confirm(&format!("{} {}?", verb, profile.path.display()))? {
+            continue;
+        }
+
+        if action == Action::Archive {
+            let target = archive.join(format!("{}.env", profile.name));
+            profiles::create_parents(&target)?;
+            fs::rename(&profile.path, &target)
+                .with_context(|| format!("Could not archive {}", profile.path.display()))?;
+            profiles::remove_empty_parents(&profile.path);
+            note!("Archived {} to {}", profile.name, target.display());
+        } else {
+            fs::remove_file(&profile.path)
+                .with_context(|| format!("Could not remove {}", profile.path.display()))?;
+            profiles::remove_empty_parents(&profile.path);
+            note!("Removed {}", profile.path.display());
+        }
+    }
+
+    Ok(())
+}
+
+/// Check if something last used at `last_used` is older than `days`.
+fn is_stale(last_used: u64, now: u64, days: u64) -> bool {
+    now.saturating_sub(last_used) > days.saturating_mul(86400)
+}
+
+/// Get the last modification time of a file as a Unix timestamp.
+fn modified(path: &Path) -> u64 {
+    fs::metadata(path)
+        .and_then(|m| m.modified())
+        .ok()
+        .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
+        .map(|d| d.as_secs())
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_stale() {
+        let now = 100 * 86400;
+        assert!(is_stale(0, now, 90));
+        assert!(!is_stale(now - 86400, now, 90));
+        assert!(!is_stale(now, now, 0));
+        assert!(is_stale(now - 1, now, 0));
+    }
+}
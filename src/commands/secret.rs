@@ -0,0 +1,47 @@
+use anyhow::{Context, Result};
+use std::io::{self, IsTerminal, Read};
+
+use crate::{keyring, prompt};
+
+/// Keep a secret in the keyring, asking for it on the terminal or reading
+/// it from standard input, so it never shows up in the shell history.
+pub fn set(name: &str) -> Result<()> {
+    let secret = if io::stdin().is_terminal() {
+        prompt::ask(&format!("Secret for {}:", name), true)?
+    } else {
+        let mut secret = String::new();
+        io::stdin()
+            .read_to_string(&mut secret)
+            .context("Failed to read the secret")?;
+        // Piped secrets, like from echo, usually end with a line break
+        secret.trim_end_matches(['\r', '\n']).to_string()
+    };
+    if secret.is_empty() {
+        anyhow::bail!("The secret for {} is empty", name);
+    }
+
+    keyring::set(name, &secret)?;
+    note!(
+        "Kept {} in the keyring, reference it as {}{}",
+        name,
+        keyring::PREFIX,
+        name
+    );
+    Ok(())
+}
+
+/// Print a secret kept in the keyring.
+pub fn get(name: &str) -> Result<()> {
+    let secret = keyring::get(name)?
+        .with_context(|| format!("There's no secret {:?} in the keyring", name))?;
+    println!("{}", secret);
+    Ok(())
+}
+
+/// Remove a secret from the keyring.
+pub fn rm(name: &str) -> Result<()> {
+    if !keyring::delete(name)? {
+        anyhow::bail!("There's no secret {:?} in the keyring", name);
+    }
+    Ok(())
+}
@@ -0,0 +1,285 @@
+use anyhow::{Context, Result};
+use sha2::{Digest, Sha256};
+use std::{
+    env, fs,
+    path::{Path, PathBuf},
+    process::Command,
+};
+
+/// The GitHub repository releases are published to.
+const REPOSITORY: &str = "patrickdappollonio/dotenv";
+
+/// Check GitHub for a newer release and, unless `check` is set, replace the
+/// running binary with it. The downloaded archive is verified against the
+/// SHA-256 checksum published alongside it before anything is replaced.
+pub fn run(check: bool, force: bool) -> Result<()> {
+    let current = env!("CARGO_PKG_VERSION");
+    let archive = platform_archive().context(
+        "Self-update is not supported on this platform, download a release manually instead",
+    )?;
+
+    let release = fetch_json(&format!(
+        "https://api.github.com/repos/{}/releases/latest",
+        REPOSITORY
+    ))?;
+    let tag = release["tag_name"]
+        .as_str()
+        .context("The latest release has no tag name")?;
+
+    let newer = match (parse_version(tag), parse_version(current)) {
+        (Some(latest), Some(current)) => latest > current,
+        _ => true,
+    };
+
+    if !newer && !force {
+        note!("dotenv {} is already the latest version", current);
+        return Ok(());
+    }
+
+    if check {
+        println!("{}", tag);
+        note!("dotenv {} is available (you have {})", tag, current);
+        return Ok(());
+    }
+
+    let assets = release["assets"]
+        .as_array()
+        .context("The latest release has no assets")?;
+    let asset_url = |predicate: &dyn Fn(&str) -> bool| {
+        assets.iter().find_map(|asset| {
+            let name = asset["name"].as_str()?;
+            predicate(name)
+                .then(|| asset["browser_download_url"].as_str())
+                .flatten()
+                .map(|url| (name.to_string(), url.to_string()))
+        })
+    };
+
+    let prefix = format!("dotenv-{}-{}", tag, archive);
+    let (archive_name, archive_url) = asset_url(&|name| {
+        name.starts_with(&prefix) && (name.ends_with(".tar.gz") || name.ends_with(".zip"))
+    })
+    .with_context(|| format!("No release asset found for {}", prefix))?;
+    let (_, checksum_url) =
+        asset_url(&|name| name.starts_with(&prefix) && name.ends_with(".sha256"))
+            .with_context(|| format!("No checksum published for {}", archive_name))?;
+
+    let workdir = tempfile::tempdir()?;
+    let archive_path = workdir.path().join(&archive_name);
+    download(&archive_url, &archive_path)?;
+    let checksum_path = workdir.path().join("checksum");
+    download(&checksum_url, &checksum_path)?;
+
+    let expected = fs::read_to_string(&checksum_path)?;
+    let expected = expected
+        .split_whitespace()
+        .next()
+        .context("The published checksum file is empty")?;
+    verify_checksum(&archive_path, expected)?;
+
+    extract(&archive_path, workdir.path())?;
+    let binary_name = if cfg!(windows) {
+        "dotenv.exe"
+    } else {
+        "dotenv"
+    };
+    let new_binary = find_file(workdir.path(), binary_name)?
+        .with_context(|| format!("The release archive does not contain {}", binary_name))?;
+
+    let current_exe = env::current_exe()
+        .and_then(fs::canonicalize)
+        .context("Could not find the location of the running binary")?;
+    replace_binary(&new_binary, &current_exe)?;
+
+    note!("Updated dotenv from {} to {}", current, tag);
+    Ok(())
+}
+
+/// Get the name GitHub release archives use for the current platform.
+fn platform_archive() -> Option<&'static str> {
+    match (env::consts::OS, env::consts::ARCH) {
+        ("linux", "x86_64") => Some("linux-x86_64"),
+        ("linux", "aarch64") => Some("linux-arm64"),
+        ("macos", "x86_64") => Some("darwin-x86_64"),
+        ("macos", "aarch64") => Some("darwin-arm64"),
+        ("windows", "x86_64") => Some("windows-x86_64"),
+        _ => None,
+    }
+}
+
+/// Parse a `v1.2.3` style version into its numeric parts.
+fn parse_version(version: &str) -> Option<(u64, u64, u64)> {
+    let version = version.trim_start_matches('v');
+    let version = version.split(['-', '+']).next()?;
+    let mut parts = version.split('.').map(|p| p.parse::<u64>().ok());
+
+    Some((
+        parts.next()??,
+        parts.next().unwrap_or(Some(0))?,
+        parts.next().unwrap_or(Some(0))?,
+    ))
+}
+
+/// Fetch a URL and parse its body as JSON.
+fn fetch_json(url: &str) -> Result<serde_json::Value> {
+    let output = curl()?
+        .args(["-fsSL", "-H", "Accept: application/vnd.github+json", url])
+        .output()
+        .context("Failed to run curl")?;
+
+    if !output.status.success() {
+        anyhow::bail!(
+            "Could not fetch {}: {}",
+            url,
+            String::from_utf8_lossy(&output.stderr).trim()
+        );
+    }
+
+    serde_json::from_slice(&output.stdout).with_context(|| format!("Invalid response from {}", url))
+}
+
+/// Download a URL into a file.
+fn download(url: &str, dest: &Path) -> Result<()> {
+    let status = curl()?
+        .args(["-fsSL", "-o"])
+        .arg(dest)
+        .arg(url)
+        .status()
+        .context("Failed to run curl")?;
+
+    if !status.success() {
+        anyhow::bail!("Could not download {}", url);
+    }
+
+    Ok(())
+}
+
+/// Build a `curl` command, which is used to talk to GitHub.
+fn curl() -> Result<Command> {
+    let path = which::which("curl").context("curl is required to check for updates")?;
+    Ok(Command::new(path))
+}
+
+/// Check that a file's SHA-256 checksum matches the expected one.
+fn verify_checksum(file: &Path, expected: &str) -> Result<()> {
+    let content = fs::read(file)?;
+    let actual = format!("{:x}", Sha256::digest(&content));
+
+    if !actual.eq_ignore_ascii_case(expected) {
+        anyhow::bail!(
+            "Checksum mismatch for {}: expected {}, got {}",
+            file.display(),
+            expected,
+            actual
+        );
+    }
+
+    Ok(())
+}
+
+/// Extract a `.tar.gz` or `.zip` archive with `tar`, which ships with
+/// Linux, macOS and Windows 10 and later.
+fn extract(archive: &Path, dest: &Path) -> Result<()> {
+    let status = Command::new("tar")
+        .arg("-xf")
+        .arg(archive)
+        .arg("-C")
+        .arg(dest)
+        .status()
+        .context("Failed to run tar")?;
+
+    if !status.success() {
+        anyhow::bail!("Could not extract {}", archive.display());
+    }
+
+    Ok(())
+}
+
+/// Find a file by name anywhere inside a directory.
+fn find_file(dir: &Path, name: &str) -> Result<Option<PathBuf>> {
+    for entry in fs::read_dir(dir)? {
+        let path = entry?.path();
+        if path.is_dir() {
+            if let Some(found) = find_file(&path, name)? {
+                return Ok(Some(found));
+            }
+        } else if path.file_name().is_some_and(|n| n == name) {
+            return Ok(Some(path));
+        }
+    }
+
+    Ok(None)
+}
+
+/// Atomically replace the binary at `target` with `source`. The new binary
+/// is first copied next to the target, so the final rename never crosses
+/// filesystems.
+fn replace_binary(source: &Path, target: &Path) -> Result<()> {
+    let dir = target.parent().context("The binary has no parent folder")?;
+    let staged = tempfile::Builder::new()
+        .prefix(".dotenv-update-")
+        .tempfile_in(dir)
+        .with_context(|| format!("Could not write to {}", dir.display()))?;
+    fs::copy(source, staged.path())?;
+
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::set_permissions(staged.path(), fs::Permissions::from_mode(0o755))?;
+    }
+
+    // Windows won't let a running binary be overwritten, but it can be renamed
+    #[cfg(windows)]
+    {
+        let old = target.with_extension("old.exe");
+        let _ = fs::remove_file(&old);
+        fs::rename(target, &old)
+            .with_context(|| format!("Could not move {} out of the way", target.display()))?;
+    }
+
+    staged
+        .persist(target)
+        .with_context(|| format!("Could not replace {}", target.display()))?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_parse_version() {
+        assert_eq!(parse_version("v1.2.3"), Some((1, 2, 3)));
+        assert_eq!(parse_version("0.4"), Some((0, 4, 0)));
+        assert_eq!(parse_version("v2.0.0-rc.1"), Some((2, 0, 0)));
+        assert_eq!(parse_version("latest"), None);
+        assert!(parse_version("v1.10.0") > parse_version("v1.9.9"));
+    }
+
+    #[test]
+    fn test_verify_checksum() -> Result<()> {
+        let dir = tempdir()?;
+        let file = dir.path().join("archive");
+        fs::write(&file, "hello")?;
+
+        let expected = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824";
+        verify_checksum(&file, expected)?;
+        verify_checksum(&file, &expected.to_uppercase())?;
+        assert!(verify_checksum(&file, "deadbeef").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_replace_binary() -> Result<()> {
+        let dir = tempdir()?;
+        let source = dir.path().join("new");
+        let target = dir.path().join("dotenv");
+        fs::write(&source, "new binary")?;
+        fs::write(&target, "old binary")?;
+
+        replace_binary(&source, &target)?;
+        assert_eq!(fs::read_to_string(&target)?, "new binary");
+        Ok(())
+    }
+}
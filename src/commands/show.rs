@@ -0,0 +1,89 @@
+use anyhow::{Context, Result};
+use std::{
+    borrow::Cow,
+    io::{self, Write},
+};
+
+use crate::{extends, mask, profiles, table};
+
+/// Print the variables defined by the resolved environment file as a
+/// table sorted by key, masking sensitive values unless `reveal` is set.
+/// With `sources`, a column shows the file each value was taken from. With
+/// `print0`, the variables are printed as NUL-terminated `KEY=value`
+/// records instead, which can hold any value, even spanning several lines.
+pub fn run(environment: Option<&str>, reveal: bool, sources: bool, print0: bool) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let vars = extends::load_sourced(&file)?;
+
+    let mut keys: Vec<&String> = vars.keys().collect();
+    keys.sort();
+
+    if print0 {
+        let shown: Vec<(&str, Cow<str>)> = keys
+            .into_iter()
+            .map(|key| {
+                let (value, _) = &vars[key];
+                (key.as_str(), mask::display_value(key, value, reveal))
+            })
+            .collect();
+        let records: Vec<(&str, &str)> = shown
+            .iter()
+            .map(|(key, value)| (*key, value.as_ref()))
+            .collect();
+        io::stdout()
+            .lock()
+            .write_all(&nul_records(&records))
+            .context("Failed to write the variables")?;
+        return Ok(());
+    }
+
+    let rows: Vec<Vec<String>> = keys
+        .into_iter()
+        .map(|key| {
+            let (value, source) = &vars[key];
+            let mut row = vec![
+                key.clone(),
+                mask::display_value(key, value, reveal).to_string(),
+            ];
+            if sources {
+                row.push(source.display().to_string());
+            }
+            row
+        })
+        .collect();
+
+    let headers: &[&str] = if sources {
+        &["KEY", "VALUE", "SOURCE"]
+    } else {
+        &["KEY", "VALUE"]
+    };
+    print!("{}", table::render(headers, &rows));
+    Ok(())
+}
+
+/// Write variables as `KEY=value` records, each one ending with a NUL
+/// byte, like `env -0` does.
+fn nul_records(vars: &[(&str, &str)]) -> Vec<u8> {
+    let mut out = Vec::new();
+    for (key, value) in vars {
+        out.extend_from_slice(key.as_bytes());
+        out.push(b'=');
+        out.extend_from_slice(value.as_bytes());
+        out.push(0);
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_nul_records() {
+        assert_eq!(
+            nul_records(&[("A", "1"), ("MULTILINE", "first\nsecond"), ("EMPTY", "")]),
+            b"A=1\0MULTILINE=first\nsecond\0EMPTY=\0".to_vec()
+        );
+        assert!(nul_records(&[]).is_empty());
+    }
+}
@@ -0,0 +1,165 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::HashMap,
+    io::{self, Write},
+    process::{Command, Stdio},
+    thread,
+};
+
+use crate::{exit, extends, markers};
+
+/// Run a command on a remote host over SSH with the variables of an
+/// environment file, resolved locally. The variables are sent through the
+/// command's standard input, ahead of dotenv's own, to a small POSIX shell
+/// wrapper that exports them and runs the command, so they end up neither
+/// in a file nor in any command line on the remote side.
+pub fn run(
+    environment: Option<&str>,
+    host: &str,
+    ssh_args: &[String],
+    command: &[String],
+) -> Result<()> {
+    let vars = extends::load_environment(environment)?;
+    let marker = format!("DOTENV_END_{}", markers::hash(&vars));
+    let payload = payload(&vars, &marker)?;
+
+    let mut child = Command::new("ssh")
+        .args(ssh_args)
+        .arg(host)
+        .arg(remote_command(&marker, command))
+        .stdin(Stdio::piped())
+        .spawn()
+        .map_err(exit::SpawnError)
+        .context("Failed to run ssh")?;
+
+    // Send the variables, then whatever dotenv itself reads, until either
+    // side is done
+    let mut stdin = child.stdin.take().context("Failed to open ssh's input")?;
+    stdin
+        .write_all(payload.as_bytes())
+        .context("Failed to send the variables to ssh")?;
+    thread::spawn(move || {
+        let _ = io::copy(&mut io::stdin().lock(), &mut stdin);
+    });
+
+    let status = child.wait().context("Failed to wait for ssh")?;
+    std::process::exit(exit::Ended::from(status).exit_code())
+}
+
+/// Write the variables as shell `export` lines, sorted by name, followed by
+/// the marker ending them.
+fn payload(vars: &HashMap<String, String>, marker: &str) -> Result<String> {
+    let mut keys: Vec<&String> = vars.keys().collect();
+    keys.sort();
+
+    let mut payload = String::new();
+    for key in keys {
+        let valid = key.starts_with(|c: char| c.is_ascii_alphabetic() || c == '_')
+            && key.chars().all(|c| c.is_ascii_alphanumeric() || c == '_');
+        if !valid {
+            anyhow::bail!(
+                "{} can't be sent over SSH, as it isn't a valid shell variable name",
+                key
+            );
+        }
+        payload.push_str(&format!(
+            "export {}={}\n",
+            key,
+            crate::quote_word(&vars[key])
+        ));
+    }
+    payload.push_str(marker);
+    payload.push('\n');
+    Ok(payload)
+}
+
+/// Build the command line run by the remote shell: a wrapper reading the
+/// `export` lines up to the marker, one line at a time so the rest of the
+/// input is left to the command, then replacing itself with the command.
+fn remote_command(marker: &str, command: &[String]) -> String {
+    let script = format!(
+        r#"env=; ended=; while IFS= read -r line; do if [ "$line" = {marker} ]; then ended=1; break; fi; env="$env$line
+"; done; if [ -z "$ended" ]; then echo "dotenv: the variables were not received" >&2; exit 125; fi; eval "$env"; unset env ended line; exec "$@""#
+    );
+    let mut words = vec!["sh".to_string(), "-c".to_string(), script, "sh".to_string()];
+    words.extend(command.iter().cloned());
+    crate::join_command(&words)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::process::Output;
+
+    fn vars(pairs: &[(&str, &str)]) -> HashMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    /// Run the remote command locally, the way sshd would through the
+    /// user's shell, with the given input.
+    fn run_remote(command: &str, input: &str) -> Result<Output> {
+        let mut child = Command::new("sh")
+            .arg("-c")
+            .arg(command)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()?;
+        child
+            .stdin
+            .take()
+            .context("no input")?
+            .write_all(input.as_bytes())?;
+        Ok(child.wait_with_output()?)
+    }
+
+    #[test]
+    fn test_payload() -> Result<()> {
+        assert_eq!(
+            payload(&vars(&[("B", "it's"), ("A", "1")]), "END")?,
+            "export A=1\nexport B='it'\\''s'\nEND\n"
+        );
+        assert!(payload(&vars(&[("NOT-VALID", "1")]), "END").is_err());
+        assert!(payload(&vars(&[("1ST", "1")]), "END").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_remote_command_exports_the_variables() -> Result<()> {
+        let vars = vars(&[
+            ("GREETING", "hello world"),
+            ("MULTILINE", "first\nDOTENV_END\n$HOME `date`"),
+        ]);
+        let marker = format!("DOTENV_END_{}", markers::hash(&vars));
+        let command: Vec<String> = [
+            "sh",
+            "-c",
+            "printf '%s|%s|' \"$GREETING\" \"$MULTILINE\"; cat",
+        ]
+        .iter()
+        .map(|s| s.to_string())
+        .collect();
+
+        let input = format!("{}rest of the input\n", payload(&vars, &marker)?);
+        let output = run_remote(&remote_command(&marker, &command), &input)?;
+        assert!(output.status.success());
+        assert_eq!(
+            String::from_utf8_lossy(&output.stdout),
+            "hello world|first\nDOTENV_END\n$HOME `date`|rest of the input\n"
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_remote_command_fails_without_the_variables() -> Result<()> {
+        let output = run_remote(
+            &remote_command("END", &["true".to_string()]),
+            "export A=1\n",
+        )?;
+        assert_eq!(output.status.code(), Some(125));
+        Ok(())
+    }
+}
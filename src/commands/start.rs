@@ -0,0 +1,386 @@
+use anyhow::{Context, Result};
+use std::{
+    fs, io,
+    path::Path,
+    process::{Child, Command, Stdio},
+    thread,
+    time::{Duration, Instant},
+};
+
+use crate::{exit, profiles, project, signals, streams};
+
+/// Name of the file listing the processes to run, looked up in the current
+/// directory and its parents.
+pub const PROCFILE: &str = "Procfile";
+
+/// How often the processes are checked for having exited.
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// A long-running process, with the shell command that starts it.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Process {
+    pub name: String,
+    pub command: String,
+}
+
+/// Get the processes to run: the ones in the given Procfile, or else the
+/// ones in the project file, or else the ones in the closest Procfile.
+pub fn load(
+    cwd: &Path,
+    project: Option<&project::Project>,
+    procfile: Option<&Path>,
+) -> Result<Vec<Process>> {
+    if let Some(path) = procfile {
+        return read(path);
+    }
+
+    if let Some(project) = project.filter(|p| !p.config.processes.is_empty()) {
+        let mut processes = Vec::new();
+        for (name, command) in &project.config.processes {
+            if !is_valid_name(name) || command.trim().is_empty() {
+                anyhow::bail!(
+                    "Invalid process {:?} in {}: names can only have letters, digits, dashes and underscores, and commands can't be empty",
+                    name,
+                    project.path.display()
+                );
+            }
+            processes.push(Process {
+                name: name.clone(),
+                command: command.trim().to_string(),
+            });
+        }
+        return Ok(processes);
+    }
+
+    for dir in profiles::project_dirs(cwd) {
+        let path = dir.join(PROCFILE);
+        if profiles::is_discoverable(&path) {
+            return read(&path);
+        }
+    }
+
+    anyhow::bail!(
+        "No {} found, and no processes are defined in {}",
+        PROCFILE,
+        project::CONFIG_FILE
+    )
+}
+
+/// Keep only the processes with the given names, in that order, or all of
+/// them if no names are given.
+pub fn select(processes: Vec<Process>, names: &[String]) -> Result<Vec<Process>> {
+    if names.is_empty() {
+        return Ok(processes);
+    }
+
+    let mut selected: Vec<Process> = Vec::new();
+    for name in names {
+        let process = processes
+            .iter()
+            .find(|p| &p.name == name)
+            .with_context(|| {
+                let available: Vec<&str> = processes.iter().map(|p| p.name.as_str()).collect();
+                format!(
+                    "Unknown process {:?}, expected one of: {}",
+                    name,
+                    available.join(", ")
+                )
+            })?;
+        if !selected.contains(process) {
+            selected.push(process.clone());
+        }
+    }
+    Ok(selected)
+}
+
+/// Run every process at once, `build` creating the command running each
+/// one's shell command. Their output is printed line by line, prefixed
+/// with their names, after `label` and a slash if given. Once any of them
+/// exits, the others are asked to stop and, if they're still running
+/// `grace` later, killed. Returns the status of the process that exited
+/// first, along with the process.
+pub fn run(
+    processes: &[Process],
+    label: Option<&str>,
+    grace: Duration,
+    build: impl Fn(&str) -> Command,
+) -> Result<(i32, Process)> {
+    let tag = |process: &Process| match label {
+        Some(label) => format!("{}/{}", label, process.name),
+        None => process.name.clone(),
+    };
+    let width = processes.iter().map(|p| tag(p).len()).max().unwrap_or(0);
+
+    // The processes share a process group, the one of the first process,
+    // so signals relayed to it reach all of them
+    let mut group: Option<u32> = None;
+    let mut running: Vec<(&Process, Child)> = Vec::new();
+    let mut printers = Vec::new();
+    for process in processes {
+        let mut cmd = build(&process.command);
+        cmd.stdin(Stdio::null());
+        cmd.stdout(Stdio::piped());
+        cmd.stderr(Stdio::piped());
+        join_group(&mut cmd, group);
+        #[cfg(windows)]
+        crate::job::suspend(&mut cmd);
+
+        let mut child = match cmd.spawn() {
+            Ok(child) => child,
+            Err(err) => {
+                shut_down(&mut running, group, grace);
+                return Err(exit::SpawnError(err))
+                    .with_context(|| format!("Failed to start process: {}", process.name));
+            }
+        };
+        group.get_or_insert(child.id());
+        #[cfg(windows)]
+        if let Err(err) = crate::job::assign(&child) {
+            running.push((process, child));
+            shut_down(&mut running, group, grace);
+            return Err(err).with_context(|| format!("Failed to start process: {}", process.name));
+        }
+
+        let copy = streams::Copy {
+            prefix: Some(format!("{:width$} | ", tag(process), width = width)),
+            log: None,
+        };
+        printers.extend(
+            child
+                .stdout
+                .take()
+                .map(|out| copy.start(out, io::stdout(), "stdout")),
+        );
+        printers.extend(
+            child
+                .stderr
+                .take()
+                .map(|err| copy.start(err, io::stderr(), "stderr")),
+        );
+        running.push((process, child));
+    }
+    if let Some(group) = group {
+        signals::forward_to(group);
+    }
+
+    let (first, ended) = loop {
+        if let Some(exited) = exited(&mut running)? {
+            break exited;
+        }
+        thread::sleep(POLL_INTERVAL);
+    };
+    if running.is_empty() {
+        note!("dotenv: {} {}", first.name, ended);
+    } else {
+        note!(
+            "dotenv: {} {}, stopping the other processes",
+            first.name,
+            ended
+        );
+    }
+
+    shut_down(&mut running, group, grace);
+    for printer in printers {
+        let _ = printer.join();
+    }
+    Ok((ended.exit_code(), first.clone()))
+}
+
+/// Read the processes in a Procfile.
+fn read(path: &Path) -> Result<Vec<Process>> {
+    let content = fs::read_to_string(path)
+        .with_context(|| format!("Failed to read {} at {}", PROCFILE, path.display()))?;
+    let processes =
+        parse(&content).with_context(|| format!("Could not parse {}", path.display()))?;
+    if processes.is_empty() {
+        anyhow::bail!("No processes are defined in {}", path.display());
+    }
+    Ok(processes)
+}
+
+/// Parse the `name: command` lines of a Procfile. Empty lines and lines
+/// starting with `#` are skipped.
+fn parse(content: &str) -> Result<Vec<Process>> {
+    let mut processes: Vec<Process> = Vec::new();
+    for (index, line) in content.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+
+        let number = index + 1;
+        let Some((name, command)) = line.split_once(':') else {
+            anyhow::bail!("Line {}: expected `name: command`, got {:?}", number, line);
+        };
+        let (name, command) = (name.trim(), command.trim());
+        if !is_valid_name(name) {
+            anyhow::bail!(
+                "Line {}: invalid process name {:?}, names can only have letters, digits, dashes and underscores",
+                number,
+                name
+            );
+        }
+        if command.is_empty() {
+            anyhow::bail!("Line {}: process {:?} has no command", number, name);
+        }
+        if processes.iter().any(|p| p.name == name) {
+            anyhow::bail!(
+                "Line {}: process {:?} is defined more than once",
+                number,
+                name
+            );
+        }
+
+        processes.push(Process {
+            name: name.to_string(),
+            command: command.to_string(),
+        });
+    }
+    Ok(processes)
+}
+
+/// Check if a process name only has letters, digits, dashes and
+/// underscores.
+fn is_valid_name(name: &str) -> bool {
+    !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '-' || c == '_')
+}
+
+/// Start a command in the given process group, or in a new one of its own.
+#[cfg(unix)]
+fn join_group(cmd: &mut Command, group: Option<u32>) {
+    use std::os::unix::process::CommandExt;
+
+    cmd.process_group(group.map_or(0, |group| group as i32));
+}
+
+#[cfg(not(unix))]
+fn join_group(_cmd: &mut Command, _group: Option<u32>) {}
+
+/// Find a process that exited, removing it from the running ones, along
+/// with how it ended.
+fn exited<'a>(
+    running: &mut Vec<(&'a Process, Child)>,
+) -> Result<Option<(&'a Process, exit::Ended)>> {
+    for index in 0..running.len() {
+        let (process, child) = &mut running[index];
+        let status = child
+            .try_wait()
+            .with_context(|| format!("Failed to wait for process: {}", process.name))?;
+        if let Some(status) = status {
+            let process = *process;
+            running.remove(index);
+            return Ok(Some((process, status.into())));
+        }
+    }
+    Ok(None)
+}
+
+/// Ask the processes still running to stop, killing them if they're still
+/// running once the grace period is over.
+fn shut_down(running: &mut Vec<(&Process, Child)>, group: Option<u32>, grace: Duration) {
+    let Some(group) = group else {
+        return;
+    };
+
+    signals::stop_group(group, false);
+    let deadline = Instant::now() + grace;
+    while !running.is_empty() {
+        running.retain_mut(|(_, child)| matches!(child.try_wait(), Ok(None)));
+        if Instant::now() >= deadline {
+            signals::stop_group(group, true);
+            for (_, child) in running.iter_mut() {
+                let _ = child.kill();
+                let _ = child.wait();
+            }
+            running.clear();
+        }
+        thread::sleep(POLL_INTERVAL);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    fn process(name: &str, command: &str) -> Process {
+        Process {
+            name: name.to_string(),
+            command: command.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_parse() -> Result<()> {
+        let processes = parse(
+            "# Development processes\nweb: npm run dev -- --port 3000\n\nworker:bundle exec sidekiq\nclock: sh -c 'echo a: b'\n",
+        )?;
+        assert_eq!(
+            processes,
+            vec![
+                process("web", "npm run dev -- --port 3000"),
+                process("worker", "bundle exec sidekiq"),
+                process("clock", "sh -c 'echo a: b'"),
+            ]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_parse_rejects_invalid_lines() {
+        assert!(parse("web npm start\n").is_err());
+        assert!(parse("web app: npm start\n").is_err());
+        assert!(parse("web:\n").is_err());
+        assert!(parse("web: a\nweb: b\n").is_err());
+    }
+
+    #[test]
+    fn test_select() -> Result<()> {
+        let all = vec![
+            process("web", "a"),
+            process("worker", "b"),
+            process("clock", "c"),
+        ];
+        assert_eq!(select(all.clone(), &[])?, all);
+        assert_eq!(
+            select(
+                all.clone(),
+                &["clock".to_string(), "web".to_string(), "clock".to_string()]
+            )?,
+            vec![process("clock", "c"), process("web", "a")]
+        );
+        assert!(select(all, &["db".to_string()]).is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_prefers_project_processes() -> Result<()> {
+        let dir = tempdir()?;
+        fs::create_dir(dir.path().join(".git"))?;
+        fs::write(dir.path().join(PROCFILE), "web: from-procfile\n")?;
+
+        assert_eq!(
+            load(dir.path(), None, None)?,
+            vec![process("web", "from-procfile")]
+        );
+
+        let config = dir.path().join(project::CONFIG_FILE);
+        fs::write(&config, "processes:\n  web: from-config\n")?;
+        let project = project::Project::load(&config)?;
+        assert_eq!(
+            load(dir.path(), Some(&project), None)?,
+            vec![process("web", "from-config")]
+        );
+
+        let explicit = dir.path().join("Procfile.dev");
+        fs::write(&explicit, "web: from-explicit\n")?;
+        assert_eq!(
+            load(dir.path(), Some(&project), Some(&explicit))?,
+            vec![process("web", "from-explicit")]
+        );
+        Ok(())
+    }
+}
@@ -0,0 +1,116 @@
+use anyhow::{Context, Result};
+use std::{collections::BTreeMap, fs};
+
+use crate::{commands::init, gpg, keyring, profiles, prompt, store};
+
+/// Move the plain environment files of the dotenv folder into its store,
+/// creating it the first time. With `keyring`, the passphrase is asked for
+/// once and kept in the keyring instead of being asked for every session.
+pub fn seal(use_keyring: bool) -> Result<()> {
+    let folder = profiles::folder()?;
+    profiles::create_private_dir(&folder)?;
+    let mut entries = if store::exists(&folder) {
+        store::entries(&folder)?
+    } else {
+        BTreeMap::new()
+    };
+
+    if use_keyring {
+        let passphrase = prompt::ask("Passphrase for the store:", true)?;
+        if passphrase.is_empty() {
+            anyhow::bail!("The passphrase can't be empty");
+        }
+        if prompt::ask("Repeat it:", true)? != passphrase {
+            anyhow::bail!("The passphrases don't match");
+        }
+        keyring::set(store::KEYRING_NAME, &passphrase)?;
+    }
+
+    let mut sealed = Vec::new();
+    for profile in profiles::list_in(&folder)? {
+        if !profile.path.is_file() || gpg::is_encrypted(&profile.path) {
+            continue;
+        }
+        let content = fs::read_to_string(&profile.path)
+            .with_context(|| format!("Failed to read .env file at {}", profile.path.display()))?;
+        entries.insert(profile.name, content);
+        sealed.push(profile.path);
+    }
+    if sealed.is_empty() && !use_keyring && store::exists(&folder) {
+        note!("Nothing to seal, the environments are all in the store");
+        return Ok(());
+    }
+
+    store::save(&folder, entries)?;
+    // Only removed once they're safely in the store
+    for file in &sealed {
+        fs::remove_file(file).with_context(|| format!("Could not remove {}", file.display()))?;
+        profiles::remove_empty_parents(file);
+    }
+    note!(
+        "Sealed {} environment(s) into {}",
+        sealed.len(),
+        folder.join(store::STORE_FILE).display()
+    );
+    Ok(())
+}
+
+/// Write the environments in the store back as plain files and remove it.
+pub fn unseal() -> Result<()> {
+    let folder = profiles::folder()?;
+    if !store::exists(&folder) {
+        anyhow::bail!("There's no store in {}", folder.display());
+    }
+
+    let entries = store::entries(&folder)?;
+    // Check first so a conflict doesn't leave the job half done
+    for name in entries.keys() {
+        let file = profiles::path_for(name)?;
+        if file.exists() {
+            anyhow::bail!(
+                "{} is both in the store and on disk, move it away before unsealing",
+                file.display()
+            );
+        }
+    }
+    for (name, content) in &entries {
+        let file = profiles::path_for(name)?;
+        profiles::create_parents(&file)?;
+        init::write_private(&file, content)?;
+    }
+
+    let file = folder.join(store::STORE_FILE);
+    fs::remove_file(&file).with_context(|| format!("Could not remove {}", file.display()))?;
+    keyring::delete(store::KEYRING_NAME).ok();
+    note!(
+        "Unsealed {} environment(s) into {}",
+        entries.len(),
+        folder.display()
+    );
+    Ok(())
+}
+
+/// Unlock the store for the rest of the session, asking for its passphrase.
+pub fn unlock() -> Result<()> {
+    let folder = profiles::folder()?;
+    if !store::exists(&folder) {
+        anyhow::bail!("There's no store in {}", folder.display());
+    }
+    let entries = store::entries(&folder)?;
+    note!(
+        "Unlocked the store, holding {} environment(s)",
+        entries.len()
+    );
+    Ok(())
+}
+
+/// Lock the store again, so its passphrase has to be given once more.
+pub fn lock() -> Result<()> {
+    if keyring::get(store::KEYRING_NAME).ok().flatten().is_some() {
+        note!("The passphrase of the store is kept in the keyring, which locks with your session");
+        return Ok(());
+    }
+    store::lock()?;
+    note!("Locked the store");
+    Ok(())
+}
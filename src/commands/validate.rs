@@ -0,0 +1,36 @@
+use anyhow::{Context, Result};
+use std::path::Path;
+
+use crate::{extends, profiles, schema::Schema};
+
+/// Check the resolved environment file against a schema, printing every
+/// problem found and returning an error if there are any.
+pub fn run(environment: Option<&str>, schema: Option<&Path>) -> Result<()> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let schema_file = Schema::locate(schema, &file).with_context(|| {
+        format!(
+            "No schema file found next to {}, use --schema to specify one",
+            file.display()
+        )
+    })?;
+
+    let schema = Schema::load(&schema_file)?;
+    let vars = extends::load(&file)?;
+
+    let problems = schema.validate(&vars);
+    if problems.is_empty() {
+        note!("{} matches {}", file.display(), schema_file.display());
+        return Ok(());
+    }
+
+    note!(
+        "{} does not match {}:",
+        file.display(),
+        schema_file.display()
+    );
+    for problem in &problems {
+        note!("  {}", problem);
+    }
+
+    anyhow::bail!("Found {} problem(s)", problems.len())
+}
@@ -0,0 +1,50 @@
+use anyhow::{Context, Result};
+use std::path::PathBuf;
+
+use crate::{env_document::EnvDocument, profiles};
+
+/// Print the value of a single variable from the resolved environment file.
+pub fn get(environment: Option<&str>, key: &str) -> Result<()> {
+    let (_, doc) = load(environment)?;
+    let value = doc
+        .get(key)
+        .with_context(|| format!("Variable not defined: {}", key))?;
+
+    println!("{}", value);
+    Ok(())
+}
+
+/// Set one or more `KEY=VALUE` pairs in the resolved environment file.
+pub fn set(environment: Option<&str>, assignments: &[String]) -> Result<()> {
+    let (file, mut doc) = load(environment)?;
+
+    for assignment in assignments {
+        let (key, value) = assignment
+            .split_once('=')
+            .with_context(|| format!("Expected KEY=VALUE, got: {}", assignment))?;
+        doc.set(key, value)
+            .with_context(|| format!("Could not set {}", key))?;
+    }
+
+    doc.save(&file)
+}
+
+/// Remove one or more variables from the resolved environment file.
+pub fn unset(environment: Option<&str>, keys: &[String]) -> Result<()> {
+    let (file, mut doc) = load(environment)?;
+
+    for key in keys {
+        if !doc.unset(key) {
+            note!("Variable not defined: {}", key);
+        }
+    }
+
+    doc.save(&file)
+}
+
+/// Resolve and load the environment file to work with.
+fn load(environment: Option<&str>) -> Result<(PathBuf, EnvDocument)> {
+    let file = profiles::resolve(environment)?.context("No environment file found")?;
+    let doc = EnvDocument::load(&file)?;
+    Ok((file, doc))
+}
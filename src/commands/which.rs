@@ -0,0 +1,54 @@
+use anyhow::Result;
+
+use crate::profiles;
+
+/// Explain which environment file would be loaded and why. The steps are
+/// printed to standard error while the selected path, if any, goes to
+/// standard output so it can be used in scripts.
+pub fn run(environment: Option<&str>) -> Result<()> {
+    let resolution = profiles::explain(environment)?;
+
+    match &resolution.name {
+        Some((_, origin)) if resolution.is_stdin() => {
+            note!(
+                "Reading the environment from standard input, as requested by {}",
+                origin
+            );
+            return Ok(());
+        }
+        Some((name, origin)) if resolution.kubernetes().is_some() => {
+            note!(
+                "Loading {} from Kubernetes, as requested by {}",
+                name,
+                origin
+            );
+            return Ok(());
+        }
+        Some((name, origin)) => note!("Using environment {:?} from {}", name, origin),
+        None => {
+            note!("No environment name given, looking for a .env file in the current directory and its parents")
+        }
+    }
+
+    for check in &resolution.checked {
+        note!(
+            "  {} {}",
+            if check.found { "found:  " } else { "missing:" },
+            check.path.display()
+        );
+    }
+
+    if let Some(file) = &resolution.file {
+        let real = profiles::real_path(file);
+        if &real != file {
+            note!("  which links to {}", real.display());
+        }
+    }
+
+    match &resolution.file {
+        Some(file) => println!("{}", file.display()),
+        None => note!("No environment file selected: commands run without loading variables"),
+    }
+
+    Ok(())
+}
@@ -0,0 +1,211 @@
+use anyhow::{Context, Result};
+use serde::Deserialize;
+use std::{
+    collections::BTreeMap,
+    env, fs,
+    path::{Path, PathBuf},
+};
+
+use crate::profiles::FOLDER_PATH_VAR;
+
+/// Name of the global configuration file, stored in the dotenv folder.
+pub const CONFIG_FILE: &str = "config.toml";
+
+/// Personal settings that apply everywhere, read from a TOML file like:
+///
+/// ```toml
+/// strict = true
+/// debug = true
+/// passthrough = ["SSH_AUTH_SOCK"]
+/// folders = ["~/.dotenv", "~/dotfiles/envs"]
+/// sensitive = ["DSN"]
+/// redact = ["ghp_[A-Za-z0-9]{36}"]
+///
+/// [directories]
+/// "~/work/acme" = "acme"
+/// "~/work/globex" = "clients/globex"
+///
+/// [extends]
+/// staging = ["base", "aws"]
+/// ```
+#[derive(Deserialize, Debug, Default, PartialEq)]
+#[serde(deny_unknown_fields)]
+pub struct Config {
+    /// Whether strict mode is always enabled.
+    #[serde(default)]
+    pub strict: bool,
+
+    /// Whether quiet mode is always enabled.
+    #[serde(default)]
+    pub quiet: bool,
+
+    /// Whether the variables injected into commands are always logged.
+    #[serde(default)]
+    pub debug: bool,
+
+    /// Extra variables kept from your shell in strict mode.
+    #[serde(default)]
+    pub passthrough: Vec<String>,
+
+    /// Folders named environments are looked up in, used when
+    /// `DOTENV_FOLDER_PATH` isn't set. Paths can start with `~/`.
+    #[serde(default)]
+    pub folders: Vec<String>,
+
+    /// Extra words that mark a variable as sensitive when they're part of
+    /// its name.
+    #[serde(default)]
+    pub sensitive: Vec<String>,
+
+    /// Regular expressions whose matches are masked in everything dotenv
+    /// shows, whatever the variable they're in.
+    #[serde(default)]
+    pub redact: Vec<String>,
+
+    /// Named environments to use when running commands inside a directory,
+    /// keyed by the directory. Paths can start with `~/`.
+    #[serde(default)]
+    pub directories: BTreeMap<String, String>,
+
+    /// Named environments each named environment builds upon, keyed by its
+    /// name, loaded before the ones its file extends itself.
+    #[serde(default)]
+    pub extends: BTreeMap<String, Vec<String>>,
+}
+
+impl Config {
+    /// Read the global configuration file. A missing file is the same as an
+    /// empty one.
+    pub fn load() -> Result<Self> {
+        let Some(path) = path() else {
+            return Ok(Self::default());
+        };
+        if !path.exists() {
+            return Ok(Self::default());
+        }
+
+        Self::load_from(&path)
+    }
+
+    /// Read a global configuration file.
+    pub fn load_from(path: &Path) -> Result<Self> {
+        let content = fs::read_to_string(path)
+            .with_context(|| format!("Failed to read config file at {}", path.display()))?;
+
+        toml::from_str(&content)
+            .with_context(|| format!("Could not parse config file: {}", path.display()))
+    }
+
+    /// Get the configured folders with `~` expanded.
+    pub fn folders(&self, home: Option<&Path>) -> Vec<PathBuf> {
+        self.folders
+            .iter()
+            .map(|folder| expand_home(folder, home))
+            .collect()
+    }
+
+    /// Find the environment mapped to `dir` or its closest parent, returning
+    /// it along with the directory it was mapped to.
+    pub fn environment_for(&self, dir: &Path, home: Option<&Path>) -> Option<(String, PathBuf)> {
+        self.directories
+            .iter()
+            .map(|(prefix, name)| (expand_home(prefix, home), name))
+            .filter(|(prefix, _)| dir.starts_with(prefix))
+            .max_by_key(|(prefix, _)| prefix.components().count())
+            .map(|(prefix, name)| (name.clone(), prefix))
+    }
+}
+
+/// Get the path of the global configuration file: `config.toml` in the
+/// first folder of `DOTENV_FOLDER_PATH` if set, or in `~/.dotenv`. It can't
+/// be in a folder listed by the file itself, since it has to be read first.
+pub fn path() -> Option<PathBuf> {
+    let folder = match env::var_os(FOLDER_PATH_VAR).filter(|v| !v.is_empty()) {
+        Some(value) => env::split_paths(&value).find(|p| !p.as_os_str().is_empty())?,
+        None => dirs::home_dir()?.join(".dotenv"),
+    };
+    Some(folder.join(CONFIG_FILE))
+}
+
+/// Replace a leading `~` in a path with the home directory.
+fn expand_home(path: &str, home: Option<&Path>) -> PathBuf {
+    match (path.strip_prefix('~'), home) {
+        (Some(rest), Some(home)) if rest.is_empty() || rest.starts_with(['/', '\\']) => {
+            home.join(rest.trim_start_matches(['/', '\\']))
+        }
+        _ => PathBuf::from(path),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_environment_for_longest_prefix() -> Result<()> {
+        let config: Config = toml::from_str(
+            "[directories]\n\"~/work\" = \"work\"\n\"~/work/acme\" = \"acme\"\n\"/srv/app\" = \"server\"\n",
+        )?;
+        let home = Path::new("/home/user");
+
+        assert_eq!(
+            config.environment_for(Path::new("/home/user/work/acme/api"), Some(home)),
+            Some(("acme".to_string(), PathBuf::from("/home/user/work/acme")))
+        );
+        assert_eq!(
+            config.environment_for(Path::new("/home/user/work/other"), Some(home)),
+            Some(("work".to_string(), PathBuf::from("/home/user/work")))
+        );
+        assert_eq!(
+            config.environment_for(Path::new("/srv/app"), Some(home)),
+            Some(("server".to_string(), PathBuf::from("/srv/app")))
+        );
+        assert_eq!(
+            config.environment_for(Path::new("/home/user/workshop"), Some(home)),
+            None
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_expand_home() {
+        let home = Some(Path::new("/home/user"));
+        assert_eq!(expand_home("~", home), PathBuf::from("/home/user"));
+        assert_eq!(expand_home("~/a", home), PathBuf::from("/home/user/a"));
+        assert_eq!(expand_home("~other/a", home), PathBuf::from("~other/a"));
+        assert_eq!(expand_home("/abs", home), PathBuf::from("/abs"));
+    }
+
+    #[test]
+    fn test_parse_defaults() -> Result<()> {
+        let config: Config = toml::from_str(
+            "strict = true\ndebug = true\npassthrough = [\"SSH_AUTH_SOCK\"]\nfolders = [\"~/envs\", \"/srv/envs\"]\nsensitive = [\"DSN\"]\nredact = [\"ghp_\\\\w+\"]\n",
+        )?;
+        assert!(config.strict);
+        assert!(!config.quiet);
+        assert!(config.debug);
+        assert_eq!(config.passthrough, vec!["SSH_AUTH_SOCK"]);
+        assert_eq!(config.sensitive, vec!["DSN"]);
+        assert_eq!(config.redact, vec![r"ghp_\w+"]);
+        assert_eq!(
+            config.folders(Some(Path::new("/home/user"))),
+            vec![PathBuf::from("/home/user/envs"), PathBuf::from("/srv/envs")]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_parse_extends() -> Result<()> {
+        let config: Config = toml::from_str(
+            "[extends]\nstaging = [\"base\", \"aws\"]\n\"clients/acme\" = [\"base\"]\n",
+        )?;
+        assert_eq!(config.extends["staging"], vec!["base", "aws"]);
+        assert_eq!(config.extends["clients/acme"], vec!["base"]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_rejects_unknown_fields() {
+        assert!(toml::from_str::<Config>("directory = {}\n").is_err());
+    }
+}
@@ -0,0 +1,58 @@
+use std::time::Duration;
+
+/// Parse a duration like `30s`, `500ms`, `5m` or `1h`. A plain number is
+/// taken as seconds.
+pub fn parse(value: &str) -> Result<Duration, String> {
+    let value = value.trim();
+    let split = value
+        .find(|c: char| !c.is_ascii_digit() && c != '.')
+        .unwrap_or(value.len());
+    let (number, unit) = value.split_at(split);
+
+    let number: f64 = number
+        .parse()
+        .map_err(|_| format!("invalid duration {:?}, expected something like 30s", value))?;
+    let seconds = match unit {
+        "ms" => number / 1000.0,
+        "" | "s" => number,
+        "m" => number * 60.0,
+        "h" => number * 3600.0,
+        _ => {
+            return Err(format!(
+                "invalid unit {:?} in {:?}, expected ms, s, m or h",
+                unit, value
+            ))
+        }
+    };
+
+    Duration::try_from_secs_f64(seconds).map_err(|_| format!("invalid duration {:?}", value))
+}
+
+/// Format a duration the way [`parse`] reads it, like `1.5s`.
+pub fn display(duration: Duration) -> String {
+    format!("{}s", duration.as_secs_f64())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse() {
+        assert_eq!(parse("30s"), Ok(Duration::from_secs(30)));
+        assert_eq!(parse("30"), Ok(Duration::from_secs(30)));
+        assert_eq!(parse("500ms"), Ok(Duration::from_millis(500)));
+        assert_eq!(parse("1.5m"), Ok(Duration::from_secs(90)));
+        assert_eq!(parse("2h"), Ok(Duration::from_secs(7200)));
+        assert!(parse("").is_err());
+        assert!(parse("s").is_err());
+        assert!(parse("10d").is_err());
+        assert!(parse("-5s").is_err());
+    }
+
+    #[test]
+    fn test_display() {
+        assert_eq!(display(Duration::from_secs(30)), "30s");
+        assert_eq!(display(Duration::from_millis(1500)), "1.5s");
+    }
+}
@@ -0,0 +1,389 @@
+use anyhow::{Context, Result};
+use std::{
+    fs,
+    io::Write,
+    path::{Path, PathBuf},
+};
+
+use crate::{age, env_parser, gpg, sops, store};
+
+/// A `.env` file kept line by line so it can be modified without losing
+/// comments, blank lines or the order in which keys are defined.
+#[derive(Debug)]
+pub struct EnvDocument {
+    lines: Vec<String>,
+}
+
+impl EnvDocument {
+    /// Read a `.env` file into a document. Files encrypted with age, SOPS
+    /// or GPG have to be decrypted first, as they couldn't be encrypted
+    /// again.
+    pub fn load(file_path: &PathBuf) -> Result<Self> {
+        if gpg::is_encrypted(file_path) {
+            anyhow::bail!(
+                "{} is encrypted with GPG, decrypt it with gpg to change it",
+                file_path.display()
+            );
+        }
+        let content = match store::entry_for(file_path)? {
+            Some(content) => content,
+            None => fs::read_to_string(file_path)
+                .with_context(|| format!("Failed to read .env file at {}", file_path.display()))?,
+        };
+        if age::is_encrypted(content.as_bytes()) {
+            anyhow::bail!(
+                "{} is encrypted with age, run `dotenv decrypt` to change it",
+                file_path.display()
+            );
+        }
+        if sops::is_encrypted(&content) {
+            anyhow::bail!(
+                "{} is encrypted with SOPS, run `sops edit` to change it",
+                file_path.display()
+            );
+        }
+
+        Ok(Self::parse(&content))
+    }
+
+    /// Split a `.env` format string into a document.
+    pub fn parse(content: &str) -> Self {
+        Self {
+            lines: content.lines().map(str::to_string).collect(),
+        }
+    }
+
+    /// Get every `KEY=VALUE` entry in the order they appear in the file,
+    /// including keys defined more than once.
+    pub fn entries(&self) -> Vec<(String, String)> {
+        self.lines
+            .iter()
+            .filter_map(|line| Self::entry(line))
+            .collect()
+    }
+
+    /// Get the value of a key. When a key is defined more than once, the
+    /// last definition wins, just like when the file is loaded.
+    pub fn get(&self, key: &str) -> Option<String> {
+        self.position(key)
+            .and_then(|idx| Self::entry(&self.lines[idx]))
+            .map(|(_, value)| value)
+    }
+
+    /// Set the value of a key, replacing its last definition in place
+    /// (keeping any trailing comment) or appending it to the end.
+    pub fn set(&mut self, key: &str, value: &str) -> Result<()> {
+        if !env_parser::is_valid_key(key) {
+            anyhow::bail!("Invalid variable name: {:?}", key);
+        }
+
+        let mut line = format!("{}={}", key, quote_value(value)?);
+        match self.position(key) {
+            Some(idx) => {
+                if let Some(comment) = trailing_comment(&self.lines[idx]) {
+                    line.push(' ');
+                    line.push_str(comment);
+                }
+                self.lines[idx] = line;
+            }
+            None => self.lines.push(line),
+        }
+
+        Ok(())
+    }
+
+    /// Remove every definition of a key, returning whether it was found.
+    pub fn unset(&mut self, key: &str) -> bool {
+        let before = self.lines.len();
+        self.lines
+            .retain(|line| Self::entry(line).is_none_or(|(k, _)| k != key));
+        self.lines.len() != before
+    }
+
+    /// Produce the canonical form of the document: every line is trimmed,
+    /// entries are written as `KEY=VALUE` with normalized quoting, runs of
+    /// blank lines are collapsed and, optionally, entries are sorted by key
+    /// within each blank-line separated section. Comments placed right
+    /// above an entry move along with it.
+    pub fn formatted(&self, sort: bool) -> EnvDocument {
+        let mut sections: Vec<Vec<String>> = vec![Vec::new()];
+        for line in &self.lines {
+            let line = Self::format_line(line);
+            if line.is_empty() {
+                if !sections.last().is_some_and(Vec::is_empty) {
+                    sections.push(Vec::new());
+                }
+            } else if let Some(section) = sections.last_mut() {
+                section.push(line);
+            }
+        }
+        sections.retain(|section| !section.is_empty());
+
+        if sort {
+            for section in &mut sections {
+                *section = Self::sort_section(section);
+            }
+        }
+
+        EnvDocument {
+            lines: sections.join(&String::new()),
+        }
+    }
+
+    /// Produce a copy of the document with every value replaced by
+    /// `placeholder`, keeping comments, blank lines and ordering. Variables
+    /// configuring dotenv itself keep their values.
+    pub fn scrubbed(&self, placeholder: &str) -> EnvDocument {
+        let lines = self
+            .lines
+            .iter()
+            .map(|line| match Self::entry(line) {
+                Some((key, _)) if !key.starts_with("DOTENV_") => {
+                    let mut scrubbed = format!("{}={}", key, placeholder);
+                    if let Some(comment) = trailing_comment(line) {
+                        scrubbed.push(' ');
+                        scrubbed.push_str(comment);
+                    }
+                    scrubbed
+                }
+                _ => line.clone(),
+            })
+            .collect();
+
+        EnvDocument { lines }
+    }
+
+    /// Write the document back to disk. The file is replaced atomically
+    /// and keeps its original permissions.
+    pub fn save(&self, file_path: &Path) -> Result<()> {
+        replace_file(file_path, self.to_string().as_bytes())
+    }
+
+    /// Normalize a single line, keeping entries that can't be re-quoted as
+    /// they were.
+    fn format_line(line: &str) -> String {
+        let line = line.trim();
+        let (key, value) = match Self::entry(line) {
+            Some(entry) => entry,
+            None => return line.to_string(),
+        };
+
+        let mut formatted = match quote_value(&value) {
+            Ok(value) => format!("{}={}", key, value),
+            Err(_) => return line.to_string(),
+        };
+
+        if let Some(comment) = trailing_comment(line) {
+            formatted.push(' ');
+            formatted.push_str(comment);
+        }
+        formatted
+    }
+
+    /// Sort the entries of a section by key. Each entry carries the
+    /// comments right above it, while comments at the end of the section
+    /// stay at the end.
+    fn sort_section(section: &[String]) -> Vec<String> {
+        let mut items: Vec<(String, Vec<String>)> = Vec::new();
+        let mut pending = Vec::new();
+        for line in section {
+            pending.push(line.clone());
+            if let Some((key, _)) = Self::entry(line) {
+                items.push((key, std::mem::take(&mut pending)));
+            }
+        }
+
+        items.sort_by(|a, b| a.0.cmp(&b.0));
+        items
+            .into_iter()
+            .flat_map(|(_, lines)| lines)
+            .chain(pending)
+            .collect()
+    }
+
+    /// Find the index of the line holding the last definition of a key.
+    fn position(&self, key: &str) -> Option<usize> {
+        self.lines
+            .iter()
+            .rposition(|line| Self::entry(line).is_some_and(|(k, _)| k == key))
+    }
+
+    /// Parse a line as a `KEY=VALUE` entry, ignoring comments.
+    fn entry(line: &str) -> Option<(String, String)> {
+        env_parser::strip_comments(line).and_then(env_parser::parse_env_line)
+    }
+}
+
+impl std::fmt::Display for EnvDocument {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        for line in &self.lines {
+            writeln!(f, "{}", line)?;
+        }
+        Ok(())
+    }
+}
+
+/// Replace a file with new contents atomically, keeping its original
+/// permissions.
+pub fn replace_file(file_path: &Path, content: &[u8]) -> Result<()> {
+    if store::write_entry(file_path, content)? {
+        return Ok(());
+    }
+    let dir = file_path.parent().unwrap_or_else(|| Path::new("."));
+    let mut scratch = tempfile::Builder::new()
+        .prefix(".dotenv-")
+        .suffix(".env")
+        .tempfile_in(dir)
+        .with_context(|| format!("Could not create temporary file in {}", dir.display()))?;
+    scratch.write_all(content)?;
+
+    if let Ok(metadata) = fs::metadata(file_path) {
+        fs::set_permissions(scratch.path(), metadata.permissions())?;
+    }
+
+    scratch
+        .persist(file_path)
+        .with_context(|| format!("Could not save changes to {}", file_path.display()))?;
+    Ok(())
+}
+
+/// Quote a value so it reads back exactly as given. The parser has no
+/// escape sequences, so values it can't represent are rejected.
+pub fn quote_value(value: &str) -> Result<String> {
+    if value.is_empty() {
+        anyhow::bail!("Values can't be empty");
+    }
+
+    if value.contains(['#', '\n', '\r']) {
+        anyhow::bail!("Values can't contain '#' or line breaks");
+    }
+
+    if value.trim() != value {
+        anyhow::bail!("Values can't start or end with whitespace");
+    }
+
+    let quoted = if value.starts_with('"') || value.ends_with('"') {
+        format!("'{}'", value)
+    } else if value.starts_with('\'')
+        || value.ends_with('\'')
+        || value.contains(char::is_whitespace)
+    {
+        format!("\"{}\"", value)
+    } else {
+        value.to_string()
+    };
+
+    Ok(quoted)
+}
+
+/// Get the trailing `# comment` of an entry line, if any.
+fn trailing_comment(line: &str) -> Option<&str> {
+    let line = line.trim();
+    line.find('#').map(|idx| &line[idx..])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const INPUT: &str = "\
+# Database settings
+DB_HOST=localhost # primary
+
+DB_PORT=5432
+DB_HOST=override
+";
+
+    #[test]
+    fn test_get_uses_last_definition() {
+        let doc = EnvDocument::parse(INPUT);
+        assert_eq!(doc.get("DB_HOST"), Some("override".to_string()));
+        assert_eq!(doc.get("DB_PORT"), Some("5432".to_string()));
+        assert_eq!(doc.get("MISSING"), None);
+    }
+
+    #[test]
+    fn test_entries_keep_file_order() {
+        let doc = EnvDocument::parse(INPUT);
+        let keys: Vec<String> = doc.entries().into_iter().map(|(k, _)| k).collect();
+        assert_eq!(keys, vec!["DB_HOST", "DB_PORT", "DB_HOST"]);
+    }
+
+    #[test]
+    fn test_set_preserves_layout() -> Result<()> {
+        let mut doc = EnvDocument::parse("# Comment\n\nA=1 # keep me\nB=2\n");
+        doc.set("A", "10")?;
+        doc.set("C", "with spaces")?;
+        assert_eq!(
+            doc.to_string(),
+            "# Comment\n\nA=10 # keep me\nB=2\nC=\"with spaces\"\n"
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_scrubbed_keeps_layout() {
+        let doc = EnvDocument::parse(
+            "# Database\nDB_HOST=localhost # primary\n\nDB_PASSWORD='hunter 2'\nDOTENV_STRICT=true\n",
+        );
+        assert_eq!(
+            doc.scrubbed("changeme").to_string(),
+            "# Database\nDB_HOST=changeme # primary\n\nDB_PASSWORD=changeme\nDOTENV_STRICT=true\n"
+        );
+    }
+
+    #[test]
+    fn test_set_round_trips_values() -> Result<()> {
+        for value in ["plain", "two words", "\"quoted\"", "'single'", "a=b"] {
+            let mut doc = EnvDocument::parse("");
+            doc.set("KEY", value)?;
+            let vars = env_parser::parse_env_str(&doc.to_string())?;
+            assert_eq!(vars.get("KEY").map(String::as_str), Some(value));
+        }
+        Ok(())
+    }
+
+    #[test]
+    fn test_set_rejects_invalid_input() {
+        let mut doc = EnvDocument::parse("");
+        assert!(doc.set("BAD KEY", "value").is_err());
+        assert!(doc.set("KEY", "").is_err());
+        assert!(doc.set("KEY", "has # hash").is_err());
+        assert!(doc.set("KEY", " padded").is_err());
+    }
+
+    #[test]
+    fn test_formatted_normalizes_lines() {
+        let doc =
+            EnvDocument::parse("\n\n   # Header   \nB = \"two\"   \n\n\n\nA='x y'   #  note\n  \n");
+        assert_eq!(
+            doc.formatted(false).to_string(),
+            "# Header\nB=two\n\nA=\"x y\" #  note\n"
+        );
+    }
+
+    #[test]
+    fn test_formatted_sorts_sections() {
+        let doc = EnvDocument::parse(
+            "# Header\n\n# about C\nC=3\nA=1\n# about B\nB=2\n# trailing\n\nZ=26\nY=25\n",
+        );
+        assert_eq!(
+            doc.formatted(true).to_string(),
+            "# Header\n\nA=1\n# about B\nB=2\n# about C\nC=3\n# trailing\n\nY=25\nZ=26\n"
+        );
+    }
+
+    #[test]
+    fn test_formatted_is_idempotent() {
+        let doc = EnvDocument::parse(INPUT).formatted(true);
+        assert_eq!(doc.formatted(true).to_string(), doc.to_string());
+    }
+
+    #[test]
+    fn test_unset_removes_all_definitions() {
+        let mut doc = EnvDocument::parse(INPUT);
+        assert!(doc.unset("DB_HOST"));
+        assert!(!doc.unset("DB_HOST"));
+        assert_eq!(doc.to_string(), "# Database settings\n\nDB_PORT=5432\n");
+    }
+}
@@ -1,14 +1,71 @@
 use anyhow::{Context, Result};
 use std::collections::HashMap;
 use std::fs;
-use std::path::PathBuf;
+use std::path::Path;
+
+use crate::{age, gpg, mask, schema, sops, store, totp};
+
+/// Comment marking the variable on the same line as sensitive, so its value
+/// is masked wherever it's shown, like `DSN=postgres://... # !secret`.
+pub const SECRET_ANNOTATION: &str = "!secret";
+
+/// The variables of a `.env` file, along with the ones it marks as
+/// sensitive, for the caller to mask.
+#[derive(Debug, Default)]
+pub struct Parsed {
+    pub vars: HashMap<String, String>,
+    pub sensitive: Vec<String>,
+}
 
 /// Parse a `.env` file and return key-value pairs of environment variables.
-pub fn parse_env_file(file_path: &PathBuf) -> Result<HashMap<String, String>> {
-    let content = fs::read_to_string(file_path)
+/// Files encrypted with age, SOPS or GPG are decrypted first.
+pub fn parse_env_file(file_path: &Path) -> Result<HashMap<String, String>> {
+    parse_env_str(&read_env_file(file_path)?)
+}
+
+/// Parse a `.env` file like [`parse_env_file`], along with the variables
+/// marked as sensitive in it or in the schema next to it. A schema that
+/// can't be read is reported, as the variables it marks won't be masked.
+pub fn load_env_file(file_path: &Path) -> Result<Parsed> {
+    let mut parsed = parse_env(&read_env_file(file_path)?)?;
+
+    let schema_file = file_path.with_file_name(schema::SCHEMA_FILE);
+    if schema_file.is_file() {
+        match schema::Schema::load(&schema_file) {
+            Ok(schema) => parsed
+                .sensitive
+                .extend(schema.secret_keys().map(str::to_string)),
+            Err(err) => note!(
+                "dotenv: The variables {} marks as secret aren't masked: {:#}",
+                schema_file.display(),
+                err
+            ),
+        }
+    }
+    Ok(parsed)
+}
+
+/// Read the contents of a `.env` file, decrypting it if it's encrypted with
+/// age, SOPS or GPG, or from the store it's kept in.
+pub fn read_env_file(file_path: &Path) -> Result<String> {
+    if let Some(content) = store::entry_for(file_path)? {
+        return Ok(content);
+    }
+    if gpg::is_encrypted(file_path) {
+        return gpg::decrypt(file_path);
+    }
+    let content = fs::read(file_path)
         .with_context(|| format!("Failed to read .env file at {}", file_path.display()))?;
 
-    parse_env_str(&content)
+    if age::is_encrypted(&content) {
+        return age::decrypt(file_path, &content);
+    }
+    let content = String::from_utf8(content)
+        .with_context(|| format!("Failed to read .env file at {}", file_path.display()))?;
+    if sops::is_encrypted(&content) {
+        return sops::decrypt(file_path);
+    }
+    Ok(content)
 }
 
 /// Parse a `.env` format string and return key-value pairs.
@@ -20,43 +77,152 @@ pub fn parse_env_file(file_path: &PathBuf) -> Result<HashMap<String, String>> {
 /// - Keys and values are trimmed.
 /// - Invalid lines (no `=` or empty key/value) are ignored.
 pub fn parse_env_str(content: &str) -> Result<HashMap<String, String>> {
-    let mut env_vars = HashMap::new();
+    parse_env(content).map(|parsed| parsed.vars)
+}
 
-    for line in content.lines() {
-        let line = line.trim();
+/// Parse a `.env` format string like [`parse_env_str`], along with the
+/// variables marked as sensitive: the ones with a `# !secret` comment and
+/// those holding a TOTP seed, as secret as the codes it gives.
+pub fn parse_env(content: &str) -> Result<Parsed> {
+    let mut parsed = Parsed::default();
 
-        // Ignore empty lines
-        if line.is_empty() {
-            continue;
+    for raw in content.lines() {
+        let line = match strip_comments(raw) {
+            Some(line) => line,
+            None => continue,
+        };
+
+        if let Some((key, value)) = parse_env_line(line) {
+            if is_marked_secret(raw) || value.starts_with(totp::PREFIX) {
+                parsed.sensitive.push(key.clone());
+            }
+            parsed.vars.insert(key, value);
         }
+    }
+
+    Ok(parsed)
+}
+
+/// A problem found in a specific line of a `.env` file.
+#[derive(Debug, PartialEq)]
+pub struct LineError {
+    pub line: usize,
+    pub message: String,
+}
+
+impl std::fmt::Display for LineError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "line {}: {}", self.line, self.message)
+    }
+}
+
+/// Validate a `.env` format string, returning every line that the parser
+/// would otherwise silently ignore or misread. An empty list means the
+/// content is well-formed.
+pub fn validate_env_str(content: &str) -> Vec<LineError> {
+    let mut errors = Vec::new();
+
+    for (idx, raw) in content.lines().enumerate() {
+        let line = match strip_comments(raw) {
+            Some(line) => line,
+            None => continue,
+        };
+
+        let mut error = |message: String| {
+            errors.push(LineError {
+                line: idx + 1,
+                message,
+            })
+        };
 
-        // Ignore shebang or line that starts with '#'
-        if line.starts_with("#!") || line.starts_with('#') {
+        let (key, value) = match line.split_once('=') {
+            Some((key, value)) => (key.trim(), value.trim()),
+            None => {
+                // A line like `DB_PASSWORD hunter2` still holds a secret
+                let shown = match line.split_once(char::is_whitespace) {
+                    Some((key, rest)) => {
+                        format!("{} {}", key, mask::display_value(key, rest.trim(), false))
+                    }
+                    None => line.to_string(),
+                };
+                error(format!("missing '=' in {:?}", shown));
+                continue;
+            }
+        };
+
+        if key.is_empty() {
+            error("missing variable name before '='".to_string());
             continue;
         }
 
-        // Strip trailing comments
-        let line = if let Some(idx) = line.find('#') {
-            &line[..idx]
-        } else {
-            line
-        };
+        if !is_valid_key(key) {
+            error(format!("invalid variable name {:?}", key));
+            continue;
+        }
 
-        let line = line.trim();
-        if line.is_empty() {
+        if value.is_empty() {
+            error(format!("missing value for {}", key));
             continue;
         }
 
-        if let Some((key, value)) = parse_env_line(line) {
-            env_vars.insert(key, value);
+        let quote = value.as_bytes()[0];
+        if (quote == b'"' || quote == b'\'')
+            && (value.len() < 2 || value.as_bytes()[value.len() - 1] != quote)
+        {
+            error(format!("unterminated quoted value for {}", key));
         }
     }
 
-    Ok(env_vars)
+    errors
+}
+
+/// Check if a variable name only uses letters, digits, `_`, `.` or `-`
+/// and doesn't start with a digit.
+pub(crate) fn is_valid_key(key: &str) -> bool {
+    let mut chars = key.chars();
+    match chars.next() {
+        Some(c) if c.is_ascii_alphabetic() || c == '_' => {}
+        _ => return false,
+    }
+
+    chars.all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '.' | '-'))
+}
+
+/// Trim a line and remove comments from it, returning `None` if nothing
+/// meaningful is left.
+///
+/// Lines starting with `#` (including shebangs) are ignored entirely and
+/// a `#` anywhere else starts a trailing comment.
+pub(crate) fn strip_comments(line: &str) -> Option<&str> {
+    let line = line.trim();
+
+    // Ignore empty lines, shebangs and lines that start with '#'
+    if line.is_empty() || line.starts_with('#') {
+        return None;
+    }
+
+    // Strip trailing comments
+    let line = match line.find('#') {
+        Some(idx) => &line[..idx],
+        None => line,
+    };
+
+    let line = line.trim();
+    if line.is_empty() {
+        None
+    } else {
+        Some(line)
+    }
+}
+
+/// Check if a line's trailing comment starts with `!secret`.
+fn is_marked_secret(line: &str) -> bool {
+    line.split_once('#')
+        .is_some_and(|(_, comment)| comment.split_whitespace().next() == Some(SECRET_ANNOTATION))
 }
 
 /// Parse a single line of the form `KEY=VALUE`.
-fn parse_env_line(line: &str) -> Option<(String, String)> {
+pub(crate) fn parse_env_line(line: &str) -> Option<(String, String)> {
     let mut split = line.splitn(2, '=');
     let key = split.next()?.trim();
     let val = split.next()?.trim();
@@ -237,6 +403,39 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_parse_env_str_secret_annotation() -> Result<()> {
+        let parsed = parse_env(
+            "ANNOTATED_DSN=postgres://app@db/app # !secret
+ANNOTATED_HOST=db # !secretive
+ANNOTATED_OTP=totp:JBSWY3DPEHPK3PXP
+",
+        )?;
+        assert_eq!(parsed.vars["ANNOTATED_DSN"], "postgres://app@db/app");
+        assert_eq!(parsed.sensitive, vec!["ANNOTATED_DSN", "ANNOTATED_OTP"]);
+        // Parsing leaves it to the caller to mask them
+        assert!(!mask::is_sensitive("ANNOTATED_DSN"));
+        Ok(())
+    }
+
+    #[test]
+    fn test_validate_env_str() {
+        let input = "# comment\nGOOD=value\nINVALIDLINE\n=VALUE\nEMPTY=\nexport FOO=bar\nQUOTED=\"open\nOK='closed' # trailing\n";
+
+        let errors = validate_env_str(input);
+        let lines: Vec<usize> = errors.iter().map(|e| e.line).collect();
+        assert_eq!(lines, vec![3, 4, 5, 6, 7]);
+        assert_eq!(
+            errors[0].to_string(),
+            "line 3: missing '=' in \"INVALIDLINE\""
+        );
+        assert!(validate_env_str("KEY=VALUE\nDB.HOST-NAME=x\n").is_empty());
+        assert_eq!(
+            validate_env_str("DB_PASSWORD hunter2\n")[0].message,
+            format!("missing '=' in \"DB_PASSWORD {}\"", mask::masked("hunter2"))
+        );
+    }
+
     #[test]
     fn test_parse_env_str_complex_comments() -> Result<()> {
         let input = r#"
@@ -0,0 +1,117 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::HashMap,
+    process::{Command, Stdio},
+};
+
+/// Prefix of the values computed by running a command through the shell,
+/// like `exec:aws sts get-session-token`, which only files that are allowed
+/// can use.
+pub const PREFIX: &str = "exec:";
+
+/// Check if a value is computed by running a command.
+pub fn is_command(value: &str) -> bool {
+    value.starts_with(PREFIX)
+}
+
+/// Find the keys whose values are computed with a command, in order.
+pub fn commands(vars: &HashMap<String, String>) -> Vec<String> {
+    let mut keys: Vec<String> = vars
+        .iter()
+        .filter(|(_, value)| is_command(value))
+        .map(|(key, _)| key.clone())
+        .collect();
+    keys.sort();
+    keys
+}
+
+/// Replace, in place, the values found by [`commands`] with what their
+/// commands print. The commands run one at a time, as they may ask for
+/// something on the terminal, like the code of a second factor, and each
+/// runs once however many keys use it. They see the other variables, so an
+/// `AWS_PROFILE` in the same file picks the account to ask.
+pub fn resolve_values(vars: &mut HashMap<String, String>, keys: Vec<String>) -> Result<()> {
+    if keys.is_empty() {
+        return Ok(());
+    }
+    let others: HashMap<String, String> = vars
+        .iter()
+        .filter(|(key, _)| !keys.contains(key))
+        .map(|(key, value)| (key.clone(), value.clone()))
+        .collect();
+    let mut computed: HashMap<String, String> = HashMap::new();
+    for key in keys {
+        let command = vars[&key][PREFIX.len()..].trim().to_string();
+        let value = match computed.get(&command) {
+            Some(value) => value.clone(),
+            None => match run(&command, &others) {
+                Ok(value) => {
+                    computed.insert(command, value.clone());
+                    value
+                }
+                Err(err) => {
+                    anyhow::bail!("Could not compute {} with `{}`: {:#}", key, command, err)
+                }
+            },
+        };
+        vars.insert(key, value);
+    }
+    Ok(())
+}
+
+/// Run a command through the shell, taking what it prints as the value,
+/// without the trailing line break. What it prints on its standard error
+/// is shown as is, like any prompt.
+fn run(command: &str, vars: &HashMap<String, String>) -> Result<String> {
+    // Values are computed before anything is loaded into the environment,
+    // so the shell is still the one dotenv was started with
+    let invocation = crate::Shell::from_env().invocation(command);
+    let output = Command::new(&invocation[0])
+        .args(&invocation[1..])
+        .envs(vars)
+        .stdin(Stdio::inherit())
+        .stderr(Stdio::inherit())
+        .output()
+        .with_context(|| format!("Failed to run {}", invocation[0]))?;
+    if !output.status.success() {
+        anyhow::bail!("the command failed with {}", output.status);
+    }
+    let value = String::from_utf8(output.stdout).context("The value isn't text")?;
+    let value = value.strip_suffix('\n').unwrap_or(&value);
+    Ok(value.strip_suffix('\r').unwrap_or(value).to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[cfg(unix)]
+    #[test]
+    fn test_resolve_values() -> Result<()> {
+        let mut vars: HashMap<String, String> = [
+            ("NAME", "bob"),
+            ("GREETING", "exec:echo \"hello $NAME\""),
+            ("AGAIN", "exec: echo \"hello $NAME\""),
+            ("PLAIN", "executive"),
+        ]
+        .into_iter()
+        .map(|(key, value)| (key.to_string(), value.to_string()))
+        .collect();
+
+        let keys = commands(&vars);
+        assert_eq!(keys, vec!["AGAIN", "GREETING"]);
+        resolve_values(&mut vars, keys)?;
+        assert_eq!(vars["GREETING"], "hello bob");
+        assert_eq!(vars["AGAIN"], "hello bob");
+        assert_eq!(vars["PLAIN"], "executive");
+
+        let mut failing: HashMap<String, String> =
+            [("TOKEN".to_string(), "exec:exit 3".to_string())].into();
+        let keys = commands(&failing);
+        let err = resolve_values(&mut failing, keys).unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("Could not compute TOKEN with `exit 3`"));
+        Ok(())
+    }
+}
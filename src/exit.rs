@@ -0,0 +1,220 @@
+use std::{fmt, io, process::ExitStatus};
+
+/// Exit code used when dotenv itself fails before running the command, for
+/// example because of an invalid flag value or an unreadable environment
+/// file.
+pub const CONFIG_ERROR: i32 = 125;
+
+/// Exit code used when the command was terminated for running longer than
+/// `--timeout`, the same one used by `timeout(1)`.
+pub const TIMED_OUT: i32 = 124;
+
+/// Exit code used when the command was found but couldn't be executed,
+/// usually because it isn't executable.
+pub const CANNOT_EXECUTE: i32 = 126;
+
+/// Exit code used when the command couldn't be found.
+pub const NOT_FOUND: i32 = 127;
+
+/// Every reserved exit code along with what it means, used to document
+/// them.
+pub static RESERVED: &[(i32, &str)] = &[
+    (
+        TIMED_OUT,
+        "the command ran longer than --timeout and was terminated",
+    ),
+    (CONFIG_ERROR, "dotenv failed before running the command"),
+    (
+        CANNOT_EXECUTE,
+        "the command was found but could not be executed",
+    ),
+    (NOT_FOUND, "the command could not be found"),
+];
+
+/// An error returned when the command couldn't be started.
+#[derive(Debug)]
+pub struct SpawnError(pub io::Error);
+
+impl fmt::Display for SpawnError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        self.0.fmt(f)
+    }
+}
+
+impl std::error::Error for SpawnError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        Some(&self.0)
+    }
+}
+
+/// How a command ended.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Ended {
+    /// It exited with a status.
+    Exited(i32),
+    /// It was killed by a signal, given by its number.
+    Killed(i32),
+}
+
+impl Ended {
+    /// The status the command exited with, or `None` if it was killed.
+    pub fn code(self) -> Option<i32> {
+        match self {
+            Ended::Exited(code) => Some(code),
+            Ended::Killed(_) => None,
+        }
+    }
+
+    /// The exit code dotenv should use for the command: its own, or 128
+    /// plus the signal's number if it was killed by one, like shells do.
+    pub fn exit_code(self) -> i32 {
+        match self {
+            Ended::Exited(code) => code,
+            Ended::Killed(signal) => 128 + signal,
+        }
+    }
+}
+
+impl From<ExitStatus> for Ended {
+    #[cfg(unix)]
+    fn from(status: ExitStatus) -> Self {
+        use std::os::unix::process::ExitStatusExt;
+
+        match (status.code(), status.signal()) {
+            (Some(code), _) => Ended::Exited(code),
+            (None, Some(signal)) => Ended::Killed(signal),
+            (None, None) => Ended::Exited(1),
+        }
+    }
+
+    #[cfg(not(unix))]
+    fn from(status: ExitStatus) -> Self {
+        Ended::Exited(status.code().unwrap_or(1))
+    }
+}
+
+impl fmt::Display for Ended {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Ended::Exited(code) => write!(f, "exited with status {}", code),
+            Ended::Killed(signal) => write!(f, "was killed by {}", signal_name(*signal)),
+        }
+    }
+}
+
+/// The signals known by name, with their numbers.
+#[cfg(unix)]
+const SIGNALS: &[(libc::c_int, &str)] = &[
+    (libc::SIGHUP, "SIGHUP"),
+    (libc::SIGINT, "SIGINT"),
+    (libc::SIGQUIT, "SIGQUIT"),
+    (libc::SIGILL, "SIGILL"),
+    (libc::SIGTRAP, "SIGTRAP"),
+    (libc::SIGABRT, "SIGABRT"),
+    (libc::SIGBUS, "SIGBUS"),
+    (libc::SIGFPE, "SIGFPE"),
+    (libc::SIGKILL, "SIGKILL"),
+    (libc::SIGUSR1, "SIGUSR1"),
+    (libc::SIGSEGV, "SIGSEGV"),
+    (libc::SIGUSR2, "SIGUSR2"),
+    (libc::SIGPIPE, "SIGPIPE"),
+    (libc::SIGALRM, "SIGALRM"),
+    (libc::SIGTERM, "SIGTERM"),
+    (libc::SIGXCPU, "SIGXCPU"),
+    (libc::SIGXFSZ, "SIGXFSZ"),
+    (libc::SIGSYS, "SIGSYS"),
+];
+#[cfg(not(unix))]
+const SIGNALS: &[(i32, &str)] = &[];
+
+/// Name a signal by its number, like `SIGKILL`.
+pub fn signal_name(signal: i32) -> String {
+    match SIGNALS.iter().find(|(number, _)| *number == signal) {
+        Some((_, name)) => name.to_string(),
+        None => format!("signal {}", signal),
+    }
+}
+
+/// Parse a signal given by name, with or without the `SIG` prefix and in
+/// any case, like `HUP` or `SIGUSR1`, or by number.
+pub fn parse_signal(value: &str) -> Result<i32, String> {
+    let value = value.trim();
+    if let Ok(number) = value.parse::<i32>() {
+        if number > 0 {
+            return Ok(number);
+        }
+    }
+    let name = value.to_ascii_uppercase();
+    let name = name.strip_prefix("SIG").unwrap_or(&name);
+    SIGNALS
+        .iter()
+        .find(|(_, known)| known[3..] == *name)
+        .map(|(number, _)| *number)
+        .ok_or_else(|| {
+            format!(
+                "unknown signal {:?}, expected a name like HUP or a number",
+                value
+            )
+        })
+}
+
+/// Get the exit code dotenv should use for an error.
+pub fn code_for(err: &anyhow::Error) -> i32 {
+    match err.downcast_ref::<SpawnError>() {
+        Some(SpawnError(err)) if err.kind() == io::ErrorKind::NotFound => NOT_FOUND,
+        Some(_) => CANNOT_EXECUTE,
+        None => CONFIG_ERROR,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use anyhow::Context;
+
+    #[test]
+    fn test_ended() {
+        assert_eq!(Ended::Exited(3).exit_code(), 3);
+        assert_eq!(Ended::Exited(3).to_string(), "exited with status 3");
+        assert_eq!(signal_name(1234), "signal 1234");
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_ended_by_signal() {
+        use std::os::unix::process::ExitStatusExt;
+
+        let ended = Ended::from(ExitStatus::from_raw(libc::SIGKILL));
+        assert_eq!(ended, Ended::Killed(libc::SIGKILL));
+        assert_eq!(ended.code(), None);
+        assert_eq!(ended.exit_code(), 137);
+        assert_eq!(ended.to_string(), "was killed by SIGKILL");
+        assert_eq!(Ended::from(ExitStatus::from_raw(2 << 8)), Ended::Exited(2));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_parse_signal() {
+        assert_eq!(parse_signal("HUP"), Ok(libc::SIGHUP));
+        assert_eq!(parse_signal("sigusr1"), Ok(libc::SIGUSR1));
+        assert_eq!(parse_signal("15"), Ok(15));
+        assert!(parse_signal("SIGNOPE").is_err());
+    }
+
+    #[test]
+    fn test_code_for() {
+        let not_found: anyhow::Result<()> =
+            Err(SpawnError(io::Error::from(io::ErrorKind::NotFound)))
+                .context("Failed to execute command: nope");
+        assert_eq!(code_for(&not_found.unwrap_err()), NOT_FOUND);
+
+        let denied: anyhow::Result<()> =
+            Err(SpawnError(io::Error::from(io::ErrorKind::PermissionDenied)))
+                .context("Failed to execute command: ./script.sh");
+        assert_eq!(code_for(&denied.unwrap_err()), CANNOT_EXECUTE);
+
+        let config: anyhow::Result<()> =
+            Err(io::Error::from(io::ErrorKind::NotFound)).context("Could not read file");
+        assert_eq!(code_for(&config.unwrap_err()), CONFIG_ERROR);
+    }
+}
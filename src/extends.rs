@@ -0,0 +1,453 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::HashMap,
+    path::{Path, PathBuf},
+};
+
+use crate::{
+    config, env_parser, exec, k8s, mask, permissions, profiles, secrets,
+    settings::{self, Scope},
+    store, trust, usage,
+};
+
+/// Variable that, when defined in an environment file, lists the named
+/// environments it builds upon, separated by commas.
+pub const EXTENDS_VAR: &str = "DOTENV_EXTENDS";
+
+/// Alias of [`EXTENDS_VAR`], for files written with that name in mind.
+pub const INHERIT_VAR: &str = "DOTENV_INHERIT";
+
+/// Parse an environment file along with the environments it extends, in
+/// the global configuration and through `DOTENV_EXTENDS` or
+/// `DOTENV_INHERIT`. Parents are loaded first, in the order they're
+/// listed, so the file's own variables win over theirs. Named environments
+/// are then overlaid with their machine-local files, if any:
+/// `<name>.<hostname>.env` and `<name>.<user>.env`.
+pub fn load(file: &Path) -> Result<HashMap<String, String>> {
+    Ok(load_sourced(file)?
+        .into_iter()
+        .map(|(key, (value, _))| (key, value))
+        .collect())
+}
+
+/// Load the selected environment file, from the current directory or the
+/// dotenv folder, or the selected Kubernetes Secret or ConfigMap, for a
+/// command run somewhere dotenv can't set its variables itself, like
+/// another machine or a container. The settings that only mean something
+/// to dotenv while reading the file are left out, and the values standing
+/// for secrets kept elsewhere are resolved, once the files computing some
+/// with a command are allowed.
+pub fn load_environment(environment: Option<&str>) -> Result<HashMap<String, String>> {
+    let loaded = match profiles::explain(environment)?.kubernetes() {
+        Some(name) => {
+            let loaded = k8s::load(name)?;
+            if let Some(key) = exec::commands(&loaded).first() {
+                anyhow::bail!(
+                    "{} from {} is computed with a command, which only files that are allowed can do",
+                    key,
+                    name
+                );
+            }
+            loaded
+        }
+        None => {
+            let file = profiles::resolve(environment)?.context("No environment file found")?;
+            usage::record(&file);
+            let loaded = load_sourced(&file)?;
+            let mut keys: Vec<&String> = loaded.keys().collect();
+            keys.sort();
+            for key in keys {
+                let (value, file) = &loaded[key];
+                if exec::is_command(value) {
+                    trust::require(file, &format!("computes {} with a command", key))?;
+                }
+            }
+            loaded
+                .into_iter()
+                .map(|(key, (value, _))| (key, value))
+                .collect()
+        }
+    };
+    let mut vars: HashMap<String, String> = loaded
+        .into_iter()
+        .filter(|(key, _)| settings::find(key).is_none_or(|s| s.scope != Scope::File))
+        .collect();
+    secrets::resolve(&mut vars)?;
+    Ok(vars)
+}
+
+/// Like [`load`], but along with each value returns the file it was
+/// finally taken from, after extending and overlaying.
+pub fn load_sourced(file: &Path) -> Result<HashMap<String, (String, PathBuf)>> {
+    load_chain(file, &mut Vec::new())
+}
+
+/// Load a file and its parents, keeping the chain of files being loaded to
+/// detect environments that end up extending themselves.
+fn load_chain(file: &Path, chain: &mut Vec<PathBuf>) -> Result<HashMap<String, (String, PathBuf)>> {
+    // Compare real paths so links to the same file still count as a loop
+    let real = profiles::real_path(file);
+    if chain.iter().any(|f| profiles::real_path(f) == real) {
+        let cycle: Vec<String> = chain
+            .iter()
+            .map(|f| f.as_path())
+            .chain([file])
+            .map(|f| f.display().to_string())
+            .collect();
+        anyhow::bail!(
+            "Environment files extend each other in a loop: {}",
+            cycle.join(" -> ")
+        );
+    }
+
+    permissions::check(file)?;
+    let own = load_marked(file)?;
+
+    let configured = configured_parents(file)?;
+    let mut vars = HashMap::new();
+    chain.push(file.to_path_buf());
+    for parent in configured.iter().map(String::as_str).chain(parents(&own)) {
+        profiles::path_for(parent)?;
+        let path = profiles::locate(parent)?.with_context(|| {
+            format!(
+                "{} extends {:?}, which does not exist in the dotenv folder",
+                file.display(),
+                parent
+            )
+        })?;
+        vars.extend(load_chain(&path, chain)?);
+    }
+    chain.pop();
+
+    vars.extend(sourced(own, file));
+    for overlay in overlays(file) {
+        permissions::check(&overlay)?;
+        vars.extend(sourced(load_marked(&overlay)?, &overlay));
+    }
+    Ok(vars)
+}
+
+/// Parse an environment file, masking from now on the variables it marks
+/// as sensitive.
+fn load_marked(file: &Path) -> Result<HashMap<String, String>> {
+    let parsed = env_parser::load_env_file(file)
+        .with_context(|| format!("Could not parse environment file: {}", file.display()))?;
+    for key in &parsed.sensitive {
+        mask::mark_sensitive(key);
+    }
+    Ok(parsed.vars)
+}
+
+/// Get the names of the environments a file extends, from its variables.
+pub fn parents(vars: &HashMap<String, String>) -> Vec<&str> {
+    [INHERIT_VAR, EXTENDS_VAR]
+        .iter()
+        .filter_map(|var| vars.get(*var))
+        .flat_map(|list| list.split(','))
+        .map(str::trim)
+        .filter(|p| !p.is_empty())
+        .collect()
+}
+
+/// Get the names of the environments the global configuration makes a
+/// named environment extend. Files outside the dotenv folders extend none.
+fn configured_parents(file: &Path) -> Result<Vec<String>> {
+    let name = profiles::folders()
+        .unwrap_or_default()
+        .iter()
+        .find_map(|folder| profiles::name_of(folder, file));
+    let Some(name) = name else {
+        return Ok(Vec::new());
+    };
+    Ok(config::Config::load()?
+        .extends
+        .remove(&name)
+        .unwrap_or_default())
+}
+
+/// Gather the files loading an environment file reads: the file, its
+/// machine-local overlays and, recursively, the files of the environments
+/// it extends.
+pub fn files(file: &Path) -> Result<Vec<PathBuf>> {
+    let mut files = Vec::new();
+    collect(file, &mut files)?;
+    Ok(files)
+}
+
+fn collect(file: &Path, files: &mut Vec<PathBuf>) -> Result<()> {
+    if files.iter().any(|f| f == file) {
+        return Ok(());
+    }
+    files.push(file.to_path_buf());
+    files.extend(overlays(file));
+
+    let vars = env_parser::parse_env_file(file)
+        .with_context(|| format!("Could not parse environment file: {}", file.display()))?;
+    let configured = configured_parents(file)?;
+    for parent in configured.iter().map(String::as_str).chain(parents(&vars)) {
+        if let Some(path) = profiles::locate(parent)? {
+            collect(&path, files)?;
+        }
+    }
+    Ok(())
+}
+
+/// Pair every value with the file it was read from.
+fn sourced(
+    vars: HashMap<String, String>,
+    file: &Path,
+) -> impl Iterator<Item = (String, (String, PathBuf))> + '_ {
+    vars.into_iter()
+        .map(move |(key, value)| (key, (value, file.to_path_buf())))
+}
+
+/// Get the machine-local files overlaid on a named environment, in the
+/// order they're applied: the one for this host and then the one for the
+/// current user. Files outside the dotenv folders have no overlays.
+pub fn overlays(file: &Path) -> Vec<PathBuf> {
+    let in_folder = profiles::folders()
+        .unwrap_or_default()
+        .iter()
+        .any(|folder| profiles::name_of(folder, file).is_some());
+    if !in_folder {
+        return Vec::new();
+    }
+
+    let (Some(dir), Some(stem)) = (file.parent(), file.file_stem().and_then(|s| s.to_str())) else {
+        return Vec::new();
+    };
+
+    [hostname(), username()]
+        .into_iter()
+        .flatten()
+        .map(|suffix| dir.join(format!("{}.{}.env", stem, suffix)))
+        .filter(|overlay| overlay.is_file() || store::holds(overlay))
+        .collect()
+}
+
+/// Get the name of this machine.
+fn hostname() -> Option<String> {
+    #[cfg(unix)]
+    {
+        let mut buf = [0u8; 256];
+        // SAFETY: the buffer is valid for its whole length and gethostname
+        // truncates the name to fit it
+        let res = unsafe { libc::gethostname(buf.as_mut_ptr().cast(), buf.len()) };
+        if res != 0 {
+            return None;
+        }
+        let len = buf.iter().position(|&b| b == 0).unwrap_or(buf.len());
+        String::from_utf8(buf[..len].to_vec())
+            .ok()
+            .filter(|name| !name.is_empty())
+    }
+
+    #[cfg(not(unix))]
+    {
+        std::env::var("COMPUTERNAME")
+            .ok()
+            .filter(|name| !name.is_empty())
+    }
+}
+
+/// Get the name of the current user.
+fn username() -> Option<String> {
+    std::env::var("USER")
+        .or_else(|_| std::env::var("USERNAME"))
+        .ok()
+        .filter(|name| !name.is_empty())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::{env, fs};
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_load_resolves_chain() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(
+            dir.path().join("base.env"),
+            "HOST=localhost\nPORT=5432\nLEVEL=debug\n",
+        )?;
+        fs::write(dir.path().join("aws.env"), "REGION=us-east-1\nLEVEL=info\n")?;
+        fs::write(
+            dir.path().join("staging.env"),
+            "DOTENV_EXTENDS=base, aws\nHOST=staging.internal\n",
+        )?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let vars = load(&dir.path().join("staging.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        let vars = vars?;
+        assert_eq!(vars["HOST"], "staging.internal");
+        assert_eq!(vars["PORT"], "5432");
+        assert_eq!(vars["REGION"], "us-east-1");
+        assert_eq!(vars["LEVEL"], "info");
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_sourced_tracks_files() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("base.env"), "HOST=localhost\nPORT=5432\n")?;
+        fs::write(
+            dir.path().join("staging.env"),
+            "DOTENV_EXTENDS=base\nHOST=staging.internal\n",
+        )?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let vars = load_sourced(&dir.path().join("staging.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        let vars = vars?;
+        assert_eq!(
+            vars["HOST"],
+            (
+                "staging.internal".to_string(),
+                dir.path().join("staging.env")
+            )
+        );
+        assert_eq!(
+            vars["PORT"],
+            ("5432".to_string(), dir.path().join("base.env"))
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_with_inherit_alias() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("base.env"), "HOST=localhost\nPORT=5432\n")?;
+        fs::write(
+            dir.path().join("prod.env"),
+            "DOTENV_INHERIT=base\nHOST=prod\n",
+        )?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let vars = load(&dir.path().join("prod.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        let vars = vars?;
+        assert_eq!(vars["HOST"], "prod");
+        assert_eq!(vars["PORT"], "5432");
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_with_configured_parents() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(
+            dir.path().join(config::CONFIG_FILE),
+            "[extends]\nprod = [\"base\"]\n",
+        )?;
+        fs::write(dir.path().join("base.env"), "HOST=localhost\nPORT=5432\n")?;
+        fs::write(dir.path().join("prod.env"), "HOST=prod\n")?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let vars = load(&dir.path().join("prod.env"));
+        let found = files(&dir.path().join("prod.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        let vars = vars?;
+        assert_eq!(vars["HOST"], "prod");
+        assert_eq!(vars["PORT"], "5432");
+        assert!(found?.contains(&dir.path().join("base.env")));
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_applies_overlays() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("base.env"), "SOCKET=/run/base.sock\n")?;
+        fs::write(
+            dir.path().join("prod.env"),
+            "DOTENV_EXTENDS=base\nHOST=prod\nPATHS=/opt\n",
+        )?;
+        fs::write(
+            dir.path().join("prod.tester.env"),
+            "PATHS=/home/tester/opt\n",
+        )?;
+        if let Some(host) = hostname() {
+            fs::write(
+                dir.path().join(format!("prod.{}.env", host)),
+                "SOCKET=/run/host.sock\nPATHS=/host/opt\n",
+            )?;
+        }
+
+        let user = env::var_os("USER");
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        env::set_var("USER", "tester");
+        let vars = load(&dir.path().join("prod.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+        restore_user(user);
+
+        let vars = vars?;
+        assert_eq!(vars["HOST"], "prod");
+        assert_eq!(vars["PATHS"], "/home/tester/opt");
+        if hostname().is_some() {
+            assert_eq!(vars["SOCKET"], "/run/host.sock");
+        }
+        Ok(())
+    }
+
+    #[test]
+    fn test_overlays_only_for_named_environments() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join(".env"), "FOO=bar\n")?;
+        fs::write(dir.path().join(".env.tester.env"), "FOO=baz\n")?;
+
+        let user = env::var_os("USER");
+        env::set_var("USER", "tester");
+        let found = overlays(&dir.path().join(".env"));
+        restore_user(user);
+
+        assert!(found.is_empty());
+        Ok(())
+    }
+
+    fn restore_user(user: Option<std::ffi::OsString>) {
+        match user {
+            Some(user) => env::set_var("USER", user),
+            None => env::remove_var("USER"),
+        }
+    }
+
+    #[test]
+    fn test_load_detects_loops() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("a.env"), "DOTENV_EXTENDS=b\n")?;
+        fs::write(dir.path().join("b.env"), "DOTENV_EXTENDS=a\n")?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let result = load(&dir.path().join("a.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        let err = result.unwrap_err().to_string();
+        assert!(err.contains("loop"), "unexpected error: {}", err);
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_missing_parent() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("a.env"), "DOTENV_EXTENDS=nope\n")?;
+
+        env::set_var(profiles::FOLDER_PATH_VAR, dir.path());
+        let result = load(&dir.path().join("a.env"));
+        env::remove_var(profiles::FOLDER_PATH_VAR);
+
+        assert!(result.is_err());
+        Ok(())
+    }
+}
@@ -0,0 +1,123 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, env, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret in Google Cloud Secret
+/// Manager, followed by its resource name, like
+/// `gcpsm:projects/p/secrets/name/versions/latest`.
+pub const PREFIX: &str = "gcpsm:";
+
+/// Replace, in place, the values referencing secrets in Secret Manager with
+/// the secrets themselves, read with `gcloud`. Each version is read once,
+/// however many keys reference it, and the versions are read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut references = Vec::new();
+    for (key, value) in vars.iter() {
+        if let Some(reference) = value.strip_prefix(PREFIX) {
+            let version = parse_reference(reference)
+                .with_context(|| format!("Invalid Secret Manager reference in {}", key))?;
+            references.push((key.clone(), version));
+        }
+    }
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let versions = references.iter().map(|(_, version)| version.as_str());
+    let secrets = secrets::fetch_all(versions, read);
+
+    let mut resolved = Vec::new();
+    for (key, version) in &references {
+        match &secrets[version.as_str()] {
+            Ok(secret) => resolved.push((key.clone(), secret.clone())),
+            Err(err) => anyhow::bail!(
+                "Could not read {} from Secret Manager for {}: {:#}",
+                version,
+                key,
+                err
+            ),
+        }
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Check a reference is the resource name of a secret, and give the one of
+/// its version, which is the latest if none is given.
+fn parse_reference(reference: &str) -> Result<String> {
+    let parts: Vec<&str> = reference.split('/').collect();
+    match parts[..] {
+        ["projects", project, "secrets", secret] if !project.is_empty() && !secret.is_empty() => {
+            Ok(format!("{}/versions/latest", reference))
+        }
+        ["projects", project, "secrets", secret, "versions", version]
+            if !project.is_empty() && !secret.is_empty() && !version.is_empty() =>
+        {
+            Ok(reference.to_string())
+        }
+        _ => anyhow::bail!(
+            "Expected a name like {}projects/p/secrets/name/versions/latest, got {}{}",
+            PREFIX,
+            PREFIX,
+            reference
+        ),
+    }
+}
+
+/// Read a version of a secret with `gcloud`, from the resource name of the
+/// version.
+fn read(version: &str) -> Result<String> {
+    let parts: Vec<&str> = version.split('/').collect();
+    let (project, secret, version) = (parts[1], parts[3], parts[5]);
+
+    let mut command = Command::new("gcloud");
+    command
+        .args(["secrets", "versions", "access", version])
+        .arg(format!("--secret={}", secret))
+        .arg(format!("--project={}", project));
+    // gcloud has credentials of its own, so it's pointed to the Application
+    // Default Credentials when they're kept in a file
+    if let Some(credentials) = env::var_os("GOOGLE_APPLICATION_CREDENTIALS") {
+        if env::var_os("CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE").is_none() {
+            command.env("CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE", credentials);
+        }
+    }
+
+    let output = match command.output() {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("gcloud is not installed, see https://cloud.google.com/sdk/docs/install")
+        }
+        Err(err) => return Err(err).context("Failed to run gcloud"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("gcloud failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    String::from_utf8(output.stdout).context("The secret isn't text")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_reference() -> Result<()> {
+        assert_eq!(
+            parse_reference("projects/p/secrets/db-password/versions/3")?,
+            "projects/p/secrets/db-password/versions/3"
+        );
+        assert_eq!(
+            parse_reference("projects/p/secrets/db-password")?,
+            "projects/p/secrets/db-password/versions/latest"
+        );
+        assert!(parse_reference("db-password").is_err());
+        assert!(parse_reference("projects/p/secrets/").is_err());
+        assert!(parse_reference("projects/p/secrets/db-password/versions/").is_err());
+        Ok(())
+    }
+}
@@ -0,0 +1,57 @@
+use anyhow::{Context, Result};
+use std::{
+    io,
+    path::Path,
+    process::{Command, Stdio},
+};
+
+/// Extension of environment files encrypted with GPG, after `.env`.
+pub const EXTENSION: &str = "gpg";
+
+/// Check if an environment file is encrypted with GPG, which is told by its
+/// `.gpg` extension, like `prod.env.gpg`.
+pub fn is_encrypted(file: &Path) -> bool {
+    file.extension().is_some_and(|ext| ext == EXTENSION)
+}
+
+/// Decrypt an environment file encrypted with GPG, straight into memory.
+/// The key comes from gpg-agent, which may ask for its passphrase.
+pub fn decrypt(file: &Path) -> Result<String> {
+    // Only the output is captured, so the passphrase can still be asked on
+    // the terminal, and the reason it failed is shown as is
+    let output = Command::new("gpg")
+        .args(["--quiet", "--decrypt"])
+        .arg(file)
+        .stderr(Stdio::inherit())
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => anyhow::bail!(
+            "{} is encrypted with GPG, which is not installed",
+            file.display()
+        ),
+        Err(err) => return Err(err).context("Failed to run gpg"),
+    };
+
+    if !output.status.success() {
+        anyhow::bail!(
+            "Could not decrypt {}: gpg failed with {}",
+            file.display(),
+            output.status
+        );
+    }
+    String::from_utf8(output.stdout)
+        .with_context(|| format!("{} doesn't decrypt to text", file.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_encrypted() {
+        assert!(is_encrypted(Path::new("/home/me/.dotenv/prod.env.gpg")));
+        assert!(!is_encrypted(Path::new("/home/me/.dotenv/prod.env")));
+        assert!(!is_encrypted(Path::new("gpg")));
+    }
+}
@@ -0,0 +1,72 @@
+use anyhow::{Context, Result};
+use sha2::{Digest, Sha256};
+use std::{
+    collections::BTreeMap,
+    fs,
+    io::Write,
+    path::{Path, PathBuf},
+};
+
+use crate::store;
+
+/// Hash the contents of a file, so a change to it can be told apart.
+pub fn hash_of(file: &Path) -> Result<String> {
+    let content = match store::entry_for(file)? {
+        Some(content) => content.into_bytes(),
+        None => fs::read(file).with_context(|| format!("Could not read {}", file.display()))?,
+    };
+    Ok(format!("{:x}", Sha256::digest(&content)))
+}
+
+/// Read a record of files, like the ones allowed with `dotenv allow`,
+/// mapping their paths to the hash of their contents. A missing or corrupt
+/// record holds nothing.
+pub fn load(record: &Path) -> BTreeMap<PathBuf, String> {
+    let content = fs::read_to_string(record).unwrap_or_default();
+    parse(&content)
+}
+
+/// Write back a record of files, replacing it at once so it's never left
+/// half-written.
+pub fn save(record: &Path, hashes: &BTreeMap<PathBuf, String>) -> Result<()> {
+    let folder = record.parent().unwrap_or_else(|| Path::new("."));
+    let mut scratch = tempfile::Builder::new()
+        .prefix(".hashes-")
+        .tempfile_in(folder)?;
+    for (file, hash) in hashes {
+        writeln!(scratch, "{} {}", hash, file.display())?;
+    }
+    scratch
+        .persist(record)
+        .with_context(|| format!("Could not write {}", record.display()))?;
+    Ok(())
+}
+
+/// Parse `hash path` lines, skipping anything malformed.
+fn parse(content: &str) -> BTreeMap<PathBuf, String> {
+    content
+        .lines()
+        .filter_map(|line| {
+            let (hash, file) = line.split_once(' ')?;
+            let valid = hash.len() == 64 && hash.bytes().all(|b| b.is_ascii_hexdigit());
+            valid.then(|| (PathBuf::from(file), hash.to_string()))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_skips_malformed_lines() {
+        let hash = "a".repeat(64);
+        let hashes = parse(&format!(
+            "{} /home/me/project/.env\ngarbage\nnothex /tmp/.env\n{} /tmp/with space/.env\n",
+            hash, hash
+        ));
+        assert_eq!(hashes.len(), 2);
+        assert_eq!(hashes[Path::new("/home/me/project/.env")], hash);
+        assert_eq!(hashes[Path::new("/tmp/with space/.env")], hash);
+    }
+}
@@ -0,0 +1,139 @@
+use std::{
+    io, mem,
+    os::windows::{io::AsRawHandle, process::CommandExt},
+    process::{Child, Command},
+    ptr,
+    sync::OnceLock,
+};
+use windows_sys::Win32::{
+    Foundation::{CloseHandle, HANDLE, INVALID_HANDLE_VALUE},
+    System::{
+        Diagnostics::ToolHelp::{
+            CreateToolhelp32Snapshot, Thread32First, Thread32Next, TH32CS_SNAPTHREAD, THREADENTRY32,
+        },
+        JobObjects::{
+            AssignProcessToJobObject, CreateJobObjectW, JobObjectExtendedLimitInformation,
+            SetInformationJobObject, JOBOBJECT_EXTENDED_LIMIT_INFORMATION,
+            JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+        },
+        Threading::{
+            OpenThread, ResumeThread, TerminateProcess, CREATE_SUSPENDED, THREAD_SUSPEND_RESUME,
+        },
+    },
+};
+
+/// A job object whose processes are killed once it's closed, which Windows
+/// does when dotenv exits, however it exits.
+struct Job(HANDLE);
+
+// SAFETY: the handle is only used to assign processes to the job, which
+// can be done from any thread
+unsafe impl Send for Job {}
+unsafe impl Sync for Job {}
+
+/// The job the commands are assigned to, created with the first one.
+static JOB: OnceLock<Option<Job>> = OnceLock::new();
+
+/// Create a job killing its processes when closed.
+fn create() -> io::Result<Job> {
+    // SAFETY: the limits are a plain C struct, zeroed meaning no limits,
+    // and the handle is closed if setting them fails
+    unsafe {
+        let handle = CreateJobObjectW(ptr::null(), ptr::null());
+        if handle.is_null() {
+            return Err(io::Error::last_os_error());
+        }
+
+        let mut limits: JOBOBJECT_EXTENDED_LIMIT_INFORMATION = mem::zeroed();
+        limits.BasicLimitInformation.LimitFlags = JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE;
+        let set = SetInformationJobObject(
+            handle,
+            JobObjectExtendedLimitInformation,
+            ptr::addr_of!(limits).cast(),
+            mem::size_of::<JOBOBJECT_EXTENDED_LIMIT_INFORMATION>() as u32,
+        );
+        if set == 0 {
+            let err = io::Error::last_os_error();
+            CloseHandle(handle);
+            return Err(err);
+        }
+        Ok(Job(handle))
+    }
+}
+
+/// Make a command start suspended, so [`assign`] puts it in the job before
+/// it runs, and before any process it starts could be left out of it.
+pub fn suspend(cmd: &mut Command) {
+    cmd.creation_flags(CREATE_SUSPENDED);
+}
+
+/// Tie the lifetime of a child started with [`suspend`] to dotenv's, like
+/// the parent-death signal does on Linux: the child, and the processes it
+/// starts, are killed when dotenv exits. The child is then resumed, and
+/// keeps running unsupervised if it couldn't be tied to dotenv.
+pub fn assign(child: &Child) -> io::Result<()> {
+    let job = JOB.get_or_init(|| match create() {
+        Ok(job) => Some(job),
+        Err(err) => {
+            note!(
+                "dotenv: could not create a job object for the command: {}",
+                err
+            );
+            None
+        }
+    });
+
+    if let Some(job) = job {
+        // SAFETY: both handles are valid, the child's for as long as `child`
+        let assigned = unsafe { AssignProcessToJobObject(job.0, child.as_raw_handle() as HANDLE) };
+        if assigned == 0 {
+            note!(
+                "dotenv: could not tie process {} to dotenv, it may outlive it: {}",
+                child.id(),
+                io::Error::last_os_error()
+            );
+        }
+    }
+
+    if let Err(err) = resume(child.id()) {
+        // SAFETY: the child's handle is valid for as long as `child`
+        unsafe { TerminateProcess(child.as_raw_handle() as HANDLE, 1) };
+        return Err(err);
+    }
+    Ok(())
+}
+
+/// Resume the threads of a suspended process. `Child` doesn't keep the
+/// handle of the main thread, so they're found among the threads running
+/// on the system.
+fn resume(pid: u32) -> io::Result<()> {
+    // SAFETY: the entry is a plain C struct with its size set, as
+    // Thread32First requires, and every handle opened is closed
+    unsafe {
+        let snapshot = CreateToolhelp32Snapshot(TH32CS_SNAPTHREAD, 0);
+        if snapshot == INVALID_HANDLE_VALUE {
+            return Err(io::Error::last_os_error());
+        }
+
+        let mut entry: THREADENTRY32 = mem::zeroed();
+        entry.dwSize = mem::size_of::<THREADENTRY32>() as u32;
+        let mut resumed = false;
+        let mut found = Thread32First(snapshot, &mut entry) != 0;
+        while found {
+            if entry.th32OwnerProcessID == pid {
+                let thread = OpenThread(THREAD_SUSPEND_RESUME, 0, entry.th32ThreadID);
+                if !thread.is_null() {
+                    resumed |= ResumeThread(thread) != u32::MAX;
+                    CloseHandle(thread);
+                }
+            }
+            found = Thread32Next(snapshot, &mut entry) != 0;
+        }
+        CloseHandle(snapshot);
+
+        if !resumed {
+            return Err(io::Error::last_os_error());
+        }
+        Ok(())
+    }
+}
@@ -0,0 +1,165 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::kms;
+
+/// Prefix of the environment names loading the data of a Kubernetes Secret
+/// or ConfigMap, followed by its namespace and name, like
+/// `k8s://production/api`.
+pub const PREFIX: &str = "k8s://";
+
+/// Check if an environment name loads a Secret or ConfigMap.
+pub fn is_reference(name: &str) -> bool {
+    name.starts_with(PREFIX)
+}
+
+/// Load the data of the Secret or ConfigMap an environment name points to,
+/// with `kubectl` and so the current context of the kubeconfig. A name
+/// without a kind, like `k8s://production/api`, is looked for as a Secret
+/// first and then as a ConfigMap, while `k8s://production/configmap/api`
+/// only looks for a ConfigMap.
+pub fn load(reference: &str) -> Result<HashMap<String, String>> {
+    let (namespace, kinds, name) = parse_reference(reference)?;
+
+    for kind in kinds {
+        if let Some(object) = get(kind, namespace, name)? {
+            return data_of(&object)
+                .with_context(|| format!("Could not load the {} {}", kind, reference));
+        }
+    }
+    anyhow::bail!(
+        "There's no {} named {:?} in the namespace {:?}",
+        kinds.join(" or "),
+        name,
+        namespace
+    )
+}
+
+/// Split an environment name into the namespace, the kinds of objects to
+/// look for, in order, and the name of the object.
+fn parse_reference(reference: &str) -> Result<(&str, &'static [&'static str], &str)> {
+    let path = reference.strip_prefix(PREFIX).unwrap_or(reference);
+    let parts: Vec<&str> = path.split('/').collect();
+    let valid = parts.iter().all(|part| !part.is_empty());
+    match parts[..] {
+        [namespace, name] if valid => Ok((namespace, &["secret", "configmap"], name)),
+        [namespace, "secret", name] if valid => Ok((namespace, &["secret"], name)),
+        [namespace, "configmap", name] if valid => Ok((namespace, &["configmap"], name)),
+        _ => anyhow::bail!(
+            "Expected a namespace and a name like {}production/api, optionally with the kind like {}production/configmap/api, got {}",
+            PREFIX,
+            PREFIX,
+            reference
+        ),
+    }
+}
+
+/// Get an object from the cluster as JSON, if it exists.
+fn get(kind: &str, namespace: &str, name: &str) -> Result<Option<serde_json::Value>> {
+    let output = Command::new("kubectl")
+        .args([
+            "get",
+            kind,
+            name,
+            "--namespace",
+            namespace,
+            "--output",
+            "json",
+        ])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("kubectl is not installed, see https://kubernetes.io/docs/tasks/tools/")
+        }
+        Err(err) => return Err(err).context("Failed to run kubectl"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            message if message.contains("(NotFound)") => return Ok(None),
+            "" => anyhow::bail!("kubectl failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    serde_json::from_slice(&output.stdout)
+        .map(Some)
+        .context("kubectl printed invalid JSON")
+}
+
+/// Take the variables from the data of a Secret, kept in base64, or of a
+/// ConfigMap, whose binary data is kept in base64 too.
+fn data_of(object: &serde_json::Value) -> Result<HashMap<String, String>> {
+    let (text, encoded) = match object["kind"].as_str() {
+        Some("Secret") => (None, object["data"].as_object()),
+        Some("ConfigMap") => (object["data"].as_object(), object["binaryData"].as_object()),
+        kind => anyhow::bail!("Expected a Secret or a ConfigMap, got {:?}", kind),
+    };
+
+    let mut vars = HashMap::new();
+    for (key, value) in text.into_iter().flatten() {
+        if let Some(value) = value.as_str() {
+            vars.insert(key.clone(), value.to_string());
+        }
+    }
+    for (key, value) in encoded.into_iter().flatten() {
+        let value = value
+            .as_str()
+            .and_then(kms::decode_base64)
+            .and_then(|bytes| String::from_utf8(bytes).ok())
+            .with_context(|| format!("The value of {} isn't text", key))?;
+        vars.insert(key.clone(), value);
+    }
+    Ok(vars)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_parse_reference() -> Result<()> {
+        assert_eq!(
+            parse_reference("k8s://production/api")?,
+            ("production", &["secret", "configmap"][..], "api")
+        );
+        assert_eq!(
+            parse_reference("k8s://production/configmap/api")?,
+            ("production", &["configmap"][..], "api")
+        );
+        assert_eq!(
+            parse_reference("k8s://production/secret/api")?,
+            ("production", &["secret"][..], "api")
+        );
+        assert!(parse_reference("k8s://api").is_err());
+        assert!(parse_reference("k8s://production/").is_err());
+        assert!(parse_reference("k8s://production/deployment/api").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_data_of() -> Result<()> {
+        let secret = json!({
+            "kind": "Secret",
+            "data": { "DB_PASSWORD": "aHVudGVyMg==", "DB_USER": "YXBw" }
+        });
+        let vars = data_of(&secret)?;
+        assert_eq!(vars["DB_PASSWORD"], "hunter2");
+        assert_eq!(vars["DB_USER"], "app");
+
+        let config_map = json!({
+            "kind": "ConfigMap",
+            "data": { "LOG_LEVEL": "debug" },
+            "binaryData": { "GREETING": "aGk=" }
+        });
+        let vars = data_of(&config_map)?;
+        assert_eq!(vars["LOG_LEVEL"], "debug");
+        assert_eq!(vars["GREETING"], "hi");
+
+        assert!(data_of(&json!({ "kind": "Secret" }))?.is_empty());
+        assert!(data_of(&json!({ "kind": "Pod" })).is_err());
+        assert!(data_of(&json!({ "kind": "Secret", "data": { "KEY": "//8=" } })).is_err());
+        Ok(())
+    }
+}
@@ -0,0 +1,301 @@
+use anyhow::Result;
+use std::collections::HashMap;
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret kept in the keyring of the
+/// operating system with `dotenv secret set`, like `keyring:db-password`.
+pub const PREFIX: &str = "keyring:";
+
+/// Name the secrets are kept under in the keyring, next to their own.
+const SERVICE: &str = "dotenv";
+
+/// Replace, in place, the values referencing secrets in the keyring with
+/// the secrets themselves.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let references: Vec<(String, String)> = vars
+        .iter()
+        .filter_map(|(key, value)| Some((key.clone(), value.strip_prefix(PREFIX)?.to_string())))
+        .collect();
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let names = references.iter().map(|(_, name)| name.as_str());
+    let found = secrets::fetch_all(names, get);
+
+    let mut resolved = Vec::new();
+    for (key, name) in &references {
+        match &found[name.as_str()] {
+            Ok(Some(secret)) => resolved.push((key.clone(), secret.clone())),
+            Ok(None) => anyhow::bail!(
+                "There's no secret {:?} in the keyring, needed by {}, set it with `dotenv secret set {}`",
+                name,
+                key,
+                name
+            ),
+            Err(err) => anyhow::bail!(
+                "Could not read {:?} from the keyring for {}: {:#}",
+                name,
+                key,
+                err
+            ),
+        }
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Read a secret from the keyring, if it's there.
+pub fn get(name: &str) -> Result<Option<String>> {
+    platform::get(name)
+}
+
+/// Keep a secret in the keyring, replacing the one with the same name.
+pub fn set(name: &str, secret: &str) -> Result<()> {
+    if name.is_empty() {
+        anyhow::bail!("The name of a secret can't be empty");
+    }
+    platform::set(name, secret)
+}
+
+/// Remove a secret from the keyring, returning whether it was there.
+pub fn delete(name: &str) -> Result<bool> {
+    platform::delete(name)
+}
+
+/// The macOS Keychain, through the `security` command.
+#[cfg(target_os = "macos")]
+mod platform {
+    use super::SERVICE;
+    use anyhow::{Context, Result};
+    use std::process::{Command, Output};
+
+    /// Exit code of `security` when there's no such item.
+    const NOT_FOUND: i32 = 44;
+
+    pub fn get(name: &str) -> Result<Option<String>> {
+        let output = security(&["find-generic-password", "-s", SERVICE, "-a", name, "-w"])?;
+        if output.status.code() == Some(NOT_FOUND) {
+            return Ok(None);
+        }
+        let output = succeeded(output)?;
+        let secret = String::from_utf8(output.stdout).context("The secret isn't text")?;
+        // The secret is printed with a line break
+        Ok(Some(
+            secret.strip_suffix('\n').unwrap_or(&secret).to_string(),
+        ))
+    }
+
+    pub fn set(name: &str, secret: &str) -> Result<()> {
+        // security only takes the secret as an argument, as its prompt reads
+        // from the terminal rather than from its input
+        let output = security(&[
+            "add-generic-password",
+            "-U",
+            "-s",
+            SERVICE,
+            "-a",
+            name,
+            "-w",
+            secret,
+        ])?;
+        succeeded(output).map(|_| ())
+    }
+
+    pub fn delete(name: &str) -> Result<bool> {
+        let output = security(&["delete-generic-password", "-s", SERVICE, "-a", name])?;
+        if output.status.code() == Some(NOT_FOUND) {
+            return Ok(false);
+        }
+        succeeded(output).map(|_| true)
+    }
+
+    fn security(args: &[&str]) -> Result<Output> {
+        Command::new("security")
+            .args(args)
+            .output()
+            .context("Failed to run security")
+    }
+
+    fn succeeded(output: Output) -> Result<Output> {
+        if !output.status.success() {
+            let message = String::from_utf8_lossy(&output.stderr);
+            match message.trim() {
+                "" => anyhow::bail!("security failed with {}", output.status),
+                message => anyhow::bail!("{}", message),
+            }
+        }
+        Ok(output)
+    }
+}
+
+/// The Secret Service, like GNOME Keyring or KWallet, through the
+/// `secret-tool` command from libsecret.
+#[cfg(all(unix, not(target_os = "macos")))]
+mod platform {
+    use super::SERVICE;
+    use anyhow::{Context, Result};
+    use std::{
+        io::{self, Write},
+        process::{Command, Output, Stdio},
+    };
+
+    pub fn get(name: &str) -> Result<Option<String>> {
+        let output = secret_tool(&["lookup", "service", SERVICE, "account", name], None)?;
+        // There's no secret when secret-tool fails without saying why
+        if !output.status.success() && output.stderr.is_empty() {
+            return Ok(None);
+        }
+        let output = succeeded(output)?;
+        String::from_utf8(output.stdout)
+            .map(Some)
+            .context("The secret isn't text")
+    }
+
+    pub fn set(name: &str, secret: &str) -> Result<()> {
+        let label = format!("{} {}", SERVICE, name);
+        let output = secret_tool(
+            &[
+                "store",
+                &format!("--label={}", label),
+                "service",
+                SERVICE,
+                "account",
+                name,
+            ],
+            Some(secret),
+        )?;
+        succeeded(output).map(|_| ())
+    }
+
+    pub fn delete(name: &str) -> Result<bool> {
+        // Clearing succeeds whether there was a secret or not
+        if get(name)?.is_none() {
+            return Ok(false);
+        }
+        let output = secret_tool(&["clear", "service", SERVICE, "account", name], None)?;
+        succeeded(output).map(|_| true)
+    }
+
+    /// Run `secret-tool`, giving it the secret on its input rather than as
+    /// an argument, which other users could see.
+    fn secret_tool(args: &[&str], secret: Option<&str>) -> Result<Output> {
+        let child = Command::new("secret-tool")
+            .args(args)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn();
+        let mut child = match child {
+            Ok(child) => child,
+            Err(err) if err.kind() == io::ErrorKind::NotFound => anyhow::bail!(
+                "secret-tool is not installed, it comes with libsecret (libsecret-tools on Debian and Ubuntu)"
+            ),
+            Err(err) => return Err(err).context("Failed to run secret-tool"),
+        };
+        // The input is closed right away when there's no secret to give
+        if let (Some(mut stdin), Some(secret)) = (child.stdin.take(), secret) {
+            stdin
+                .write_all(secret.as_bytes())
+                .context("Failed to give the secret to secret-tool")?;
+        }
+        child
+            .wait_with_output()
+            .context("Failed to run secret-tool")
+    }
+
+    fn succeeded(output: Output) -> Result<Output> {
+        if !output.status.success() {
+            let message = String::from_utf8_lossy(&output.stderr);
+            match message.trim() {
+                "" => anyhow::bail!("secret-tool failed with {}", output.status),
+                message => anyhow::bail!("{}", message),
+            }
+        }
+        Ok(output)
+    }
+}
+
+/// The Windows Credential Manager, keeping the secrets as generic
+/// credentials named `dotenv:<name>`.
+#[cfg(windows)]
+mod platform {
+    use super::SERVICE;
+    use anyhow::{Context, Result};
+    use std::{io, mem, ptr};
+    use windows_sys::Win32::{
+        Foundation::ERROR_NOT_FOUND,
+        Security::Credentials::{
+            CredDeleteW, CredFree, CredReadW, CredWriteW, CREDENTIALW, CRED_PERSIST_LOCAL_MACHINE,
+            CRED_TYPE_GENERIC,
+        },
+    };
+
+    pub fn get(name: &str) -> Result<Option<String>> {
+        let target = target(name);
+        let mut credential: *mut CREDENTIALW = ptr::null_mut();
+        // SAFETY: the target is a NUL-terminated wide string, and the
+        // credential is only read when found, then freed
+        let secret = unsafe {
+            if CredReadW(target.as_ptr(), CRED_TYPE_GENERIC, 0, &mut credential) == 0 {
+                return match io::Error::last_os_error() {
+                    err if err.raw_os_error() == Some(ERROR_NOT_FOUND as i32) => Ok(None),
+                    err => Err(err).context("Failed to read from the Credential Manager"),
+                };
+            }
+            let blob = std::slice::from_raw_parts(
+                (*credential).CredentialBlob,
+                (*credential).CredentialBlobSize as usize,
+            )
+            .to_vec();
+            CredFree(credential.cast_const().cast());
+            blob
+        };
+        String::from_utf8(secret)
+            .map(Some)
+            .context("The secret isn't text")
+    }
+
+    pub fn set(name: &str, secret: &str) -> Result<()> {
+        let mut target = target(name);
+        let mut user = wide(name);
+        let mut blob = secret.as_bytes().to_vec();
+        // SAFETY: the credential is a plain C struct, zeroed meaning no
+        // flags nor attributes, pointing to buffers living until it's written
+        unsafe {
+            let mut credential: CREDENTIALW = mem::zeroed();
+            credential.Type = CRED_TYPE_GENERIC;
+            credential.TargetName = target.as_mut_ptr();
+            credential.UserName = user.as_mut_ptr();
+            credential.CredentialBlob = blob.as_mut_ptr();
+            credential.CredentialBlobSize = blob.len() as u32;
+            credential.Persist = CRED_PERSIST_LOCAL_MACHINE;
+            if CredWriteW(&credential, 0) == 0 {
+                return Err(io::Error::last_os_error())
+                    .context("Failed to write to the Credential Manager");
+            }
+        }
+        Ok(())
+    }
+
+    pub fn delete(name: &str) -> Result<bool> {
+        let target = target(name);
+        // SAFETY: the target is a NUL-terminated wide string
+        if unsafe { CredDeleteW(target.as_ptr(), CRED_TYPE_GENERIC, 0) } == 0 {
+            return match io::Error::last_os_error() {
+                err if err.raw_os_error() == Some(ERROR_NOT_FOUND as i32) => Ok(false),
+                err => Err(err).context("Failed to delete from the Credential Manager"),
+            };
+        }
+        Ok(true)
+    }
+
+    fn target(name: &str) -> Vec<u16> {
+        wide(&format!("{}:{}", SERVICE, name))
+    }
+
+    fn wide(text: &str) -> Vec<u16> {
+        text.encode_utf16().chain(Some(0)).collect()
+    }
+}
@@ -0,0 +1,108 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+/// Prefix of the values encrypted with AWS KMS, followed by the ciphertext
+/// in base64, like `kms:AQICAH...`.
+pub const PREFIX: &str = "kms:";
+
+/// Decrypt, in place, the values encrypted with AWS KMS, using the AWS
+/// credentials dotenv runs with.
+pub fn decrypt_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    for (key, value) in vars.iter_mut() {
+        if let Some(ciphertext) = value.strip_prefix(PREFIX) {
+            *value = decrypt(ciphertext)
+                .with_context(|| format!("Could not decrypt {} with AWS KMS", key))?;
+        }
+    }
+    Ok(())
+}
+
+/// Decrypt a ciphertext given in base64 with the AWS CLI. The key doesn't
+/// need to be given, as KMS records it in the ciphertext.
+fn decrypt(ciphertext: &str) -> Result<String> {
+    let ciphertext = ciphertext.trim();
+    if decode_base64(ciphertext).is_none_or(|bytes| bytes.is_empty()) {
+        anyhow::bail!("The value after {:?} isn't a ciphertext in base64", PREFIX);
+    }
+
+    let output = Command::new("aws")
+        .args(["kms", "decrypt", "--ciphertext-blob", ciphertext])
+        .args(["--cli-binary-format", "base64"])
+        .args(["--query", "Plaintext", "--output", "text"])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("The AWS CLI is not installed, see https://aws.amazon.com/cli/")
+        }
+        Err(err) => return Err(err).context("Failed to run aws"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("aws failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+
+    // The plaintext is printed in base64 too
+    let printed = String::from_utf8_lossy(&output.stdout);
+    let plaintext =
+        decode_base64(printed.trim()).context("The AWS CLI printed an unexpected plaintext")?;
+    String::from_utf8(plaintext).context("The value doesn't decrypt to text")
+}
+
+/// Decode standard base64, with or without padding.
+pub fn decode_base64(text: &str) -> Option<Vec<u8>> {
+    let mut bytes = Vec::with_capacity(text.len() * 3 / 4);
+    let mut buffer = 0u32;
+    let mut bits = 0;
+    for c in text.trim_end_matches('=').bytes() {
+        let value = match c {
+            b'A'..=b'Z' => c - b'A',
+            b'a'..=b'z' => c - b'a' + 26,
+            b'0'..=b'9' => c - b'0' + 52,
+            b'+' => 62,
+            b'/' => 63,
+            _ => return None,
+        };
+        buffer = (buffer << 6) | value as u32;
+        bits += 6;
+        if bits >= 8 {
+            bits -= 8;
+            bytes.push((buffer >> bits) as u8);
+        }
+    }
+    // A single character left over can't hold a whole byte
+    if bits >= 6 {
+        return None;
+    }
+    Some(bytes)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_decode_base64() {
+        assert_eq!(decode_base64("aHVudGVyMg=="), Some(b"hunter2".to_vec()));
+        assert_eq!(decode_base64("aHVudGVyMg"), Some(b"hunter2".to_vec()));
+        assert_eq!(decode_base64("YWJj"), Some(b"abc".to_vec()));
+        assert_eq!(decode_base64("/+8="), Some(vec![0xff, 0xef]));
+        assert_eq!(decode_base64(""), Some(Vec::new()));
+        assert_eq!(decode_base64("not base64!"), None);
+        assert_eq!(decode_base64("YWJjZ"), None);
+    }
+
+    #[test]
+    fn test_decrypt_values_rejects_invalid_ciphertexts() {
+        let mut vars = HashMap::from([("DB_PASSWORD".to_string(), "kms:not base64".to_string())]);
+        let err = decrypt_values(&mut vars).unwrap_err();
+        assert!(format!("{:#}", err).contains("DB_PASSWORD"));
+
+        let mut plain = HashMap::from([("DB_HOST".to_string(), "localhost".to_string())]);
+        assert!(decrypt_values(&mut plain).is_ok());
+        assert_eq!(plain["DB_HOST"], "localhost");
+    }
+}
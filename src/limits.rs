@@ -0,0 +1,246 @@
+use anyhow::Result;
+use std::process::Command;
+
+/// Resource limits that can be set with `--rlimit`, by name.
+#[cfg(unix)]
+const RESOURCES: &[(&str, Resource)] = &[
+    ("as", libc::RLIMIT_AS as Resource),
+    ("core", libc::RLIMIT_CORE as Resource),
+    ("cpu", libc::RLIMIT_CPU as Resource),
+    ("data", libc::RLIMIT_DATA as Resource),
+    ("fsize", libc::RLIMIT_FSIZE as Resource),
+    ("memlock", libc::RLIMIT_MEMLOCK as Resource),
+    ("nofile", libc::RLIMIT_NOFILE as Resource),
+    ("nproc", libc::RLIMIT_NPROC as Resource),
+    ("stack", libc::RLIMIT_STACK as Resource),
+];
+
+/// The type setrlimit takes resources as, which depends on the platform.
+#[cfg(all(unix, target_os = "linux", target_env = "gnu"))]
+type Resource = libc::__rlimit_resource_t;
+#[cfg(all(unix, not(all(target_os = "linux", target_env = "gnu"))))]
+type Resource = libc::c_int;
+
+/// A resource limit given with `--rlimit`, like `nofile=4096`.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Rlimit {
+    #[cfg(unix)]
+    resource: Resource,
+    soft: Option<u64>,
+    hard: Option<u64>,
+}
+
+/// Parse a resource limit like `nofile=4096`, `core=unlimited` or
+/// `nofile=1024:4096`, the soft limit coming before the hard one. A single
+/// value sets both, like `ulimit` does.
+#[cfg(unix)]
+pub fn parse_rlimit(value: &str) -> Result<Rlimit, String> {
+    let (name, limits) = value.split_once('=').ok_or_else(|| {
+        format!(
+            "invalid limit {:?}, expected something like nofile=4096",
+            value
+        )
+    })?;
+    let resource = RESOURCES
+        .iter()
+        .find(|(known, _)| known.eq_ignore_ascii_case(name.trim()))
+        .map(|(_, resource)| *resource)
+        .ok_or_else(|| {
+            let names: Vec<&str> = RESOURCES.iter().map(|(name, _)| *name).collect();
+            format!(
+                "unknown resource {:?}, expected one of: {}",
+                name,
+                names.join(", ")
+            )
+        })?;
+
+    let amount = |amount: &str| match amount.trim() {
+        "unlimited" => Ok(None),
+        amount => amount.parse().map(Some).map_err(|_| {
+            format!(
+                "invalid limit {:?} in {:?}, expected a number or unlimited",
+                amount, value
+            )
+        }),
+    };
+    let (soft, hard) = match limits.split_once(':') {
+        Some((soft, hard)) => (amount(soft)?, amount(hard)?),
+        None => (amount(limits)?, amount(limits)?),
+    };
+    let above = match (soft, hard) {
+        (Some(soft), Some(hard)) => soft > hard,
+        (None, Some(_)) => true,
+        _ => false,
+    };
+    if above {
+        return Err(format!(
+            "the soft limit in {:?} is above the hard one",
+            value
+        ));
+    }
+
+    Ok(Rlimit {
+        resource,
+        soft,
+        hard,
+    })
+}
+
+#[cfg(not(unix))]
+pub fn parse_rlimit(_value: &str) -> Result<Rlimit, String> {
+    Err("resource limits are only supported on Unix".to_string())
+}
+
+/// Parse a umask given in octal, like `027`.
+pub fn parse_umask(value: &str) -> Result<u32, String> {
+    match u32::from_str_radix(value.trim(), 8) {
+        Ok(mask) if mask <= 0o777 => Ok(mask),
+        _ => Err(format!(
+            "invalid umask {:?}, expected an octal mode like 027",
+            value
+        )),
+    }
+}
+
+/// The resources the commands dotenv runs can use.
+#[derive(Debug, Default)]
+pub struct Limits {
+    pub rlimits: Vec<Rlimit>,
+    /// Scheduling priority, from -20 to 19.
+    pub nice: Option<i32>,
+    /// How likely the command is to be picked by the out-of-memory killer,
+    /// from -1000 to 1000 (Linux only).
+    pub oom_score_adj: Option<i32>,
+    /// The permission bits removed from the files the command creates.
+    pub umask: Option<u32>,
+}
+
+impl Limits {
+    /// Check that the limits can be set on this platform.
+    pub fn check(&self) -> Result<()> {
+        if !cfg!(unix) && (self.nice.is_some() || !self.rlimits.is_empty()) {
+            anyhow::bail!("--rlimit and --nice are only supported on Unix");
+        }
+        if !cfg!(unix) && self.umask.is_some() {
+            anyhow::bail!("--umask is only supported on Unix");
+        }
+        if !cfg!(target_os = "linux") && self.oom_score_adj.is_some() {
+            anyhow::bail!("--oom-score-adj is only supported on Linux");
+        }
+        Ok(())
+    }
+
+    /// Set the limits in the command's process before it runs. Call before
+    /// dropping privileges, which raising limits may need.
+    #[cfg(unix)]
+    pub fn apply(&self, cmd: &mut Command) {
+        use std::io::Error;
+        use std::os::unix::process::CommandExt;
+
+        if self.rlimits.is_empty()
+            && self.nice.is_none()
+            && self.oom_score_adj.is_none()
+            && self.umask.is_none()
+        {
+            return;
+        }
+
+        // Formatted beforehand, as allocating isn't safe in the child
+        let oom_score_adj = self.oom_score_adj.map(|adj| format!("{}\n", adj));
+        let rlimits = self.rlimits.clone();
+        let nice = self.nice;
+        let umask = self.umask;
+
+        // SAFETY: the closure runs in the child before exec and only calls
+        // async-signal-safe functions
+        unsafe {
+            cmd.pre_exec(move || {
+                for rlimit in &rlimits {
+                    let limit = libc::rlimit {
+                        rlim_cur: rlimit
+                            .soft
+                            .map_or(libc::RLIM_INFINITY, |v| v as libc::rlim_t),
+                        rlim_max: rlimit
+                            .hard
+                            .map_or(libc::RLIM_INFINITY, |v| v as libc::rlim_t),
+                    };
+                    if libc::setrlimit(rlimit.resource, &limit) != 0 {
+                        return Err(Error::last_os_error());
+                    }
+                }
+
+                if let Some(nice) = nice {
+                    if libc::setpriority(libc::PRIO_PROCESS, 0, nice) != 0 {
+                        return Err(Error::last_os_error());
+                    }
+                }
+
+                if let Some(umask) = umask {
+                    libc::umask(umask as libc::mode_t);
+                }
+
+                if let Some(adj) = &oom_score_adj {
+                    let path = b"/proc/self/oom_score_adj\0";
+                    let fd = libc::open(path.as_ptr().cast(), libc::O_WRONLY);
+                    if fd == -1 {
+                        return Err(Error::last_os_error());
+                    }
+                    let written = libc::write(fd, adj.as_ptr().cast(), adj.len());
+                    libc::close(fd);
+                    if written == -1 {
+                        return Err(Error::last_os_error());
+                    }
+                }
+
+                Ok(())
+            });
+        }
+    }
+
+    #[cfg(not(unix))]
+    pub fn apply(&self, _cmd: &mut Command) {}
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_rlimit() {
+        assert_eq!(
+            parse_rlimit("nofile=4096"),
+            Ok(Rlimit {
+                resource: libc::RLIMIT_NOFILE as Resource,
+                soft: Some(4096),
+                hard: Some(4096)
+            })
+        );
+        assert_eq!(
+            parse_rlimit("NOFILE=1024:4096").map(|l| (l.soft, l.hard)),
+            Ok((Some(1024), Some(4096)))
+        );
+        assert_eq!(
+            parse_rlimit("core=unlimited").map(|l| (l.soft, l.hard)),
+            Ok((None, None))
+        );
+        assert_eq!(
+            parse_rlimit("core=0:unlimited").map(|l| (l.soft, l.hard)),
+            Ok((Some(0), None))
+        );
+        assert!(parse_rlimit("nofile").is_err());
+        assert!(parse_rlimit("files=10").is_err());
+        assert!(parse_rlimit("nofile=many").is_err());
+        assert!(parse_rlimit("nofile=4096:1024").is_err());
+        assert!(parse_rlimit("nofile=unlimited:1024").is_err());
+    }
+
+    #[test]
+    fn test_parse_umask() {
+        assert_eq!(parse_umask("027"), Ok(0o027));
+        assert_eq!(parse_umask("0022"), Ok(0o022));
+        assert_eq!(parse_umask("777"), Ok(0o777));
+        assert!(parse_umask("1000").is_err());
+        assert!(parse_umask("089").is_err());
+        assert!(parse_umask("").is_err());
+    }
+}
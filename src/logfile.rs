@@ -0,0 +1,212 @@
+use anyhow::{Context, Result};
+use std::{
+    fs::{self, File, OpenOptions},
+    io::{self, Write},
+    path::{Path, PathBuf},
+    sync::Mutex,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+/// A file the command's output is copied to, one timestamped line at a
+/// time, rotated once it grows too large.
+pub struct LogFile {
+    path: PathBuf,
+    file: File,
+    size: u64,
+    max_size: Option<u64>,
+    keep: u32,
+}
+
+impl LogFile {
+    /// Open a log file for appending, creating it if needed. Once it grows
+    /// past `max_size` bytes, it's renamed to `<path>.1`, the previous
+    /// `<path>.1` to `<path>.2` and so on, keeping `keep` of them.
+    pub fn open(path: &Path, max_size: Option<u64>, keep: u32) -> Result<Self> {
+        let file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(path)
+            .with_context(|| format!("Could not open log file: {}", path.display()))?;
+        let size = file.metadata().map(|m| m.len()).unwrap_or(0);
+
+        Ok(Self {
+            path: path.to_path_buf(),
+            file,
+            size,
+            max_size,
+            keep,
+        })
+    }
+
+    /// Append a line written by the command to one of its streams.
+    fn write_line(&mut self, stream: &str, line: &[u8]) -> io::Result<()> {
+        let mut entry = format!("{} [{}] ", timestamp(SystemTime::now()), stream).into_bytes();
+        entry.extend_from_slice(line);
+        if !entry.ends_with(b"\n") {
+            entry.push(b'\n');
+        }
+
+        let len = entry.len() as u64;
+        if self
+            .max_size
+            .is_some_and(|max| self.size > 0 && self.size + len > max)
+        {
+            self.rotate()?;
+        }
+        self.file.write_all(&entry)?;
+        self.size += len;
+        Ok(())
+    }
+
+    /// Move the current file out of the way, shifting the older ones, and
+    /// start a new one.
+    fn rotate(&mut self) -> io::Result<()> {
+        if self.keep == 0 {
+            self.file.set_len(0)?;
+        } else {
+            let _ = fs::remove_file(self.rotated(self.keep));
+            for index in (1..self.keep).rev() {
+                let _ = fs::rename(self.rotated(index), self.rotated(index + 1));
+            }
+            fs::rename(&self.path, self.rotated(1))?;
+            self.file = OpenOptions::new()
+                .create(true)
+                .append(true)
+                .open(&self.path)?;
+        }
+        self.size = 0;
+        Ok(())
+    }
+
+    /// Get the path of the `index`th rotated file.
+    fn rotated(&self, index: u32) -> PathBuf {
+        let mut path = self.path.clone().into_os_string();
+        path.push(format!(".{}", index));
+        PathBuf::from(path)
+    }
+}
+
+/// Write a line to a shared log file, reporting any failure, and returning
+/// whether it worked.
+pub fn record(log: &Mutex<LogFile>, stream: &str, line: &[u8]) -> bool {
+    let Ok(mut log) = log.lock() else {
+        return false;
+    };
+    match log.write_line(stream, line) {
+        Ok(()) => true,
+        Err(err) => {
+            note!(
+                "dotenv: could not write to log file {}: {}",
+                log.path.display(),
+                err
+            );
+            false
+        }
+    }
+}
+
+/// Parse a size like `10M`, `512K` or `1G`, in bytes. A plain number is
+/// taken as bytes.
+pub fn parse_size(value: &str) -> Result<u64, String> {
+    let value = value.trim();
+    let split = value
+        .find(|c: char| !c.is_ascii_digit())
+        .unwrap_or(value.len());
+    let (number, unit) = value.split_at(split);
+
+    let number: u64 = number
+        .parse()
+        .map_err(|_| format!("invalid size {:?}, expected something like 10M", value))?;
+    let multiplier: u64 = match unit.to_ascii_uppercase().as_str() {
+        "" | "B" => 1,
+        "K" | "KB" => 1 << 10,
+        "M" | "MB" => 1 << 20,
+        "G" | "GB" => 1 << 30,
+        _ => {
+            return Err(format!(
+                "invalid unit {:?} in {:?}, expected K, M or G",
+                unit, value
+            ))
+        }
+    };
+
+    match number.checked_mul(multiplier) {
+        Some(0) | None => Err(format!("invalid size {:?}", value)),
+        Some(size) => Ok(size),
+    }
+}
+
+/// Format a time as an RFC 3339 timestamp in UTC with milliseconds, like
+/// `2024-05-01T12:30:00.250Z`.
+fn timestamp(time: SystemTime) -> String {
+    let since_epoch = time.duration_since(UNIX_EPOCH).unwrap_or_default();
+    let secs = since_epoch.as_secs();
+    let (days, secs_of_day) = (secs / 86400, secs % 86400);
+
+    // Convert days since the epoch to a date in the proleptic Gregorian
+    // calendar, from Howard Hinnant's civil_from_days
+    let z = days as i64 + 719468;
+    let era = z.div_euclid(146097);
+    let doe = z - era * 146097;
+    let yoe = (doe - doe / 1460 + doe / 36524 - doe / 146096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + i64::from(month <= 2);
+
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}.{:03}Z",
+        year,
+        month,
+        day,
+        secs_of_day / 3600,
+        secs_of_day % 3600 / 60,
+        secs_of_day % 60,
+        since_epoch.subsec_millis()
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_timestamp() {
+        assert_eq!(timestamp(UNIX_EPOCH), "1970-01-01T00:00:00.000Z");
+        assert_eq!(
+            timestamp(UNIX_EPOCH + Duration::from_millis(1_709_210_096_250)),
+            "2024-02-29T12:34:56.250Z"
+        );
+    }
+
+    #[test]
+    fn test_parse_size() {
+        assert_eq!(parse_size("100"), Ok(100));
+        assert_eq!(parse_size("512K"), Ok(512 * 1024));
+        assert_eq!(parse_size("10mb"), Ok(10 * 1024 * 1024));
+        assert_eq!(parse_size("1G"), Ok(1 << 30));
+        assert!(parse_size("0").is_err());
+        assert!(parse_size("10T").is_err());
+        assert!(parse_size("M").is_err());
+    }
+
+    #[test]
+    fn test_rotation_keeps_older_files() -> Result<()> {
+        let dir = tempdir()?;
+        let path = dir.path().join("app.log");
+        let mut log = LogFile::open(&path, Some(40), 2)?;
+        for line in ["first\n", "second\n", "third\n", "fourth\n"] {
+            log.write_line("stdout", line.as_bytes())?;
+        }
+
+        let read = |path: PathBuf| -> Result<String> { Ok(fs::read_to_string(path)?) };
+        assert!(read(path.clone())?.ends_with("[stdout] fourth\n"));
+        assert!(read(log.rotated(1))?.ends_with("[stdout] third\n"));
+        assert!(read(log.rotated(2))?.ends_with("[stdout] second\n"));
+        assert!(!log.rotated(3).exists());
+        Ok(())
+    }
+}
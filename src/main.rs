@@ -1,121 +1,1798 @@
 use anyhow::{Context, Result};
-use clap::Parser;
-use std::{collections::HashMap, env, path::PathBuf, process::Command};
+use clap::{Args, CommandFactory, Parser, Subcommand, ValueEnum};
+use std::{
+    collections::{BTreeMap, HashMap},
+    env,
+    ffi::OsString,
+    fmt,
+    io::{self, IsTerminal, Read},
+    path::{Path, PathBuf},
+    process::{Command, ExitStatus, Stdio},
+    sync::{Arc, Mutex},
+    time::Duration,
+};
 
+#[macro_use]
+mod output;
+
+mod age;
+mod akv;
+mod aws_sm;
+mod bw;
+mod commands;
+mod config;
+mod duration;
+mod env_document;
 mod env_parser;
+mod exec;
+mod exit;
+mod extends;
+mod gcpsm;
+mod gpg;
+mod hashes;
+#[cfg(windows)]
+mod job;
+mod k8s;
+mod keyring;
+mod kms;
+mod limits;
+mod logfile;
+mod markers;
+mod mask;
+mod op;
+mod permissions;
+mod pin;
+mod plugin;
+mod profiles;
+mod project;
+mod prompt;
+mod pty;
+mod retry;
+mod schema;
+mod secrets;
+mod secrets_dir;
+mod settings;
+mod signals;
+mod sops;
+mod ssm;
+mod store;
+mod streams;
+mod table;
+mod totp;
+mod trust;
+mod usage;
+mod user;
+mod vault;
+mod watch;
+
+/// Environment variable that enables no-override mode, like
+/// `--no-override` does.
+const NO_OVERRIDE_VAR: &str = "DOTENV_NO_OVERRIDE";
+
+/// Variable that, when defined in an environment file, lists extra
+/// variables to keep from your shell in strict mode, separated by commas.
+const KEEP_VAR: &str = "DOTENV_KEEP";
+
+/// Variable that, when defined in an environment file, lists variables to
+/// remove from your shell's environment, separated by commas.
+const UNSET_VAR: &str = "DOTENV_UNSET";
+
+/// Environment variable that, when truthy, logs where each variable
+/// injected into the command came from.
+const DEBUG_VAR: &str = "DOTENV_DEBUG";
+
+/// Variable that, when defined in an environment file, lists variables
+/// that must be set and not empty for the command to run, separated by
+/// commas.
+const REQUIRED_VAR: &str = "DOTENV_REQUIRED";
+
+/// Variable that, when truthy in an environment file, silences the
+/// warnings about its variables replacing the ones set in your shell.
+const ALLOW_SHADOWING_VAR: &str = "DOTENV_ALLOW_SHADOWING";
+
+/// Variable that, when defined in an environment file, holds a script run
+/// through the shell before the command, which doesn't run if it fails.
+const PRE_COMMAND_VAR: &str = "DOTENV_PRE_COMMAND";
+
+/// Variable that, when defined in an environment file, holds a script run
+/// through the shell once the command exits.
+const POST_COMMAND_VAR: &str = "DOTENV_POST_COMMAND";
+
+/// Variable that, when defined in an environment file, holds a script run
+/// through the shell when the command exits with a non-zero status.
+const ON_FAILURE_VAR: &str = "DOTENV_ON_FAILURE";
+
+/// Variable set for the on-failure script to the command's exit status.
+const EXIT_CODE_VAR: &str = "DOTENV_EXIT_CODE";
+
+/// Variable set for the on-failure script to the command that failed.
+const FAILED_COMMAND_VAR: &str = "DOTENV_FAILED_COMMAND";
+
+/// Environment variable choosing what happens when dotenv runs under
+/// another dotenv, like `--nested` does.
+const NESTED_VAR: &str = "DOTENV_NESTED";
+
+/// Environment variable naming the shell that runs the scripts given with
+/// `--shell`, instead of `$SHELL`.
+const SHELL_VAR: &str = "DOTENV_SHELL";
+
+/// Environment variable with the umask to run the command with, when
+/// --umask is not given.
+const UMASK_VAR: &str = "DOTENV_UMASK";
 
 static STRICT_WHITELIST: &[&str] = &[
     "PATH", "HOME", "SHELL", "USER", "SHLVL", "LANG", "TERM", "LOGNAME", "PWD", "OLDPWD", "EDITOR",
     "VISUAL", "DISPLAY", "HOSTNAME",
 ];
 
+/// The minimal set of variables kept by `--strict=sane`.
+static SANE_WHITELIST: &[&str] = &["PATH", "HOME", "TMPDIR", "LANG", "TERM", "USER"];
+
 #[derive(Parser, Debug)]
 #[command(
     name = "dotenv",
+    version,
     author = "Patrick D'appollonio <hey@patrickdap.com>",
-    about = "Dynamically inject just the environment variables you allow to the command you're about to execute."
+    about = "Dynamically inject just the environment variables you allow to the command you're about to execute.",
+    args_conflicts_with_subcommands = true
 )]
 struct Cli {
+    #[command(subcommand)]
+    subcommand: Option<Commands>,
+
+    #[command(flatten)]
+    options: RunOptions,
+
+    /// Quiet mode: don't print dotenv's own messages, only the output of the command
+    #[arg(short, long, global = true)]
+    quiet: bool,
+
+    /// Secure mode: refuse to load environment files other users can access or that are owned by someone else, instead of warning
+    #[arg(long, global = true)]
+    secure: bool,
+
+    /// Show sensitive values in debug output and error messages instead of masking them
+    #[arg(long, global = true)]
+    reveal_secrets: bool,
+
+    /// The command and arguments to run (e.g. `python main.py`)
+    command: Vec<String>,
+}
+
+/// Which variables from your shell strict mode keeps.
+#[derive(ValueEnum, Clone, Copy, Debug, PartialEq)]
+enum Strictness {
+    /// Keep the standard whitelist, with variables like PATH, HOME, SHELL, EDITOR or DISPLAY
+    Standard,
+    /// Keep only PATH, HOME, TMPDIR, LANG, TERM and USER
+    Sane,
+}
+
+impl Strictness {
+    /// Get the variables kept from your shell in this mode.
+    fn whitelist(self) -> &'static [&'static str] {
+        match self {
+            Strictness::Standard => STRICT_WHITELIST,
+            Strictness::Sane => SANE_WHITELIST,
+        }
+    }
+
+    /// Parse the value of `DOTENV_STRICT`: `sane`, or any truthy value
+    /// for the standard mode.
+    fn from_setting(value: &str) -> Option<Self> {
+        if value.eq_ignore_ascii_case("sane") {
+            Some(Strictness::Sane)
+        } else {
+            is_truthy(value).then_some(Strictness::Standard)
+        }
+    }
+}
+
+/// What to do when dotenv runs under a command started by another dotenv.
+#[derive(ValueEnum, Clone, Copy, Debug, PartialEq, Default)]
+enum Nesting {
+    /// Load the environment on top of the one already loaded
+    #[default]
+    Merge,
+    /// Remove the variables loaded by the outer dotenv first
+    Override,
+    /// Refuse to run the command
+    Error,
+}
+
+/// Where a variable injected into the command came from.
+#[derive(Debug, Clone, PartialEq)]
+enum Source {
+    File(PathBuf),
+    Stdin,
+    /// A Kubernetes Secret or ConfigMap, by its `k8s://` name.
+    Kubernetes(String),
+    Inherited,
+}
+
+impl fmt::Display for Source {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Source::File(path) => write!(f, "{}", path.display()),
+            Source::Stdin => write!(f, "standard input"),
+            Source::Kubernetes(name) => write!(f, "{}", name),
+            Source::Inherited => write!(f, "the inherited environment"),
+        }
+    }
+}
+
+/// Variables loaded for the command, each with its value and where it came
+/// from.
+type Loaded = HashMap<String, (String, Source)>;
+
+/// Flags controlling how the command is run, shared by the implicit form
+/// and the `run` subcommand.
+#[derive(Args, Debug)]
+struct RunOptions {
     /// Specify the named environment file in ~/.dotenv/ (e.g. `example` for ~/.dotenv/example.env)
     #[arg(short, long)]
     environment: Option<String>,
 
+    /// Run this script through the shell instead of a command (e.g. `make build && make test`), using $DOTENV_SHELL or $SHELL
+    #[arg(short, long, value_name = "SCRIPT", conflicts_with = "command")]
+    shell: Option<String>,
+
     /// Strict mode: only environment variables from the .env file plus a minimal whitelist are kept
+    #[arg(long, value_name = "MODE", num_args = 0..=1, require_equals = true, default_missing_value = "standard")]
+    strict: Option<Strictness>,
+
+    /// Load .env, .env.<APP_ENV>, .env.local and .env.<APP_ENV>.local, each overriding the previous ones
+    #[arg(long, conflicts_with = "environment")]
+    layered: bool,
+
+    /// The application environment whose layered files are loaded, implies --layered (defaults to $APP_ENV)
+    #[arg(long, value_name = "NAME", conflicts_with = "environment")]
+    app_env: Option<String>,
+
+    /// Keep the values of variables already set in your shell instead of replacing them with the file's
     #[arg(long)]
-    strict: bool,
+    no_override: bool,
 
-    /// The command and arguments to run (e.g. `python main.py`)
-    #[arg(required = true)]
-    command: Vec<String>,
+    /// Refuse to run if the environment files changed since they were pinned with `dotenv pin`
+    #[arg(long)]
+    verify: bool,
+
+    /// Variables that must be set and not empty for the command to run, separated by commas (e.g. `DB_URL,API_KEY`)
+    #[arg(long, value_name = "VARS", value_delimiter = ',')]
+    require: Vec<String>,
+
+    /// Schema the environment must match before running the command (defaults to a .env.schema file next to the environment file)
+    #[arg(long, value_name = "PATH")]
+    schema: Option<PathBuf>,
+
+    /// What to do when already running under dotenv (defaults to $DOTENV_NESTED, or merge)
+    #[arg(long, value_name = "MODE")]
+    nested: Option<Nesting>,
+
+    /// Run the command on a pseudo-terminal, so it behaves as if it was run directly from a terminal
+    #[arg(long)]
+    tty: bool,
+
+    /// Terminate the command if it runs longer than this (e.g. `30s`, `5m`), exiting with status 124
+    #[arg(long, value_name = "DURATION", value_parser = duration::parse)]
+    timeout: Option<Duration>,
+
+    /// How long to wait after asking the command to stop, because it timed out or dotenv was asked to stop, before killing it
+    #[arg(long, value_name = "DURATION", value_parser = duration::parse, default_value = "10s")]
+    kill_after: Duration,
+
+    /// Run the command again up to this many times when it exits with a non-zero status
+    #[arg(long, value_name = "N", default_value_t = 0)]
+    retries: u32,
+
+    /// How long to wait before running a failed command again
+    #[arg(long, value_name = "DURATION", value_parser = duration::parse, default_value = "1s", requires = "retries")]
+    retry_delay: Duration,
+
+    /// Multiply the delay by this after every failed attempt (e.g. `2` to double it each time)
+    #[arg(long, value_name = "FACTOR", value_parser = retry::parse_backoff, default_value = "1", requires = "retries")]
+    retry_backoff: f64,
+
+    /// Only run the command again when it exits with one of these statuses, separated by commas (e.g. `75,124`)
+    #[arg(
+        long,
+        value_name = "CODES",
+        value_delimiter = ',',
+        requires = "retries"
+    )]
+    retry_on: Vec<i32>,
+
+    /// Restart the command whenever it exits with a non-zero status or is killed, waiting longer after every restart
+    #[arg(long, conflicts_with = "retries")]
+    supervise: bool,
+
+    /// How many times a supervised command is restarted at most before giving up
+    #[arg(long, value_name = "N", default_value_t = 10, requires = "supervise")]
+    max_restarts: u32,
+
+    /// Start over, loading the environment again, whenever one of the files it was loaded from changes (Unix only)
+    #[arg(long)]
+    watch: bool,
+
+    /// Send this signal to the command when a watched file changes instead of restarting it (e.g. `HUP`)
+    #[arg(long, value_name = "SIGNAL", value_parser = exit::parse_signal, requires = "watch")]
+    reload_signal: Option<i32>,
+
+    /// Start every line the command prints with this label, or the environment's name
+    #[arg(long, value_name = "LABEL", num_args = 0..=1, require_equals = true, conflicts_with = "tty")]
+    prefix_output: Option<Option<String>>,
+
+    /// Also copy the command's output to this file, with a timestamp on every line
+    #[arg(long, value_name = "PATH", conflicts_with = "tty")]
+    log_file: Option<PathBuf>,
+
+    /// Rotate the log file once it grows past this size (e.g. `10M`), renaming it to <PATH>.1
+    #[arg(long, value_name = "SIZE", value_parser = logfile::parse_size, requires = "log_file")]
+    log_max_size: Option<u64>,
+
+    /// How many rotated log files to keep
+    #[arg(long, value_name = "N", default_value_t = 5, requires = "log_max_size")]
+    log_keep: u32,
+
+    /// Run the command as this user, by name or ID, with its primary group unless --group is given (Unix only)
+    #[arg(long, value_name = "USER")]
+    user: Option<String>,
+
+    /// Run the command with this group, by name or ID (Unix only)
+    #[arg(long, value_name = "GROUP")]
+    group: Option<String>,
+
+    /// Set a resource limit for the command, like nofile=4096, nofile=1024:4096 for separate soft and hard limits, or core=unlimited; can be repeated (Unix only)
+    #[arg(long, value_name = "RESOURCE=LIMIT", value_parser = limits::parse_rlimit)]
+    rlimit: Vec<limits::Rlimit>,
+
+    /// Run the command with this niceness, from -20 (highest priority) to 19 (lowest) (Unix only)
+    #[arg(long, value_name = "N", allow_hyphen_values = true, value_parser = clap::value_parser!(i32).range(-20..=19))]
+    nice: Option<i32>,
+
+    /// Adjust how likely the out-of-memory killer is to pick the command, from -1000 (never) to 1000 (first) (Linux only)
+    #[arg(long, value_name = "N", allow_hyphen_values = true, value_parser = clap::value_parser!(i32).range(-1000..=1000))]
+    oom_score_adj: Option<i32>,
+
+    /// Run the command with this umask, in octal like 027 (defaults to $DOTENV_UMASK) (Unix only)
+    #[arg(long, value_name = "MASK", value_parser = limits::parse_umask)]
+    umask: Option<u32>,
+
+    /// Replace dotenv with the command instead of running it as a child process (Unix only)
+    #[arg(long, conflicts_with_all = ["tty", "timeout", "retries", "supervise", "watch", "log_file", "prefix_output"])]
+    exec: bool,
+
+    /// Don't warn when a variable from the file replaces a different value already set in your shell
+    #[arg(long)]
+    allow_shadowing: bool,
+
+    /// Extra variables to keep from your shell in strict mode, separated by commas (e.g. `SSH_AUTH_SOCK,AWS_PROFILE`)
+    #[arg(long, value_name = "VARS", value_delimiter = ',')]
+    keep: Vec<String>,
+
+    /// Variables to remove from your shell's environment before running the command, separated by commas (e.g. `AWS_PROFILE,AWS_SESSION_TOKEN`)
+    #[arg(long, value_name = "VARS", value_delimiter = ',')]
+    unset: Vec<String>,
+
+    /// In strict mode, also keep the variables from your shell whose names start with this prefix (e.g. `AWS_`), can be repeated
+    #[arg(long, value_name = "PREFIX")]
+    pass_prefix: Vec<String>,
+
+    /// Inject the file's variables with this prefix added to their names (e.g. `APP_` turns `PORT` into `APP_PORT`)
+    #[arg(long, value_name = "PREFIX")]
+    prefix: Option<String>,
+
+    /// Remove this prefix from the names of the file's variables that have it (e.g. `APP_` turns `APP_PORT` into `PORT`)
+    #[arg(long, value_name = "PREFIX")]
+    strip_prefix: Option<String>,
+
+    /// Also load a variable from every file in this directory, named after the file (e.g. `/run/secrets`), skipped if it doesn't exist; can be repeated
+    #[arg(long, value_name = "DIR")]
+    secrets_dir: Vec<PathBuf>,
+
+    /// Add this prefix to the names of the variables loaded from --secrets-dir
+    #[arg(long, value_name = "PREFIX", requires = "secrets_dir")]
+    secrets_prefix: Option<String>,
 }
 
-fn main() -> Result<()> {
+#[derive(Subcommand, Debug)]
+enum Commands {
+    /// List the environment files stored in the dotenv folder
+    List {
+        /// Print the list as JSON
+        #[arg(long, conflicts_with = "names")]
+        json: bool,
+
+        /// Print only the environment names, one per line
+        #[arg(long)]
+        names: bool,
+
+        /// Print the environments as a tree of their folders
+        #[arg(long, conflicts_with_all = ["json", "names"])]
+        tree: bool,
+    },
+
+    /// Show the variables defined by an environment, masking sensitive values
+    Show {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Print sensitive values instead of masking them
+        #[arg(long)]
+        reveal: bool,
+
+        /// Show the file each value was taken from, after extending and overlaying
+        #[arg(long)]
+        sources: bool,
+
+        /// Print each variable as a KEY=value record ending with a NUL byte, for `xargs -0` and similar tools
+        #[arg(long, conflicts_with = "sources")]
+        print0: bool,
+    },
+
+    /// Encrypt an environment file in place with age, so it can be committed safely
+    Encrypt {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Encrypt the file for an age or SSH public key (e.g. `age1...`), can be repeated
+        #[arg(short, long, value_name = "KEY", required = true)]
+        recipient: Vec<String>,
+    },
+
+    /// Decrypt an environment file encrypted with age in place, to change it
+    Decrypt {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+    },
+
+    /// Keep every named environment in a single file encrypted with a passphrase
+    Store {
+        #[command(subcommand)]
+        action: StoreCommand,
+    },
+
+    /// Keep secrets in the keyring of the operating system, to reference them as `keyring:NAME`
+    Secret {
+        #[command(subcommand)]
+        action: SecretCommand,
+    },
+
+    /// Open an environment file in $VISUAL or $EDITOR, validating it before saving
+    Edit {
+        /// The named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        name: Option<String>,
+    },
+
+    /// Create a new environment file from a commented template
+    Init {
+        /// The name of the environment file to create in ~/.dotenv/ (defaults to a .env file in the current directory)
+        name: Option<String>,
+
+        /// Copy the variables from an existing file, like a .env.example
+        #[arg(long)]
+        from: Option<PathBuf>,
+
+        /// Overwrite the environment file if it already exists
+        #[arg(long)]
+        force: bool,
+    },
+
+    /// Print the value of a variable from an environment file
+    Get {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// The name of the variable to print
+        key: String,
+    },
+
+    /// Set variables in an environment file, keeping comments and ordering intact
+    Set {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// One or more `KEY=VALUE` pairs to set
+        #[arg(required = true)]
+        assignments: Vec<String>,
+    },
+
+    /// Remove variables from an environment file, keeping comments and ordering intact
+    Unset {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// One or more variable names to remove
+        #[arg(required = true)]
+        keys: Vec<String>,
+    },
+
+    /// Rewrite environment files with normalized spacing and quoting
+    Fmt {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long, conflicts_with = "files")]
+        environment: Option<String>,
+
+        /// Paths to the files to format, instead of a named environment
+        files: Vec<PathBuf>,
+
+        /// Sort the variables by key within each block of lines
+        #[arg(long)]
+        sort: bool,
+
+        /// Don't write any changes, but exit with an error if a file isn't formatted
+        #[arg(long)]
+        check: bool,
+    },
+
+    /// Show the variables added, removed and changed between two environments
+    Diff {
+        /// The environment to compare from, as a file path or a named environment
+        left: String,
+
+        /// The environment to compare to, as a file path or a named environment
+        right: String,
+
+        /// Print sensitive values instead of masking them
+        #[arg(long)]
+        reveal: bool,
+
+        /// Exit with status 1 if the environments differ
+        #[arg(long)]
+        exit_code: bool,
+    },
+
+    /// Merge several environment files into one
+    Merge {
+        /// The environments to merge, as file paths or named environments
+        #[arg(required = true, num_args = 2..)]
+        sources: Vec<String>,
+
+        /// Which file wins when a variable is defined in more than one of them
+        #[arg(long, value_enum, default_value_t = commands::merge::Precedence::Last)]
+        precedence: commands::merge::Precedence,
+
+        /// Write the merged file to this path instead of standard output
+        #[arg(short, long)]
+        output: Option<PathBuf>,
+
+        /// Exit with an error if a variable has different values in different files
+        #[arg(long)]
+        fail_on_conflict: bool,
+
+        /// Print sensitive values in conflict reports instead of masking them
+        #[arg(long)]
+        reveal: bool,
+    },
+
+    /// Check an environment file against a schema
+    Validate {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Path to the schema file (defaults to a .env.schema file next to the environment file)
+        #[arg(long)]
+        schema: Option<PathBuf>,
+    },
+
+    /// Generate and check .env.example files
+    Example {
+        #[command(subcommand)]
+        action: ExampleCommand,
+    },
+
+    /// Explain which environment file would be loaded and why
+    Which {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+    },
+
+    /// Check the dotenv configuration and environment files for common problems
+    Doctor,
+
+    /// Make an environment file, the environments it extends and their overlays only accessible by you
+    FixPerms {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+    },
+
+    /// Record the hash of an environment file, the environments it extends and their overlays, for --verify
+    Pin {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+    },
+
+    /// Allow a file outside the dotenv folders to be loaded, until it changes
+    Allow {
+        /// The file to allow (defaults to the files that would be loaded from the current directory)
+        path: Option<PathBuf>,
+    },
+
+    /// Stop allowing a file outside the dotenv folders to be loaded
+    Deny {
+        /// The file to deny (defaults to the files that would be loaded from the current directory)
+        path: Option<PathBuf>,
+    },
+
+    /// Print a shell completion script for bash, zsh or fish
+    Completion {
+        /// The shell to generate the completion script for
+        #[arg(value_enum)]
+        shell: commands::completion::Shell,
+    },
+
+    /// Print the dotenv man page in roff format
+    Man,
+
+    /// Update dotenv to the latest release published on GitHub
+    SelfUpdate {
+        /// Only check whether a newer release is available
+        #[arg(long)]
+        check: bool,
+
+        /// Reinstall the latest release even if it's not newer
+        #[arg(long)]
+        force: bool,
+    },
+
+    /// Copy a named environment to a new name
+    Cp {
+        /// The name of the environment to copy
+        from: String,
+
+        /// The name of the new environment
+        to: String,
+
+        /// Overwrite the new environment without asking if it already exists
+        #[arg(short, long)]
+        force: bool,
+    },
+
+    /// Rename a named environment
+    Mv {
+        /// The name of the environment to rename
+        from: String,
+
+        /// The new name of the environment
+        to: String,
+
+        /// Overwrite the new environment without asking if it already exists
+        #[arg(short, long)]
+        force: bool,
+    },
+
+    /// Delete named environments
+    Rm {
+        /// The names of the environments to delete
+        #[arg(required = true)]
+        names: Vec<String>,
+
+        /// Delete without asking for confirmation
+        #[arg(short, long)]
+        force: bool,
+    },
+
+    /// List, archive or delete environments that haven't been used in a while
+    Prune {
+        /// How many days an environment must go unused to be considered stale
+        #[arg(long, default_value_t = 90)]
+        days: u64,
+
+        /// Move stale environments to the .archive folder inside the dotenv folder
+        #[arg(long, conflicts_with = "delete")]
+        archive: bool,
+
+        /// Delete stale environments
+        #[arg(long)]
+        delete: bool,
+
+        /// Archive or delete without asking for confirmation
+        #[arg(short, long)]
+        force: bool,
+    },
+
+    /// Run a command once in every named environment matching a pattern, then summarize how each run went
+    Foreach {
+        /// Named environments to run the command in, where `*` matches any name part and `**` nested folders too (e.g. `clusters/*`), can be repeated
+        #[arg(short, long = "environment", value_name = "PATTERN", required = true)]
+        environments: Vec<String>,
+
+        /// How many environments to run the command in at once
+        #[arg(short, long, value_name = "N", default_value_t = 1, value_parser = clap::value_parser!(u64).range(1..))]
+        jobs: u64,
+
+        /// Skip the remaining environments once the command fails in one
+        #[arg(long)]
+        fail_fast: bool,
+
+        /// The command and arguments to run (e.g. `kubectl get nodes`)
+        #[arg(trailing_var_arg = true, required = true)]
+        command: Vec<String>,
+    },
+
+    /// Run a docker command starting a container, like `run`, with the variables from an environment file
+    Docker {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// The docker command and its arguments (e.g. `run --rm alpine env`)
+        #[arg(last = true, required = true)]
+        args: Vec<String>,
+    },
+
+    /// Run a command on a remote host over SSH with the variables from a local environment file
+    Ssh {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Pass an argument to ssh before the host (e.g. `--ssh-arg=-p2222`), can be repeated
+        #[arg(long, value_name = "ARG", allow_hyphen_values = true)]
+        ssh_arg: Vec<String>,
+
+        /// The host to connect to, as given to ssh (e.g. `deploy@web1`)
+        host: String,
+
+        /// The command and arguments to run on the host (e.g. `./migrate.sh`)
+        #[arg(last = true, required = true)]
+        command: Vec<String>,
+    },
+
+    /// Run docker compose with the variables from an environment file available for interpolation
+    Compose {
+        #[command(flatten)]
+        options: Box<RunOptions>,
+
+        /// The docker compose command and its arguments (e.g. `up -d`)
+        // Shares the id of the command of `run`, which --shell conflicts with
+        #[arg(id = "command", value_name = "ARGS", trailing_var_arg = true)]
+        args: Vec<String>,
+    },
+
+    /// Run the processes in a Procfile or the project file at once, with the variables from an environment file
+    Start {
+        #[command(flatten)]
+        options: Box<RunOptions>,
+
+        /// Path to the Procfile (defaults to the project file's processes, or the closest Procfile)
+        #[arg(long, value_name = "PATH")]
+        procfile: Option<PathBuf>,
+
+        /// The names of the processes to run (defaults to all of them)
+        // Shares the id of the command of `run`, which --shell conflicts with
+        #[arg(id = "command", value_name = "NAME")]
+        names: Vec<String>,
+    },
+
+    /// Run a command with the variables from an environment file
+    #[command(visible_alias = "exec")]
+    Run {
+        #[command(flatten)]
+        options: Box<RunOptions>,
+
+        /// The command and arguments to run (e.g. `python main.py`); everything after the program name is passed to it
+        #[arg(trailing_var_arg = true)]
+        command: Vec<String>,
+    },
+}
+
+#[derive(Subcommand, Debug)]
+enum StoreCommand {
+    /// Move the plain environment files of the dotenv folder into the encrypted store, creating it
+    Seal {
+        /// Keep the passphrase in the keyring of the operating system instead of asking for it every session
+        #[arg(long)]
+        keyring: bool,
+    },
+
+    /// Write the environments in the store back as plain files and remove it
+    Unseal,
+
+    /// Ask for the passphrase of the store, so it isn't asked again for the rest of the session
+    Unlock,
+
+    /// Forget the passphrase of the store until it's unlocked again
+    Lock,
+}
+
+#[derive(Subcommand, Debug)]
+enum SecretCommand {
+    /// Keep a secret, asked for on the terminal or read from standard input
+    Set {
+        /// The name of the secret
+        name: String,
+    },
+
+    /// Print a secret
+    Get {
+        /// The name of the secret
+        name: String,
+    },
+
+    /// Remove a secret
+    Rm {
+        /// The name of the secret
+        name: String,
+    },
+}
+
+#[derive(Subcommand, Debug)]
+enum ExampleCommand {
+    /// Write a copy of an environment file with its values scrubbed, safe to commit
+    Generate {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Path to the example file (defaults to a .env.example file next to the environment file)
+        #[arg(short, long)]
+        output: Option<PathBuf>,
+
+        /// Overwrite the example file if it already exists
+        #[arg(short, long)]
+        force: bool,
+    },
+
+    /// Report the keys present in an environment file or its example but not in both
+    Check {
+        /// Specify the named environment file in ~/.dotenv/ (defaults to the .env file in the current directory)
+        #[arg(short, long)]
+        environment: Option<String>,
+
+        /// Path to the example file (defaults to a .env.example file next to the environment file)
+        #[arg(long)]
+        example: Option<PathBuf>,
+    },
+}
+
+fn main() {
     let cli = Cli::parse();
-    let mut strict = cli.strict;
 
-    if cli.command.is_empty() {
+    // An invalid configuration file is reported by the commands reading it
+    let global = config::Config::load().unwrap_or_default();
+    mask::add_sensitive_words(&global.sensitive);
+
+    let quiet = match env::var(output::QUIET_VAR) {
+        Ok(value) => is_truthy(&value),
+        Err(_) => global.quiet,
+    };
+    output::set_quiet(cli.quiet || quiet);
+    permissions::set_secure(
+        cli.secure || env::var(permissions::SECURE_VAR).is_ok_and(|v| is_truthy(&v)),
+    );
+    mask::set_reveal(cli.reveal_secrets);
+    // Nothing is shown before the patterns are, so a typo in one can't
+    // leave the secrets it's meant to cover exposed
+    let pattern = env::var(mask::REDACT_VAR).ok().filter(|v| !v.is_empty());
+    let patterns = global.redact.iter().chain(&pattern).map(String::as_str);
+    if let Err(err) = mask::add_redact_patterns(patterns) {
+        fail(err);
+    }
+
+    if let Err(err) = run(cli) {
+        fail(err);
+    }
+}
+
+/// Report the error that stops dotenv and exit with the code for it. It's
+/// printed even in quiet mode, as it's the only explanation of the exit
+/// code.
+fn fail(err: anyhow::Error) -> ! {
+    eprintln!("Error: {}", mask::redact(&format!("{:?}", err)));
+    std::process::exit(exit::code_for(&err));
+}
+
+fn run(cli: Cli) -> Result<()> {
+    if let Some(subcommand) = cli.subcommand {
+        return match subcommand {
+            Commands::List { json, names, tree } => commands::list::run(json, names, tree),
+            Commands::Show {
+                environment,
+                reveal,
+                sources,
+                print0,
+            } => commands::show::run(environment.as_deref(), reveal, sources, print0),
+            Commands::Encrypt {
+                environment,
+                recipient,
+            } => commands::crypt::encrypt(environment.as_deref(), &recipient),
+            Commands::Decrypt { environment } => commands::crypt::decrypt(environment.as_deref()),
+            Commands::Store { action } => match action {
+                StoreCommand::Seal { keyring } => commands::store::seal(keyring),
+                StoreCommand::Unseal => commands::store::unseal(),
+                StoreCommand::Unlock => commands::store::unlock(),
+                StoreCommand::Lock => commands::store::lock(),
+            },
+            Commands::Secret { action } => match action {
+                SecretCommand::Set { name } => commands::secret::set(&name),
+                SecretCommand::Get { name } => commands::secret::get(&name),
+                SecretCommand::Rm { name } => commands::secret::rm(&name),
+            },
+            Commands::Edit { name } => commands::edit::run(name.as_deref()),
+            Commands::Init { name, from, force } => {
+                commands::init::run(name.as_deref(), from.as_deref(), force)
+            }
+            Commands::Get { environment, key } => commands::vars::get(environment.as_deref(), &key),
+            Commands::Set {
+                environment,
+                assignments,
+            } => commands::vars::set(environment.as_deref(), &assignments),
+            Commands::Unset { environment, keys } => {
+                commands::vars::unset(environment.as_deref(), &keys)
+            }
+            Commands::Fmt {
+                environment,
+                files,
+                sort,
+                check,
+            } => commands::fmt::run(environment.as_deref(), &files, sort, check),
+            Commands::Diff {
+                left,
+                right,
+                reveal,
+                exit_code,
+            } => commands::diff::run(&left, &right, reveal, exit_code),
+            Commands::Merge {
+                sources,
+                precedence,
+                output,
+                fail_on_conflict,
+                reveal,
+            } => commands::merge::run(
+                &sources,
+                precedence,
+                output.as_deref(),
+                fail_on_conflict,
+                reveal,
+            ),
+            Commands::Validate {
+                environment,
+                schema,
+            } => commands::validate::run(environment.as_deref(), schema.as_deref()),
+            Commands::Example { action } => match action {
+                ExampleCommand::Generate {
+                    environment,
+                    output,
+                    force,
+                } => commands::example::generate(environment.as_deref(), output.as_deref(), force),
+                ExampleCommand::Check {
+                    environment,
+                    example,
+                } => commands::example::check(environment.as_deref(), example.as_deref()),
+            },
+            Commands::Which { environment } => commands::which::run(environment.as_deref()),
+            Commands::Doctor => commands::doctor::run(),
+            Commands::FixPerms { environment } => commands::fix_perms::run(environment.as_deref()),
+            Commands::Pin { environment } => commands::pin::run(environment.as_deref()),
+            Commands::Allow { path } => commands::allow::allow(path.as_deref()),
+            Commands::Deny { path } => commands::allow::deny(path.as_deref()),
+            Commands::Completion { shell } => commands::completion::run(shell, Cli::command()),
+            Commands::Man => commands::man::run(Cli::command()),
+            Commands::SelfUpdate { check, force } => commands::self_update::run(check, force),
+            Commands::Cp { from, to, force } => commands::manage::copy(&from, &to, force),
+            Commands::Mv { from, to, force } => commands::manage::rename(&from, &to, force),
+            Commands::Rm { names, force } => commands::manage::remove(&names, force),
+            Commands::Prune {
+                days,
+                archive,
+                delete,
+                force,
+            } => {
+                let action = if archive {
+                    commands::prune::Action::Archive
+                } else if delete {
+                    commands::prune::Action::Delete
+                } else {
+                    commands::prune::Action::List
+                };
+                commands::prune::run(days, action, force)
+            }
+            Commands::Docker { environment, args } => {
+                commands::docker::run(environment.as_deref(), &args)
+            }
+            Commands::Ssh {
+                environment,
+                ssh_arg,
+                host,
+                command,
+            } => commands::ssh::run(environment.as_deref(), &host, &ssh_arg, &command),
+            Commands::Foreach {
+                environments,
+                jobs,
+                fail_fast,
+                command,
+            } => commands::foreach::run(&environments, jobs as usize, fail_fast, &command),
+            Commands::Start {
+                options,
+                procfile,
+                names,
+            } => execute(&options, Target::Processes { procfile, names }),
+            Commands::Compose { options, args } => {
+                // Compose reads the values it interpolates from its own
+                // environment before its .env file, so everything dotenv
+                // resolves reaches the compose file
+                let command: Vec<String> = ["docker", "compose"]
+                    .into_iter()
+                    .map(String::from)
+                    .chain(args)
+                    .collect();
+                execute(&options, Target::Command(&command))
+            }
+            Commands::Run { options, command } => execute(&options, Target::Command(&command)),
+        };
+    }
+
+    execute(&cli.options, Target::Command(&cli.command))
+}
+
+/// What to run once the environment is loaded.
+enum Target<'a> {
+    /// A command and its arguments, or nothing to run the project's
+    /// default command.
+    Command(&'a [String]),
+    /// The processes in a Procfile or the project file, or only the named
+    /// ones.
+    Processes {
+        procfile: Option<PathBuf>,
+        names: Vec<String>,
+    },
+}
+
+/// Refuse to run unless the files loaded, and those they pull in, are pinned.
+fn verify_pins(files: &[PathBuf]) -> Result<()> {
+    let files: Vec<&PathBuf> = files
+        .iter()
+        .filter(|file| file.is_file() || store::holds(file))
+        .collect();
+    if files.is_empty() {
+        anyhow::bail!("--verify needs an environment file to verify");
+    }
+    for file in files {
+        for file in extends::files(file)? {
+            pin::verify(&file)?;
+        }
+    }
+    Ok(())
+}
+
+/// Refuse to use the files outside the dotenv folders that haven't been
+/// allowed, so a cloned repository can't run its own commands when running
+/// yours, or, with `DOTENV_TRUST=commands`, only the ones that would.
+fn check_trust(
+    loaded: &HashMap<String, (String, Source)>,
+    project: Option<&project::Project>,
+) -> Result<()> {
+    let all = trust::guards_all();
+    let mut reasons: BTreeMap<&Path, String> = BTreeMap::new();
+    let mut keys: Vec<&String> = loaded.keys().collect();
+    keys.sort();
+    for key in keys {
+        let (value, source) = &loaded[key];
+        if let Source::Kubernetes(name) = source {
+            if exec::is_command(value) {
+                anyhow::bail!(
+                    "{} from {} is computed with a command, which only files that are allowed can do",
+                    key,
+                    name
+                );
+            }
+        }
+        let Source::File(file) = source else {
+            continue;
+        };
+        if trust::runs_command(key) {
+            reasons
+                .entry(file)
+                .or_insert_with(|| format!("sets {}", key));
+        } else if exec::is_command(value) {
+            reasons
+                .entry(file)
+                .or_insert_with(|| format!("computes {} with a command", key));
+        } else if all {
+            reasons
+                .entry(file)
+                .or_insert_with(|| "is outside the dotenv folders".to_string());
+        }
+    }
+
+    if let Some(project) = project {
+        let config = &project.config;
+        let runs = !config.command.is_empty()
+            || config.pre_command.is_some()
+            || config.post_command.is_some()
+            || config.on_failure.is_some()
+            || !config.processes.is_empty();
+        if runs {
+            reasons.insert(&project.path, "sets a command to run".to_string());
+        } else if all {
+            reasons.insert(&project.path, "is outside the dotenv folders".to_string());
+        }
+    }
+
+    for (file, reason) in reasons {
+        trust::require(file, &reason)?;
+    }
+    Ok(())
+}
+
+/// Run a command, or several processes, with the variables from the
+/// selected environment file, exiting with the command's own exit code.
+fn execute(options: &RunOptions, target: Target) -> Result<()> {
+    // Kept to start over from when a watched file changes
+    let pristine: Vec<(OsString, OsString)> = env::vars_os().collect();
+    let environment = options.environment.as_deref();
+    let cwd = env::current_dir().context("Could not get current directory")?;
+    let global = config::Config::load()?;
+    let project = project::Project::find(&cwd)?;
+    let config = project.as_ref().map(|p| &p.config);
+
+    let configured = global.strict || config.is_some_and(|c| c.strict);
+    let mut strict = options
+        .strict
+        .or(configured.then_some(Strictness::Standard));
+
+    let (given, processes) = match target {
+        Target::Command(command) => (command, None),
+        Target::Processes { procfile, names } => {
+            let unsupported = [
+                ("--shell", options.shell.is_some()),
+                ("--tty", options.tty),
+                ("--exec", options.exec),
+                ("--timeout", options.timeout.is_some()),
+                ("--retries", options.retries > 0),
+                ("--supervise", options.supervise),
+                ("--watch", options.watch),
+                ("--log-file", options.log_file.is_some()),
+            ];
+            if let Some((flag, _)) = unsupported.iter().find(|(_, used)| *used) {
+                anyhow::bail!("{} can't be used with dotenv start", flag);
+            }
+            let all = commands::start::load(&cwd, project.as_ref(), procfile.as_deref())?;
+            (&[][..], Some(commands::start::select(all, &names)?))
+        }
+    };
+    if options.timeout.is_some() && !cfg!(unix) {
+        anyhow::bail!("--timeout is only supported on Unix");
+    }
+    if options.watch && !cfg!(unix) {
+        anyhow::bail!("--watch is only supported on Unix");
+    }
+
+    // Look up the user and group to run as early, so a typo doesn't go
+    // unnoticed until the command runs
+    let credentials = if options.user.is_some() || options.group.is_some() {
+        Some(user::resolve(
+            options.user.as_deref(),
+            options.group.as_deref(),
+        )?)
+    } else {
+        None
+    };
+    let limits =
+        limits::Limits {
+            rlimits: options.rlimit.clone(),
+            nice: options.nice,
+            oom_score_adj: options.oom_score_adj,
+            umask: match options.umask {
+                Some(umask) => Some(umask),
+                None => match env::var(UMASK_VAR) {
+                    Ok(value) => Some(limits::parse_umask(&value).map_err(|err| {
+                        anyhow::anyhow!("Invalid value for {}: {}", UMASK_VAR, err)
+                    })?),
+                    Err(_) => None,
+                },
+            },
+        };
+    limits.check()?;
+    let launch = Launch {
+        credentials,
+        limits,
+        shell: Shell::from_env(),
+    };
+
+    // Decide what to do with the environment loaded by an outer dotenv,
+    // detected through the markers it sets
+    if let Some(outer) = markers::outer() {
+        let nesting = match options.nested {
+            Some(nesting) => nesting,
+            None => match env::var(NESTED_VAR) {
+                Ok(value) => Nesting::from_str(&value, true).map_err(|_| {
+                    anyhow::anyhow!(
+                        "Invalid value for {}: {:?}, expected merge, override or error",
+                        NESTED_VAR,
+                        value
+                    )
+                })?,
+                Err(_) => Nesting::default(),
+            },
+        };
+        match nesting {
+            Nesting::Merge => {}
+            Nesting::Override => markers::unload(),
+            Nesting::Error => anyhow::bail!(
+                "Already running under dotenv with {} loaded, use --nested to merge or override it",
+                outer
+            ),
+        }
+    }
+
+    // Load environment variables from standard input or Kubernetes when
+    // asked to, from the layered files, from the files listed in the project file, or
+    // from the environment file if it exists
+    let resolution = profiles::explain(environment)?;
+    let mut anchor = cwd.join(".env");
+    let mut env_file = None;
+    let mut files = Vec::new();
+    let loaded = if options.layered || options.app_env.is_some() {
+        if let Some((name, origin)) = &resolution.name {
+            anyhow::bail!(
+                "Layered files can't be combined with a named environment, but {:?} was selected by {}",
+                name,
+                origin
+            );
+        }
+
+        let app_env = options.app_env.clone().or_else(|| {
+            env::var(profiles::APP_ENV_VAR)
+                .ok()
+                .filter(|v| !v.is_empty())
+        });
+        files = profiles::layers(&cwd, app_env.as_deref());
+        parse_env_files(&files)?
+    } else if resolution.is_stdin() {
+        files.push(PathBuf::from(profiles::STDIN));
+        let mut content = String::new();
+        io::stdin()
+            .read_to_string(&mut content)
+            .context("Could not read environment from standard input")?;
+        let parsed = env_parser::parse_env(&content)
+            .context("Could not parse environment from standard input")?;
+        for key in &parsed.sensitive {
+            mask::mark_sensitive(key);
+        }
+        parsed
+            .vars
+            .into_iter()
+            .map(|(key, value)| (key, (value, Source::Stdin)))
+            .collect()
+    } else if let Some(name) = resolution.kubernetes() {
+        files.push(PathBuf::from(name));
+        k8s::load(name)?
+            .into_iter()
+            .map(|(key, value)| (key, (value, Source::Kubernetes(name.to_string()))))
+            .collect()
+    } else if let Some(project) = project
+        .as_ref()
+        .filter(|p| resolution.name.is_none() && !p.config.files.is_empty())
+    {
+        anchor = project.path.clone();
+        files = project.files();
+        parse_env_files(&files)?
+    } else {
+        let (vars, file) = load_default(environment)?;
+        env_file = file.clone();
+        files.extend(file.clone());
+        anchor = file.unwrap_or(anchor);
+        vars
+    };
+    if options.verify || env::var(pin::VERIFY_VAR).is_ok_and(|v| is_truthy(&v)) {
+        verify_pins(&files)?;
+    }
+    check_trust(&loaded, project.as_ref())?;
+    let mut loaded = rename(
+        loaded,
+        options.strip_prefix.as_deref(),
+        options.prefix.as_deref(),
+    );
+
+    // The secrets mounted in a directory, like in a container, override
+    // the file's variables
+    for dir in &options.secrets_dir {
+        let secrets = secrets_dir::load(dir, options.secrets_prefix.as_deref())?;
+        if !secrets.is_empty() {
+            files.push(dir.clone());
+        }
+        for (key, secret, file) in secrets {
+            if exec::is_command(&secret) {
+                trust::require(&file, &format!("computes {} with a command", key))?;
+            }
+            loaded.insert(key, (secret, Source::File(file)));
+        }
+    }
+    let mut sources: HashMap<String, Source> = HashMap::new();
+    let mut env_vars_from_file: HashMap<String, String> = HashMap::new();
+    for (key, (value, source)) in loaded {
+        sources.insert(key.clone(), source);
+        env_vars_from_file.insert(key, value);
+    }
+    // With --watch, every file read counts, even if all its variables
+    // were overridden by another
+    let mut watched: Vec<PathBuf> = project.iter().map(|p| p.path.clone()).collect();
+    if options.watch {
+        for file in files.iter().filter(|file| file.is_file()) {
+            watched.extend(extends::files(file)?);
+        }
+    }
+    for source in sources.values() {
+        if let Source::File(file) = source {
+            watched.push(file.clone());
+        }
+    }
+    watched.sort();
+    watched.dedup();
+    if options.watch && watched.is_empty() {
+        anyhow::bail!("--watch needs environment files to watch");
+    }
+    secrets::resolve(&mut env_vars_from_file)?;
+
+    // Remove the blocklisted variables from the inherited environment, so
+    // neither no-override nor strict mode can bring them back; the file's
+    // own values are injected later
+    let from_file = env_vars_from_file
+        .get(UNSET_VAR)
+        .map(|list| split_list(list))
+        .unwrap_or_default();
+    for var in options.unset.iter().map(String::as_str).chain(from_file) {
+        env::remove_var(var);
+    }
+
+    // In no-override mode, values already in the environment win over the
+    // file's, so the file only fills in what's missing
+    let no_override = options.no_override || env::var(NO_OVERRIDE_VAR).is_ok_and(|v| is_truthy(&v));
+    if no_override {
+        for key in keep_existing(&mut env_vars_from_file) {
+            sources.insert(key, Source::Inherited);
+        }
+    }
+
+    // Warn about variables from the file silently replacing different
+    // values already set in the shell, which is easy to miss
+    let allow_shadowing = options.allow_shadowing
+        || env_vars_from_file
+            .get(ALLOW_SHADOWING_VAR)
+            .is_some_and(|v| is_truthy(v));
+    if !allow_shadowing {
+        for key in shadowed(&env_vars_from_file) {
+            note!(
+                "dotenv: {} from {} replaces the different value already set in your shell",
+                key,
+                sources[&key]
+            );
+        }
+    }
+
+    let debug = match env::var(DEBUG_VAR) {
+        Ok(value) => is_truthy(&value),
+        Err(_) => global.debug,
+    };
+    if debug {
+        let mut keys: Vec<&String> = env_vars_from_file.keys().collect();
+        keys.sort();
+        for key in keys {
+            let value = mask::display_value(key, &env_vars_from_file[key], false);
+            note!("dotenv: {}={} from {}", key, value, sources[key]);
+        }
+    }
+
+    let required: Vec<String> = options
+        .require
+        .iter()
+        .map(String::as_str)
+        .chain(
+            env_vars_from_file
+                .get(REQUIRED_VAR)
+                .map(|list| split_list(list))
+                .unwrap_or_default(),
+        )
+        .map(String::from)
+        .collect();
+
+    // The scripts run around the command, from the file or else the
+    // project file
+    let hook = |var: &str, configured: Option<&String>| {
+        env_vars_from_file
+            .get(var)
+            .or(configured)
+            .filter(|script| !script.trim().is_empty())
+            .cloned()
+    };
+    let pre_command = hook(PRE_COMMAND_VAR, config.and_then(|c| c.pre_command.as_ref()));
+    let post_command = hook(
+        POST_COMMAND_VAR,
+        config.and_then(|c| c.post_command.as_ref()),
+    );
+    let on_failure = hook(ON_FAILURE_VAR, config.and_then(|c| c.on_failure.as_ref()));
+
+    // Run the script given with --shell through the shell, or else the
+    // command given, after the file's own command if it has one, or else
+    // the project's default command
+    let (alias, given) = pick_alias(&env_vars_from_file, given)?;
+    let command: Vec<String> = match (options.shell.as_deref(), alias, config) {
+        (Some(script), _, _) => launch.shell.invocation(script),
+        (None, Some(alias), _) => split_command(alias.command)
+            .and_then(|words| fill_alias(&words, given))
+            .with_context(|| format!("Invalid {}: {}", alias.var, alias.command))?,
+        (None, None, Some(config)) if given.is_empty() => config.command.clone(),
+        _ => given.to_vec(),
+    };
+    if processes.is_none() && command.is_empty() {
         anyhow::bail!("No command provided.");
     }
 
-    // Determine the environment file to use
-    let env_file = match &cli.environment {
-        Some(name) => get_named_env_file(name)?,
-        None => {
-            let current = env::current_dir().context("Could not get current directory")?;
-            let file = current.join(".env");
-            if file.exists() {
-                Some(file)
-            } else {
-                None
+    let fingerprint = markers::hash(&env_vars_from_file);
+    let mut loaded_keys: Vec<String> = env_vars_from_file.keys().cloned().collect();
+    loaded_keys.sort();
+
+    // Check if there is an env var for strict mode
+    if strict.is_none() {
+        if let Some(val) = env_vars_from_file.get("DOTENV_STRICT") {
+            strict = Strictness::from_setting(val);
+        }
+    }
+
+    // Check if we finally have strict mode, if so, strip
+    // all env vars except the whitelisted ones
+    if let Some(strictness) = strict {
+        let mut new_env_vars: HashMap<String, String> = HashMap::new();
+        let passthrough = config.map(|c| c.passthrough.as_slice()).unwrap_or_default();
+        let from_file = env_vars_from_file
+            .get(KEEP_VAR)
+            .map(|list| split_list(list))
+            .unwrap_or_default();
+        let kept = strictness
+            .whitelist()
+            .iter()
+            .copied()
+            .chain(global.passthrough.iter().map(String::as_str))
+            .chain(passthrough.iter().map(String::as_str))
+            .chain(options.keep.iter().map(String::as_str))
+            .chain(from_file);
+        for var in kept {
+            if let Ok(val) = env::var(var) {
+                new_env_vars.insert(var.to_string(), val);
+            }
+        }
+        for (key, value) in env::vars() {
+            if has_prefix(&key, &options.pass_prefix) {
+                new_env_vars.insert(key, value);
+            }
+        }
+
+        for (key, value) in env_vars_from_file {
+            new_env_vars.insert(key, value);
+        }
+
+        clear_environment();
+
+        for (key, value) in new_env_vars {
+            env::set_var(key, value);
+        }
+    } else {
+        // Strict mode is disabled, so we can inject all the
+        // variables
+        for (key, value) in env_vars_from_file {
+            env::set_var(key, value);
+        }
+    }
+
+    // Let the command know which environment it runs under
+    let profile = resolution
+        .name
+        .as_ref()
+        .map(|(name, _)| name.as_str())
+        .filter(|_| !resolution.is_stdin());
+    markers::set(&files, profile, &fingerprint, &loaded_keys);
+
+    // The label --prefix-output tags the output with: the one given, or
+    // the named environment's name, or the name of the folder the
+    // environment file is in
+    let label = options.prefix_output.as_ref().map(|label| match label {
+        Some(label) => label.clone(),
+        None => profile.map(String::from).unwrap_or_else(|| {
+            anchor.parent().and_then(Path::file_name).map_or_else(
+                || "dotenv".to_string(),
+                |n| n.to_string_lossy().into_owned(),
+            )
+        }),
+    });
+
+    // Ask for the required variables that are missing when there's someone
+    // at the terminal to answer, then fail before running the command if
+    // any of them is still missing
+    let mut missing = missing_vars(&required);
+    if !missing.is_empty() && io::stdin().is_terminal() {
+        let renamed = options.prefix.is_some() || options.strip_prefix.is_some();
+        let answers = ask_missing(&missing)?;
+        if let Some(file) = env_file
+            .as_ref()
+            .filter(|_| !renamed && !answers.is_empty())
+        {
+            let question = format!("Save these values to {}?", file.display());
+            if prompt::confirm(&question)? {
+                let mut doc = env_document::EnvDocument::load(file)?;
+                for (key, value) in &answers {
+                    doc.set(key, value)?;
+                }
+                doc.save(file)?;
+                note!("Saved {} value(s) to {}", answers.len(), file.display());
+            }
+        }
+        missing = missing_vars(&required);
+    }
+    if !missing.is_empty() {
+        anyhow::bail!(
+            "Required variables are missing or empty: {}",
+            missing.join(", ")
+        );
+    }
+
+    // Check the environment the command would receive against the
+    // schema, if there is one
+    if let Some(schema_file) = schema::Schema::locate(options.schema.as_deref(), &anchor) {
+        let schema = schema::Schema::load(&schema_file)?;
+        for key in schema.secret_keys() {
+            mask::mark_sensitive(key);
+        }
+        let problems = schema.validate(&env::vars().collect());
+        if !problems.is_empty() {
+            note!("The environment does not match {}:", schema_file.display());
+            for problem in &problems {
+                note!("  {}", problem);
             }
+            anyhow::bail!("Found {} problem(s)", problems.len());
         }
-    };
+    }
 
-    // Load environment variables from the file if the file exists
-    let env_vars_from_file = if let Some(file_path) = env_file {
-        if file_path.exists() {
-            env_parser::parse_env_file(&file_path).with_context(|| {
-                format!("Could not parse environment file: {}", file_path.display(),)
-            })?
-        } else {
-            HashMap::new()
+    // Run the pre-command with the same environment, giving up if it fails
+    if let Some(script) = &pre_command {
+        let status = run_hook(script, &[], &launch)?;
+        if !status.success() {
+            anyhow::bail!(
+                "The pre-command failed with {}, so the command wasn't run",
+                status
+            );
         }
-    } else {
-        HashMap::new()
-    };
+    }
 
-    // Check if there is an env var for strict mode
-    if !strict {
-        if let Some(val) = env_vars_from_file.get("DOTENV_STRICT") {
-            if is_truthy(val) {
-                strict = true;
-            }
+    let (code, ran) = match processes {
+        // Run every process at once, each through the shell
+        Some(processes) => {
+            signals::install();
+            let (code, process) =
+                commands::start::run(&processes, label.as_deref(), options.kill_after, |line| {
+                    let invocation = launch.shell.invocation(line);
+                    build_command(&invocation[0], &invocation[1..], &launch)
+                })?;
+            (code, process.command)
         }
-    }
+        None => {
+            // Execute the program with the new variables
+            let (program, args) = command.split_first().context("No program specified")?;
 
-    // Check if we finally have strict mode, if so, strip
-    // all env vars except the whitelisted ones
-    if strict {
-        let mut new_env_vars: HashMap<String, String> = HashMap::new();
-        for &var in STRICT_WHITELIST {
-            if let Ok(val) = env::var(var) {
-                new_env_vars.insert(var.to_string(), val);
+            // With --exec, replace dotenv with the program, so there's no
+            // process in between and the program gets dotenv's process ID
+            // and signals
+            if options.exec {
+                if post_command.is_some() {
+                    note!("dotenv: skipping the post-command, as --exec doesn't wait for the command to exit");
+                }
+                let err = replace_process(build_command(program, args, &launch))?;
+                return Err(exit::SpawnError(err))
+                    .with_context(|| format!("Failed to execute command: {}", program));
+            }
+
+            let code = run_attempts(options, program, args, label.as_deref(), &launch, &watched)?;
+            if watch::restarting() {
+                return restart(pristine);
             }
+            let ran = match &options.shell {
+                Some(script) => script.clone(),
+                None => join_command(&command),
+            };
+            (code, ran)
         }
+    };
 
-        for (key, value) in env_vars_from_file {
-            new_env_vars.insert(key, value);
+    // Run the on-failure script, then the post-command, with the same
+    // environment, keeping the command's exit code whatever happens
+    let on_failure = on_failure.filter(|_| code != 0).map(|script| {
+        let code = code.to_string();
+        (
+            script,
+            "on-failure script",
+            vec![(EXIT_CODE_VAR, code), (FAILED_COMMAND_VAR, ran)],
+        )
+    });
+    let post_command = post_command.map(|script| (script, "post-command", Vec::new()));
+    for (script, name, extra) in on_failure.into_iter().chain(post_command) {
+        match run_hook(&script, &extra, &launch) {
+            Ok(status) if status.success() => {}
+            Ok(status) => note!("dotenv: the {} failed with {}", name, status),
+            Err(err) => note!("dotenv: {:?}", err),
         }
+    }
+    std::process::exit(code);
+}
 
-        clear_environment();
+/// Run a script around the command through the shell, with some extra
+/// variables, and wait for it.
+fn run_hook(script: &str, extra: &[(&str, String)], launch: &Launch) -> Result<ExitStatus> {
+    let invocation = launch.shell.invocation(script);
+    let mut cmd = build_command(&invocation[0], &invocation[1..], launch);
+    cmd.envs(extra.iter().map(|(key, value)| (key, value)));
+    cmd.status()
+        .map_err(exit::SpawnError)
+        .with_context(|| format!("Failed to execute command: {}", script))
+}
 
-        for (key, value) in new_env_vars {
-            env::set_var(key, value);
+/// Run the program, and run it again while it fails and the retry policy,
+/// or the supervision one, allows it, returning the exit code dotenv should
+/// exit with. Its output is prefixed with `label`, if given.
+fn run_attempts(
+    options: &RunOptions,
+    program: &str,
+    args: &[String],
+    label: Option<&str>,
+    launch: &Launch,
+    watched: &[PathBuf],
+) -> Result<i32> {
+    // Every attempt logs to the same file
+    let log = match &options.log_file {
+        Some(path) => {
+            let log = logfile::LogFile::open(path, options.log_max_size, options.log_keep)?;
+            Some(Arc::new(Mutex::new(log)))
         }
+        None => None,
+    };
+    let copy = streams::Copy {
+        prefix: label.map(|label| format!("{} | ", label)),
+        log,
+    };
+
+    let (policy, action) = if options.supervise {
+        (retry::Policy::supervise(options.max_restarts), "restart")
     } else {
-        // Strict mode is disabled, so we can inject all the
-        // variables
-        for (key, value) in env_vars_from_file {
-            env::set_var(key, value);
+        let policy = retry::Policy {
+            retries: options.retries,
+            delay: options.retry_delay,
+            backoff: options.retry_backoff,
+            max_delay: Duration::MAX,
+            on: options.retry_on.clone(),
+            killed: false,
+        };
+        (policy, "retry")
+    };
+    signals::install();
+    let mut attempt = 1;
+    loop {
+        let cmd = build_command(program, args, launch);
+        let ended = run_attempt(cmd, options, program, &copy, watched)?;
+        let code = ended.code();
+        if watch::restarting() {
+            return Ok(ended.exit_code());
         }
+        if signals::stopping() || !policy.should_retry(attempt, code) {
+            if let exit::Ended::Killed(_) = ended {
+                note!("dotenv: {} {}", program, ended);
+            }
+            if options.supervise
+                && code != Some(0)
+                && attempt > policy.retries
+                && !signals::stopping()
+            {
+                note!("dotenv: {} failed {} times, giving up", program, attempt);
+            }
+            return Ok(ended.exit_code());
+        }
+
+        let delay = policy.delay(attempt);
+        note!(
+            "dotenv: {} {}, {}ing in {} ({} {} of {})",
+            program,
+            ended,
+            action,
+            duration::display(delay),
+            action,
+            attempt,
+            policy.retries
+        );
+        if !signals::pause(delay) {
+            return Ok(ended.exit_code());
+        }
+        attempt += 1;
     }
+}
 
-    // Execute the program with the new variables
-    let (program, args) = cli.command.split_first().context("No program specified")?;
+/// How the commands dotenv runs are started, besides their arguments.
+struct Launch {
+    /// The user and group to run as, if not dotenv's own.
+    credentials: Option<user::Credentials>,
+    /// The resources the commands can use.
+    limits: limits::Limits,
+    /// The shell scripts run through, picked before any file was loaded.
+    shell: Shell,
+}
 
-    // Create the command and set the arguments apart so they outlive
-    // the borrow checker
-    let mut cmd = Command::new(program);
+/// Create the command running a program with the current environment,
+/// passed sorted by name rather than in the order the variables were set,
+/// so the command always sees the same one.
+fn build_command(program: &str, args: &[String], launch: &Launch) -> Command {
+    let mut cmd = program_command(program);
     cmd.args(args);
+    cmd.env_clear();
+    cmd.envs(sorted_environment());
+    // Set the limits first, while the command still has dotenv's
+    // privileges
+    launch.limits.apply(&mut cmd);
+    if let Some(credentials) = &launch.credentials {
+        credentials.apply(&mut cmd);
+    }
+    cmd
+}
+
+/// Create the command starting a program.
+#[cfg(not(windows))]
+fn program_command(program: &str) -> Command {
+    Command::new(program)
+}
+
+/// Create the command starting a program, looked up in `PATH` with the
+/// extensions in `PATHEXT` like the shell does, so `npm` finds `npm.cmd`
+/// too, and not only `npm.exe`.
+#[cfg(windows)]
+fn program_command(program: &str) -> Command {
+    let Ok(path) = which::which(program) else {
+        return Command::new(program);
+    };
+    let extension = path
+        .extension()
+        .and_then(|e| e.to_str())
+        .map(|e| e.to_ascii_lowercase());
+    match extension.as_deref() {
+        // Batch files are run through cmd.exe by the standard library,
+        // which quotes their arguments safely
+        Some("exe" | "com" | "bat" | "cmd") => Command::new(path),
+        // Other scripts are opened with the program associated with them
+        _ => {
+            let mut cmd = Command::new("cmd");
+            cmd.arg("/d").arg("/c").arg(path);
+            cmd
+        }
+    }
+}
 
+/// Run the command once and wait for it, returning how it ended, with the
+/// status reserved for it if it timed out. Its output goes through `copy`,
+/// if needed, and the `watched` files are watched with `--watch`.
+fn run_attempt(
+    mut cmd: Command,
+    options: &RunOptions,
+    program: &str,
+    copy: &streams::Copy,
+    watched: &[PathBuf],
+) -> Result<exit::Ended> {
     // On Linux, set the Pdeathsig so the child receives SIGTERM if the parent dies
     #[cfg(target_os = "linux")]
     {
         use std::io::{Error, ErrorKind};
         use std::os::unix::process::CommandExt;
 
+        // Captured beforehand, as dotenv may itself be init, like when
+        // it's a container's entrypoint
+        let parent = std::process::id() as libc::pid_t;
         unsafe {
-            cmd.pre_exec(|| {
+            cmd.pre_exec(move || {
                 // Set the parent-death signal to SIGTERM
                 if libc::prctl(libc::PR_SET_PDEATHSIG, libc::SIGTERM, 0, 0, 0) != 0 {
                     return Err(Error::last_os_error());
@@ -128,11 +1805,11 @@ fn main() -> Result<()> {
 
                 // Double-check parent PID
                 let ppid = libc::getppid();
-                if ppid == 1 {
-                    // The parent is init, meaning we won't get PDEATHSIG if the original parent is gone
+                if ppid != parent {
+                    // The parent was replaced by init, meaning we won't get PDEATHSIG since the original parent is gone
                     return Err(Error::new(
                         ErrorKind::Other,
-                        "Unable to operate on a program whose parent is init",
+                        "Unable to operate on a program whose parent is gone",
                     ));
                 }
 
@@ -141,11 +1818,469 @@ fn main() -> Result<()> {
         }
     }
 
-    // Grab the exit code from the executed program
-    let status = cmd
-        .status()
+    // Start the program in its own process group or, with --tty, on a
+    // pseudo-terminal in a session of its own, relaying the signals dotenv
+    // receives to the whole group, and grab its exit code
+    let pty = if options.tty {
+        Some(pty::attach(&mut cmd)?)
+    } else {
+        None
+    };
+    let has_terminal = pty.is_none() && signals::isolate(&mut cmd);
+    if copy.is_needed() {
+        cmd.stdout(Stdio::piped());
+        cmd.stderr(Stdio::piped());
+    }
+    // On Windows, where there's no parent-death signal, put the child in a
+    // job killed along with dotenv, before it gets to run
+    #[cfg(windows)]
+    job::suspend(&mut cmd);
+    let mut child = cmd
+        .spawn()
+        .map_err(exit::SpawnError)
         .with_context(|| format!("Failed to execute command: {}", program))?;
-    std::process::exit(status.code().unwrap_or(1));
+    #[cfg(windows)]
+    job::assign(&child).with_context(|| format!("Failed to start command: {}", program))?;
+    // Close the copies of the pseudo-terminal passed to the command
+    drop(cmd);
+    let mut copies = Vec::new();
+    if let Some(stdout) = child.stdout.take() {
+        copies.push(copy.start(stdout, io::stdout(), "stdout"));
+    }
+    if let Some(stderr) = child.stderr.take() {
+        copies.push(copy.start(stderr, io::stderr(), "stderr"));
+    }
+    let relay = pty.map(pty::Pty::relay).transpose()?;
+    signals::forward_to(child.id());
+    let watchdog = signals::watch(child.id(), options.timeout, options.kill_after);
+    let watcher = options.watch.then(|| {
+        watch::watch(
+            watched.to_vec(),
+            child.id(),
+            options.reload_signal,
+            options.kill_after,
+        )
+    });
+    let status = signals::wait(&mut child, has_terminal);
+    let timed_out = watchdog.stop();
+    if let Some(watcher) = watcher {
+        watcher.stop();
+    }
+    if has_terminal {
+        signals::reclaim_terminal();
+    }
+    if let Some(relay) = relay {
+        relay.finish();
+    }
+    for copy in copies {
+        let _ = copy.join();
+    }
+    let status = status.with_context(|| format!("Failed to wait for command: {}", program))?;
+    if timed_out {
+        if let Some(timeout) = options.timeout {
+            note!(
+                "dotenv: {} was terminated after running for {}",
+                program,
+                duration::display(timeout)
+            );
+        }
+        return Ok(exit::Ended::Exited(exit::TIMED_OUT));
+    }
+    Ok(status.into())
+}
+
+/// The shell scripts are run through, like the one given with `--shell`.
+#[derive(Debug, Clone, PartialEq)]
+struct Shell {
+    program: String,
+    flag: &'static str,
+}
+
+impl Shell {
+    /// Pick the shell from the current environment: the one in
+    /// `DOTENV_SHELL`, or `$SHELL`, or else `sh` (`cmd` on Windows). It has
+    /// to be picked before any file is loaded into the environment, so no
+    /// file can choose it.
+    fn from_env() -> Self {
+        let shell = [SHELL_VAR, "SHELL"]
+            .iter()
+            .find_map(|var| env::var(var).ok().filter(|v| !v.is_empty()));
+        match shell {
+            Some(program) => Self {
+                program,
+                flag: "-c",
+            },
+            None if cfg!(windows) => Self {
+                program: "cmd".to_string(),
+                flag: "/C",
+            },
+            None => Self {
+                program: "sh".to_string(),
+                flag: "-c",
+            },
+        }
+    }
+
+    /// Build the command running a script through the shell.
+    fn invocation(&self, script: &str) -> Vec<String> {
+        vec![
+            self.program.clone(),
+            self.flag.to_string(),
+            script.to_string(),
+        ]
+    }
+}
+
+/// Join a command and its arguments back into a line a shell would run,
+/// quoting the arguments that need it.
+fn join_command(command: &[String]) -> String {
+    let words: Vec<String> = command.iter().map(|word| quote_word(word)).collect();
+    words.join(" ")
+}
+
+/// Quote a word for a POSIX shell, unless it only has characters the shell
+/// doesn't treat specially.
+fn quote_word(word: &str) -> String {
+    let plain = !word.is_empty()
+        && word
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || "-_./=:,+@%".contains(c));
+    if plain {
+        word.to_string()
+    } else {
+        format!("'{}'", word.replace('\'', r"'\''"))
+    }
+}
+
+/// A command set by an environment file, run with the arguments given.
+#[derive(Debug)]
+struct Alias<'a> {
+    /// The variable setting the command.
+    var: String,
+    command: &'a str,
+}
+
+/// Find the command set by an environment file to run with the arguments
+/// given, along with the remaining arguments. A first argument like
+/// `:deploy` picks the file's `DOTENV_COMMAND_DEPLOY` rather than its
+/// `DOTENV_COMMAND`.
+fn pick_alias<'a>(
+    vars: &'a HashMap<String, String>,
+    given: &'a [String],
+) -> Result<(Option<Alias<'a>>, &'a [String])> {
+    let set = |var: &str| {
+        vars.get(var)
+            .map(String::as_str)
+            .filter(|command| !command.trim().is_empty())
+    };
+
+    let Some((name, rest)) = given
+        .split_first()
+        .and_then(|(first, rest)| Some((first.strip_prefix(':')?, rest)))
+        .filter(|(name, _)| !name.is_empty())
+    else {
+        let alias = set(profiles::COMMAND_VAR).map(|command| Alias {
+            var: profiles::COMMAND_VAR.to_string(),
+            command,
+        });
+        return Ok((alias, given));
+    };
+
+    let var = format!(
+        "{}{}",
+        profiles::COMMAND_PREFIX,
+        name.to_ascii_uppercase().replace('-', "_")
+    );
+    if let Some(command) = set(&var) {
+        return Ok((Some(Alias { var, command }), rest));
+    }
+
+    let mut available: Vec<String> = vars
+        .keys()
+        .filter_map(|key| key.strip_prefix(profiles::COMMAND_PREFIX))
+        .filter(|name| set(&format!("{}{}", profiles::COMMAND_PREFIX, name)).is_some())
+        .map(|name| format!(":{}", name.to_ascii_lowercase().replace('_', "-")))
+        .collect();
+    available.sort();
+    if available.is_empty() {
+        anyhow::bail!(
+            "No command named {:?}: the environment doesn't set {}",
+            name,
+            var
+        );
+    }
+    anyhow::bail!(
+        "No command named {:?}, expected one of: {}",
+        name,
+        available.join(", ")
+    )
+}
+
+/// Put the arguments given into the words of an alias: a `{args}` word is
+/// replaced by all of them, and `{1}`, `{2}` and so on by one of them,
+/// anywhere in a word. Without placeholders, the arguments are added at the
+/// end.
+fn fill_alias(words: &[String], args: &[String]) -> Result<Vec<String>> {
+    let mut filled = Vec::new();
+    let mut templated = false;
+    for word in words {
+        if word == "{args}" {
+            filled.extend(args.iter().cloned());
+            templated = true;
+            continue;
+        }
+
+        let mut out = String::new();
+        let mut rest = word.as_str();
+        while let Some(start) = rest.find('{') {
+            out.push_str(&rest[..start]);
+            rest = &rest[start..];
+            let Some(end) = rest.find('}') else {
+                break;
+            };
+            let value = match &rest[1..end] {
+                "args" => args.join(" "),
+                index if !index.is_empty() && index.bytes().all(|b| b.is_ascii_digit()) => index
+                    .parse::<usize>()
+                    .ok()
+                    .and_then(|index| args.get(index.checked_sub(1)?))
+                    .cloned()
+                    .with_context(|| {
+                        format!(
+                            "{{{}}} needs at least {} argument(s), got {}",
+                            index,
+                            index,
+                            args.len()
+                        )
+                    })?,
+                // Not a placeholder, like the braces of an awk program
+                _ => {
+                    out.push('{');
+                    rest = &rest[1..];
+                    continue;
+                }
+            };
+            out.push_str(&value);
+            templated = true;
+            rest = &rest[end + 1..];
+        }
+        out.push_str(rest);
+        filled.push(out);
+    }
+
+    if !templated {
+        filled.extend(args.iter().cloned());
+    }
+    Ok(filled)
+}
+
+/// Split a command line into words the way a shell would, without
+/// expanding anything: words are separated by whitespace, single quotes
+/// keep everything as is, and within double quotes or outside of quotes,
+/// a backslash keeps the next character as is.
+fn split_command(line: &str) -> Result<Vec<String>> {
+    let mut words = Vec::new();
+    let mut word: Option<String> = None;
+    let mut chars = line.chars();
+    while let Some(c) = chars.next() {
+        match c {
+            c if c.is_whitespace() => words.extend(word.take()),
+            '\'' => {
+                let word = word.get_or_insert_with(String::new);
+                loop {
+                    match chars.next() {
+                        Some('\'') => break,
+                        Some(c) => word.push(c),
+                        None => anyhow::bail!("Missing closing quote (')"),
+                    }
+                }
+            }
+            '"' => {
+                let word = word.get_or_insert_with(String::new);
+                loop {
+                    match chars.next() {
+                        Some('"') => break,
+                        Some('\\') => match chars.next() {
+                            Some(c @ ('"' | '\\' | '$' | '`')) => word.push(c),
+                            Some('\n') => {}
+                            Some(c) => {
+                                word.push('\\');
+                                word.push(c);
+                            }
+                            None => anyhow::bail!("Missing closing quote (\")"),
+                        },
+                        Some(c) => word.push(c),
+                        None => anyhow::bail!("Missing closing quote (\")"),
+                    }
+                }
+            }
+            '\\' => match chars.next() {
+                Some('\n') => {}
+                Some(c) => word.get_or_insert_with(String::new).push(c),
+                None => anyhow::bail!("Nothing to escape after the trailing backslash"),
+            },
+            c => word.get_or_insert_with(String::new).push(c),
+        }
+    }
+    words.extend(word);
+    Ok(words)
+}
+
+/// Replace dotenv with a command, only returning the error if that fails.
+#[cfg(unix)]
+fn replace_process(mut cmd: Command) -> Result<io::Error> {
+    use std::os::unix::process::CommandExt;
+
+    Ok(cmd.exec())
+}
+
+#[cfg(not(unix))]
+fn replace_process(_cmd: Command) -> Result<io::Error> {
+    anyhow::bail!("--exec is only supported on Unix")
+}
+
+/// Start dotenv over, with the arguments and the environment it was started
+/// with, so the files are loaded again. Only returns if that fails.
+fn restart(pristine: Vec<(OsString, OsString)>) -> Result<()> {
+    let mut cmd = Command::new(env::current_exe().context("Could not find dotenv itself")?);
+    cmd.args(env::args_os().skip(1));
+    cmd.env_clear();
+    cmd.envs(pristine);
+    let err = replace_process(cmd)?;
+    Err(err).context("Failed to restart dotenv")
+}
+
+/// Load the selected environment file, if there is one, recording that the
+/// environment was used. The file is returned along with its variables.
+fn load_default(environment: Option<&str>) -> Result<(Loaded, Option<PathBuf>)> {
+    match profiles::resolve(environment)? {
+        Some(file_path) => {
+            usage::record(&file_path);
+            let vars = parse_env_files(std::slice::from_ref(&file_path))?;
+            Ok((vars, Some(file_path)))
+        }
+        None => Ok((HashMap::new(), None)),
+    }
+}
+
+/// Parse several environment files, each overriding the variables of the
+/// previous ones, along with the file each value was taken from. Files
+/// that are links to one already loaded are skipped.
+fn parse_env_files(files: &[PathBuf]) -> Result<Loaded> {
+    let mut vars = HashMap::new();
+    let mut loaded = Vec::new();
+    for file in files {
+        let real = profiles::real_path(file);
+        if loaded.contains(&real) {
+            continue;
+        }
+        vars.extend(
+            extends::load_sourced(file)?
+                .into_iter()
+                .map(|(key, (value, path))| (key, (value, Source::File(path)))),
+        );
+        loaded.push(real);
+    }
+    Ok(vars)
+}
+
+/// Split a comma-separated list of variable names, ignoring blanks.
+fn split_list(list: &str) -> Vec<&str> {
+    list.split(',')
+        .map(str::trim)
+        .filter(|name| !name.is_empty())
+        .collect()
+}
+
+/// Rename the variables loaded from a file, first removing `strip` from
+/// the names that start with it and then adding `prefix`. Variables
+/// configuring dotenv itself keep their names.
+fn rename<V>(
+    vars: HashMap<String, V>,
+    strip: Option<&str>,
+    prefix: Option<&str>,
+) -> HashMap<String, V> {
+    vars.into_iter()
+        .map(|(key, value)| {
+            if key.starts_with("DOTENV_") {
+                return (key, value);
+            }
+            let name = strip
+                .and_then(|strip| key.strip_prefix(strip))
+                .filter(|name| !name.is_empty())
+                .unwrap_or(&key);
+            (format!("{}{}", prefix.unwrap_or_default(), name), value)
+        })
+        .collect()
+}
+
+/// Check if a variable name starts with any of the given prefixes.
+fn has_prefix(name: &str, prefixes: &[String]) -> bool {
+    prefixes
+        .iter()
+        .any(|prefix| !prefix.is_empty() && name.starts_with(prefix.as_str()))
+}
+
+/// Replace the value of every variable that's already set in the
+/// environment with its current value, returning the names of the
+/// variables replaced.
+fn keep_existing(vars: &mut HashMap<String, String>) -> Vec<String> {
+    let mut kept = Vec::new();
+    for (key, value) in vars.iter_mut() {
+        if let Ok(existing) = env::var(key) {
+            *value = existing;
+            kept.push(key.clone());
+        }
+    }
+    kept
+}
+
+/// Get the names, sorted, of the variables that would replace a different
+/// value already set in the environment. Variables configuring dotenv
+/// itself are left out.
+fn shadowed(vars: &HashMap<String, String>) -> Vec<String> {
+    let mut keys: Vec<String> = vars
+        .iter()
+        .filter(|(key, _)| !key.starts_with("DOTENV_"))
+        .filter(|(key, value)| env::var(key).is_ok_and(|existing| existing != **value))
+        .map(|(key, _)| key.clone())
+        .collect();
+    keys.sort();
+    keys
+}
+
+/// Get the names, in order and without repeats, of the variables that
+/// are not set or are empty in the environment.
+fn missing_vars(names: &[String]) -> Vec<&str> {
+    let mut missing: Vec<&str> = Vec::new();
+    for name in names {
+        if env::var_os(name).is_none_or(|v| v.is_empty()) && !missing.contains(&name.as_str()) {
+            missing.push(name);
+        }
+    }
+    missing
+}
+
+/// Ask on the terminal for the value of each variable, hiding what's typed
+/// for the ones that look sensitive, and set the answers in the
+/// environment. Variables left empty are skipped.
+fn ask_missing(names: &[&str]) -> Result<Vec<(String, String)>> {
+    note!("Some required variables are missing, enter their values or leave them empty to skip:");
+    let mut answers = Vec::new();
+    for name in names {
+        let value = prompt::ask(&format!("{}:", name), mask::is_sensitive(name))?;
+        if value.is_empty() {
+            continue;
+        }
+        env::set_var(name, &value);
+        answers.push((name.to_string(), value));
+    }
+    Ok(answers)
+}
+
+/// Get the current environment sorted by variable name.
+fn sorted_environment() -> BTreeMap<OsString, OsString> {
+    env::vars_os().collect()
 }
 
 /// Clear all environment variables
@@ -164,20 +2299,12 @@ fn is_truthy(value: &str) -> bool {
     )
 }
 
-fn get_named_env_file(name: &str) -> Result<Option<PathBuf>> {
-    let home_dir = dirs::home_dir().context("Could not get home directory: the home directory is required to fetch specific environment files.")?;
-    let dotenv_dir = home_dir.join(".dotenv");
-
-    let file = dotenv_dir.join(format!("{}.env", name));
-    if file.exists() {
-        Ok(Some(file))
-    } else {
-        eprintln!(
-            "Environment file does not exist in home directory settings folder: {}",
-            file.display()
-        );
-        Ok(None)
-    }
+/// Serialise the tests that change the environment of the whole process,
+/// so tests running at the same time never see it half changed.
+#[cfg(test)]
+fn lock_env() -> std::sync::MutexGuard<'static, ()> {
+    static LOCK: std::sync::Mutex<()> = std::sync::Mutex::new(());
+    LOCK.lock().unwrap_or_else(|err| err.into_inner())
 }
 
 #[cfg(test)]
@@ -233,6 +2360,348 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_run_subcommand_matches_implicit_form() {
+        let cli = Cli::parse_from(["dotenv", "run", "-e", "prod", "--strict", "ls", "-la"]);
+        match cli.subcommand {
+            Some(Commands::Run { options, command }) => {
+                assert_eq!(options.environment.as_deref(), Some("prod"));
+                assert_eq!(options.strict, Some(Strictness::Standard));
+                assert_eq!(command, vec!["ls", "-la"]);
+            }
+            other => panic!("expected the run subcommand, got {:?}", other),
+        }
+
+        let cli = Cli::parse_from(["dotenv", "exec", "--", "echo", "--strict"]);
+        match cli.subcommand {
+            Some(Commands::Run { options, command }) => {
+                assert_eq!(options.strict, None);
+                assert_eq!(command, vec!["echo", "--strict"]);
+            }
+            other => panic!("expected the run subcommand, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_compose_subcommand() {
+        let cli = Cli::parse_from(["dotenv", "compose", "-e", "prod", "up", "-d", "--build"]);
+        match cli.subcommand {
+            Some(Commands::Compose { options, args }) => {
+                assert_eq!(options.environment.as_deref(), Some("prod"));
+                assert_eq!(args, vec!["up", "-d", "--build"]);
+            }
+            other => panic!("expected the compose subcommand, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_strict_modes() {
+        let cli = Cli::parse_from(["dotenv", "--strict=sane", "env"]);
+        assert_eq!(cli.options.strict, Some(Strictness::Sane));
+        assert_eq!(cli.command, vec!["env"]);
+
+        let cli = Cli::parse_from(["dotenv", "--strict", "env"]);
+        assert_eq!(cli.options.strict, Some(Strictness::Standard));
+        assert_eq!(cli.command, vec!["env"]);
+
+        assert_eq!(Strictness::from_setting("SANE"), Some(Strictness::Sane));
+        assert_eq!(Strictness::from_setting("true"), Some(Strictness::Standard));
+        assert_eq!(Strictness::from_setting("no"), None);
+        assert!(Strictness::Sane
+            .whitelist()
+            .iter()
+            .all(|var| ["PATH", "HOME", "TMPDIR", "LANG", "TERM", "USER"].contains(var)));
+    }
+
+    #[test]
+    fn test_split_list() {
+        assert_eq!(
+            split_list("PATH, HOME,,TERM ,SSH_AUTH_SOCK"),
+            vec!["PATH", "HOME", "TERM", "SSH_AUTH_SOCK"]
+        );
+        assert!(split_list(" , ").is_empty());
+    }
+
+    #[test]
+    fn test_keep_flag_accepts_lists() {
+        let cli = Cli::parse_from([
+            "dotenv",
+            "--strict",
+            "--keep",
+            "SSH_AUTH_SOCK,AWS_PROFILE",
+            "--keep",
+            "TZ",
+            "--",
+            "env",
+        ]);
+        assert_eq!(cli.options.keep, vec!["SSH_AUTH_SOCK", "AWS_PROFILE", "TZ"]);
+    }
+
+    #[test]
+    fn test_rename() {
+        let vars = HashMap::from([
+            ("PORT".to_string(), "8080".to_string()),
+            ("APP_HOST".to_string(), "localhost".to_string()),
+            ("APP_".to_string(), "empty".to_string()),
+            ("DOTENV_STRICT".to_string(), "true".to_string()),
+        ]);
+
+        let prefixed = rename(vars.clone(), None, Some("WEB_"));
+        assert_eq!(prefixed["WEB_PORT"], "8080");
+        assert_eq!(prefixed["WEB_APP_HOST"], "localhost");
+        assert_eq!(prefixed["DOTENV_STRICT"], "true");
+
+        let stripped = rename(vars.clone(), Some("APP_"), None);
+        assert_eq!(stripped["PORT"], "8080");
+        assert_eq!(stripped["HOST"], "localhost");
+        assert_eq!(stripped["APP_"], "empty");
+
+        let both = rename(vars, Some("APP_"), Some("WEB_"));
+        assert_eq!(both["WEB_HOST"], "localhost");
+        assert_eq!(both["WEB_PORT"], "8080");
+    }
+
+    #[test]
+    fn test_shell_invocation() {
+        let _env = crate::lock_env();
+        let shell = env::var_os("SHELL");
+        env::set_var(SHELL_VAR, "/bin/zsh");
+        let with_setting = Shell::from_env().invocation("make && make test");
+        env::remove_var(SHELL_VAR);
+        env::set_var("SHELL", "/bin/bash");
+        let picked = Shell::from_env();
+        // Changing the environment afterwards, like loading a file does,
+        // doesn't change the shell already picked
+        env::set_var("SHELL", "/bin/evil");
+        let with_shell = picked.invocation("true");
+        match shell {
+            Some(shell) => env::set_var("SHELL", shell),
+            None => env::remove_var("SHELL"),
+        }
+
+        assert_eq!(with_setting, vec!["/bin/zsh", "-c", "make && make test"]);
+        assert_eq!(with_shell, vec!["/bin/bash", "-c", "true"]);
+    }
+
+    #[test]
+    fn test_join_command() {
+        let command: Vec<String> = ["psql", "-c", "select 1", "--set=x", "it's", ""]
+            .iter()
+            .map(|s| s.to_string())
+            .collect();
+        assert_eq!(
+            join_command(&command),
+            r"psql -c 'select 1' --set=x 'it'\''s' ''"
+        );
+    }
+
+    #[test]
+    fn test_pick_alias() -> anyhow::Result<()> {
+        let vars: HashMap<String, String> = [
+            ("DOTENV_COMMAND", "kubectl"),
+            ("DOTENV_COMMAND_DEPLOY", "make deploy"),
+            ("DOTENV_COMMAND_TAIL_LOGS", "kubectl logs -f"),
+            ("DOTENV_COMMAND_EMPTY", " "),
+        ]
+        .iter()
+        .map(|(k, v)| (k.to_string(), v.to_string()))
+        .collect();
+        let args = |args: &[&str]| -> Vec<String> { args.iter().map(|a| a.to_string()).collect() };
+
+        let given = args(&["get", "pods"]);
+        let (alias, rest) = pick_alias(&vars, &given)?;
+        assert_eq!(
+            alias.map(|a| (a.var, a.command)),
+            Some(("DOTENV_COMMAND".to_string(), "kubectl"))
+        );
+        assert_eq!(rest, given);
+
+        let given = args(&[":tail-logs", "web"]);
+        let (alias, rest) = pick_alias(&vars, &given)?;
+        assert_eq!(alias.map(|a| a.command), Some("kubectl logs -f"));
+        assert_eq!(rest, ["web"]);
+
+        let err = pick_alias(&vars, &args(&[":logs"]))
+            .unwrap_err()
+            .to_string();
+        assert!(err.contains(":deploy, :tail-logs"), "{}", err);
+        assert!(pick_alias(&vars, &args(&[":empty"])).is_err());
+        assert!(pick_alias(&HashMap::new(), &args(&[":deploy"])).is_err());
+
+        // A lone colon isn't a name
+        let given = args(&[":"]);
+        assert_eq!(pick_alias(&vars, &given)?.1, given);
+        Ok(())
+    }
+
+    #[test]
+    fn test_fill_alias() -> anyhow::Result<()> {
+        let words = |line: &str| -> Vec<String> { line.split(' ').map(String::from).collect() };
+        let args = words("web -f");
+
+        assert_eq!(
+            fill_alias(&words("kubectl logs"), &args)?,
+            ["kubectl", "logs", "web", "-f"]
+        );
+        assert_eq!(
+            fill_alias(&words("ssh bastion -- {args} --now"), &args)?,
+            ["ssh", "bastion", "--", "web", "-f", "--now"]
+        );
+        assert_eq!(
+            fill_alias(&words("kubectl logs deploy/{1} {2}"), &args)?,
+            ["kubectl", "logs", "deploy/web", "-f"]
+        );
+        assert_eq!(
+            fill_alias(&words("sh -c echo_{args}"), &args)?,
+            ["sh", "-c", "echo_web -f"]
+        );
+        assert_eq!(fill_alias(&words("ssh {args}"), &[])?, ["ssh"]);
+        assert_eq!(
+            fill_alias(&words("awk {print} {x} {"), &args)?,
+            ["awk", "{print}", "{x}", "{", "web", "-f"]
+        );
+        assert!(fill_alias(&words("echo {3}"), &args).is_err());
+        assert!(fill_alias(&words("echo {0}"), &args).is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_split_command() -> anyhow::Result<()> {
+        assert_eq!(
+            split_command("kubectl --context=prod  -n web")?,
+            ["kubectl", "--context=prod", "-n", "web"]
+        );
+        assert_eq!(
+            split_command(r#"psql -c 'select 1' --set="a \"b\" \$c" it\'s ''"#)?,
+            ["psql", "-c", "select 1", r#"--set=a "b" $c"#, "it's", ""]
+        );
+        assert_eq!(
+            split_command(r#""C:\Program Files\app""#)?,
+            [r"C:\Program Files\app"]
+        );
+        assert!(split_command("").is_ok_and(|words| words.is_empty()));
+        assert!(split_command("echo 'oops").is_err());
+        assert!(split_command(r#"echo "oops"#).is_err());
+        assert!(split_command(r"echo \").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_shell_flag_conflicts_with_command() {
+        assert!(Cli::try_parse_from(["dotenv", "-s", "echo hi"]).is_ok());
+        assert!(Cli::try_parse_from(["dotenv", "-s", "echo hi", "--", "env"]).is_err());
+        assert!(Cli::try_parse_from(["dotenv", "run", "--shell", "echo hi"]).is_ok());
+    }
+
+    #[test]
+    fn test_has_prefix() {
+        let prefixes = vec!["AWS_".to_string(), "KUBE_".to_string()];
+        assert!(has_prefix("AWS_PROFILE", &prefixes));
+        assert!(has_prefix("KUBE_CONFIG", &prefixes));
+        assert!(!has_prefix("AWSOME", &prefixes));
+        assert!(!has_prefix("PATH", &[String::new()]));
+        assert!(!has_prefix("PATH", &[]));
+    }
+
+    #[test]
+    fn test_unset_flag_accepts_lists() {
+        let cli = Cli::parse_from([
+            "dotenv",
+            "--unset",
+            "AWS_PROFILE,AWS_SESSION_TOKEN",
+            "--",
+            "env",
+        ]);
+        assert_eq!(cli.options.unset, vec!["AWS_PROFILE", "AWS_SESSION_TOKEN"]);
+    }
+
+    #[test]
+    fn test_keep_existing() {
+        let _env = crate::lock_env();
+        env::set_var("DOTENV_TEST_CI_VALUE", "from-ci");
+        env::remove_var("DOTENV_TEST_FILE_ONLY");
+
+        let mut vars = HashMap::from([
+            ("DOTENV_TEST_CI_VALUE".to_string(), "from-file".to_string()),
+            ("DOTENV_TEST_FILE_ONLY".to_string(), "from-file".to_string()),
+        ]);
+        let kept = keep_existing(&mut vars);
+        env::remove_var("DOTENV_TEST_CI_VALUE");
+
+        assert_eq!(kept, vec!["DOTENV_TEST_CI_VALUE"]);
+        assert_eq!(vars["DOTENV_TEST_CI_VALUE"], "from-ci");
+        assert_eq!(vars["DOTENV_TEST_FILE_ONLY"], "from-file");
+    }
+
+    #[test]
+    fn test_shadowed() {
+        let _env = crate::lock_env();
+        env::set_var("DOTENV_TEST_SHADOWED", "from-shell");
+        env::set_var("DOTENV_TEST_SAME", "same");
+        env::remove_var("DOTENV_TEST_NEW");
+
+        let vars = HashMap::from([
+            ("DOTENV_TEST_SHADOWED".to_string(), "from-file".to_string()),
+            ("DOTENV_TEST_SAME".to_string(), "same".to_string()),
+            ("DOTENV_TEST_NEW".to_string(), "new".to_string()),
+        ]);
+        let found = shadowed(&vars);
+        env::remove_var("DOTENV_TEST_SHADOWED");
+        env::remove_var("DOTENV_TEST_SAME");
+
+        // Variables configuring dotenv are never reported
+        assert!(found.is_empty());
+
+        env::set_var("SHADOW_TEST_PROFILE", "prod");
+        let vars = HashMap::from([
+            ("SHADOW_TEST_PROFILE".to_string(), "dev".to_string()),
+            ("SHADOW_TEST_OTHER".to_string(), "x".to_string()),
+        ]);
+        let found = shadowed(&vars);
+        env::remove_var("SHADOW_TEST_PROFILE");
+
+        assert_eq!(found, vec!["SHADOW_TEST_PROFILE"]);
+    }
+
+    #[test]
+    fn test_missing_vars() {
+        let _env = crate::lock_env();
+        env::set_var("DOTENV_TEST_REQUIRED_SET", "value");
+        env::set_var("DOTENV_TEST_REQUIRED_EMPTY", "");
+        env::remove_var("DOTENV_TEST_REQUIRED_UNSET");
+
+        let names: Vec<String> = [
+            "DOTENV_TEST_REQUIRED_SET",
+            "DOTENV_TEST_REQUIRED_EMPTY",
+            "DOTENV_TEST_REQUIRED_UNSET",
+            "DOTENV_TEST_REQUIRED_EMPTY",
+        ]
+        .map(String::from)
+        .to_vec();
+        let missing = missing_vars(&names);
+        env::remove_var("DOTENV_TEST_REQUIRED_SET");
+        env::remove_var("DOTENV_TEST_REQUIRED_EMPTY");
+
+        assert_eq!(
+            missing,
+            vec!["DOTENV_TEST_REQUIRED_EMPTY", "DOTENV_TEST_REQUIRED_UNSET"]
+        );
+    }
+
+    #[test]
+    fn test_sorted_environment() {
+        env::set_var("DOTENV_TEST_SORTED_B", "b");
+        env::set_var("DOTENV_TEST_SORTED_A", "a");
+        env::set_var("DOTENV_TEST_SORTED_B", "replaced");
+        let sorted = sorted_environment();
+        env::remove_var("DOTENV_TEST_SORTED_A");
+        env::remove_var("DOTENV_TEST_SORTED_B");
+
+        let keys: Vec<&OsString> = sorted.keys().collect();
+        assert!(keys.windows(2).all(|pair| pair[0] < pair[1]));
+        assert_eq!(sorted[&OsString::from("DOTENV_TEST_SORTED_B")], "replaced");
+    }
+
     #[test]
     fn test_clear_environment() {
         env::set_var("TESTVAR", "VALUE");
@@ -260,7 +2729,7 @@ mod tests {
             "test",
         ];
         let cli = Cli::parse_from(cli_args);
-        assert!(cli.strict);
+        assert!(cli.options.strict.is_some());
 
         // Clear environment in the main function and re-set it based on strict mode
         clear_environment();
@@ -309,7 +2778,7 @@ mod tests {
             "test",
         ];
         let cli = Cli::parse_from(cli_args);
-        assert!(!cli.strict);
+        assert!(cli.options.strict.is_none());
 
         let env_vars_from_file = env_parser::parse_env_file(&location)?;
 
@@ -353,7 +2822,7 @@ mod tests {
         ];
         let cli = Cli::parse_from(cli_args);
 
-        let mut strict = cli.strict;
+        let mut strict = cli.options.strict.is_some();
         let env_vars = env_parser::parse_env_file(&location)?;
         if !strict {
             if let Some(val) = env_vars.get("DOTENV_STRICT") {
@@ -0,0 +1,184 @@
+use std::{collections::HashMap, env, path::PathBuf};
+
+/// Variable set in the command's environment to the environment files that
+/// were loaded, separated like `PATH` entries, or `-` for standard input.
+pub const FILE_VAR: &str = "DOTENV_FILE";
+
+/// Variable set in the command's environment to the name of the named
+/// environment that was loaded, if any.
+pub const PROFILE_VAR: &str = "DOTENV_PROFILE";
+
+/// Variable set in the command's environment to a hash of the variables
+/// loaded, so two runs can be told apart without printing their values.
+pub const HASH_VAR: &str = "DOTENV_HASH";
+
+/// Variable set in the command's environment to the names of the variables
+/// loaded, separated by commas, so a nested dotenv can remove them.
+pub const KEYS_VAR: &str = "DOTENV_KEYS";
+
+/// Every marker variable.
+const MARKERS: &[&str] = &[FILE_VAR, PROFILE_VAR, HASH_VAR, KEYS_VAR];
+
+/// Set the marker variables describing the environment the command runs
+/// under, `hash` and `keys` being the hash and the names of the variables
+/// loaded. When running under another dotenv, its files and keys are kept
+/// in front of the new ones. Nothing is set when no file was loaded.
+pub fn set(files: &[PathBuf], profile: Option<&str>, hash: &str, keys: &[String]) {
+    if files.is_empty() {
+        return;
+    }
+
+    let mut all_files: Vec<PathBuf> = env::var_os(FILE_VAR)
+        .map(|outer| env::split_paths(&outer).collect())
+        .unwrap_or_default();
+    for file in files {
+        if !all_files.contains(file) {
+            all_files.push(file.clone());
+        }
+    }
+
+    let mut all_keys: Vec<String> = env::var(KEYS_VAR)
+        .map(|outer| {
+            outer
+                .split(',')
+                .filter(|k| !k.is_empty())
+                .map(String::from)
+                .collect()
+        })
+        .unwrap_or_default();
+    for key in keys {
+        if !all_keys.contains(key) {
+            all_keys.push(key.clone());
+        }
+    }
+
+    if let Ok(joined) = env::join_paths(&all_files) {
+        env::set_var(FILE_VAR, joined);
+    }
+    if let Some(profile) = profile {
+        env::set_var(PROFILE_VAR, profile);
+    }
+    env::set_var(HASH_VAR, hash);
+    env::set_var(KEYS_VAR, all_keys.join(","));
+}
+
+/// Get the environment files loaded by the dotenv this one runs under, if
+/// it was started through dotenv.
+pub fn outer() -> Option<String> {
+    env::var(FILE_VAR).ok().filter(|files| !files.is_empty())
+}
+
+/// Remove the variables loaded by the dotenv this one runs under, along
+/// with its markers.
+pub fn unload() {
+    if let Ok(keys) = env::var(KEYS_VAR) {
+        for key in keys.split(',').filter(|key| !key.is_empty()) {
+            env::remove_var(key);
+        }
+    }
+    for marker in MARKERS {
+        env::remove_var(marker);
+    }
+}
+
+/// Hash a set of variables with 64-bit FNV-1a over their sorted `KEY=VALUE`
+/// lines, so the result doesn't depend on the order they were loaded in or
+/// on the Rust version dotenv was built with.
+pub fn hash(vars: &HashMap<String, String>) -> String {
+    let mut keys: Vec<&String> = vars.keys().collect();
+    keys.sort();
+
+    let mut hash: u64 = 0xcbf29ce484222325;
+    for key in keys {
+        for byte in format!("{}={}\n", key, vars[key]).bytes() {
+            hash ^= u64::from(byte);
+            hash = hash.wrapping_mul(0x100000001b3);
+        }
+    }
+    format!("{:016x}", hash)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_set_keeps_outer_markers() {
+        let _env = crate::lock_env();
+        set(
+            &[PathBuf::from("/outer.env")],
+            None,
+            "0",
+            &["A".to_string(), "B".to_string()],
+        );
+        set(
+            &[PathBuf::from("/inner.env")],
+            Some("inner"),
+            "1",
+            &["B".to_string(), "C".to_string()],
+        );
+        let files = env::var_os(FILE_VAR);
+        let keys = env::var(KEYS_VAR);
+        let profile = env::var(PROFILE_VAR);
+        for marker in MARKERS {
+            env::remove_var(marker);
+        }
+
+        let files: Vec<PathBuf> = env::split_paths(&files.unwrap()).collect();
+        assert_eq!(
+            files,
+            vec![PathBuf::from("/outer.env"), PathBuf::from("/inner.env")]
+        );
+        assert_eq!(keys.as_deref(), Ok("A,B,C"));
+        assert_eq!(profile.as_deref(), Ok("inner"));
+    }
+
+    #[test]
+    fn test_unload_removes_outer_variables() {
+        let _env = crate::lock_env();
+        env::set_var("DOTENV_TEST_OUTER_A", "1");
+        env::set_var("DOTENV_TEST_OUTER_B", "2");
+        env::set_var("DOTENV_TEST_KEPT", "3");
+        set(
+            &[PathBuf::from("/app/.env")],
+            Some("outer"),
+            "0",
+            &[
+                "DOTENV_TEST_OUTER_A".to_string(),
+                "DOTENV_TEST_OUTER_B".to_string(),
+            ],
+        );
+        assert_eq!(outer().as_deref(), Some("/app/.env"));
+
+        unload();
+        let kept = env::var("DOTENV_TEST_KEPT");
+        env::remove_var("DOTENV_TEST_KEPT");
+
+        assert!(outer().is_none());
+        assert!(env::var(KEYS_VAR).is_err());
+        assert!(env::var("DOTENV_TEST_OUTER_A").is_err());
+        assert!(env::var("DOTENV_TEST_OUTER_B").is_err());
+        assert!(env::var(PROFILE_VAR).is_err());
+        assert_eq!(kept.as_deref(), Ok("3"));
+    }
+
+    #[test]
+    fn test_hash_ignores_order() {
+        let first = HashMap::from([
+            ("A".to_string(), "1".to_string()),
+            ("B".to_string(), "2".to_string()),
+        ]);
+        let second = HashMap::from([
+            ("B".to_string(), "2".to_string()),
+            ("A".to_string(), "1".to_string()),
+        ]);
+        let changed = HashMap::from([
+            ("A".to_string(), "1".to_string()),
+            ("B".to_string(), "3".to_string()),
+        ]);
+
+        assert_eq!(hash(&first), hash(&second));
+        assert_ne!(hash(&first), hash(&changed));
+        assert_eq!(hash(&HashMap::new()), "cbf29ce484222325");
+    }
+}
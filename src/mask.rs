@@ -0,0 +1,243 @@
+use anyhow::{Context, Result};
+use regex::Regex;
+use sha2::{Digest, Sha256};
+use std::{
+    borrow::Cow,
+    collections::{hash_map::RandomState, BTreeSet},
+    hash::BuildHasher,
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Mutex, OnceLock,
+    },
+};
+
+/// Words that, when found as part of a variable name, mark its value as
+/// sensitive (e.g. `DB_PASSWORD` or `AWS_SECRET_ACCESS_KEY`).
+static SENSITIVE_WORDS: &[&str] = &[
+    "SECRET",
+    "SECRETS",
+    "PASSWORD",
+    "PASSWD",
+    "PASS",
+    "TOKEN",
+    "KEY",
+    "APIKEY",
+    "CREDENTIAL",
+    "CREDENTIALS",
+    "PRIVATE",
+    "AUTH",
+];
+
+/// Extra words, set once from the global configuration file, that also
+/// mark a value as sensitive.
+static EXTRA_WORDS: OnceLock<Vec<String>> = OnceLock::new();
+
+/// Add words to the list used to spot sensitive variables, for the rest of
+/// the program.
+pub fn add_sensitive_words(words: &[String]) {
+    let _ = EXTRA_WORDS.set(words.iter().map(|w| w.to_uppercase()).collect());
+}
+
+/// Environment variable with a regular expression whose matches are
+/// masked in everything dotenv shows, like the one about a token in
+/// `redact` in the global configuration.
+pub const REDACT_VAR: &str = "DOTENV_REDACT";
+
+/// Patterns, from the global configuration file and `DOTENV_REDACT`, whose
+/// matches are masked wherever they show up, for secrets whose names don't
+/// give them away.
+static PATTERNS: Mutex<Vec<Regex>> = Mutex::new(Vec::new());
+
+/// Add patterns whose matches are masked in every message and listing, for
+/// the rest of the program, along with the ones added before. None are
+/// added if any of them is invalid.
+pub fn add_redact_patterns<'a>(patterns: impl IntoIterator<Item = &'a str>) -> Result<()> {
+    let mut compiled = Vec::new();
+    for pattern in patterns {
+        let regex = Regex::new(pattern)
+            .with_context(|| format!("Invalid redaction pattern {:?}", pattern))?;
+        compiled.push(regex);
+    }
+    PATTERNS
+        .lock()
+        .unwrap_or_else(|err| err.into_inner())
+        .extend(compiled);
+    Ok(())
+}
+
+/// Mask the parts of a text matching the redaction patterns, unless
+/// `--reveal-secrets` is set.
+pub fn redact(text: &str) -> Cow<'_, str> {
+    let mut text = Cow::Borrowed(text);
+    if REVEAL.load(Ordering::Relaxed) {
+        return text;
+    }
+    let patterns = PATTERNS.lock().unwrap_or_else(|err| err.into_inner());
+    for pattern in patterns.iter() {
+        let replaced =
+            pattern.replace_all(&text, |captures: &regex::Captures| match &captures[0] {
+                "" => String::new(),
+                found => masked(found),
+            });
+        if let Cow::Owned(replaced) = replaced {
+            text = Cow::Owned(replaced);
+        }
+    }
+    text
+}
+
+/// Variables marked as sensitive by the files loaded so far, with a
+/// `# !secret` comment or in a schema, whatever their names.
+static MARKED: Mutex<BTreeSet<String>> = Mutex::new(BTreeSet::new());
+
+/// Mark a variable as sensitive for the rest of the program, even if its
+/// name doesn't look like it.
+pub fn mark_sensitive(key: &str) {
+    MARKED
+        .lock()
+        .unwrap_or_else(|err| err.into_inner())
+        .insert(key.to_string());
+}
+
+/// Whether sensitive values are shown everywhere, set once with
+/// `--reveal-secrets`.
+static REVEAL: AtomicBool = AtomicBool::new(false);
+
+/// Show sensitive values in every message and listing for the rest of the
+/// program, instead of masking them.
+pub fn set_reveal(reveal: bool) {
+    REVEAL.store(reveal, Ordering::Relaxed);
+}
+
+/// The text shown in place of a sensitive value, before its fingerprint.
+pub const MASK: &str = "****";
+
+/// Check if a variable holds a sensitive value, either because it was
+/// marked as such or because its name looks like it. Names are split on
+/// `_`, `-` and `.` and each part is compared against a list of well-known
+/// words, so `API_KEY` matches but `KEYBOARD_LAYOUT` doesn't.
+pub fn is_sensitive(key: &str) -> bool {
+    let marked = MARKED
+        .lock()
+        .unwrap_or_else(|err| err.into_inner())
+        .contains(key);
+    if marked {
+        return true;
+    }
+    let extra = EXTRA_WORDS.get().map(Vec::as_slice).unwrap_or_default();
+    key.split(['_', '-', '.']).any(|part| {
+        let part = part.to_uppercase();
+        SENSITIVE_WORDS.contains(&part.as_str()) || extra.contains(&part)
+    })
+}
+
+/// Return the value to display for a variable, masking it if the key
+/// is sensitive, or the parts of it matching the redaction patterns, unless
+/// `reveal` or `--reveal-secrets` is set.
+pub fn display_value<'a>(key: &str, value: &'a str, reveal: bool) -> Cow<'a, str> {
+    if reveal {
+        Cow::Borrowed(value)
+    } else if !REVEAL.load(Ordering::Relaxed) && is_sensitive(key) {
+        Cow::Owned(masked(value))
+    } else {
+        redact(value)
+    }
+}
+
+/// Key the fingerprints of masked values are made with, picked anew on
+/// every run, so a fingerprint can't be checked against a guessed secret.
+static FINGERPRINT_KEY: OnceLock<[u8; 32]> = OnceLock::new();
+
+/// Mask a value, keeping a short fingerprint of it so two masked values
+/// can still be told apart, like in a diff, without showing either. The
+/// fingerprints only compare within the same run.
+pub fn masked(value: &str) -> String {
+    let key = FINGERPRINT_KEY.get_or_init(|| {
+        // The hasher of hash maps is seeded randomly by the standard library
+        let state = RandomState::new();
+        let mut key = [0u8; 32];
+        for (i, chunk) in key.chunks_mut(8).enumerate() {
+            chunk.copy_from_slice(&state.hash_one(i).to_le_bytes());
+        }
+        key
+    });
+    let digest = Sha256::new()
+        .chain_update(key)
+        .chain_update(value.as_bytes())
+        .finalize();
+    let fingerprint: String = digest[..3].iter().map(|b| format!("{:02x}", b)).collect();
+    format!("{}{}", MASK, fingerprint)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_sensitive() {
+        assert!(is_sensitive("DB_PASSWORD"));
+        assert!(is_sensitive("AWS_SECRET_ACCESS_KEY"));
+        assert!(is_sensitive("GITHUB_TOKEN"));
+        assert!(is_sensitive("api_key"));
+        assert!(is_sensitive("APIKEY"));
+        assert!(is_sensitive("SECRET"));
+        assert!(!is_sensitive("KEYBOARD_LAYOUT"));
+        assert!(!is_sensitive("PATH"));
+        assert!(!is_sensitive("PWD"));
+        assert!(!is_sensitive("DB_HOST"));
+    }
+
+    #[test]
+    fn test_mark_sensitive() {
+        assert!(!is_sensitive("MARKED_DSN"));
+        mark_sensitive("MARKED_DSN");
+        assert!(is_sensitive("MARKED_DSN"));
+        assert_eq!(
+            display_value("MARKED_DSN", "postgres://app@db/app", false),
+            masked("postgres://app@db/app")
+        );
+    }
+
+    #[test]
+    fn test_masked() {
+        let hunter2 = masked("hunter2");
+        assert!(hunter2.starts_with(MASK));
+        assert_eq!(hunter2.len(), MASK.len() + 6);
+        assert_eq!(hunter2, masked("hunter2"));
+        assert_ne!(hunter2, masked("hunter3"));
+    }
+
+    #[test]
+    fn test_display_value() {
+        assert_eq!(
+            display_value("DB_PASSWORD", "hunter2", false),
+            masked("hunter2")
+        );
+        assert_eq!(display_value("DB_PASSWORD", "hunter2", true), "hunter2");
+        assert_eq!(display_value("DB_HOST", "localhost", false), "localhost");
+    }
+
+    #[test]
+    fn test_redact() -> Result<()> {
+        add_redact_patterns([r"zq-\d+"])?;
+        assert_eq!(
+            redact("postgres://app:zq-42@db"),
+            format!("postgres://app:{}@db", masked("zq-42"))
+        );
+        assert_eq!(redact("nothing to hide"), "nothing to hide");
+        assert_eq!(
+            display_value("DSN", "app:zq-42@db", false),
+            format!("app:{}@db", masked("zq-42"))
+        );
+        assert_eq!(display_value("DSN", "app:zq-42@db", true), "app:zq-42@db");
+        assert!(add_redact_patterns([r"zx-\d+", "("]).is_err());
+        assert_eq!(redact("zx-7"), "zx-7");
+
+        add_redact_patterns([r"zy-\d+"])?;
+        assert_eq!(
+            redact("zq-42 zy-7"),
+            format!("{} {}", masked("zq-42"), masked("zy-7"))
+        );
+        Ok(())
+    }
+}
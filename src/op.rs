@@ -0,0 +1,82 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a field in 1Password, which are kept as
+/// a whole, like `op://vault/item/field`.
+pub const PREFIX: &str = "op://";
+
+/// Replace, in place, the values referencing fields in 1Password with the
+/// fields themselves, read with the 1Password CLI as `op run` does. Each
+/// reference is read once, however many keys use it, and the references are
+/// read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let references: Vec<(String, String)> = vars
+        .iter()
+        .filter(|(_, value)| value.starts_with(PREFIX))
+        .map(|(key, value)| (key.clone(), value.clone()))
+        .collect();
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let ids = references.iter().map(|(_, reference)| reference.as_str());
+    let fields = secrets::fetch_all(ids, read);
+
+    let mut resolved = Vec::new();
+    for (key, reference) in &references {
+        match &fields[reference.as_str()] {
+            Ok(field) => resolved.push((key.clone(), field.clone())),
+            Err(err) => anyhow::bail!(
+                "Could not read {} from 1Password for {}: {:#}",
+                reference,
+                key,
+                err
+            ),
+        }
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Read a field with `op read`, which uses either the account the CLI is
+/// signed in to or, with `OP_CONNECT_HOST` and `OP_CONNECT_TOKEN`, a
+/// 1Password Connect server.
+fn read(reference: &str) -> Result<String> {
+    let output = Command::new("op")
+        .args(["read", "--no-newline", reference])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => anyhow::bail!(
+            "The 1Password CLI is not installed, see https://developer.1password.com/docs/cli/get-started/"
+        ),
+        Err(err) => return Err(err).context("Failed to run op"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("op failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    String::from_utf8(output.stdout).context("The field isn't text")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_resolve_values_without_references() -> Result<()> {
+        let mut vars = HashMap::from([
+            ("DB_HOST".to_string(), "localhost".to_string()),
+            ("DOCS".to_string(), "https://op.example.com".to_string()),
+        ]);
+        resolve_values(&mut vars)?;
+        assert_eq!(vars["DB_HOST"], "localhost");
+        assert_eq!(vars["DOCS"], "https://op.example.com");
+        Ok(())
+    }
+}
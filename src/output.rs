@@ -0,0 +1,81 @@
+use std::{
+    env,
+    fmt::Arguments,
+    fs::OpenOptions,
+    io::Write,
+    sync::atomic::{AtomicBool, Ordering},
+};
+
+use crate::mask;
+
+/// Environment variable that enables quiet mode, like `--quiet` does.
+pub const QUIET_VAR: &str = "DOTENV_QUIET";
+
+/// Environment variable pointing to a file where messages are appended
+/// while quiet mode is enabled, instead of being discarded. It has nothing
+/// to do with `--log-file`, which keeps the command's own output.
+pub const QUIET_LOG_VAR: &str = "DOTENV_QUIET_LOG";
+
+static QUIET: AtomicBool = AtomicBool::new(false);
+
+/// Enable or disable quiet mode for the rest of the program.
+pub fn set_quiet(quiet: bool) {
+    QUIET.store(quiet, Ordering::Relaxed);
+}
+
+/// Check if quiet mode is enabled.
+pub fn is_quiet() -> bool {
+    QUIET.load(Ordering::Relaxed)
+}
+
+/// Print one of dotenv's own messages to standard error. In quiet mode,
+/// the message is appended to the file in `DOTENV_QUIET_LOG` if set, or
+/// discarded otherwise, so the output of the command being run is the only
+/// thing that reaches the terminal. Either way, whatever matches the
+/// redaction patterns is masked.
+pub fn message(args: Arguments) {
+    let message = args.to_string();
+    let message = mask::redact(&message);
+    if !is_quiet() {
+        eprintln!("{}", message);
+        return;
+    }
+
+    if let Some(path) = env::var_os(QUIET_LOG_VAR).filter(|p| !p.is_empty()) {
+        if let Ok(mut file) = OpenOptions::new().create(true).append(true).open(path) {
+            let _ = writeln!(file, "{}", message);
+        }
+    }
+}
+
+/// Print one of dotenv's own messages, honoring quiet mode. Takes the same
+/// arguments as `eprintln!`.
+macro_rules! note {
+    ($($arg:tt)*) => {
+        $crate::output::message(format_args!($($arg)*))
+    };
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_quiet_messages_go_to_log_file() -> anyhow::Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        let log = dir.path().join("dotenv.log");
+        env::set_var(QUIET_LOG_VAR, &log);
+
+        set_quiet(true);
+        note!("first {}", 1);
+        note!("second");
+        set_quiet(false);
+        env::remove_var(QUIET_LOG_VAR);
+
+        assert_eq!(fs::read_to_string(&log)?, "first 1\nsecond\n");
+        Ok(())
+    }
+}
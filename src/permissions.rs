@@ -0,0 +1,140 @@
+use anyhow::Result;
+use std::{
+    path::Path,
+    sync::atomic::{AtomicBool, Ordering},
+};
+
+/// Environment variable that enables secure mode, like `--secure` does.
+pub const SECURE_VAR: &str = "DOTENV_SECURE";
+
+static SECURE: AtomicBool = AtomicBool::new(false);
+
+/// Enable or disable secure mode for the rest of the program, refusing to
+/// load environment files other users could read or change instead of
+/// only warning about them.
+pub fn set_secure(secure: bool) {
+    SECURE.store(secure, Ordering::Relaxed);
+}
+
+/// Check an environment file is only accessible by the current user before
+/// loading it, warning when it isn't or, in secure mode, failing.
+pub fn check(file: &Path) -> Result<()> {
+    let problems = problems(file);
+    if problems.is_empty() {
+        return Ok(());
+    }
+
+    let problems = problems.join(" and ");
+    if SECURE.load(Ordering::Relaxed) {
+        anyhow::bail!(
+            "Refusing to load {}, which is {}, run `dotenv fix-perms` to fix it",
+            file.display(),
+            problems
+        );
+    }
+    note!(
+        "dotenv: {} is {}, run `dotenv fix-perms` to fix it",
+        file.display(),
+        problems
+    );
+    Ok(())
+}
+
+/// Describe what makes a file unsafe to keep secrets in: other users being
+/// able to access it, or it being owned by someone else, who could change it.
+#[cfg(unix)]
+pub fn problems(file: &Path) -> Vec<String> {
+    use std::os::unix::fs::MetadataExt;
+
+    let Ok(metadata) = file.metadata() else {
+        return Vec::new();
+    };
+    let mut problems = Vec::new();
+    let mode = metadata.mode() & 0o777;
+    if mode & 0o077 != 0 {
+        problems.push(format!("accessible by other users (mode {:o})", mode));
+    }
+    if owned_by_other(file) {
+        problems.push(format!("owned by another user (uid {})", metadata.uid()));
+    }
+    problems
+}
+
+/// Check if a file belongs to another user, who could change it.
+#[cfg(unix)]
+pub fn owned_by_other(file: &Path) -> bool {
+    use std::os::unix::fs::MetadataExt;
+
+    // SAFETY: geteuid has no preconditions and can't fail
+    file.metadata()
+        .is_ok_and(|metadata| metadata.uid() != unsafe { libc::geteuid() })
+}
+
+/// Files aren't owned the same way on non-Unix platforms, so none counts
+/// as another user's.
+#[cfg(not(unix))]
+pub fn owned_by_other(_file: &Path) -> bool {
+    false
+}
+
+/// Permissions are managed differently on non-Unix platforms, so there's
+/// nothing to check.
+#[cfg(not(unix))]
+pub fn problems(_file: &Path) -> Vec<String> {
+    Vec::new()
+}
+
+/// Take away the access other users have to a file, returning its previous
+/// mode if it changed. Who owns the file can't be changed the same way.
+#[cfg(unix)]
+pub fn restrict(file: &Path) -> Result<Option<u32>> {
+    use anyhow::Context;
+    use std::{fs, os::unix::fs::PermissionsExt};
+
+    let mode = file
+        .metadata()
+        .with_context(|| format!("Could not read {}", file.display()))?
+        .permissions()
+        .mode()
+        & 0o777;
+    if mode & 0o077 == 0 {
+        return Ok(None);
+    }
+    fs::set_permissions(file, fs::Permissions::from_mode(mode & 0o700))
+        .with_context(|| format!("Could not change the permissions of {}", file.display()))?;
+    Ok(Some(mode))
+}
+
+/// Permissions are managed differently on non-Unix platforms, so there's
+/// nothing to restrict.
+#[cfg(not(unix))]
+pub fn restrict(_file: &Path) -> Result<Option<u32>> {
+    Ok(None)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[cfg(unix)]
+    #[test]
+    fn test_restrict() -> Result<()> {
+        use std::{fs, os::unix::fs::PermissionsExt};
+        use tempfile::tempdir;
+
+        let dir = tempdir()?;
+        let file = dir.path().join(".env");
+        fs::write(&file, "DB_PASSWORD=hunter2\n")?;
+        fs::set_permissions(&file, fs::Permissions::from_mode(0o644))?;
+
+        assert_eq!(
+            problems(&file),
+            vec!["accessible by other users (mode 644)".to_string()]
+        );
+        assert_eq!(restrict(&file)?, Some(0o644));
+        assert_eq!(fs::metadata(&file)?.permissions().mode() & 0o777, 0o600);
+        assert!(problems(&file).is_empty());
+        assert_eq!(restrict(&file)?, None);
+        Ok(())
+    }
+}
@@ -0,0 +1,46 @@
+use anyhow::Result;
+use std::path::{Path, PathBuf};
+
+use crate::{hashes, profiles};
+
+/// Name of the file, inside the dotenv folder, where the environment files
+/// pinned with `dotenv pin` are recorded along with a hash of their contents.
+pub const PIN_FILE: &str = ".pinned";
+
+/// Environment variable that enables verification, like `--verify` does.
+pub const VERIFY_VAR: &str = "DOTENV_VERIFY";
+
+/// Record the contents of files as they are now, so running with `--verify`
+/// refuses to load them once they change, until they're pinned again.
+pub fn pin(files: &[PathBuf]) -> Result<()> {
+    let folder = profiles::folder()?;
+    profiles::create_private_dir(&folder)?;
+
+    let record = folder.join(PIN_FILE);
+    let mut pinned = hashes::load(&record);
+    for file in files {
+        let real = profiles::real_path(file);
+        let hash = hashes::hash_of(&real)?;
+        pinned.insert(real, hash);
+    }
+    hashes::save(&record, &pinned)
+}
+
+/// Refuse to use a file that wasn't pinned, or that changed since it was,
+/// so an unreviewed edit doesn't reach an automated job.
+pub fn verify(file: &Path) -> Result<()> {
+    let real = profiles::real_path(file);
+    let hash = hashes::hash_of(&real)?;
+    let pinned = hashes::load(&profiles::folder()?.join(PIN_FILE));
+    match pinned.get(&real) {
+        Some(pinned) if *pinned == hash => Ok(()),
+        Some(_) => anyhow::bail!(
+            "{} changed since it was pinned, review the changes and run `dotenv pin` again to use it",
+            file.display()
+        ),
+        None => anyhow::bail!(
+            "{} hasn't been pinned, review it and run `dotenv pin` to use it with --verify",
+            file.display()
+        ),
+    }
+}
@@ -0,0 +1,221 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::HashMap,
+    env,
+    io::{Read, Write},
+    path::{Path, PathBuf},
+    process::{Command, Stdio},
+    thread,
+    time::{Duration, Instant},
+};
+
+use crate::{duration, secrets};
+
+/// Prefix of the executables resolving values, followed by the prefix of
+/// the values they resolve, so `dotenv-resolver-foo` resolves `foo:...`.
+pub const RESOLVER_PREFIX: &str = "dotenv-resolver-";
+
+/// Environment variable with how long a resolver can take before it's
+/// killed, like `10s`.
+pub const TIMEOUT_VAR: &str = "DOTENV_RESOLVER_TIMEOUT";
+
+/// How long a resolver can take when `DOTENV_RESOLVER_TIMEOUT` isn't set.
+const DEFAULT_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// How often a running resolver is checked on.
+const POLL_INTERVAL: Duration = Duration::from_millis(10);
+
+/// The values to resolve with resolvers, by variable, and the resolver for
+/// each of their prefixes.
+#[derive(Debug, Default)]
+pub struct Pending {
+    references: Vec<(String, String)>,
+    resolvers: HashMap<String, PathBuf>,
+}
+
+/// Find the values a resolver on `PATH` is installed for, leaving alone the
+/// ones with a prefix dotenv resolves itself or without a resolver, like
+/// `localhost:5432`.
+pub fn references(vars: &HashMap<String, String>) -> Pending {
+    let mut pending = Pending::default();
+    let mut missing = Vec::new();
+    for (key, value) in vars {
+        let Some((scheme, _)) = split(value) else {
+            continue;
+        };
+        if secrets::is_builtin(value) || missing.contains(&scheme) {
+            continue;
+        }
+        if !pending.resolvers.contains_key(scheme) {
+            match which::which(format!("{}{}", RESOLVER_PREFIX, scheme)) {
+                Ok(resolver) => {
+                    pending.resolvers.insert(scheme.to_string(), resolver);
+                }
+                Err(_) => {
+                    missing.push(scheme);
+                    continue;
+                }
+            }
+        }
+        pending.references.push((key.clone(), value.clone()));
+    }
+    pending
+}
+
+/// Replace, in place, the values found by [`references`] with what their
+/// resolvers print. Each reference is resolved once, however many keys use
+/// it, and the references are resolved at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>, pending: Pending) -> Result<()> {
+    if pending.references.is_empty() {
+        return Ok(());
+    }
+    let timeout = match env::var(TIMEOUT_VAR) {
+        Ok(value) => duration::parse(&value)
+            .map_err(|err| anyhow::anyhow!("Invalid value for {}: {}", TIMEOUT_VAR, err))?,
+        Err(_) => DEFAULT_TIMEOUT,
+    };
+
+    let ids = pending.references.iter().map(|(_, value)| value.as_str());
+    let resolved = secrets::fetch_all(ids, |value| {
+        let (scheme, reference) = split(value).context("Not a reference")?;
+        run(&pending.resolvers[scheme], reference, timeout)
+    });
+
+    let mut values = Vec::new();
+    for (key, value) in &pending.references {
+        match &resolved[value.as_str()] {
+            Ok(secret) => values.push((key.clone(), secret.clone())),
+            Err(err) => {
+                let scheme = split(value).map(|(scheme, _)| scheme).unwrap_or_default();
+                anyhow::bail!(
+                    "Could not resolve {} with {}{} for {}: {:#}",
+                    value,
+                    RESOLVER_PREFIX,
+                    scheme,
+                    key,
+                    err
+                )
+            }
+        }
+    }
+    vars.extend(values);
+    Ok(())
+}
+
+/// Split a value into its prefix, without the colon, and the reference
+/// after it, if the prefix could name a resolver: lowercase letters, digits
+/// and dashes, starting with a letter.
+fn split(value: &str) -> Option<(&str, &str)> {
+    let (scheme, reference) = value.split_once(':')?;
+    let valid = scheme.starts_with(|c: char| c.is_ascii_lowercase())
+        && scheme
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+    (valid && !reference.is_empty()).then_some((scheme, reference))
+}
+
+/// Run a resolver, giving it the reference on its input, followed by a line
+/// break, and taking what it prints as the value, without the trailing
+/// line break. It's killed if it takes longer than the timeout.
+fn run(resolver: &Path, reference: &str, timeout: Duration) -> Result<String> {
+    let mut child = Command::new(resolver)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .with_context(|| format!("Failed to run {}", resolver.display()))?;
+
+    // Everything is read and written from other threads, so the resolver
+    // can't block on a full output while it's being waited for
+    let mut stdin = child.stdin.take().context("Failed to open the input")?;
+    let input = format!("{}\n", reference);
+    thread::spawn(move || stdin.write_all(input.as_bytes()));
+    let stdout = read_all(child.stdout.take().context("Failed to open the output")?);
+    let stderr = read_all(child.stderr.take().context("Failed to open the output")?);
+
+    let started = Instant::now();
+    let status = loop {
+        if let Some(status) = child
+            .try_wait()
+            .context("Failed to wait for the resolver")?
+        {
+            break status;
+        }
+        if started.elapsed() >= timeout {
+            let _ = child.kill();
+            let _ = child.wait();
+            anyhow::bail!("timed out after {}", duration::display(timeout));
+        }
+        thread::sleep(POLL_INTERVAL);
+    };
+
+    let stdout = stdout.join().unwrap_or_default();
+    if !status.success() {
+        let stderr = stderr.join().unwrap_or_default();
+        let message = String::from_utf8_lossy(&stderr);
+        match message.trim() {
+            "" => anyhow::bail!("failed with {}", status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    let value = String::from_utf8(stdout).context("The value isn't text")?;
+    let value = value.strip_suffix('\n').unwrap_or(&value);
+    Ok(value.strip_suffix('\r').unwrap_or(value).to_string())
+}
+
+/// Read all of an output from another thread.
+fn read_all(mut output: impl Read + Send + 'static) -> thread::JoinHandle<Vec<u8>> {
+    thread::spawn(move || {
+        let mut content = Vec::new();
+        let _ = output.read_to_end(&mut content);
+        content
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_split() {
+        assert_eq!(split("foo:bar/baz"), Some(("foo", "bar/baz")));
+        assert_eq!(split("my-vault2:key"), Some(("my-vault2", "key")));
+        assert_eq!(
+            split("https://example.com"),
+            Some(("https", "//example.com"))
+        );
+        assert_eq!(split("Foo:bar"), None);
+        assert_eq!(split("1password:bar"), None);
+        assert_eq!(split("foo:"), None);
+        assert_eq!(split("no reference"), None);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run() -> Result<()> {
+        use std::{fs, os::unix::fs::PermissionsExt};
+
+        let dir = tempfile::tempdir()?;
+        let write_resolver = |name: &str, script: &str| -> Result<PathBuf> {
+            let path = dir.path().join(name);
+            fs::write(&path, format!("#!/bin/sh\n{}\n", script))?;
+            fs::set_permissions(&path, fs::Permissions::from_mode(0o755))?;
+            Ok(path)
+        };
+
+        let echo = write_resolver("echo", "read ref; echo \"secret for $ref\"")?;
+        assert_eq!(
+            run(&echo, "db/password", DEFAULT_TIMEOUT)?,
+            "secret for db/password"
+        );
+
+        let failing = write_resolver("failing", "echo 'no such secret' >&2; exit 3")?;
+        let err = run(&failing, "db/password", DEFAULT_TIMEOUT).unwrap_err();
+        assert_eq!(err.to_string(), "no such secret");
+
+        let slow = write_resolver("slow", "sleep 5")?;
+        let err = run(&slow, "db/password", Duration::from_millis(100)).unwrap_err();
+        assert!(err.to_string().starts_with("timed out"));
+        Ok(())
+    }
+}
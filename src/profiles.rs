@@ -0,0 +1,960 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::HashMap,
+    env, fmt, fs,
+    path::{Path, PathBuf},
+};
+
+use crate::{age, config, env_parser, gpg, k8s, permissions, sops, store};
+
+/// Environment variable used to override the folder where named
+/// environment files are stored (defaults to `~/.dotenv`).
+pub const FOLDER_PATH_VAR: &str = "DOTENV_FOLDER_PATH";
+
+/// Variable that, when defined in an environment file, sets the command
+/// to run for that environment.
+pub const COMMAND_VAR: &str = "DOTENV_COMMAND";
+
+/// Prefix of the variables that, when defined in an environment file, set
+/// named commands for that environment, run with `dotenv :name`.
+pub const COMMAND_PREFIX: &str = "DOTENV_COMMAND_";
+
+/// A named environment file stored in the dotenv folder.
+#[derive(Debug)]
+pub struct Profile {
+    pub name: String,
+    pub path: PathBuf,
+    pub variables: usize,
+    pub has_command: bool,
+    /// Whether the file is encrypted with age or GPG, in which case it isn't
+    /// decrypted to be listed, and has no variables or command as far as
+    /// the listing goes.
+    pub encrypted: bool,
+}
+
+impl Profile {
+    /// Describe an environment from its variables.
+    fn parsed(name: String, path: PathBuf, vars: &HashMap<String, String>) -> Self {
+        Self {
+            name,
+            path,
+            variables: vars.len(),
+            has_command: vars.contains_key(COMMAND_VAR),
+            encrypted: false,
+        }
+    }
+}
+
+/// Get the folder where new named environment files are stored: the first
+/// of [`folders`].
+pub fn folder() -> Result<PathBuf> {
+    Ok(folders()?.remove(0))
+}
+
+/// Get every folder named environments are looked up in, in order. The
+/// `DOTENV_FOLDER_PATH` environment variable can list several of them,
+/// separated like `PATH` entries are, to combine a personal folder with a
+/// shared one. Without it, the folders from the global configuration file
+/// are used, if any.
+pub fn folders() -> Result<Vec<PathBuf>> {
+    if let Some(value) = env::var_os(FOLDER_PATH_VAR).filter(|v| !v.is_empty()) {
+        let paths: Vec<PathBuf> = env::split_paths(&value)
+            .filter(|path| !path.as_os_str().is_empty())
+            .collect();
+        if !paths.is_empty() {
+            return Ok(paths);
+        }
+    }
+
+    let home_dir = dirs::home_dir().context("Could not get home directory: the home directory is required to fetch specific environment files.")?;
+    let configured = config::Config::load()?.folders(Some(&home_dir));
+    if !configured.is_empty() {
+        return Ok(configured);
+    }
+
+    Ok(vec![home_dir.join(".dotenv")])
+}
+
+/// Environment variable naming the application environment, like
+/// `production`, whose layered `.env` files are loaded.
+pub const APP_ENV_VAR: &str = "APP_ENV";
+
+/// Environment variable that selects a named environment when
+/// `--environment` isn't given.
+pub const ENVIRONMENT_VAR: &str = "DOTENV";
+
+/// Environment name that reads the variables from standard input instead
+/// of a file.
+pub const STDIN: &str = "-";
+
+/// Where the name of the environment to load came from.
+#[derive(Debug, PartialEq)]
+pub enum Origin {
+    Flag,
+    Variable,
+    /// Mapped to the given directory in the global configuration file.
+    Directory(PathBuf),
+}
+
+impl fmt::Display for Origin {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Origin::Flag => write!(f, "the --environment flag"),
+            Origin::Variable => write!(f, "the {} environment variable", ENVIRONMENT_VAR),
+            Origin::Directory(dir) => write!(
+                f,
+                "the mapping for {} in {}",
+                dir.display(),
+                config::CONFIG_FILE
+            ),
+        }
+    }
+}
+
+/// A path looked at while resolving the environment file.
+#[derive(Debug, PartialEq)]
+pub struct Check {
+    pub path: PathBuf,
+    pub found: bool,
+}
+
+/// The outcome of resolving the environment file, along with every path
+/// that was looked at, so it can be explained to the user.
+#[derive(Debug)]
+pub struct Resolution {
+    pub name: Option<(String, Origin)>,
+    pub checked: Vec<Check>,
+    pub file: Option<PathBuf>,
+}
+
+impl Resolution {
+    /// Check if the variables should be read from standard input.
+    pub fn is_stdin(&self) -> bool {
+        self.name.as_ref().is_some_and(|(name, _)| name == STDIN)
+    }
+
+    /// Get the name of the Kubernetes Secret or ConfigMap to read the
+    /// variables from, if that's what was selected.
+    pub fn kubernetes(&self) -> Option<&str> {
+        self.name
+            .as_ref()
+            .map(|(name, _)| name.as_str())
+            .filter(|name| k8s::is_reference(name))
+    }
+}
+
+/// Find the environment file to use: the named environment in the dotenv
+/// folder when a name is given (either with `--environment` or the
+/// `DOTENV` environment variable), or the closest `.env` file from the
+/// current directory otherwise.
+pub fn resolve(environment: Option<&str>) -> Result<Option<PathBuf>> {
+    let resolution = explain(environment)?;
+    if resolution.is_stdin() {
+        anyhow::bail!(
+            "Reading the environment from standard input is only supported when running a command"
+        );
+    }
+    if let Some(name) = resolution.kubernetes() {
+        anyhow::bail!(
+            "Loading {} from Kubernetes is only supported when running a command",
+            name
+        );
+    }
+
+    if resolution.file.is_none() {
+        if let Some((name, _)) = &resolution.name {
+            for check in &resolution.checked {
+                note!(
+                    "Environment file does not exist in dotenv folder: {}",
+                    check.path.display()
+                );
+            }
+            suggest(name);
+        }
+    }
+
+    Ok(resolution.file)
+}
+
+/// Resolve the environment file like [`resolve`] does, recording where the
+/// environment name came from and which paths were checked.
+pub fn explain(environment: Option<&str>) -> Result<Resolution> {
+    let name = selected(environment)?;
+    if name
+        .as_ref()
+        .is_some_and(|(name, _)| name == STDIN || k8s::is_reference(name))
+    {
+        return Ok(Resolution {
+            name,
+            checked: Vec::new(),
+            file: None,
+        });
+    }
+
+    // The encrypted files are looked for too, but only reported if found
+    let quiet = |candidate: &Path| match &name {
+        Some((name, _)) => gpg::is_encrypted(candidate) && !gpg::is_encrypted(Path::new(name)),
+        None => false,
+    };
+    let candidates: Vec<PathBuf> = match &name {
+        Some((name, _)) => folders()?
+            .iter()
+            .flat_map(|folder| files_named(folder, name))
+            .collect(),
+        None => {
+            let cwd = env::current_dir().context("Could not get current directory")?;
+            search_path(&cwd, dirs::home_dir().as_deref())
+        }
+    };
+
+    // Stop at the first file found, only reporting the paths checked so far
+    let mut checked = Vec::new();
+    let mut file = None;
+    for candidate in candidates {
+        let found = match &name {
+            Some(_) => candidate.is_file() || store::holds(&candidate),
+            None => is_discoverable(&candidate),
+        };
+        if !found && quiet(&candidate) {
+            continue;
+        }
+        checked.push(Check {
+            path: candidate.clone(),
+            found,
+        });
+        if found {
+            file = Some(candidate);
+            break;
+        }
+    }
+
+    Ok(Resolution {
+        name,
+        checked,
+        file,
+    })
+}
+
+/// Get the `.env` files to look for when no environment name is given:
+/// the one in `start` and then those in its parents, up to the root of the
+/// git repository `start` is in or the home directory, whichever comes
+/// first. Outside of both, like under `/tmp`, only `start` is looked in, as
+/// anyone could have left a file in its parents.
+fn search_path(start: &Path, home: Option<&Path>) -> Vec<PathBuf> {
+    let depth = start
+        .ancestors()
+        .position(|dir| dir.join(".git").exists() || Some(dir) == home)
+        .map_or(1, |boundary| boundary + 1);
+    start
+        .ancestors()
+        .take(depth)
+        .map(|dir| dir.join(".env"))
+        .collect()
+}
+
+/// Check if a file found by looking up from the current directory can be
+/// used: it has to exist and belong to you, so nobody else can slip one in
+/// a shared directory. Files that belong to someone else are skipped,
+/// saying so.
+pub fn is_discoverable(file: &Path) -> bool {
+    if !file.is_file() {
+        return false;
+    }
+    if permissions::owned_by_other(file) {
+        note!(
+            "dotenv: Skipping {}, which is owned by another user",
+            file.display()
+        );
+        return false;
+    }
+    true
+}
+
+/// Get the directories project files are looked up in: `start` and its
+/// parents, up to the root of the git repository or the home directory,
+/// or only `start` outside of both.
+pub fn project_dirs(start: &Path) -> Vec<PathBuf> {
+    search_path(start, dirs::home_dir().as_deref())
+        .iter()
+        .filter_map(|candidate| candidate.parent().map(Path::to_path_buf))
+        .collect()
+}
+
+/// Get the layered `.env` files to load, from lowest to highest
+/// precedence: `.env`, `.env.<app_env>`, `.env.local` and
+/// `.env.<app_env>.local`. They're read from the closest directory, looking
+/// up from `start` like [`explain`] does, that has any of them. Files that
+/// don't exist are left out.
+pub fn layers(start: &Path, app_env: Option<&str>) -> Vec<PathBuf> {
+    let mut names = vec![".env".to_string()];
+    if let Some(app_env) = app_env {
+        names.push(format!(".env.{}", app_env));
+    }
+    names.push(".env.local".to_string());
+    if let Some(app_env) = app_env {
+        names.push(format!(".env.{}.local", app_env));
+    }
+
+    for dir in project_dirs(start) {
+        let found: Vec<PathBuf> = names
+            .iter()
+            .map(|name| dir.join(name))
+            .filter(|path| is_discoverable(path))
+            .collect();
+        if !found.is_empty() {
+            return found;
+        }
+    }
+
+    Vec::new()
+}
+
+/// Get the name of the environment to load and where it came from: the
+/// `--environment` flag if given, the `DOTENV` environment variable, or
+/// the environment mapped to the current directory in the global
+/// configuration file.
+pub fn selected(environment: Option<&str>) -> Result<Option<(String, Origin)>> {
+    if let Some(name) = environment {
+        return Ok(Some((name.to_string(), Origin::Flag)));
+    }
+
+    if let Some(name) = env::var(ENVIRONMENT_VAR).ok().filter(|v| !v.is_empty()) {
+        return Ok(Some((name, Origin::Variable)));
+    }
+
+    let cwd = env::current_dir().context("Could not get current directory")?;
+    let mapped = config::Config::load()?.environment_for(&cwd, dirs::home_dir().as_deref());
+    Ok(mapped.map(|(name, dir)| (name, Origin::Directory(dir))))
+}
+
+/// Find an environment file given either as a path to an existing file
+/// or as the name of an environment in the dotenv folder.
+pub fn resolve_path_or_name(target: &str) -> Result<Option<PathBuf>> {
+    let path = PathBuf::from(target);
+    if path.is_file() {
+        return Ok(Some(path));
+    }
+
+    named_file(target)
+}
+
+/// Find the named environment file in the dotenv folder, ignoring the
+/// `DOTENV` environment variable.
+pub fn named_file(name: &str) -> Result<Option<PathBuf>> {
+    if let Some(file) = locate(name)? {
+        return Ok(Some(file));
+    }
+
+    for folder in folders()? {
+        note!(
+            "Environment file does not exist in dotenv folder: {}",
+            folder.join(format!("{}.env", name)).display()
+        );
+    }
+    suggest(name);
+    Ok(None)
+}
+
+/// Find the named environment file in the first dotenv folder that has it,
+/// either on disk or in the folder's store, where it's given the path it
+/// would have on disk.
+pub fn locate(name: &str) -> Result<Option<PathBuf>> {
+    for folder in folders()? {
+        if let Some(file) = files_named(&folder, name).into_iter().find(|f| f.exists()) {
+            return Ok(Some(file));
+        }
+        if store::contains(&folder, name)? {
+            return Ok(Some(folder.join(format!("{}.env", name))));
+        }
+    }
+    Ok(None)
+}
+
+/// Get the files a named environment can be stored as in a folder, in the
+/// order they're looked for: `<name>.env`, then `<name>.env.gpg`. A name
+/// given with the `.gpg` extension is only looked for as is.
+fn files_named(folder: &Path, name: &str) -> Vec<PathBuf> {
+    if gpg::is_encrypted(Path::new(name)) {
+        return vec![folder.join(name)];
+    }
+    vec![
+        folder.join(format!("{}.env", name)),
+        folder.join(format!("{}.env.{}", name, gpg::EXTENSION)),
+    ]
+}
+
+/// Print the names of stored environments that look like the given one,
+/// if there are any.
+fn suggest(name: &str) {
+    let Ok(folders) = folders() else {
+        return;
+    };
+
+    let mut names: Vec<String> = folders.iter().flat_map(|f| names_in(f)).collect();
+    names.sort();
+    names.dedup();
+
+    let matches = similar_names(name, &names);
+    match matches.as_slice() {
+        [] => {}
+        [only] => note!("Did you mean {}?", only),
+        _ => note!("Did you mean one of: {}?", matches.join(", ")),
+    }
+}
+
+/// Get the names of the `.env` files in the given folder and its
+/// subfolders without parsing them. Unreadable folders are skipped.
+fn names_in(dir: &Path) -> Vec<String> {
+    let mut files = Vec::new();
+    let _ = walk(dir, dir, &mut files);
+    files.into_iter().map(|(name, _)| name).collect()
+}
+
+/// Find the candidates close enough to the given name to be a likely typo,
+/// closest first. At most three are returned.
+fn similar_names(name: &str, candidates: &[String]) -> Vec<String> {
+    let wanted = name.to_lowercase();
+    let threshold = (wanted.chars().count() / 3).max(1);
+
+    let mut scored: Vec<(usize, &String)> = candidates
+        .iter()
+        .map(|candidate| {
+            let distance = strsim::damerau_levenshtein(&wanted, &candidate.to_lowercase());
+            (distance, candidate)
+        })
+        .filter(|(distance, _)| *distance <= threshold)
+        .collect();
+
+    scored.sort();
+    scored
+        .into_iter()
+        .take(3)
+        .map(|(_, candidate)| candidate.clone())
+        .collect()
+}
+
+/// Get the path a named environment is stored at in the first dotenv
+/// folder, whether it exists or not. Names can be nested in folders separated by
+/// `/` (like `clients/acme/aws`), but can't be empty or point outside the
+/// folder.
+pub fn path_for(name: &str) -> Result<PathBuf> {
+    let valid = name != STDIN
+        && !name.ends_with(".env")
+        && name
+            .split('/')
+            .all(|part| !part.is_empty() && !part.starts_with('.') && !part.contains('\\'));
+
+    if !valid {
+        anyhow::bail!(
+            "Invalid environment name {:?}: use the name without the .env extension, with folders separated by /",
+            name
+        );
+    }
+
+    Ok(folder()?.join(format!("{}.env", name)))
+}
+
+/// Get the path a file really lives at, following symlinks, so the same
+/// file reached through different links is recognized as such. Paths that
+/// can't be resolved are returned as they are.
+pub fn real_path(path: &Path) -> PathBuf {
+    fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf())
+}
+
+/// Get the name of an environment file stored in the dotenv folder, with
+/// nested folders separated by `/`. Returns `None` for files outside it.
+pub fn name_of(folder: &Path, file: &Path) -> Option<String> {
+    let mut relative = file.strip_prefix(folder).ok()?.to_path_buf();
+    if gpg::is_encrypted(&relative) {
+        relative.set_extension("");
+    }
+    if relative.extension()? != "env" {
+        return None;
+    }
+
+    let stem = relative.with_extension("");
+    let mut parts = Vec::new();
+    for component in stem.components() {
+        parts.push(component.as_os_str().to_str()?.to_string());
+    }
+    Some(parts.join("/"))
+}
+
+/// Create a directory (and its parents) only accessible by the current user.
+pub fn create_private_dir(dir: &Path) -> Result<()> {
+    let mut builder = fs::DirBuilder::new();
+    builder.recursive(true);
+
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::DirBuilderExt;
+        builder.mode(0o700);
+    }
+
+    builder
+        .create(dir)
+        .with_context(|| format!("Could not create dotenv folder: {}", dir.display()))
+}
+
+/// Create the folders a nested environment file is stored in, if missing.
+pub fn create_parents(file: &Path) -> Result<()> {
+    match file.parent() {
+        Some(parent) if !parent.exists() => create_private_dir(parent),
+        _ => Ok(()),
+    }
+}
+
+/// Remove the folders a nested environment file was stored in once they're
+/// left empty, stopping at the dotenv folder it's in. Failures are ignored
+/// since the folders are harmless to keep.
+pub fn remove_empty_parents(file: &Path) {
+    let Some(root) = folders()
+        .unwrap_or_default()
+        .into_iter()
+        .find(|folder| file.starts_with(folder))
+    else {
+        return;
+    };
+
+    let mut current = file.parent();
+    while let Some(dir) = current {
+        if dir == root || !dir.starts_with(&root) || fs::remove_dir(dir).is_err() {
+            break;
+        }
+        current = dir.parent();
+    }
+}
+
+/// List all the environment files stored in the dotenv folders. When the
+/// same name exists in several folders, only the one that would be loaded
+/// is listed.
+pub fn list() -> Result<Vec<Profile>> {
+    let mut profiles: Vec<Profile> = Vec::new();
+    for folder in folders()? {
+        for profile in list_in(&folder)? {
+            if !profiles.iter().any(|p| p.name == profile.name) {
+                profiles.push(profile);
+            }
+        }
+    }
+
+    profiles.sort_by(|a, b| a.name.split('/').cmp(b.name.split('/')));
+    Ok(profiles)
+}
+
+/// List all the `.env` files in the given folder and its subfolders,
+/// sorted by name. A missing folder is treated as an empty one.
+pub fn list_in(dir: &Path) -> Result<Vec<Profile>> {
+    if !dir.exists() {
+        return Ok(Vec::new());
+    }
+
+    let mut files = Vec::new();
+    walk(dir, dir, &mut files)
+        .with_context(|| format!("Could not read dotenv folder: {}", dir.display()))?;
+
+    let mut profiles = Vec::new();
+    for (name, path) in files {
+        let content = fs::read(&path)
+            .with_context(|| format!("Failed to read .env file at {}", path.display()))?;
+        if age::is_encrypted(&content) || gpg::is_encrypted(&path) {
+            profiles.push(Profile {
+                name,
+                path,
+                variables: 0,
+                has_command: false,
+                encrypted: true,
+            });
+            continue;
+        }
+
+        // SOPS leaves the keys in plain text, so they can be listed without
+        // decrypting the file
+        let text = String::from_utf8_lossy(&content);
+        let vars = if sops::is_encrypted(&text) {
+            env_parser::parse_env_str(&text).map(|vars| {
+                vars.into_iter()
+                    .filter(|(key, _)| !sops::is_metadata(key))
+                    .collect()
+            })
+        } else {
+            env_parser::parse_env_file(&path)
+        }
+        .with_context(|| format!("Could not parse environment file: {}", path.display()))?;
+        profiles.push(Profile::parsed(name, path, &vars));
+    }
+
+    // The files on disk win over the ones in the store, like when loading
+    if store::exists(dir) {
+        for (name, content) in store::entries(dir)? {
+            if profiles.iter().any(|p| p.name == name) {
+                continue;
+            }
+            let path = dir.join(format!("{}.env", name));
+            let vars = env_parser::parse_env_str(&content)?;
+            profiles.push(Profile::parsed(name, path, &vars));
+        }
+    }
+
+    // Compare folder by folder so nested environments stay grouped
+    profiles.sort_by(|a, b| a.name.split('/').cmp(b.name.split('/')));
+    Ok(profiles)
+}
+
+/// Collect the name and path of every `.env` file under `dir`, descending
+/// into subfolders. Hidden files and folders, like the archive kept by
+/// `prune`, are skipped.
+fn walk(root: &Path, dir: &Path, found: &mut Vec<(String, PathBuf)>) -> std::io::Result<()> {
+    for entry in fs::read_dir(dir)? {
+        let entry = entry?;
+        let path = entry.path();
+        if entry.file_name().to_string_lossy().starts_with('.') {
+            continue;
+        }
+
+        // Don't follow symlinked folders, they could loop back
+        if entry.file_type()?.is_dir() {
+            walk(root, &path, found)?;
+        } else if path.is_file() {
+            if let Some(name) = name_of(root, &path) {
+                found.push((name, path));
+            }
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_list_in_finds_env_files() -> Result<()> {
+        let dir = tempdir()?;
+        fs::write(
+            dir.path().join("prod.env"),
+            "FOO=bar\nDOTENV_COMMAND=kubectl\n",
+        )?;
+        fs::write(dir.path().join("dev.env"), "# comment\nFOO=baz\n")?;
+        fs::write(dir.path().join("notes.txt"), "FOO=ignored\n")?;
+        fs::create_dir(dir.path().join("folder.env"))?;
+
+        let profiles = list_in(dir.path())?;
+        let names: Vec<&str> = profiles.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(names, vec!["dev", "prod"]);
+
+        assert_eq!(profiles[0].variables, 1);
+        assert!(!profiles[0].has_command);
+        assert_eq!(profiles[1].variables, 2);
+        assert!(profiles[1].has_command);
+        assert_eq!(profiles[1].path, dir.path().join("prod.env"));
+        Ok(())
+    }
+
+    #[test]
+    fn test_list_in_nested_folders() -> Result<()> {
+        let dir = tempdir()?;
+        fs::create_dir_all(dir.path().join("clients/acme"))?;
+        fs::create_dir_all(dir.path().join(".archive"))?;
+        fs::write(dir.path().join("clients/acme/aws.env"), "FOO=bar\n")?;
+        fs::write(dir.path().join("clients/zeta.env"), "FOO=bar\n")?;
+        fs::write(dir.path().join("clients-old.env"), "FOO=bar\n")?;
+        fs::write(dir.path().join(".archive/old.env"), "FOO=bar\n")?;
+
+        let profiles = list_in(dir.path())?;
+        let names: Vec<&str> = profiles.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(
+            names,
+            vec!["clients/acme/aws", "clients/zeta", "clients-old"]
+        );
+        assert_eq!(profiles[0].path, dir.path().join("clients/acme/aws.env"));
+        Ok(())
+    }
+
+    #[test]
+    fn test_list_in_missing_folder() -> Result<()> {
+        let dir = tempdir()?;
+        let profiles = list_in(&dir.path().join("does-not-exist"))?;
+        assert!(profiles.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_explain_named_environment() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        fs::write(dir.path().join("prod.env"), "FOO=bar\n")?;
+        env::set_var(FOLDER_PATH_VAR, dir.path());
+
+        let flag = explain(Some("prod"));
+        env::set_var(ENVIRONMENT_VAR, "missing");
+        let variable = explain(None);
+        env::remove_var(ENVIRONMENT_VAR);
+        env::remove_var(FOLDER_PATH_VAR);
+
+        let flag = flag?;
+        assert_eq!(flag.name, Some(("prod".to_string(), Origin::Flag)));
+        assert_eq!(flag.file, Some(dir.path().join("prod.env")));
+
+        let variable = variable?;
+        assert_eq!(
+            variable.name,
+            Some(("missing".to_string(), Origin::Variable))
+        );
+        assert_eq!(variable.file, None);
+        assert_eq!(
+            variable.checked,
+            vec![Check {
+                path: dir.path().join("missing.env"),
+                found: false
+            }]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_similar_names() {
+        let candidates: Vec<String> = ["prod", "production", "Dev", "staging", "prod2"]
+            .iter()
+            .map(|s| s.to_string())
+            .collect();
+
+        assert_eq!(similar_names("prodd", &candidates), vec!["prod", "prod2"]);
+        assert_eq!(similar_names("stagign", &candidates), vec!["staging"]);
+        assert_eq!(similar_names("dve", &candidates), vec!["Dev"]);
+        assert!(similar_names("qa", &candidates).is_empty());
+    }
+
+    #[test]
+    fn test_names_in_skips_other_files() -> Result<()> {
+        let dir = tempdir()?;
+        fs::write(dir.path().join("prod.env"), "FOO=bar\n")?;
+        fs::write(dir.path().join("broken.env"), "not valid\n")?;
+        fs::write(dir.path().join("notes.txt"), "FOO=ignored\n")?;
+
+        let mut names = names_in(dir.path());
+        names.sort();
+        assert_eq!(names, vec!["broken", "prod"]);
+        assert!(names_in(&dir.path().join("missing")).is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_search_path_stops_at_git_root() -> Result<()> {
+        let dir = tempdir()?;
+        let nested = dir.path().join("project/src/deep");
+        fs::create_dir_all(&nested)?;
+        fs::create_dir(dir.path().join("project/.git"))?;
+
+        assert_eq!(
+            search_path(&nested, None),
+            vec![
+                nested.join(".env"),
+                dir.path().join("project/src/.env"),
+                dir.path().join("project/.env"),
+            ]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_search_path_stops_at_home() -> Result<()> {
+        let dir = tempdir()?;
+        let nested = dir.path().join("code");
+        fs::create_dir_all(&nested)?;
+
+        assert_eq!(
+            search_path(&nested, Some(dir.path())),
+            vec![nested.join(".env"), dir.path().join(".env")]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_search_path_outside_home_and_repository() -> Result<()> {
+        let dir = tempdir()?;
+        let nested = dir.path().join("shared/tmp");
+        fs::create_dir_all(&nested)?;
+
+        assert_eq!(
+            search_path(&nested, Some(Path::new("/nonexistent/home"))),
+            vec![nested.join(".env")]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_layers_order() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        for name in [
+            ".env",
+            ".env.local",
+            ".env.production",
+            ".env.production.local",
+            ".env.test",
+        ] {
+            fs::write(dir.path().join(name), "FOO=bar\n")?;
+        }
+
+        assert_eq!(
+            layers(dir.path(), Some("production")),
+            vec![
+                dir.path().join(".env"),
+                dir.path().join(".env.production"),
+                dir.path().join(".env.local"),
+                dir.path().join(".env.production.local"),
+            ]
+        );
+        assert_eq!(
+            layers(dir.path(), None),
+            vec![dir.path().join(".env"), dir.path().join(".env.local")]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_layers_from_parent_directory() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        let nested = dir.path().join("src");
+        fs::create_dir(&nested)?;
+        fs::create_dir(dir.path().join(".git"))?;
+        fs::write(dir.path().join(".env.local"), "FOO=bar\n")?;
+
+        assert_eq!(layers(&nested, None), vec![dir.path().join(".env.local")]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_explain_stdin() -> Result<()> {
+        let _env = crate::lock_env();
+        env::set_var(ENVIRONMENT_VAR, STDIN);
+        let variable = explain(None);
+        env::remove_var(ENVIRONMENT_VAR);
+
+        let variable = variable?;
+        assert!(variable.is_stdin());
+        assert!(variable.checked.is_empty());
+        assert_eq!(variable.file, None);
+
+        assert!(explain(Some(STDIN))?.is_stdin());
+        assert!(resolve(Some(STDIN)).is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_path_for_rejects_invalid_names() {
+        for name in [
+            "",
+            "../escape",
+            "a/../b",
+            "a//b",
+            "a/",
+            "/abs",
+            "a/.hidden",
+            ".hidden",
+            "prod.env",
+            "a\\b",
+            STDIN,
+        ] {
+            assert!(path_for(name).is_err(), "{:?} should be rejected", name);
+        }
+    }
+
+    #[test]
+    fn test_path_for_nested_names() -> Result<()> {
+        let _env = crate::lock_env();
+        env::set_var(FOLDER_PATH_VAR, "/tmp/some-dotenv-folder");
+        let result = path_for("clients/acme/aws");
+        env::remove_var(FOLDER_PATH_VAR);
+        assert_eq!(
+            result?,
+            PathBuf::from("/tmp/some-dotenv-folder/clients/acme/aws.env")
+        );
+        Ok(())
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_real_path_follows_symlinks() -> Result<()> {
+        let dir = tempdir()?;
+        let target = dir.path().join("prod.env");
+        fs::write(&target, "FOO=bar\n")?;
+        std::os::unix::fs::symlink(&target, dir.path().join(".env"))?;
+
+        assert_eq!(real_path(&dir.path().join(".env")), real_path(&target));
+        assert_eq!(
+            real_path(&dir.path().join("missing.env")),
+            dir.path().join("missing.env")
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_name_of() {
+        let folder = Path::new("/home/user/.dotenv");
+        assert_eq!(
+            name_of(folder, &folder.join("work/kubectl.env")),
+            Some("work/kubectl".to_string())
+        );
+        assert_eq!(
+            name_of(folder, &folder.join("prod.env")),
+            Some("prod".to_string())
+        );
+        assert_eq!(
+            name_of(folder, &folder.join("prod.env.gpg")),
+            Some("prod".to_string())
+        );
+        assert_eq!(name_of(folder, &folder.join("notes.txt")), None);
+        assert_eq!(name_of(folder, &folder.join("notes.txt.gpg")), None);
+        assert_eq!(name_of(folder, Path::new("/elsewhere/prod.env")), None);
+    }
+
+    #[test]
+    fn test_folders_split_like_path() -> Result<()> {
+        let _env = crate::lock_env();
+        let dir = tempdir()?;
+        let personal = dir.path().join("personal");
+        let shared = dir.path().join("shared");
+        fs::create_dir_all(&personal)?;
+        fs::create_dir_all(&shared)?;
+        fs::write(personal.join("prod.env"), "FOO=mine\n")?;
+        fs::write(shared.join("prod.env"), "FOO=theirs\n")?;
+        fs::write(shared.join("team.env"), "FOO=theirs\n")?;
+
+        let value = env::join_paths([&personal, &shared])?;
+        env::set_var(FOLDER_PATH_VAR, &value);
+        let all = folders();
+        let primary = folder();
+        let team = explain(Some("team"));
+        let listed = list();
+        env::remove_var(FOLDER_PATH_VAR);
+
+        assert_eq!(all?, vec![personal.clone(), shared.clone()]);
+        assert_eq!(primary?, personal);
+
+        let team = team?;
+        assert_eq!(team.file, Some(shared.join("team.env")));
+        assert_eq!(team.checked.len(), 2);
+
+        let listed = listed?;
+        let paths: Vec<&Path> = listed.iter().map(|p| p.path.as_path()).collect();
+        assert_eq!(
+            paths,
+            vec![personal.join("prod.env"), shared.join("team.env")]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_folder_honors_env_var() -> Result<()> {
+        let _env = crate::lock_env();
+        env::set_var(FOLDER_PATH_VAR, "/tmp/some-dotenv-folder");
+        let result = folder();
+        env::remove_var(FOLDER_PATH_VAR);
+        assert_eq!(result?, PathBuf::from("/tmp/some-dotenv-folder"));
+        Ok(())
+    }
+}
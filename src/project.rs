@@ -0,0 +1,170 @@
+use anyhow::{Context, Result};
+use serde::Deserialize;
+use std::{
+    collections::BTreeMap,
+    fs,
+    path::{Path, PathBuf},
+};
+
+use crate::profiles;
+
+/// Name of the project configuration file, looked up in the current
+/// directory and its parents.
+pub const CONFIG_FILE: &str = ".dotenv.yaml";
+
+/// Settings shared by everyone working on a project, read from a YAML file
+/// like:
+///
+/// ```yaml
+/// files: [.env, .env.shared]
+/// command: [npm, run, dev]
+/// strict: true
+/// passthrough: [SSH_AUTH_SOCK]
+/// pre_command: aws sso login --profile dev
+/// processes:
+///   web: npm run dev
+///   worker: npm run worker
+/// ```
+#[derive(Deserialize, Debug, Default, PartialEq)]
+#[serde(deny_unknown_fields)]
+pub struct Config {
+    /// Environment files to load, relative to the configuration file, each
+    /// overriding the variables of the previous ones.
+    #[serde(default)]
+    pub files: Vec<PathBuf>,
+
+    /// Command to run when none is given.
+    #[serde(default)]
+    pub command: Vec<String>,
+
+    /// Whether strict mode is always enabled.
+    #[serde(default)]
+    pub strict: bool,
+
+    /// Extra variables kept from your shell in strict mode.
+    #[serde(default)]
+    pub passthrough: Vec<String>,
+
+    /// Script run through the shell before the command, unless the
+    /// environment file sets its own.
+    #[serde(default)]
+    pub pre_command: Option<String>,
+
+    /// Script run through the shell once the command exits, unless the
+    /// environment file sets its own.
+    #[serde(default)]
+    pub post_command: Option<String>,
+
+    /// Script run through the shell when the command exits with a non-zero
+    /// status, unless the environment file sets its own.
+    #[serde(default)]
+    pub on_failure: Option<String>,
+
+    /// Long-running processes run at once by `dotenv start`, keyed by their
+    /// names, instead of the ones in a Procfile.
+    #[serde(default)]
+    pub processes: BTreeMap<String, String>,
+}
+
+/// A project configuration file along with the folder it applies to.
+#[derive(Debug)]
+pub struct Project {
+    pub path: PathBuf,
+    pub config: Config,
+}
+
+impl Project {
+    /// Find the closest project configuration file, looking up from
+    /// `start` the same way `.env` files are found.
+    pub fn find(start: &Path) -> Result<Option<Self>> {
+        for dir in profiles::project_dirs(start) {
+            let path = dir.join(CONFIG_FILE);
+            if profiles::is_discoverable(&path) {
+                return Self::load(&path).map(Some);
+            }
+        }
+        Ok(None)
+    }
+
+    /// Read a project configuration file.
+    pub fn load(path: &Path) -> Result<Self> {
+        let content = fs::read_to_string(path)
+            .with_context(|| format!("Failed to read project file at {}", path.display()))?;
+
+        let config = serde_yaml::from_str(&content)
+            .with_context(|| format!("Could not parse project file: {}", path.display()))?;
+
+        Ok(Self {
+            path: path.to_path_buf(),
+            config,
+        })
+    }
+
+    /// Get the environment files to load, resolved relative to the folder
+    /// of the configuration file. Files that don't exist are left out, so
+    /// optional ones like `.env.local` can be listed too.
+    pub fn files(&self) -> Vec<PathBuf> {
+        let root = self.path.parent().unwrap_or_else(|| Path::new("."));
+        self.config
+            .files
+            .iter()
+            .map(|file| root.join(file))
+            .filter(|file| file.is_file())
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_find_in_parent_directory() -> Result<()> {
+        let dir = tempdir()?;
+        let nested = dir.path().join("src");
+        fs::create_dir(&nested)?;
+        fs::create_dir(dir.path().join(".git"))?;
+        fs::write(
+            dir.path().join(CONFIG_FILE),
+            "files: [.env, .env.local]\ncommand: [npm, start]\nstrict: true\npassthrough:\n  - SSH_AUTH_SOCK\n",
+        )?;
+        fs::write(dir.path().join(".env"), "FOO=bar\n")?;
+
+        let project = Project::find(&nested)?.expect("project file should be found");
+        assert_eq!(project.path, dir.path().join(CONFIG_FILE));
+        assert_eq!(project.config.command, vec!["npm", "start"]);
+        assert!(project.config.strict);
+        assert_eq!(project.config.passthrough, vec!["SSH_AUTH_SOCK"]);
+        assert_eq!(project.files(), vec![dir.path().join(".env")]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_find_without_project_file() -> Result<()> {
+        let dir = tempdir()?;
+        fs::create_dir(dir.path().join(".git"))?;
+        assert!(Project::find(dir.path())?.is_none());
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_hooks() -> Result<()> {
+        let dir = tempdir()?;
+        let path = dir.path().join(CONFIG_FILE);
+        fs::write(&path, "pre_command: aws sso login\n")?;
+        let project = Project::load(&path)?;
+        assert_eq!(project.config.pre_command.as_deref(), Some("aws sso login"));
+        assert!(project.config.post_command.is_none());
+        Ok(())
+    }
+
+    #[test]
+    fn test_load_rejects_unknown_fields() -> Result<()> {
+        let dir = tempdir()?;
+        let path = dir.path().join(CONFIG_FILE);
+        fs::write(&path, "strikt: true\n")?;
+        assert!(Project::load(&path).is_err());
+        Ok(())
+    }
+}
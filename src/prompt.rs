@@ -0,0 +1,100 @@
+use anyhow::Result;
+use std::io::{self, BufRead, IsTerminal, Write};
+
+/// Ask a yes/no question on the terminal, defaulting to "no". When standard
+/// input isn't a terminal there's nobody to answer, so an error asking for
+/// `--force` is returned instead.
+pub fn confirm(question: &str) -> Result<bool> {
+    if !io::stdin().is_terminal() {
+        anyhow::bail!("{} Refusing to continue without --force", question);
+    }
+
+    eprint!("{} [y/N] ", question);
+    io::stderr().flush()?;
+
+    let mut answer = String::new();
+    io::stdin().lock().read_line(&mut answer)?;
+    Ok(is_yes(&answer))
+}
+
+/// Ask for a value on the terminal, returning the answer without its line
+/// break. With `hidden`, what's typed isn't echoed back, for secrets.
+pub fn ask(question: &str, hidden: bool) -> Result<String> {
+    eprint!("{} ", question);
+    io::stderr().flush()?;
+
+    let mut answer = String::new();
+    {
+        let _echo = if hidden { EchoOff::enable() } else { None };
+        io::stdin().lock().read_line(&mut answer)?;
+    }
+    if hidden {
+        // The line break typed wasn't echoed either
+        eprintln!();
+    }
+
+    Ok(answer.trim_end_matches(['\r', '\n']).to_string())
+}
+
+/// Turns off echoing on the terminal while alive, restoring the previous
+/// settings when dropped.
+struct EchoOff {
+    #[cfg(unix)]
+    previous: libc::termios,
+}
+
+impl EchoOff {
+    /// Turn off echoing, returning `None` if the terminal settings can't be
+    /// changed.
+    #[cfg(unix)]
+    fn enable() -> Option<Self> {
+        // SAFETY: termios is a plain C struct filled in by tcgetattr
+        // before being read
+        unsafe {
+            let mut previous: libc::termios = std::mem::zeroed();
+            if libc::tcgetattr(libc::STDIN_FILENO, &mut previous) != 0 {
+                return None;
+            }
+            let mut silent = previous;
+            silent.c_lflag &= !libc::ECHO;
+            if libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &silent) != 0 {
+                return None;
+            }
+            Some(Self { previous })
+        }
+    }
+
+    #[cfg(not(unix))]
+    fn enable() -> Option<Self> {
+        None
+    }
+}
+
+#[cfg(unix)]
+impl Drop for EchoOff {
+    fn drop(&mut self) {
+        // SAFETY: restores the settings read by tcgetattr in enable
+        unsafe {
+            libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &self.previous);
+        }
+    }
+}
+
+/// Check if an answer to a prompt means "yes".
+fn is_yes(answer: &str) -> bool {
+    matches!(answer.trim().to_lowercase().as_str(), "y" | "yes")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_yes() {
+        assert!(is_yes("y\n"));
+        assert!(is_yes(" YES "));
+        assert!(!is_yes("\n"));
+        assert!(!is_yes("no"));
+        assert!(!is_yes("yep"));
+    }
+}
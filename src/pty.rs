@@ -0,0 +1,183 @@
+use anyhow::Result;
+use std::process::Command;
+
+#[cfg(unix)]
+use anyhow::Context;
+#[cfg(unix)]
+use std::{
+    fs::File,
+    io::{self, Read, Write},
+    os::fd::{AsRawFd, FromRawFd, OwnedFd},
+    process::Stdio,
+    thread::{self, JoinHandle},
+};
+
+/// The end of a pseudo-terminal kept by dotenv while the command runs on
+/// the other end.
+#[cfg(unix)]
+pub struct Pty {
+    master: File,
+}
+
+/// Copies the command's output to standard output until the
+/// pseudo-terminal closes, keeping the terminal in raw mode meanwhile.
+#[cfg(unix)]
+pub struct Relay {
+    output: JoinHandle<()>,
+    raw: Option<RawMode>,
+}
+
+/// Run the command on a new pseudo-terminal, sized like the terminal
+/// dotenv runs in, if any. Its standard streams are all attached to the
+/// pseudo-terminal and it becomes the leader of a new session, so the
+/// pseudo-terminal is its controlling terminal.
+#[cfg(unix)]
+pub fn attach(cmd: &mut Command) -> Result<Pty> {
+    use std::os::unix::process::CommandExt;
+
+    let (mut master, mut slave) = (0, 0);
+    // SAFETY: winsize is a plain C struct filled in by ioctl, and openpty
+    // writes the two descriptors it opens
+    let opened = unsafe {
+        let mut size: libc::winsize = std::mem::zeroed();
+        let mut size =
+            (libc::ioctl(libc::STDIN_FILENO, libc::TIOCGWINSZ, &mut size) == 0).then_some(size);
+        libc::openpty(
+            &mut master,
+            &mut slave,
+            std::ptr::null_mut(),
+            std::ptr::null_mut(),
+            size.as_mut().map_or(std::ptr::null_mut(), |s| s as *mut _),
+        )
+    };
+    if opened != 0 {
+        return Err(io::Error::last_os_error()).context("Could not open a pseudo-terminal");
+    }
+
+    // SAFETY: openpty succeeded, so both descriptors are open and owned here
+    let (master, slave) = unsafe { (File::from_raw_fd(master), OwnedFd::from_raw_fd(slave)) };
+    cmd.stdin(Stdio::from(slave.try_clone()?));
+    cmd.stdout(Stdio::from(slave.try_clone()?));
+    cmd.stderr(Stdio::from(slave));
+
+    // SAFETY: the closure runs in the child before exec and only calls
+    // async-signal-safe functions
+    unsafe {
+        cmd.pre_exec(|| {
+            if libc::setsid() == -1 || libc::ioctl(libc::STDIN_FILENO, libc::TIOCSCTTY, 0) == -1 {
+                return Err(io::Error::last_os_error());
+            }
+            Ok(())
+        });
+    }
+
+    Ok(Pty { master })
+}
+
+#[cfg(unix)]
+impl Pty {
+    /// Start copying standard input to the command and its output to
+    /// standard output. Call once the command started, so the copies of
+    /// the pseudo-terminal the command got are the only ones left open.
+    pub fn relay(self) -> Result<Relay> {
+        crate::signals::resize_with(self.master.as_raw_fd());
+        let raw = RawMode::enable();
+
+        let mut input = self.master.try_clone()?;
+        thread::spawn(move || {
+            let _ = io::copy(&mut io::stdin().lock(), &mut input);
+            // Standard input ended, so let the command know with an
+            // end-of-file character, like typing Ctrl-D
+            let _ = input.write_all(&[0x04]);
+        });
+
+        let mut output = self.master;
+        let output = thread::spawn(move || {
+            let mut stdout = io::stdout();
+            let mut buf = [0u8; 8192];
+            // Reading fails with EIO once the command and everything it
+            // started closed the pseudo-terminal
+            while let Ok(read) = output.read(&mut buf) {
+                if read == 0 || stdout.write_all(&buf[..read]).is_err() {
+                    break;
+                }
+                let _ = stdout.flush();
+            }
+        });
+
+        Ok(Relay { output, raw })
+    }
+}
+
+#[cfg(unix)]
+impl Relay {
+    /// Wait for the remaining output to be copied and restore the terminal.
+    pub fn finish(self) {
+        let _ = self.output.join();
+        drop(self.raw);
+    }
+}
+
+/// Puts the terminal dotenv runs in in raw mode while alive, so every key
+/// typed reaches the command as is, restoring the previous settings when
+/// dropped.
+#[cfg(unix)]
+struct RawMode {
+    previous: libc::termios,
+}
+
+#[cfg(unix)]
+impl RawMode {
+    /// Enable raw mode, returning `None` if standard input isn't a
+    /// terminal.
+    fn enable() -> Option<Self> {
+        // SAFETY: termios is a plain C struct filled in by tcgetattr
+        // before being read
+        unsafe {
+            let mut previous: libc::termios = std::mem::zeroed();
+            if libc::tcgetattr(libc::STDIN_FILENO, &mut previous) != 0 {
+                return None;
+            }
+            let mut raw = previous;
+            libc::cfmakeraw(&mut raw);
+            if libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &raw) != 0 {
+                return None;
+            }
+            Some(Self { previous })
+        }
+    }
+}
+
+#[cfg(unix)]
+impl Drop for RawMode {
+    fn drop(&mut self) {
+        // SAFETY: restores the settings read by tcgetattr in enable
+        unsafe {
+            libc::tcsetattr(libc::STDIN_FILENO, libc::TCSANOW, &self.previous);
+        }
+    }
+}
+
+/// Pseudo-terminals are only supported on Unix.
+#[cfg(not(unix))]
+pub struct Pty;
+
+#[cfg(not(unix))]
+pub struct Relay;
+
+#[cfg(not(unix))]
+pub fn attach(_cmd: &mut Command) -> Result<Pty> {
+    anyhow::bail!("--tty is only supported on Unix")
+}
+
+#[cfg(not(unix))]
+impl Pty {
+    pub fn relay(self) -> Result<Relay> {
+        Ok(Relay)
+    }
+}
+
+#[cfg(not(unix))]
+impl Relay {
+    pub fn finish(self) {}
+}
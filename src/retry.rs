@@ -0,0 +1,134 @@
+use std::time::Duration;
+
+/// When to run the command again after it exits with a non-zero status,
+/// and how long to wait in between.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Policy {
+    /// How many times the command is run again at most.
+    pub retries: u32,
+    /// How long to wait before running the command again the first time.
+    pub delay: Duration,
+    /// How much longer each wait is than the previous one.
+    pub backoff: f64,
+    /// The longest wait between two attempts.
+    pub max_delay: Duration,
+    /// The exit statuses worth running the command again for, or empty
+    /// for every non-zero one.
+    pub on: Vec<i32>,
+    /// Whether to also run the command again when it's killed by a signal.
+    pub killed: bool,
+}
+
+/// How long supervised commands wait before their first restart.
+const RESTART_DELAY: Duration = Duration::from_secs(1);
+
+/// The longest supervised commands wait before restarting.
+const MAX_RESTART_DELAY: Duration = Duration::from_secs(30);
+
+impl Policy {
+    /// The policy of `--supervise`: restart the command whenever it exits
+    /// abnormally, up to `max_restarts` times, doubling the wait between
+    /// restarts up to 30 seconds.
+    pub fn supervise(max_restarts: u32) -> Self {
+        Self {
+            retries: max_restarts,
+            delay: RESTART_DELAY,
+            backoff: 2.0,
+            max_delay: MAX_RESTART_DELAY,
+            on: Vec::new(),
+            killed: true,
+        }
+    }
+
+    /// Whether to run the command again after the given attempt, counting
+    /// from 1, exited with `code`, or `None` if it was killed by a signal.
+    pub fn should_retry(&self, attempt: u32, code: Option<i32>) -> bool {
+        let failed = match code {
+            Some(code) => code != 0 && (self.on.is_empty() || self.on.contains(&code)),
+            None => self.killed,
+        };
+        failed && attempt <= self.retries
+    }
+
+    /// How long to wait after the given attempt, counting from 1, before
+    /// running the command again.
+    pub fn delay(&self, attempt: u32) -> Duration {
+        let factor = self.backoff.powi(attempt.saturating_sub(1) as i32);
+        Duration::try_from_secs_f64(self.delay.as_secs_f64() * factor)
+            .unwrap_or(Duration::MAX)
+            .min(self.max_delay)
+    }
+}
+
+/// Parse a backoff factor, which can't make the waits shorter.
+pub fn parse_backoff(value: &str) -> Result<f64, String> {
+    match value.trim().parse::<f64>() {
+        Ok(factor) if factor.is_finite() && factor >= 1.0 => Ok(factor),
+        _ => Err(format!(
+            "invalid backoff {:?}, expected a number of at least 1 (e.g. 2 to double the delay)",
+            value
+        )),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn policy(on: Vec<i32>) -> Policy {
+        Policy {
+            retries: 2,
+            delay: Duration::from_secs(1),
+            backoff: 2.0,
+            max_delay: Duration::MAX,
+            on,
+            killed: false,
+        }
+    }
+
+    #[test]
+    fn test_should_retry() {
+        let any = policy(vec![]);
+        assert!(any.should_retry(1, Some(1)));
+        assert!(any.should_retry(2, Some(75)));
+        assert!(!any.should_retry(3, Some(1)));
+        assert!(!any.should_retry(1, Some(0)));
+        assert!(!any.should_retry(1, None));
+
+        let some = policy(vec![75, 124]);
+        assert!(some.should_retry(1, Some(75)));
+        assert!(some.should_retry(1, Some(124)));
+        assert!(!some.should_retry(1, Some(1)));
+    }
+
+    #[test]
+    fn test_supervise_restarts_killed_commands() {
+        let policy = Policy::supervise(3);
+        assert!(policy.should_retry(1, None));
+        assert!(policy.should_retry(3, Some(2)));
+        assert!(!policy.should_retry(4, None));
+        assert!(!policy.should_retry(1, Some(0)));
+    }
+
+    #[test]
+    fn test_delay_backs_off() {
+        let policy = policy(vec![]);
+        assert_eq!(policy.delay(1), Duration::from_secs(1));
+        assert_eq!(policy.delay(2), Duration::from_secs(2));
+        assert_eq!(policy.delay(3), Duration::from_secs(4));
+
+        let supervised = Policy::supervise(10);
+        assert_eq!(supervised.delay(5), Duration::from_secs(16));
+        assert_eq!(supervised.delay(6), Duration::from_secs(30));
+        assert_eq!(supervised.delay(100), Duration::from_secs(30));
+    }
+
+    #[test]
+    fn test_parse_backoff() {
+        assert_eq!(parse_backoff("2"), Ok(2.0));
+        assert_eq!(parse_backoff("1.5"), Ok(1.5));
+        assert!(parse_backoff("0.5").is_err());
+        assert!(parse_backoff("fast").is_err());
+        assert!(parse_backoff("inf").is_err());
+    }
+}
@@ -0,0 +1,96 @@
+use anyhow::Result;
+use std::{collections::HashMap, thread};
+
+use crate::{akv, aws_sm, bw, exec, gcpsm, k8s, keyring, kms, op, plugin, ssm, totp, vault};
+
+/// Prefixes of the values dotenv resolves itself, which resolvers on `PATH`
+/// can't take over.
+const BUILTIN_PREFIXES: &[&str] = &[
+    kms::PREFIX,
+    vault::PREFIX,
+    aws_sm::PREFIX,
+    ssm::PREFIX,
+    ssm::PATH_PREFIX,
+    gcpsm::PREFIX,
+    akv::PREFIX,
+    op::PREFIX,
+    bw::PREFIX,
+    keyring::PREFIX,
+    k8s::PREFIX,
+    exec::PREFIX,
+    totp::PREFIX,
+];
+
+/// Replace, in place, the values that stand for secrets kept elsewhere,
+/// like `kms:...`, `vault:...` or `ssm:...`, with the secrets themselves, so
+/// they only ever exist in memory. Prefixes dotenv doesn't know are left to
+/// the `dotenv-resolver-*` executables on `PATH`. The values computed with
+/// `exec:` come last, so their commands see every other secret.
+pub fn resolve(vars: &mut HashMap<String, String>) -> Result<()> {
+    // Found before anything is resolved, so a secret that happens to look
+    // like a reference isn't resolved again
+    let pending = plugin::references(vars);
+    let commands = exec::commands(vars);
+    totp::resolve_values(vars)?;
+    kms::decrypt_values(vars)?;
+    vault::resolve_values(vars)?;
+    aws_sm::resolve_values(vars)?;
+    ssm::resolve_values(vars)?;
+    gcpsm::resolve_values(vars)?;
+    akv::resolve_values(vars)?;
+    op::resolve_values(vars)?;
+    bw::resolve_values(vars)?;
+    keyring::resolve_values(vars)?;
+    plugin::resolve_values(vars, pending)?;
+    exec::resolve_values(vars, commands)
+}
+
+/// Check if a value has one of the prefixes dotenv resolves itself, like
+/// `vault:`, in any of its forms, so `op:` is as much 1Password's as `op://`.
+pub fn is_builtin(value: &str) -> bool {
+    let scheme = |prefix: &'static str| prefix.split(':').next().unwrap_or(prefix);
+    let Some((value_scheme, _)) = value.split_once(':') else {
+        return false;
+    };
+    BUILTIN_PREFIXES
+        .iter()
+        .any(|prefix| scheme(prefix) == value_scheme)
+}
+
+/// Fetch each of the secrets referenced once, however many values
+/// reference it, fetching them all at the same time.
+pub fn fetch_all<'a, T: Send>(
+    ids: impl IntoIterator<Item = &'a str>,
+    fetch: impl Fn(&str) -> Result<T> + Sync,
+) -> HashMap<&'a str, Result<T>> {
+    let mut ids: Vec<&str> = ids.into_iter().collect();
+    ids.sort();
+    ids.dedup();
+
+    let fetch = &fetch;
+    thread::scope(|scope| {
+        let fetches: Vec<_> = ids
+            .into_iter()
+            .map(|id| (id, scope.spawn(move || fetch(id))))
+            .collect();
+        fetches
+            .into_iter()
+            .map(|(id, fetch)| (id, fetch.join().expect("fetching a secret panicked")))
+            .collect()
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_builtin() {
+        assert!(is_builtin("vault:secret/db#password"));
+        assert!(is_builtin("op://vault/item/field"));
+        assert!(is_builtin("ssm-path:/app/"));
+        assert!(!is_builtin("foo:bar"));
+        assert!(!is_builtin("localhost:5432"));
+        assert!(!is_builtin("hunter2"));
+    }
+}
@@ -0,0 +1,94 @@
+use anyhow::{Context, Result};
+use std::{
+    fs,
+    io::ErrorKind,
+    path::{Path, PathBuf},
+};
+
+/// Read a directory holding a secret per file, like the `/run/secrets` that
+/// Docker and Podman mount secrets in, giving a variable for each file
+/// named after it, along with the file. A directory that doesn't exist
+/// gives no variables, so the same command works outside the container.
+pub fn load(dir: &Path, prefix: Option<&str>) -> Result<Vec<(String, String, PathBuf)>> {
+    let entries = match fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(err) => {
+            return Err(err)
+                .with_context(|| format!("Could not read secrets from {}", dir.display()))
+        }
+    };
+
+    let mut secrets = Vec::new();
+    for entry in entries {
+        let path = entry
+            .with_context(|| format!("Could not read secrets from {}", dir.display()))?
+            .path();
+        // Hidden files, like the ones Kubernetes keeps its versions of the
+        // secrets in, aren't secrets themselves
+        let Some(name) = path.file_name().and_then(|name| name.to_str()) else {
+            continue;
+        };
+        if name.starts_with('.') || !path.is_file() {
+            continue;
+        }
+
+        let secret = fs::read_to_string(&path)
+            .with_context(|| format!("Could not read the secret in {}", path.display()))?;
+        // Secrets written with echo or an editor end with a line break
+        let secret = secret.strip_suffix('\n').unwrap_or(&secret);
+        let secret = secret.strip_suffix('\r').unwrap_or(secret);
+        let variable = format!("{}{}", prefix.unwrap_or_default(), variable_name(name));
+        secrets.push((variable, secret.to_string(), path));
+    }
+    secrets.sort();
+    Ok(secrets)
+}
+
+/// Name the variable for a secret after its file, in uppercase and with
+/// anything but letters and digits turned into underscores, so `db-password`
+/// gives `DB_PASSWORD`.
+fn variable_name(file_name: &str) -> String {
+    file_name
+        .chars()
+        .map(|c| match c {
+            c if c.is_ascii_alphanumeric() => c.to_ascii_uppercase(),
+            _ => '_',
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_variable_name() {
+        assert_eq!(variable_name("db-password"), "DB_PASSWORD");
+        assert_eq!(variable_name("API_TOKEN"), "API_TOKEN");
+        assert_eq!(variable_name("tls.key"), "TLS_KEY");
+    }
+
+    #[test]
+    fn test_load() -> Result<()> {
+        let dir = tempdir()?;
+        fs::write(dir.path().join("db-password"), "hunter2\n")?;
+        fs::write(dir.path().join("api_token"), "abc123")?;
+        fs::write(dir.path().join(".hidden"), "skipped")?;
+        fs::create_dir(dir.path().join("..data"))?;
+
+        let secrets = load(dir.path(), Some("APP_"))?;
+        let names: Vec<(&str, &str)> = secrets
+            .iter()
+            .map(|(name, secret, _)| (name.as_str(), secret.as_str()))
+            .collect();
+        assert_eq!(
+            names,
+            vec![("APP_API_TOKEN", "abc123"), ("APP_DB_PASSWORD", "hunter2")]
+        );
+
+        assert!(load(&dir.path().join("missing"), None)?.is_empty());
+        Ok(())
+    }
+}
@@ -0,0 +1,235 @@
+use crate::{
+    age::IDENTITY_VAR,
+    extends::{EXTENDS_VAR, INHERIT_VAR},
+    markers::{FILE_VAR, HASH_VAR, KEYS_VAR, PROFILE_VAR},
+    mask::REDACT_VAR,
+    output::{QUIET_LOG_VAR, QUIET_VAR},
+    permissions::SECURE_VAR,
+    pin::VERIFY_VAR,
+    plugin::TIMEOUT_VAR,
+    profiles::{APP_ENV_VAR, COMMAND_VAR, ENVIRONMENT_VAR, FOLDER_PATH_VAR},
+    trust::TRUST_VAR,
+};
+
+/// Where a setting is read from.
+#[derive(Debug, PartialEq, Clone, Copy)]
+pub enum Scope {
+    /// The environment `dotenv` itself runs in, like your shell.
+    Process,
+    /// The contents of an environment file.
+    File,
+    /// Not read at all, but set by dotenv in the command's environment.
+    Child,
+}
+
+/// A variable, usually named `DOTENV_*`, that changes how dotenv behaves.
+#[derive(Debug)]
+pub struct Setting {
+    pub name: &'static str,
+    pub scope: Scope,
+    pub description: &'static str,
+}
+
+/// Every setting dotenv understands. This is the list used by `doctor` to
+/// spot unknown settings and by `man` to document them.
+pub static SETTINGS: &[Setting] = &[
+    Setting {
+        name: ENVIRONMENT_VAR,
+        scope: Scope::Process,
+        description: "Name of the environment to load when --environment is not given.",
+    },
+    Setting {
+        name: FOLDER_PATH_VAR,
+        scope: Scope::Process,
+        description: "Folders where named environment files are looked up, instead of ~/.dotenv, separated like PATH entries. New environments go in the first one.",
+    },
+    Setting {
+        name: QUIET_VAR,
+        scope: Scope::Process,
+        description: "When truthy, enables quiet mode as if --quiet was given.",
+    },
+    Setting {
+        name: SECURE_VAR,
+        scope: Scope::Process,
+        description: "When truthy, enables secure mode as if --secure was given.",
+    },
+    Setting {
+        name: VERIFY_VAR,
+        scope: Scope::Process,
+        description: "When truthy, refuses to run if the environment files changed since they were pinned, as if --verify was given.",
+    },
+    Setting {
+        name: TRUST_VAR,
+        scope: Scope::Process,
+        description: "When \"commands\", only the files outside the dotenv folders running commands must be allowed with `dotenv allow`, instead of all of them.",
+    },
+    Setting {
+        name: TIMEOUT_VAR,
+        scope: Scope::Process,
+        description: "How long a dotenv-resolver-* executable can take to resolve a value before it's killed, 30s by default.",
+    },
+    Setting {
+        name: REDACT_VAR,
+        scope: Scope::Process,
+        description: "A regular expression whose matches are masked in dotenv's messages and listings, besides the `redact` patterns of the global configuration.",
+    },
+    Setting {
+        name: QUIET_LOG_VAR,
+        scope: Scope::Process,
+        description: "File where dotenv's own messages are appended while in quiet mode.",
+    },
+    Setting {
+        name: "DOTENV_NO_OVERRIDE",
+        scope: Scope::Process,
+        description: "When truthy, enables no-override mode as if --no-override was given.",
+    },
+    Setting {
+        name: "DOTENV_DEBUG",
+        scope: Scope::Process,
+        description: "When truthy, logs each variable injected into the command and where it came from, masking sensitive values unless --reveal-secrets is given.",
+    },
+    Setting {
+        name: "DOTENV_NESTED",
+        scope: Scope::Process,
+        description: "What to do when running under another dotenv: merge, override or error, as with --nested.",
+    },
+    Setting {
+        name: "DOTENV_SHELL",
+        scope: Scope::Process,
+        description: "Shell running the scripts given with --shell, instead of $SHELL.",
+    },
+    Setting {
+        name: IDENTITY_VAR,
+        scope: Scope::Process,
+        description: "Path to the age identity file used to decrypt encrypted environment files.",
+    },
+    Setting {
+        name: "DOTENV_UMASK",
+        scope: Scope::Process,
+        description: "Umask the command runs with, in octal, when --umask is not given.",
+    },
+    Setting {
+        name: APP_ENV_VAR,
+        scope: Scope::Process,
+        description: "Application environment whose layered files are loaded with --layered.",
+    },
+    Setting {
+        name: "DOTENV_STRICT",
+        scope: Scope::File,
+        description: "When truthy, enables strict mode as if --strict was given; \"sane\" selects --strict=sane.",
+    },
+    Setting {
+        name: "DOTENV_KEEP",
+        scope: Scope::File,
+        description: "Comma-separated variables to keep from your shell in strict mode, besides the whitelist.",
+    },
+    Setting {
+        name: "DOTENV_ALLOW_SHADOWING",
+        scope: Scope::File,
+        description: "When truthy, silences the warnings about the file's variables replacing different values set in your shell.",
+    },
+    Setting {
+        name: "DOTENV_REQUIRED",
+        scope: Scope::File,
+        description: "Comma-separated variables that must be set and not empty for the command to run.",
+    },
+    Setting {
+        name: "DOTENV_UNSET",
+        scope: Scope::File,
+        description: "Comma-separated variables to remove from your shell's environment before running the command.",
+    },
+    Setting {
+        name: "DOTENV_PRE_COMMAND",
+        scope: Scope::File,
+        description: "Script run through the shell, with the same environment, before the command, which doesn't run if it fails.",
+    },
+    Setting {
+        name: "DOTENV_POST_COMMAND",
+        scope: Scope::File,
+        description: "Script run through the shell, with the same environment, once the command exits.",
+    },
+    Setting {
+        name: "DOTENV_ON_FAILURE",
+        scope: Scope::File,
+        description: "Script run through the shell, with the same environment, when the command exits with a non-zero status.",
+    },
+    Setting {
+        name: COMMAND_VAR,
+        scope: Scope::File,
+        description: "Command associated with the environment file.",
+    },
+    Setting {
+        name: "DOTENV_COMMAND_<NAME>",
+        scope: Scope::File,
+        description: "Named command associated with the environment file, run with dotenv :name, where dashes in the name stand for underscores.",
+    },
+    Setting {
+        name: EXTENDS_VAR,
+        scope: Scope::File,
+        description: "Comma-separated named environments whose variables are loaded first, so the file only has to override what differs.",
+    },
+    Setting {
+        name: INHERIT_VAR,
+        scope: Scope::File,
+        description: "Alias of DOTENV_EXTENDS.",
+    },
+    Setting {
+        name: FILE_VAR,
+        scope: Scope::Child,
+        description: "The environment files loaded, separated like PATH entries, or - for standard input.",
+    },
+    Setting {
+        name: PROFILE_VAR,
+        scope: Scope::Child,
+        description: "The name of the named environment loaded, if any.",
+    },
+    Setting {
+        name: HASH_VAR,
+        scope: Scope::Child,
+        description: "A hash of the variables loaded, which changes whenever any of them does.",
+    },
+    Setting {
+        name: KEYS_VAR,
+        scope: Scope::Child,
+        description: "The names of the variables loaded, separated by commas.",
+    },
+    Setting {
+        name: "DOTENV_EXIT_CODE",
+        scope: Scope::Child,
+        description: "The exit status of the command that failed, set for the DOTENV_ON_FAILURE script.",
+    },
+    Setting {
+        name: "DOTENV_FAILED_COMMAND",
+        scope: Scope::Child,
+        description: "The command that failed, set for the DOTENV_ON_FAILURE script.",
+    },
+];
+
+/// Find a setting by name. Settings ending in `<NAME>` stand for every
+/// variable starting like them.
+pub fn find(name: &str) -> Option<&'static Setting> {
+    SETTINGS
+        .iter()
+        .find(|s| match s.name.strip_suffix("<NAME>") {
+            Some(prefix) => name.len() > prefix.len() && name.starts_with(prefix),
+            None => s.name == name,
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_find() {
+        assert_eq!(find("DOTENV_STRICT").map(|s| s.scope), Some(Scope::File));
+        assert_eq!(find("DOTENV").map(|s| s.scope), Some(Scope::Process));
+        assert_eq!(find("DOTENV_FILE").map(|s| s.scope), Some(Scope::Child));
+        assert!(find("DOTENV_UNKNOWN").is_none());
+        assert_eq!(
+            find("DOTENV_COMMAND_DEPLOY").map(|s| s.name),
+            Some("DOTENV_COMMAND_<NAME>")
+        );
+        assert!(find("DOTENV_COMMAND_").is_none());
+    }
+}
@@ -0,0 +1,367 @@
+use std::{
+    io,
+    process::{Child, Command, ExitStatus},
+    time::{Duration, Instant},
+};
+
+#[cfg(unix)]
+use std::{
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        mpsc, Arc,
+    },
+    thread,
+};
+
+/// Signals relayed to the command while dotenv waits for it.
+#[cfg(unix)]
+const FORWARDED: &[libc::c_int] = &[
+    libc::SIGHUP,
+    libc::SIGINT,
+    libc::SIGQUIT,
+    libc::SIGTERM,
+    libc::SIGUSR1,
+    libc::SIGUSR2,
+    libc::SIGWINCH,
+];
+
+/// How often the watchdog checks whether the command should be stopped.
+#[cfg(unix)]
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// Process group of the command signals are relayed to, or 0 before it
+/// starts.
+#[cfg(unix)]
+static GROUP: std::sync::atomic::AtomicI32 = std::sync::atomic::AtomicI32::new(0);
+
+/// Pseudo-terminal the command runs on, if any, or -1.
+#[cfg(unix)]
+static PTY: std::sync::atomic::AtomicI32 = std::sync::atomic::AtomicI32::new(-1);
+
+/// Whether dotenv was asked to stop, by any of the relayed signals other
+/// than `SIGWINCH`, `SIGUSR1` and `SIGUSR2`.
+#[cfg(unix)]
+static STOPPING: AtomicBool = AtomicBool::new(false);
+
+/// Catch the signals relayed to the command, so they don't terminate
+/// dotenv while it waits for the command to finish. Call before starting
+/// the command: signals received until [`forward_to`] is called are
+/// dropped.
+#[cfg(unix)]
+pub fn install() {
+    for &signal in FORWARDED {
+        // SAFETY: sigaction is a plain C struct, and the handler only calls
+        // async-signal-safe functions
+        unsafe {
+            let mut action: libc::sigaction = std::mem::zeroed();
+            action.sa_sigaction = relay as usize;
+            action.sa_flags = libc::SA_RESTART;
+            libc::sigemptyset(&mut action.sa_mask);
+            libc::sigaction(signal, &action, std::ptr::null_mut());
+        }
+    }
+}
+
+/// Start the command in a process group of its own, so signals relayed to
+/// it also reach everything it starts, like the stages of a shell pipeline.
+/// When dotenv runs in the foreground of a terminal, the new group takes
+/// over the terminal so the command can still read from it and receive
+/// the keys typed, like Ctrl-C. Returns whether the terminal was handed
+/// over, to give it back with [`reclaim_terminal`] once the command exits.
+#[cfg(unix)]
+pub fn isolate(cmd: &mut Command) -> bool {
+    use std::os::unix::process::CommandExt;
+
+    cmd.process_group(0);
+
+    // SAFETY: isatty, tcgetpgrp and getpgrp only read process state
+    let foreground = unsafe {
+        libc::isatty(libc::STDIN_FILENO) == 1
+            && libc::tcgetpgrp(libc::STDIN_FILENO) == libc::getpgrp()
+    };
+    if foreground {
+        // SAFETY: the closure runs in the child after it joined its own
+        // process group, and only calls async-signal-safe functions
+        unsafe {
+            cmd.pre_exec(|| {
+                take_terminal(libc::getpgrp());
+                Ok(())
+            });
+        }
+    }
+    foreground
+}
+
+/// Give the terminal back to dotenv's process group.
+#[cfg(unix)]
+pub fn reclaim_terminal() {
+    // SAFETY: getpgrp only reads process state
+    take_terminal(unsafe { libc::getpgrp() });
+}
+
+/// Make a process group the foreground one of the terminal. Processes
+/// outside the foreground are stopped by `SIGTTOU` when doing so, which is
+/// ignored in the meantime.
+#[cfg(unix)]
+fn take_terminal(group: libc::pid_t) {
+    // SAFETY: signal and tcsetpgrp are async-signal-safe
+    unsafe {
+        let previous = libc::signal(libc::SIGTTOU, libc::SIG_IGN);
+        libc::tcsetpgrp(libc::STDIN_FILENO, group);
+        libc::signal(libc::SIGTTOU, previous);
+    }
+}
+
+/// Wait for the command to exit. When it's stopped from the terminal, like
+/// with Ctrl-Z, dotenv gives the terminal back to the shell and stops too,
+/// then hands the terminal over again and resumes the command once it's
+/// continued itself. When dotenv is init, like in a container, the
+/// orphaned processes it inherits are reaped in the meantime.
+#[cfg(unix)]
+pub fn wait(child: &mut Child, has_terminal: bool) -> io::Result<ExitStatus> {
+    use std::os::unix::process::ExitStatusExt;
+
+    let pid = child.id() as libc::pid_t;
+    // SAFETY: getpid only reads process state
+    let waited = if unsafe { libc::getpid() } == 1 {
+        -1
+    } else {
+        pid
+    };
+    loop {
+        let mut status = 0;
+        // SAFETY: waitpid only writes to the status it's given
+        let exited = unsafe { libc::waitpid(waited, &mut status, libc::WUNTRACED) };
+        if exited == -1 {
+            let err = io::Error::last_os_error();
+            if err.kind() == io::ErrorKind::Interrupted {
+                continue;
+            }
+            return Err(err);
+        }
+        if exited != pid {
+            continue;
+        }
+
+        if !libc::WIFSTOPPED(status) {
+            return Ok(ExitStatus::from_raw(status));
+        }
+
+        if has_terminal {
+            reclaim_terminal();
+            // SAFETY: raise and kill only send signals
+            unsafe {
+                libc::raise(libc::SIGSTOP);
+            }
+            take_terminal(pid);
+            // SAFETY: as above
+            unsafe {
+                libc::kill(-pid, libc::SIGCONT);
+            }
+        }
+    }
+}
+
+/// Start relaying the caught signals to the command's process group.
+#[cfg(unix)]
+pub fn forward_to(pid: u32) {
+    GROUP.store(pid as i32, std::sync::atomic::Ordering::SeqCst);
+}
+
+/// Resize a pseudo-terminal like the terminal dotenv runs in whenever the
+/// latter is resized, instead of relaying `SIGWINCH` to the command. The
+/// command is then notified by the pseudo-terminal itself.
+#[cfg(unix)]
+pub fn resize_with(pty: libc::c_int) {
+    PTY.store(pty, std::sync::atomic::Ordering::SeqCst);
+}
+
+/// Whether dotenv was asked to stop since [`install`] was called, like
+/// with Ctrl-C, so the command shouldn't be run again.
+#[cfg(unix)]
+pub fn stopping() -> bool {
+    STOPPING.load(Ordering::SeqCst)
+}
+
+/// Wait for a while before running the command again, returning early and
+/// `false` if dotenv is asked to stop in the meantime.
+#[cfg(unix)]
+pub fn pause(duration: Duration) -> bool {
+    let mut left = duration;
+    while !left.is_zero() {
+        if stopping() {
+            return false;
+        }
+        let slept = left.min(POLL_INTERVAL);
+        thread::sleep(slept);
+        left -= slept;
+    }
+    !stopping()
+}
+
+/// Ask every process in a process group to stop with `SIGTERM` or, when
+/// `force`d, kill them with `SIGKILL`.
+#[cfg(unix)]
+pub fn stop_group(group: u32, force: bool) {
+    let signal = if force { libc::SIGKILL } else { libc::SIGTERM };
+    // SAFETY: kill only sends a signal
+    unsafe {
+        libc::kill(-(group as libc::pid_t), signal);
+    }
+}
+
+/// Ask a child process to stop with `SIGTERM`.
+#[cfg(unix)]
+pub fn terminate(child: &mut Child) {
+    // SAFETY: kill only sends a signal
+    unsafe {
+        libc::kill(child.id() as libc::pid_t, libc::SIGTERM);
+    }
+}
+
+/// Kills the command's process group when it doesn't stop in time once
+/// asked to, and asks it to stop when it runs for too long.
+#[cfg(unix)]
+pub struct Watchdog {
+    done: mpsc::Sender<()>,
+    timed_out: Arc<AtomicBool>,
+}
+
+/// Start watching the command. Once dotenv is asked to stop, and the signal
+/// relayed to the command, it has `grace` to exit before its process group
+/// is sent `SIGKILL`. The same goes once `timeout` elapses, if given, the
+/// group being sent `SIGTERM` first.
+#[cfg(unix)]
+pub fn watch(pid: u32, timeout: Option<Duration>, grace: Duration) -> Watchdog {
+    let (done, finished) = mpsc::channel::<()>();
+    let timed_out = Arc::new(AtomicBool::new(false));
+
+    let flag = Arc::clone(&timed_out);
+    thread::spawn(move || {
+        let group = -(pid as libc::pid_t);
+        let started = Instant::now();
+        loop {
+            if finished.recv_timeout(POLL_INTERVAL) != Err(mpsc::RecvTimeoutError::Timeout) {
+                return;
+            }
+            if stopping() {
+                break;
+            }
+            if timeout.is_some_and(|timeout| started.elapsed() >= timeout) {
+                flag.store(true, Ordering::SeqCst);
+                // SAFETY: kill only sends a signal
+                unsafe {
+                    libc::kill(group, libc::SIGTERM);
+                }
+                break;
+            }
+        }
+        if finished.recv_timeout(grace) == Err(mpsc::RecvTimeoutError::Timeout) {
+            // SAFETY: as above
+            unsafe {
+                libc::kill(group, libc::SIGKILL);
+            }
+        }
+    });
+
+    Watchdog { done, timed_out }
+}
+
+#[cfg(unix)]
+impl Watchdog {
+    /// Stop watching once the command exited, returning whether it was
+    /// terminated for running too long.
+    pub fn stop(self) -> bool {
+        let _ = self.done.send(());
+        self.timed_out.load(Ordering::SeqCst)
+    }
+}
+
+/// Relay a signal to the command and everything else in its process group.
+/// `SIGINT` is relayed as `SIGTERM`, the signal commands expect to be asked
+/// to shut down with.
+#[cfg(unix)]
+extern "C" fn relay(signal: libc::c_int) {
+    if ![libc::SIGWINCH, libc::SIGUSR1, libc::SIGUSR2].contains(&signal) {
+        STOPPING.store(true, Ordering::SeqCst);
+    }
+
+    let pty = PTY.load(std::sync::atomic::Ordering::SeqCst);
+    if signal == libc::SIGWINCH && pty >= 0 {
+        // SAFETY: winsize is a plain C struct and ioctl is
+        // async-signal-safe
+        unsafe {
+            let mut size: libc::winsize = std::mem::zeroed();
+            if libc::ioctl(libc::STDIN_FILENO, libc::TIOCGWINSZ, &mut size) == 0 {
+                libc::ioctl(pty, libc::TIOCSWINSZ, &size);
+            }
+        }
+        return;
+    }
+
+    let group = GROUP.load(std::sync::atomic::Ordering::SeqCst);
+    if group > 0 {
+        let signal = if signal == libc::SIGINT {
+            libc::SIGTERM
+        } else {
+            signal
+        };
+        // SAFETY: kill is async-signal-safe
+        unsafe {
+            libc::kill(-group, signal);
+        }
+    }
+}
+
+#[cfg(not(unix))]
+pub fn install() {}
+
+#[cfg(not(unix))]
+pub fn isolate(_cmd: &mut Command) -> bool {
+    false
+}
+
+#[cfg(not(unix))]
+pub fn reclaim_terminal() {}
+
+#[cfg(not(unix))]
+pub fn forward_to(_pid: u32) {}
+
+#[cfg(not(unix))]
+pub fn stopping() -> bool {
+    false
+}
+
+#[cfg(not(unix))]
+pub fn pause(duration: Duration) -> bool {
+    std::thread::sleep(duration);
+    true
+}
+
+#[cfg(not(unix))]
+pub fn stop_group(_group: u32, _force: bool) {}
+
+#[cfg(not(unix))]
+pub fn terminate(child: &mut Child) {
+    let _ = child.kill();
+}
+
+#[cfg(not(unix))]
+pub struct Watchdog;
+
+#[cfg(not(unix))]
+pub fn watch(_pid: u32, _timeout: Option<Duration>, _grace: Duration) -> Watchdog {
+    Watchdog
+}
+
+#[cfg(not(unix))]
+impl Watchdog {
+    pub fn stop(self) -> bool {
+        false
+    }
+}
+
+#[cfg(not(unix))]
+pub fn wait(child: &mut Child, _has_terminal: bool) -> io::Result<ExitStatus> {
+    child.wait()
+}
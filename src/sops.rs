@@ -0,0 +1,83 @@
+use anyhow::{Context, Result};
+use std::{io, path::Path, process::Command};
+
+/// Prefix of the keys SOPS adds to the files it encrypts, like `sops_mac`,
+/// to keep its metadata.
+const METADATA_PREFIX: &str = "sops_";
+
+/// Check if the contents of an environment file are encrypted with SOPS,
+/// which leaves the keys in plain text and adds its own metadata to them.
+pub fn is_encrypted(content: &str) -> bool {
+    content.lines().any(|line| {
+        let line = line.trim();
+        line.starts_with("sops_mac=") || line.starts_with("sops_version=")
+    })
+}
+
+/// Check if a variable holds the metadata SOPS adds to encrypted files
+/// rather than a value of the environment.
+pub fn is_metadata(key: &str) -> bool {
+    key.starts_with(METADATA_PREFIX)
+}
+
+/// Decrypt an environment file encrypted with SOPS. The keys are found by
+/// SOPS itself, from its usual sources: age identities, cloud KMS
+/// credentials or the PGP keyring.
+pub fn decrypt(file: &Path) -> Result<String> {
+    let output = Command::new("sops")
+        .args([
+            "--decrypt",
+            "--input-type",
+            "dotenv",
+            "--output-type",
+            "dotenv",
+        ])
+        .arg(file)
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => anyhow::bail!(
+            "{} is encrypted with SOPS, which is not installed, see https://getsops.io",
+            file.display()
+        ),
+        Err(err) => return Err(err).context("Failed to run sops"),
+    };
+
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!(
+                "Could not decrypt {}: sops failed with {}",
+                file.display(),
+                output.status
+            ),
+            message => anyhow::bail!("Could not decrypt {}: {}", file.display(), message),
+        }
+    }
+    String::from_utf8(output.stdout)
+        .with_context(|| format!("{} doesn't decrypt to text", file.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_encrypted() {
+        let encrypted = "DB_PASSWORD=ENC[AES256_GCM,data:Tr7o,iv:1=,tag:k=,type:str]\n\
+            sops_age__list_0__map_recipient=age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n\
+            sops_lastmodified=2024-01-01T00:00:00Z\n\
+            sops_mac=ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]\n\
+            sops_version=3.9.0\n";
+        assert!(is_encrypted(encrypted));
+        assert!(!is_encrypted("DB_PASSWORD=hunter2\n"));
+        assert!(!is_encrypted("# sops_version=3.9.0\n"));
+    }
+
+    #[test]
+    fn test_is_metadata() {
+        assert!(is_metadata("sops_mac"));
+        assert!(!is_metadata("SOPS_AGE_KEY_FILE"));
+        assert!(!is_metadata("DB_PASSWORD"));
+    }
+}
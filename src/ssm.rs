@@ -0,0 +1,188 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a parameter in AWS Systems Manager
+/// Parameter Store, followed by its name, like `ssm:/app/prod/db/password`.
+pub const PREFIX: &str = "ssm:";
+
+/// Prefix of the values importing every parameter under a path in
+/// Parameter Store as a variable of its own, like `ssm-path:/app/prod`.
+pub const PATH_PREFIX: &str = "ssm-path:";
+
+/// Replace, in place, the values referencing parameters in Parameter Store
+/// with the parameters themselves, and the values importing a path with the
+/// parameters under it, read with the AWS CLI and the AWS credentials
+/// dotenv runs with. `SecureString` parameters are decrypted.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    import_paths(vars)?;
+
+    let references: Vec<(String, String)> = vars
+        .iter()
+        .filter_map(|(key, value)| Some((key.clone(), value.strip_prefix(PREFIX)?.to_string())))
+        .collect();
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let names = references.iter().map(|(_, name)| name.as_str());
+    let parameters = secrets::fetch_all(names, read);
+
+    let mut resolved = Vec::new();
+    for (key, name) in &references {
+        match &parameters[name.as_str()] {
+            Ok(value) => resolved.push((key.clone(), value.clone())),
+            Err(err) => anyhow::bail!(
+                "Could not read {} from AWS Parameter Store for {}: {:#}",
+                name,
+                key,
+                err
+            ),
+        }
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Replace the values importing a path with a variable for each parameter
+/// under it, named after what follows the path, like `DB_HOST` for
+/// `/app/prod/db/host` under `/app/prod`. The variables in the file take
+/// precedence over the imported ones.
+fn import_paths(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut imports: Vec<(String, String)> = vars
+        .iter()
+        .filter_map(|(key, value)| {
+            Some((key.clone(), value.strip_prefix(PATH_PREFIX)?.to_string()))
+        })
+        .collect();
+    if imports.is_empty() {
+        return Ok(());
+    }
+    // In the order of their keys, so the same path always wins when two of
+    // them have parameters named alike
+    imports.sort();
+
+    let paths = imports.iter().map(|(_, path)| path.as_str());
+    let hierarchies = secrets::fetch_all(paths, read_path);
+
+    let mut imported = HashMap::new();
+    for (key, path) in &imports {
+        vars.remove(key);
+        let parameters = match &hierarchies[path.as_str()] {
+            Ok(parameters) => parameters,
+            Err(err) => anyhow::bail!(
+                "Could not read the parameters under {} from AWS Parameter Store for {}: {:#}",
+                path,
+                key,
+                err
+            ),
+        };
+        for (name, value) in parameters {
+            if let Some(variable) = variable_name(path, name) {
+                imported.entry(variable).or_insert_with(|| value.clone());
+            }
+        }
+    }
+    for (variable, value) in imported {
+        vars.entry(variable).or_insert(value);
+    }
+    Ok(())
+}
+
+/// Name the variable for a parameter under a path, after what follows the
+/// path, in uppercase and with anything but letters and digits turned into
+/// underscores.
+fn variable_name(path: &str, name: &str) -> Option<String> {
+    let relative = name
+        .strip_prefix(path.trim_end_matches('/'))?
+        .strip_prefix('/')?;
+    if relative.is_empty() {
+        return None;
+    }
+    Some(
+        relative
+            .chars()
+            .map(|c| match c {
+                c if c.is_ascii_alphanumeric() => c.to_ascii_uppercase(),
+                _ => '_',
+            })
+            .collect(),
+    )
+}
+
+/// Read the value of a parameter with the AWS CLI.
+fn read(name: &str) -> Result<String> {
+    let response = aws(&["get-parameter", "--name", name])?;
+    match response["Parameter"]["Value"].as_str() {
+        Some(value) => Ok(value.to_string()),
+        None => anyhow::bail!("aws printed no value for the parameter"),
+    }
+}
+
+/// Read the names and values of every parameter under a path, however deep,
+/// with the AWS CLI, which fetches all their pages.
+fn read_path(path: &str) -> Result<Vec<(String, String)>> {
+    let response = aws(&["get-parameters-by-path", "--path", path, "--recursive"])?;
+    let Some(parameters) = response["Parameters"].as_array() else {
+        anyhow::bail!("aws printed no parameters");
+    };
+    Ok(parameters
+        .iter()
+        .filter_map(|parameter| {
+            Some((
+                parameter["Name"].as_str()?.to_string(),
+                parameter["Value"].as_str()?.to_string(),
+            ))
+        })
+        .collect())
+}
+
+/// Run an `aws ssm` command, decrypting `SecureString` parameters, and
+/// parse what it prints.
+fn aws(args: &[&str]) -> Result<serde_json::Value> {
+    let output = Command::new("aws")
+        .arg("ssm")
+        .args(args)
+        .args(["--with-decryption", "--output", "json"])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("The AWS CLI is not installed, see https://aws.amazon.com/cli/")
+        }
+        Err(err) => return Err(err).context("Failed to run aws"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("aws failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    serde_json::from_slice(&output.stdout).context("aws printed invalid JSON")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_variable_name() {
+        assert_eq!(
+            variable_name("/app/prod", "/app/prod/db/host"),
+            Some("DB_HOST".to_string())
+        );
+        assert_eq!(
+            variable_name("/app/prod/", "/app/prod/api-token"),
+            Some("API_TOKEN".to_string())
+        );
+        assert_eq!(
+            variable_name("/", "/log.level"),
+            Some("LOG_LEVEL".to_string())
+        );
+        assert_eq!(variable_name("/app/prod", "/app/prod"), None);
+        assert_eq!(variable_name("/app/prod", "/app/dev/db/host"), None);
+        assert_eq!(variable_name("/app/prod", "/app/production/db/host"), None);
+    }
+}
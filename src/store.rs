@@ -0,0 +1,205 @@
+use anyhow::{Context, Result};
+use std::{
+    collections::BTreeMap,
+    io::{self, Write},
+    path::{Path, PathBuf},
+    process::{Command, Stdio},
+    sync::Mutex,
+    thread,
+};
+
+use crate::{keyring, profiles};
+
+/// Name of the file, inside a dotenv folder, holding the environments
+/// sealed with `dotenv store seal`, encrypted with GPG as a whole.
+pub const STORE_FILE: &str = ".store.gpg";
+
+/// Name the passphrase of the store is kept under in the keyring, when it's
+/// kept there rather than asked for.
+pub const KEYRING_NAME: &str = ".store";
+
+/// The stores decrypted so far, by folder, so the passphrase is only needed
+/// once per run.
+static OPENED: Mutex<BTreeMap<PathBuf, BTreeMap<String, String>>> = Mutex::new(BTreeMap::new());
+
+/// Check if a folder keeps its environments in a store.
+pub fn exists(folder: &Path) -> bool {
+    folder.join(STORE_FILE).is_file()
+}
+
+/// Check if the store of a folder, if any, holds the named environment.
+pub fn contains(folder: &Path, name: &str) -> Result<bool> {
+    Ok(exists(folder) && entries(folder)?.contains_key(name))
+}
+
+/// Get the environments kept in the store of a folder, by name.
+pub fn entries(folder: &Path) -> Result<BTreeMap<String, String>> {
+    let mut opened = OPENED.lock().unwrap_or_else(|err| err.into_inner());
+    if let Some(entries) = opened.get(folder) {
+        return Ok(entries.clone());
+    }
+
+    let file = folder.join(STORE_FILE);
+    let plain = gpg(&["--decrypt"], Some(&file), b"")
+        .with_context(|| format!("Could not unlock {}", file.display()))?;
+    let entries: BTreeMap<String, String> = serde_json::from_slice(&plain)
+        .with_context(|| format!("{} isn't a dotenv store", file.display()))?;
+    opened.insert(folder.to_path_buf(), entries.clone());
+    Ok(entries)
+}
+
+/// Get the contents of an environment file that's kept in a store rather
+/// than on disk, if it is.
+pub fn entry_for(file: &Path) -> Result<Option<String>> {
+    if file.exists() {
+        return Ok(None);
+    }
+    let Some((folder, name)) = entry_of(file)? else {
+        return Ok(None);
+    };
+    Ok(entries(&folder)?.remove(&name))
+}
+
+/// Check if an environment file is kept in a store.
+pub fn holds(file: &Path) -> bool {
+    entry_for(file).is_ok_and(|entry| entry.is_some())
+}
+
+/// Write an environment file into the store of its folder when there's one
+/// and the file isn't on disk, returning whether it was.
+pub fn write_entry(file: &Path, content: &[u8]) -> Result<bool> {
+    if file.exists() {
+        return Ok(false);
+    }
+    let Some((folder, name)) = entry_of(file)? else {
+        return Ok(false);
+    };
+
+    let content = String::from_utf8(content.to_vec())
+        .with_context(|| format!("Only text can be kept in the store, not {}", name))?;
+    let mut entries = entries(&folder)?;
+    entries.insert(name, content);
+    save(&folder, entries)
+        .with_context(|| format!("Could not save changes to {}", file.display()))?;
+    Ok(true)
+}
+
+/// Encrypt the environments of a folder into its store, replacing it.
+pub fn save(folder: &Path, entries: BTreeMap<String, String>) -> Result<()> {
+    let plain = serde_json::to_vec(&entries)?;
+    let encrypted = gpg(
+        &[
+            "--symmetric",
+            "--cipher-algo",
+            "AES256",
+            "--armor",
+            "--output",
+            "-",
+        ],
+        None,
+        &plain,
+    )?;
+
+    // Written to a private temporary file first, so the store is never
+    // left half-written
+    let file = folder.join(STORE_FILE);
+    let mut scratch = tempfile::Builder::new()
+        .prefix(".store-")
+        .tempfile_in(folder)?;
+    scratch.write_all(&encrypted)?;
+    scratch
+        .persist(&file)
+        .with_context(|| format!("Could not write {}", file.display()))?;
+
+    OPENED
+        .lock()
+        .unwrap_or_else(|err| err.into_inner())
+        .insert(folder.to_path_buf(), entries);
+    Ok(())
+}
+
+/// Forget the passphrases gpg-agent remembers, so the store has to be
+/// unlocked again.
+pub fn lock() -> Result<()> {
+    let status = match Command::new("gpgconf")
+        .args(["--reload", "gpg-agent"])
+        .status()
+    {
+        Ok(status) => status,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("gpgconf is not installed, it comes with GnuPG")
+        }
+        Err(err) => return Err(err).context("Failed to run gpgconf"),
+    };
+    if !status.success() {
+        anyhow::bail!("gpgconf failed with {}", status);
+    }
+    Ok(())
+}
+
+/// Find the folder with a store an environment file would be kept in, and
+/// the name it's kept under.
+fn entry_of(file: &Path) -> Result<Option<(PathBuf, String)>> {
+    for folder in profiles::folders()? {
+        if !exists(&folder) {
+            continue;
+        }
+        if let Some(name) = profiles::name_of(&folder, file) {
+            return Ok(Some((folder, name)));
+        }
+    }
+    Ok(None)
+}
+
+/// Run gpg with some input, and on a file if given. The passphrase is given
+/// to it first when it's kept in the keyring, or else asked for by
+/// gpg-agent, which remembers it for the rest of the session like it does
+/// for `pass`.
+fn gpg(args: &[&str], file: Option<&Path>, input: &[u8]) -> Result<Vec<u8>> {
+    let passphrase = keyring::get(KEYRING_NAME).ok().flatten();
+
+    let mut cmd = Command::new("gpg");
+    cmd.arg("--quiet");
+    if passphrase.is_some() {
+        cmd.args([
+            "--batch",
+            "--pinentry-mode",
+            "loopback",
+            "--passphrase-fd",
+            "0",
+        ]);
+    }
+    // The reason it failed is shown as is, like when decrypting files
+    cmd.args(args).args(file);
+    let spawned = cmd
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::inherit())
+        .spawn();
+    let mut child = match spawned {
+        Ok(child) => child,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!("The store is encrypted with GPG, which is not installed")
+        }
+        Err(err) => return Err(err).context("Failed to run gpg"),
+    };
+
+    // gpg reads the passphrase up to the first line break and the input
+    // after it, written from another thread so it can't block on a full
+    // output while dotenv is still writing
+    let mut stdin = child.stdin.take().context("Failed to open gpg's input")?;
+    let mut data = Vec::new();
+    if let Some(passphrase) = passphrase {
+        data.extend_from_slice(passphrase.as_bytes());
+        data.push(b'\n');
+    }
+    data.extend_from_slice(input);
+    let writer = thread::spawn(move || stdin.write_all(&data));
+
+    let output = child.wait_with_output().context("Failed to wait for gpg")?;
+    let _ = writer.join();
+    if !output.status.success() {
+        anyhow::bail!("gpg failed with {}", output.status);
+    }
+    Ok(output.stdout)
+}
@@ -0,0 +1,76 @@
+use std::{
+    io::{Read, Write},
+    sync::{Arc, Mutex},
+    thread::{self, JoinHandle},
+};
+
+use crate::logfile::{self, LogFile};
+
+/// What happens to the output of the command on its way to the terminal.
+#[derive(Clone, Default)]
+pub struct Copy {
+    /// Label written in front of every line, if any.
+    pub prefix: Option<String>,
+    /// File every line is also copied to, if any.
+    pub log: Option<Arc<Mutex<LogFile>>>,
+}
+
+impl Copy {
+    /// Whether the output has to go through dotenv at all.
+    pub fn is_needed(&self) -> bool {
+        self.prefix.is_some() || self.log.is_some()
+    }
+
+    /// Copy what the command writes to one of its streams, named `stream`
+    /// in the log file, to `out` until the stream closes. Output is passed
+    /// along as it comes, unless it's prefixed: then it's passed along one
+    /// line at a time, so lines from different commands don't get mixed.
+    pub fn start<R, W>(&self, mut input: R, mut out: W, stream: &'static str) -> JoinHandle<()>
+    where
+        R: Read + Send + 'static,
+        W: Write + Send + 'static,
+    {
+        let copy = self.clone();
+        thread::spawn(move || {
+            let mut buf = [0u8; 8192];
+            let mut pending: Vec<u8> = Vec::new();
+            let mut logging = copy.log.is_some();
+            loop {
+                let read = match input.read(&mut buf) {
+                    Ok(0) | Err(_) => break,
+                    Ok(read) => read,
+                };
+                if copy.prefix.is_none() {
+                    let _ = out.write_all(&buf[..read]);
+                    let _ = out.flush();
+                }
+
+                pending.extend_from_slice(&buf[..read]);
+                while let Some(end) = pending.iter().position(|&b| b == b'\n') {
+                    let line: Vec<u8> = pending.drain(..=end).collect();
+                    copy.line(&mut out, stream, &line, &mut logging);
+                }
+            }
+            if !pending.is_empty() {
+                copy.line(&mut out, stream, &pending, &mut logging);
+            }
+        })
+    }
+
+    /// Pass a whole line along, prefixed if needed, and log it, unless
+    /// logging failed before.
+    fn line<W: Write>(&self, out: &mut W, stream: &str, line: &[u8], logging: &mut bool) {
+        if let Some(prefix) = &self.prefix {
+            let mut prefixed = prefix.clone().into_bytes();
+            prefixed.extend_from_slice(line);
+            if !prefixed.ends_with(b"\n") {
+                prefixed.push(b'\n');
+            }
+            let _ = out.write_all(&prefixed);
+            let _ = out.flush();
+        }
+        if let Some(log) = self.log.as_ref().filter(|_| *logging) {
+            *logging = logfile::record(log, stream, line);
+        }
+    }
+}
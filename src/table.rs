@@ -0,0 +1,55 @@
+/// Render rows as left-aligned columns separated by two spaces, with a
+/// header row on top. The last column is never padded so lines don't end
+/// in trailing whitespace.
+pub fn render(headers: &[&str], rows: &[Vec<String>]) -> String {
+    let mut widths: Vec<usize> = headers.iter().map(|h| h.chars().count()).collect();
+    for row in rows {
+        for (i, cell) in row.iter().enumerate() {
+            widths[i] = widths[i].max(cell.chars().count());
+        }
+    }
+
+    let mut out = String::new();
+    let headers: Vec<String> = headers.iter().map(|h| h.to_string()).collect();
+    for row in std::iter::once(&headers).chain(rows) {
+        let last = row.len().saturating_sub(1);
+        for (i, cell) in row.iter().enumerate() {
+            if i == last {
+                out.push_str(cell);
+            } else {
+                out.push_str(&format!("{:<width$}  ", cell, width = widths[i]));
+            }
+        }
+        out.push('\n');
+    }
+
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render_aligns_columns() {
+        let rows = vec![
+            vec![
+                "prod".to_string(),
+                "4".to_string(),
+                "/a/prod.env".to_string(),
+            ],
+            vec![
+                "example".to_string(),
+                "12".to_string(),
+                "/a/example.env".to_string(),
+            ],
+        ];
+        let out = render(&["NAME", "VARIABLES", "PATH"], &rows);
+        assert_eq!(
+            out,
+            "NAME     VARIABLES  PATH\n\
+             prod     4          /a/prod.env\n\
+             example  12         /a/example.env\n"
+        );
+    }
+}
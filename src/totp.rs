@@ -0,0 +1,130 @@
+use anyhow::{Context, Result};
+use hmac::{Hmac, Mac};
+use sha1::Sha1;
+use std::{
+    collections::HashMap,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use crate::keyring;
+
+/// Prefix of the values rendered as the current one-time code of a TOTP
+/// seed in base32, like `totp:JBSWY3DPEHPK3PXP`, or of a seed kept in the
+/// keyring, like `totp:keyring:github`.
+pub const PREFIX: &str = "totp:";
+
+/// How long each code lasts, in seconds, as authenticator apps use.
+const PERIOD: u64 = 30;
+
+/// How many digits each code has.
+const DIGITS: u32 = 6;
+
+/// Replace, in place, the values with a TOTP seed with the code it gives
+/// right now, so a command taking a code from its environment gets a fresh
+/// one each time.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let seeds: Vec<(String, String)> = vars
+        .iter()
+        .filter_map(|(key, value)| Some((key.clone(), value.strip_prefix(PREFIX)?.to_string())))
+        .collect();
+    if seeds.is_empty() {
+        return Ok(());
+    }
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .context("The clock is set before 1970")?
+        .as_secs();
+
+    for (key, seed) in seeds {
+        match generate(&seed, now) {
+            Ok(code) => {
+                vars.insert(key, code);
+            }
+            Err(err) => anyhow::bail!("Could not generate a TOTP code for {}: {:#}", key, err),
+        }
+    }
+    Ok(())
+}
+
+/// Generate the code of a seed, reading it from the keyring first when it
+/// names a secret there.
+fn generate(seed: &str, now: u64) -> Result<String> {
+    let seed = match seed.strip_prefix(keyring::PREFIX) {
+        Some(name) => keyring::get(name)?
+            .with_context(|| format!("There's no secret named {} in the keyring", name))?,
+        None => seed.to_string(),
+    };
+    let key = decode_base32(&seed).context("The seed isn't valid base32")?;
+    if key.is_empty() {
+        anyhow::bail!("The seed is empty");
+    }
+    Ok(code(&key, now / PERIOD))
+}
+
+/// Compute the code for a counter as RFC 4226 does, from the HMAC-SHA1 of
+/// the counter keyed with the seed.
+fn code(key: &[u8], counter: u64) -> String {
+    // SHA-1 is what TOTP seeds are almost always meant for, and its
+    // weaknesses as a hash don't matter here
+    let mut mac = Hmac::<Sha1>::new_from_slice(key).expect("HMAC takes keys of any length");
+    mac.update(&counter.to_be_bytes());
+    let mac = mac.finalize().into_bytes();
+    let offset = (mac[19] & 0x0f) as usize;
+    let truncated = u32::from_be_bytes([
+        mac[offset] & 0x7f,
+        mac[offset + 1],
+        mac[offset + 2],
+        mac[offset + 3],
+    ]);
+    format!(
+        "{:0width$}",
+        truncated % 10u32.pow(DIGITS),
+        width = DIGITS as usize
+    )
+}
+
+/// Decode base32 as authenticators show seeds: in any case, with spaces to
+/// group the characters and with or without padding.
+fn decode_base32(text: &str) -> Option<Vec<u8>> {
+    let mut bytes = Vec::with_capacity(text.len() * 5 / 8);
+    let mut buffer = 0u64;
+    let mut bits = 0;
+    for c in text.trim_end_matches('=').bytes() {
+        let value = match c.to_ascii_uppercase() {
+            b' ' | b'-' => continue,
+            c @ b'A'..=b'Z' => c - b'A',
+            c @ b'2'..=b'7' => c - b'2' + 26,
+            _ => return None,
+        };
+        buffer = (buffer << 5) | value as u64;
+        bits += 5;
+        if bits >= 8 {
+            bits -= 8;
+            bytes.push((buffer >> bits) as u8);
+        }
+    }
+    Some(bytes)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_decode_base32() {
+        assert_eq!(decode_base32("MZXW6YQ="), Some(b"foob".to_vec()));
+        assert_eq!(decode_base32("mzxw 6yq"), Some(b"foob".to_vec()));
+        assert_eq!(decode_base32("MZXW1"), None);
+    }
+
+    #[test]
+    fn test_generate() -> Result<()> {
+        // The test vectors of RFC 6238 for SHA-1, with their last six digits
+        let seed = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ";
+        assert_eq!(generate(seed, 59)?, "287082");
+        assert_eq!(generate(seed, 1111111109)?, "081804");
+        assert_eq!(generate(seed, 1234567890)?, "005924");
+        assert!(generate("not base32!", 59).is_err());
+        Ok(())
+    }
+}
@@ -0,0 +1,149 @@
+use anyhow::Result;
+use std::{env, path::Path};
+
+use crate::{hashes, profiles};
+
+/// Name of the file, inside the dotenv folder, where the files allowed with
+/// `dotenv allow` are recorded along with a hash of their contents.
+pub const TRUST_FILE: &str = ".trusted";
+
+/// Environment variable that, set to `commands`, only requires the files
+/// outside the dotenv folders running commands to be allowed before loading
+/// them, instead of every one of them.
+pub const TRUST_VAR: &str = "DOTENV_TRUST";
+
+/// Variables that make dotenv run a command of the file's choosing.
+const COMMAND_VARS: &[&str] = &[
+    profiles::COMMAND_VAR,
+    crate::PRE_COMMAND_VAR,
+    crate::POST_COMMAND_VAR,
+    crate::ON_FAILURE_VAR,
+];
+
+/// Variables that take over the command that's run, by changing where it's
+/// found, what's loaded into it or the shell running it.
+const TAKEOVER_VARS: &[&str] = &[
+    "PATH",
+    "LD_PRELOAD",
+    "LD_LIBRARY_PATH",
+    "LD_AUDIT",
+    "DYLD_INSERT_LIBRARIES",
+    "DYLD_LIBRARY_PATH",
+    "BASH_ENV",
+    "ENV",
+    "SHELL",
+    crate::SHELL_VAR,
+];
+
+/// Check if a variable makes dotenv run a command of the file's choosing,
+/// like `DOTENV_COMMAND` or a hook, or takes over the one you run, like
+/// `PATH` or `LD_PRELOAD`.
+pub fn runs_command(key: &str) -> bool {
+    COMMAND_VARS.contains(&key)
+        || TAKEOVER_VARS.contains(&key)
+        || key.starts_with(profiles::COMMAND_PREFIX)
+}
+
+/// Check if every file outside the dotenv folders has to be allowed, which
+/// is the case unless `DOTENV_TRUST=commands` narrows it down to the ones
+/// running commands.
+pub fn guards_all() -> bool {
+    !env::var(TRUST_VAR).is_ok_and(|v| v.trim().eq_ignore_ascii_case("commands"))
+}
+
+/// Refuse to use a file that isn't trusted, saying why it needs to be,
+/// like a `.env` file in a freshly cloned repository setting
+/// `DOTENV_COMMAND`.
+pub fn require(file: &Path, reason: &str) -> Result<()> {
+    if is_trusted(file) {
+        return Ok(());
+    }
+    anyhow::bail!(
+        "{} {} and hasn't been allowed, review it and run `dotenv allow {}` to use it",
+        file.display(),
+        reason,
+        file.display()
+    )
+}
+
+/// Check if a file can be used: the ones in the dotenv folders are yours,
+/// while any other needs to be allowed, and allowed again once changed.
+pub fn is_trusted(file: &Path) -> bool {
+    let real = profiles::real_path(file);
+    let in_folder = profiles::folders()
+        .unwrap_or_default()
+        .iter()
+        .any(|folder| real.starts_with(profiles::real_path(folder)));
+    if in_folder {
+        return true;
+    }
+
+    let Ok(folder) = profiles::folder() else {
+        return false;
+    };
+    let Ok(hash) = hashes::hash_of(&real) else {
+        return false;
+    };
+    hashes::load(&folder.join(TRUST_FILE))
+        .get(&real)
+        .is_some_and(|allowed| *allowed == hash)
+}
+
+/// Allow a file to be used as it is now, until it changes.
+pub fn allow(file: &Path) -> Result<()> {
+    let real = profiles::real_path(file);
+    let hash = hashes::hash_of(&real)?;
+    let folder = profiles::folder()?;
+    profiles::create_private_dir(&folder)?;
+
+    let record = folder.join(TRUST_FILE);
+    let mut trusted = hashes::load(&record);
+    trusted.insert(real, hash);
+    hashes::save(&record, &trusted)
+}
+
+/// Stop allowing a file to be used, returning whether it was allowed.
+pub fn deny(file: &Path) -> Result<bool> {
+    let real = profiles::real_path(file);
+    let record = profiles::folder()?.join(TRUST_FILE);
+    let mut trusted = hashes::load(&record);
+    if trusted.remove(&real).is_none() {
+        return Ok(false);
+    }
+    hashes::save(&record, &trusted)?;
+    Ok(true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_runs_command() {
+        assert!(runs_command("DOTENV_COMMAND"));
+        assert!(runs_command("DOTENV_COMMAND_DEPLOY"));
+        assert!(runs_command("DOTENV_PRE_COMMAND"));
+        assert!(runs_command("DOTENV_ON_FAILURE"));
+        assert!(runs_command("PATH"));
+        assert!(runs_command("LD_PRELOAD"));
+        assert!(runs_command("DOTENV_SHELL"));
+        assert!(!runs_command("DOTENV_STRICT"));
+        assert!(!runs_command("COMMAND"));
+    }
+
+    #[test]
+    fn test_guards_all() {
+        let _env = crate::lock_env();
+        env::remove_var(TRUST_VAR);
+        let unset = guards_all();
+        env::set_var(TRUST_VAR, "all");
+        let all = guards_all();
+        env::set_var(TRUST_VAR, " Commands ");
+        let commands = guards_all();
+        env::remove_var(TRUST_VAR);
+
+        assert!(unset);
+        assert!(all);
+        assert!(!commands);
+    }
+}
@@ -0,0 +1,101 @@
+use anyhow::Result;
+use std::{
+    collections::BTreeMap,
+    fs,
+    io::Write,
+    path::Path,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use crate::profiles;
+
+/// Name of the file, inside the dotenv folder, where the last time each
+/// named environment was used is recorded.
+pub const USAGE_FILE: &str = ".usage";
+
+/// Record that an environment file was just used. Only files stored in the
+/// first dotenv folder, or links to them, are tracked, and failures are
+/// ignored since this is just bookkeeping that should never get in the way
+/// of running a command.
+pub fn record(file: &Path) {
+    let _ = try_record(file);
+}
+
+fn try_record(file: &Path) -> Result<()> {
+    // Follow links so a project's .env pointing into the dotenv folder counts
+    // as a use of that environment
+    let folder = profiles::real_path(&profiles::folder()?);
+    let name = match profiles::name_of(&folder, &profiles::real_path(file)) {
+        Some(name) => name,
+        None => return Ok(()),
+    };
+
+    let mut log = load(&folder);
+    log.insert(name, now());
+    save(&folder, &log)
+}
+
+/// Read the usage log of a folder, mapping environment names to the Unix
+/// timestamp they were last used at. A missing or corrupt log is empty.
+pub fn load(folder: &Path) -> BTreeMap<String, u64> {
+    let content = fs::read_to_string(folder.join(USAGE_FILE)).unwrap_or_default();
+    parse(&content)
+}
+
+/// Write back the usage log of a folder.
+pub fn save(folder: &Path, log: &BTreeMap<String, u64>) -> Result<()> {
+    let mut scratch = tempfile::Builder::new()
+        .prefix(".usage-")
+        .tempfile_in(folder)?;
+    for (name, timestamp) in log {
+        writeln!(scratch, "{} {}", name, timestamp)?;
+    }
+    scratch.persist(folder.join(USAGE_FILE))?;
+    Ok(())
+}
+
+/// Get the current time as a Unix timestamp.
+pub fn now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or_default()
+}
+
+/// Parse `name timestamp` lines, skipping anything malformed.
+fn parse(content: &str) -> BTreeMap<String, u64> {
+    content
+        .lines()
+        .filter_map(|line| {
+            let (name, timestamp) = line.trim().rsplit_once(' ')?;
+            Some((name.to_string(), timestamp.parse().ok()?))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_parse_skips_malformed_lines() {
+        let log = parse("prod 1700000000\ngarbage\nstaging nope\ndev 42\n");
+        assert_eq!(log.len(), 2);
+        assert_eq!(log.get("prod"), Some(&1700000000));
+        assert_eq!(log.get("dev"), Some(&42));
+    }
+
+    #[test]
+    fn test_save_and_load() -> Result<()> {
+        let dir = tempdir()?;
+        let mut log = BTreeMap::new();
+        log.insert("prod".to_string(), 10);
+        log.insert("dev".to_string(), 20);
+
+        save(dir.path(), &log)?;
+        assert_eq!(load(dir.path()), log);
+        assert!(load(&dir.path().join("missing")).is_empty());
+        Ok(())
+    }
+}
@@ -0,0 +1,239 @@
+use anyhow::Result;
+use std::{ffi::CString, path::PathBuf, process::Command};
+
+/// The user and group the commands run as, instead of dotenv's own.
+#[derive(Debug, PartialEq)]
+pub struct Credentials {
+    /// Name of the user, to look up its supplementary groups.
+    pub name: Option<CString>,
+    pub uid: Option<u32>,
+    pub gid: Option<u32>,
+    /// Home folder of the user, set as `HOME` for the commands.
+    pub home: Option<PathBuf>,
+}
+
+impl Credentials {
+    /// Run a command with these credentials, with the supplementary groups
+    /// of the user instead of dotenv's, or only the group given when there's
+    /// no user with a name. They're changed right before the command runs,
+    /// rather than with `CommandExt::uid`, so the limits set beforehand can
+    /// still use dotenv's privileges.
+    #[cfg(unix)]
+    pub fn apply(&self, cmd: &mut Command) {
+        use std::io::Error;
+        use std::os::unix::process::CommandExt;
+
+        if let Some(home) = &self.home {
+            cmd.env("HOME", home);
+        }
+
+        let (name, uid, gid) = (self.name.clone(), self.uid, self.gid);
+        // SAFETY: the closure runs in the child before exec, and initgroups
+        // only reads the group database, as the parent would
+        unsafe {
+            cmd.pre_exec(move || {
+                if let Some(gid) = gid {
+                    let code = match &name {
+                        Some(name) => libc::initgroups(name.as_ptr(), gid as _),
+                        None => libc::setgroups(1, &gid),
+                    };
+                    // Without CAP_SETGID there are no other groups to set
+                    if code != 0 && Error::last_os_error().raw_os_error() != Some(libc::EPERM) {
+                        return Err(Error::last_os_error());
+                    }
+                    if libc::setgid(gid) != 0 {
+                        return Err(Error::last_os_error());
+                    }
+                }
+                if let Some(uid) = uid {
+                    if libc::setuid(uid) != 0 {
+                        return Err(Error::last_os_error());
+                    }
+                }
+                Ok(())
+            });
+        }
+    }
+
+    #[cfg(not(unix))]
+    pub fn apply(&self, _cmd: &mut Command) {}
+}
+
+/// Look up the credentials for a user and a group, given as names or
+/// numeric IDs. Without a group, the user's primary group is used.
+#[cfg(unix)]
+pub fn resolve(user: Option<&str>, group: Option<&str>) -> Result<Credentials> {
+    let mut credentials = Credentials {
+        name: None,
+        uid: None,
+        gid: None,
+        home: None,
+    };
+
+    if let Some(user) = user {
+        match lookup_user(user) {
+            Some(entry) => {
+                credentials.name = Some(entry.name);
+                credentials.uid = Some(entry.uid);
+                credentials.gid = Some(entry.gid);
+                credentials.home = Some(entry.home);
+            }
+            None => match user.parse::<u32>() {
+                // A user unknown to the system, like in many containers,
+                // has no primary group to fall back to
+                Ok(uid) if group.is_some() => credentials.uid = Some(uid),
+                Ok(uid) => anyhow::bail!(
+                    "User {} has no entry in the user database, give its group with --group too",
+                    uid
+                ),
+                Err(_) => anyhow::bail!("Unknown user: {}", user),
+            },
+        }
+    }
+
+    if let Some(group) = group {
+        let gid = lookup_group(group)
+            .or_else(|| group.parse().ok())
+            .ok_or_else(|| anyhow::anyhow!("Unknown group: {}", group))?;
+        credentials.gid = Some(gid);
+    }
+
+    Ok(credentials)
+}
+
+#[cfg(not(unix))]
+pub fn resolve(_user: Option<&str>, _group: Option<&str>) -> Result<Credentials> {
+    anyhow::bail!("--user and --group are only supported on Unix")
+}
+
+/// A user in the user database.
+#[cfg(unix)]
+struct User {
+    name: CString,
+    uid: u32,
+    gid: u32,
+    home: PathBuf,
+}
+
+/// Find a user by name or numeric ID in the user database.
+#[cfg(unix)]
+fn lookup_user(user: &str) -> Option<User> {
+    use std::ffi::{CStr, OsStr};
+    use std::os::unix::ffi::OsStrExt;
+
+    let name = CString::new(user).ok()?;
+    let uid = user.parse::<libc::uid_t>().ok();
+    with_buffer(|buf| {
+        // SAFETY: passwd is a plain C struct filled in by getpwnam_r or
+        // getpwuid_r, pointing into the buffer given, which outlives it
+        unsafe {
+            let mut entry: libc::passwd = std::mem::zeroed();
+            let mut found = std::ptr::null_mut();
+            let code = match uid {
+                Some(uid) => {
+                    libc::getpwuid_r(uid, &mut entry, buf.as_mut_ptr(), buf.len(), &mut found)
+                }
+                None => libc::getpwnam_r(
+                    name.as_ptr(),
+                    &mut entry,
+                    buf.as_mut_ptr(),
+                    buf.len(),
+                    &mut found,
+                ),
+            };
+            if code != 0 || found.is_null() {
+                return (code, None);
+            }
+            let home = CStr::from_ptr(entry.pw_dir).to_bytes();
+            let user = User {
+                name: CStr::from_ptr(entry.pw_name).to_owned(),
+                uid: entry.pw_uid,
+                gid: entry.pw_gid,
+                home: PathBuf::from(OsStr::from_bytes(home)),
+            };
+            (0, Some(user))
+        }
+    })
+}
+
+/// Find a group by name or numeric ID in the group database.
+#[cfg(unix)]
+fn lookup_group(group: &str) -> Option<u32> {
+    let name = CString::new(group).ok()?;
+    let gid = group.parse::<libc::gid_t>().ok();
+    with_buffer(|buf| {
+        // SAFETY: group is a plain C struct filled in by getgrnam_r or
+        // getgrgid_r, pointing into the buffer given, which outlives it
+        unsafe {
+            let mut entry: libc::group = std::mem::zeroed();
+            let mut found = std::ptr::null_mut();
+            let code = match gid {
+                Some(gid) => {
+                    libc::getgrgid_r(gid, &mut entry, buf.as_mut_ptr(), buf.len(), &mut found)
+                }
+                None => libc::getgrnam_r(
+                    name.as_ptr(),
+                    &mut entry,
+                    buf.as_mut_ptr(),
+                    buf.len(),
+                    &mut found,
+                ),
+            };
+            if code != 0 || found.is_null() {
+                return (code, None);
+            }
+            (0, Some(entry.gr_gid))
+        }
+    })
+}
+
+/// Call one of the reentrant database lookups with a buffer for the
+/// strings it returns, growing it while the lookup reports it's too small.
+#[cfg(unix)]
+fn with_buffer<T>(lookup: impl Fn(&mut [libc::c_char]) -> (libc::c_int, Option<T>)) -> Option<T> {
+    let mut buf = vec![0 as libc::c_char; 1024];
+    loop {
+        match lookup(&mut buf) {
+            (libc::ERANGE, _) if buf.len() < 1 << 20 => buf.resize(buf.len() * 2, 0),
+            (_, found) => return found,
+        }
+    }
+}
+
+#[cfg(all(test, unix))]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_resolve_root() -> Result<()> {
+        let by_name = resolve(Some("root"), None)?;
+        assert_eq!(by_name.name.as_deref(), Some(c"root"));
+        assert_eq!(by_name.uid, Some(0));
+        assert_eq!(by_name.gid, Some(0));
+        assert!(by_name.home.is_some());
+        assert_eq!(resolve(Some("0"), None)?, by_name);
+        Ok(())
+    }
+
+    #[test]
+    fn test_resolve_group() -> Result<()> {
+        let credentials = resolve(None, Some("0"))?;
+        assert_eq!(credentials.name, None);
+        assert_eq!(credentials.uid, None);
+        assert_eq!(credentials.gid, Some(0));
+        Ok(())
+    }
+
+    #[test]
+    fn test_resolve_unknown() {
+        assert!(resolve(Some("dotenv-no-such-user"), None).is_err());
+        assert!(resolve(None, Some("dotenv-no-such-group")).is_err());
+        assert!(resolve(Some("4242424"), None).is_err());
+        assert_eq!(
+            resolve(Some("4242424"), Some("4242424"))
+                .ok()
+                .and_then(|c| c.uid),
+            Some(4242424)
+        );
+    }
+}
@@ -0,0 +1,150 @@
+use anyhow::{Context, Result};
+use std::{collections::HashMap, io, process::Command};
+
+use crate::secrets;
+
+/// Prefix of the values referencing a secret in HashiCorp Vault, followed
+/// by its path and field, like `vault:secret/data/app:password`. The field
+/// isn't given after a `#`, as in other tools, since that starts a comment
+/// in environment files.
+pub const PREFIX: &str = "vault:";
+
+/// Replace, in place, the values referencing secrets in Vault with the
+/// secrets themselves, read with the `vault` command and so with its usual
+/// settings, like `VAULT_ADDR` and `VAULT_TOKEN`. Each path is read once,
+/// however many fields are taken from it, and the paths are read at once.
+pub fn resolve_values(vars: &mut HashMap<String, String>) -> Result<()> {
+    let mut references = Vec::new();
+    for (key, value) in vars.iter() {
+        if let Some(reference) = value.strip_prefix(PREFIX) {
+            let (path, field) = parse_reference(reference)
+                .with_context(|| format!("Invalid Vault reference in {}", key))?;
+            references.push((key.clone(), path.to_string(), field.to_string()));
+        }
+    }
+    if references.is_empty() {
+        return Ok(());
+    }
+
+    let paths = references.iter().map(|(_, path, _)| path.as_str());
+    let secrets = secrets::fetch_all(paths, read);
+
+    let mut resolved = Vec::new();
+    for (key, path, field) in &references {
+        let secret = match &secrets[path.as_str()] {
+            Ok(secret) => secret,
+            Err(err) => anyhow::bail!("Could not read {} from Vault for {}: {:#}", path, key, err),
+        };
+        let value = field_of(secret, field).with_context(|| {
+            format!(
+                "The secret at {} in Vault has no field {:?}, needed by {}",
+                path, field, key
+            )
+        })?;
+        resolved.push((key.clone(), value));
+    }
+    vars.extend(resolved);
+    Ok(())
+}
+
+/// Split a reference like `secret/data/app:password` into the path of the
+/// secret and the field to take from it.
+fn parse_reference(reference: &str) -> Result<(&str, &str)> {
+    match reference.rsplit_once(':') {
+        Some((path, field)) if !path.is_empty() && !field.is_empty() => Ok((path, field)),
+        _ => anyhow::bail!(
+            "Expected a path and a field like {}secret/data/app:password, got {}{}",
+            PREFIX,
+            PREFIX,
+            reference
+        ),
+    }
+}
+
+/// Read the secret at a path with `vault read`, as JSON.
+fn read(path: &str) -> Result<serde_json::Value> {
+    let output = Command::new("vault")
+        .args(["read", "-format=json", path])
+        .output();
+    let output = match output {
+        Ok(output) => output,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {
+            anyhow::bail!(
+                "vault is not installed, see https://developer.hashicorp.com/vault/install"
+            )
+        }
+        Err(err) => return Err(err).context("Failed to run vault"),
+    };
+    if !output.status.success() {
+        let message = String::from_utf8_lossy(&output.stderr);
+        match message.trim() {
+            "" => anyhow::bail!("vault failed with {}", output.status),
+            message => anyhow::bail!("{}", message),
+        }
+    }
+    serde_json::from_slice(&output.stdout).context("vault printed invalid JSON")
+}
+
+/// Take a field from a secret read from Vault. Secrets in version 2 of the
+/// key-value engine have their fields nested in `data` next to `metadata`,
+/// while other engines have them right in `data`. Fields that aren't
+/// strings are given as JSON.
+fn field_of(secret: &serde_json::Value, field: &str) -> Option<String> {
+    let data = &secret["data"];
+    let fields = if data["data"].is_object() && data["metadata"].is_object() {
+        &data["data"]
+    } else {
+        data
+    };
+    match fields.get(field)? {
+        serde_json::Value::String(value) => Some(value.clone()),
+        value => Some(value.to_string()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_parse_reference() -> Result<()> {
+        assert_eq!(
+            parse_reference("secret/data/app:password")?,
+            ("secret/data/app", "password")
+        );
+        assert_eq!(parse_reference("a:b:c")?, ("a:b", "c"));
+        assert!(parse_reference("secret/data/app").is_err());
+        assert!(parse_reference("secret/data/app:").is_err());
+        assert!(parse_reference(":password").is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_field_of() {
+        let kv2 = json!({
+            "data": {
+                "data": { "password": "hunter2", "port": 5432 },
+                "metadata": { "version": 3 }
+            }
+        });
+        assert_eq!(field_of(&kv2, "password"), Some("hunter2".to_string()));
+        assert_eq!(field_of(&kv2, "port"), Some("5432".to_string()));
+        assert_eq!(field_of(&kv2, "metadata"), None);
+
+        let kv1 = json!({ "data": { "password": "hunter2" } });
+        assert_eq!(field_of(&kv1, "password"), Some("hunter2".to_string()));
+        assert_eq!(field_of(&kv1, "user"), None);
+    }
+
+    #[test]
+    fn test_resolve_values_without_references() -> Result<()> {
+        let mut vars = HashMap::from([("DB_HOST".to_string(), "localhost".to_string())]);
+        resolve_values(&mut vars)?;
+        assert_eq!(vars["DB_HOST"], "localhost");
+
+        let mut invalid = HashMap::from([("DB_PASSWORD".to_string(), "vault:app".to_string())]);
+        assert!(resolve_values(&mut invalid).is_err());
+        Ok(())
+    }
+}
@@ -0,0 +1,178 @@
+use std::{
+    fs,
+    path::PathBuf,
+    time::{Duration, SystemTime},
+};
+
+#[cfg(unix)]
+use std::{
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        mpsc,
+    },
+    thread,
+};
+
+/// How often the environment files are checked for changes.
+#[cfg(unix)]
+const POLL_INTERVAL: Duration = Duration::from_millis(250);
+
+/// Whether the command was stopped because a file it was loaded from
+/// changed, so dotenv should start over rather than exit.
+#[cfg(unix)]
+static RESTARTING: AtomicBool = AtomicBool::new(false);
+
+/// What a file looks like from the outside, to notice it changed without
+/// reading it: when it was last modified and its size, or nothing if it
+/// doesn't exist.
+type Stamp = Option<(SystemTime, u64)>;
+
+fn stamps(files: &[PathBuf]) -> Vec<Stamp> {
+    files
+        .iter()
+        .map(|file| {
+            let metadata = fs::metadata(file).ok()?;
+            Some((metadata.modified().ok()?, metadata.len()))
+        })
+        .collect()
+}
+
+/// Stops watching the files once the command exits.
+#[cfg(unix)]
+pub struct Watcher {
+    done: mpsc::Sender<()>,
+}
+
+/// Start watching the files the command's environment was loaded from.
+/// When one of them changes, the command's process group is sent `reload`,
+/// if given, for commands that read their configuration again on a signal.
+/// Otherwise it's asked to stop with `SIGTERM`, and killed if it's still
+/// running `grace` later, for dotenv to start over with the new values.
+#[cfg(unix)]
+pub fn watch(files: Vec<PathBuf>, pid: u32, reload: Option<i32>, grace: Duration) -> Watcher {
+    let (done, finished) = mpsc::channel::<()>();
+
+    thread::spawn(move || {
+        let group = -(pid as libc::pid_t);
+        let mut last = stamps(&files);
+        loop {
+            if finished.recv_timeout(POLL_INTERVAL) != Err(mpsc::RecvTimeoutError::Timeout) {
+                return;
+            }
+            let mut current = stamps(&files);
+            if current == last {
+                continue;
+            }
+            // Editors may save a file in several steps, so wait for it to
+            // stay the same for a moment
+            loop {
+                if finished.recv_timeout(POLL_INTERVAL) != Err(mpsc::RecvTimeoutError::Timeout) {
+                    return;
+                }
+                let next = stamps(&files);
+                if next == current {
+                    break;
+                }
+                current = next;
+            }
+
+            let changed: Vec<String> = files
+                .iter()
+                .zip(last.iter().zip(&current))
+                .filter(|(_, (before, after))| before != after)
+                .map(|(file, _)| file.display().to_string())
+                .collect();
+            last = current;
+            match reload {
+                Some(signal) => {
+                    note!(
+                        "dotenv: {} changed, sending {} to the command",
+                        changed.join(", "),
+                        crate::exit::signal_name(signal)
+                    );
+                    // SAFETY: kill only sends a signal
+                    unsafe {
+                        libc::kill(group, signal);
+                    }
+                }
+                None => {
+                    note!(
+                        "dotenv: {} changed, restarting the command",
+                        changed.join(", ")
+                    );
+                    RESTARTING.store(true, Ordering::SeqCst);
+                    // SAFETY: as above
+                    unsafe {
+                        libc::kill(group, libc::SIGTERM);
+                    }
+                    break;
+                }
+            }
+        }
+        if finished.recv_timeout(grace) == Err(mpsc::RecvTimeoutError::Timeout) {
+            // SAFETY: as above
+            unsafe {
+                libc::kill(group, libc::SIGKILL);
+            }
+        }
+    });
+
+    Watcher { done }
+}
+
+#[cfg(unix)]
+impl Watcher {
+    /// Stop watching once the command exited.
+    pub fn stop(self) {
+        let _ = self.done.send(());
+    }
+}
+
+/// Whether the command was stopped to start over with the files that
+/// changed.
+#[cfg(unix)]
+pub fn restarting() -> bool {
+    RESTARTING.load(Ordering::SeqCst)
+}
+
+#[cfg(not(unix))]
+pub struct Watcher;
+
+#[cfg(not(unix))]
+pub fn watch(_files: Vec<PathBuf>, _pid: u32, _reload: Option<i32>, _grace: Duration) -> Watcher {
+    Watcher
+}
+
+#[cfg(not(unix))]
+impl Watcher {
+    pub fn stop(self) {}
+}
+
+#[cfg(not(unix))]
+pub fn restarting() -> bool {
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::tempdir;
+
+    #[test]
+    fn test_stamps() -> anyhow::Result<()> {
+        let dir = tempdir()?;
+        let file = dir.path().join(".env");
+        let missing = dir.path().join(".env.local");
+        fs::write(&file, "FOO=bar\n")?;
+
+        let files = vec![file.clone(), missing];
+        let before = stamps(&files);
+        assert!(before[0].is_some());
+        assert_eq!(before[1], None);
+        assert_eq!(stamps(&files), before);
+
+        fs::write(&file, "FOO=bar baz\n")?;
+        assert_ne!(stamps(&files), before);
+        Ok(())
+    }
+}